@@ -21,3 +21,26 @@ type APIBackend interface {
 	PUT(ctx context.Context, path string, body []byte) (*Result, error)
 	DELETE(ctx context.Context, path string) (*Result, error)
 }
+
+// Lister is implemented by backends that can enumerate their resources.
+type Lister interface {
+	// List returns the sorted paths of every resource whose path begins with prefix.
+	// Paths are returned in the same form as they are passed to GET, beginning with "/".
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+// ConditionalWriter is implemented by backends that can check an If-Match precondition atomically with the write
+// it guards, so that no other write to the resource, through this process or any other, can come between them.
+type ConditionalWriter interface {
+	// PUTIfMatch replaces the resource at path with body if its ETag matches ifMatch, the value of an If-Match
+	// header. It fails with an error matching ErrPreconditionFailed if the resource does not exist or doesn't match.
+	PUTIfMatch(ctx context.Context, path string, body []byte, ifMatch string) (*Result, error)
+	// DELETEIfMatch deletes the resource at path if its ETag matches ifMatch, failing as PUTIfMatch does.
+	DELETEIfMatch(ctx context.Context, path, ifMatch string) (*Result, error)
+}
+
+// Listing is the response body for a GET of a collection path, one ending in "/"
+type Listing struct {
+	// Paths are the resources under the collection, at any depth
+	Paths []string `json:"paths"`
+}
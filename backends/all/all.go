@@ -0,0 +1,13 @@
+// Package all registers every backend's URL schemes for use with gitbackedrest.Open.
+//
+//	import _ "github.com/theothertomelliott/git-backed-rest/backends/all"
+package all
+
+import (
+	_ "github.com/theothertomelliott/git-backed-rest/backends/filesystem"
+	_ "github.com/theothertomelliott/git-backed-rest/backends/gitlocal"
+	_ "github.com/theothertomelliott/git-backed-rest/backends/gitporcelain"
+	_ "github.com/theothertomelliott/git-backed-rest/backends/gitprotocol"
+	_ "github.com/theothertomelliott/git-backed-rest/backends/memory"
+	_ "github.com/theothertomelliott/git-backed-rest/backends/s3"
+)
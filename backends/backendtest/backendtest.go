@@ -0,0 +1,379 @@
+// Package backendtest checks that a backend behaves as the server expects of every backend:
+// creates conflict with existing resources, updates and deletes require one, concurrent creates of a path
+// have a single winner, and reads during writes see whole writes. Backends implementing
+// gitbackedrest.ConditionalWriter are also checked to have a single winner of concurrent conditional writes. Backends run it from their own tests:
+//
+//	func TestConformance(t *testing.T) {
+//		backendtest.Run(t, func(t *testing.T) gitbackedrest.APIBackend {
+//			return NewBackend()
+//		})
+//	}
+package backendtest
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"testing"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+// Run runs the conformance tests, calling newBackend for an empty backend in each
+func Run(t *testing.T, newBackend func(t *testing.T) gitbackedrest.APIBackend) {
+	t.Run("CRUD", func(t *testing.T) { testCRUD(t, newBackend(t)) })
+	t.Run("NestedPaths", func(t *testing.T) { testNestedPaths(t, newBackend(t)) })
+	t.Run("Content", func(t *testing.T) { testContent(t, newBackend(t)) })
+	t.Run("List", func(t *testing.T) {
+		lister, ok := newBackend(t).(gitbackedrest.Lister)
+		if !ok {
+			t.Skip("backend does not implement Lister")
+		}
+		testList(t, lister)
+	})
+	t.Run("ConcurrentCreates", func(t *testing.T) { testConcurrentCreates(t, newBackend(t)) })
+	t.Run("ConcurrentUpdates", func(t *testing.T) { testConcurrentUpdates(t, newBackend(t)) })
+	t.Run("ConcurrentReads", func(t *testing.T) { testConcurrentReads(t, newBackend(t)) })
+	t.Run("ConditionalWrites", func(t *testing.T) {
+		backend := newBackend(t)
+		writer, ok := backend.(gitbackedrest.ConditionalWriter)
+		if !ok {
+			t.Skip("backend does not implement ConditionalWriter")
+		}
+		testConditionalWrites(t, backend, writer)
+	})
+	t.Run("ConcurrentConditionalWrites", func(t *testing.T) {
+		backend := newBackend(t)
+		writer, ok := backend.(gitbackedrest.ConditionalWriter)
+		if !ok {
+			t.Skip("backend does not implement ConditionalWriter")
+		}
+		testConcurrentConditionalWrites(t, backend, writer)
+	})
+}
+
+func expectStatus(t *testing.T, operation string, err error, expected int) {
+	t.Helper()
+	if expected == 0 {
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", operation, err)
+		}
+		return
+	}
+	if status := gitbackedrest.GetHTTPStatusCode(err, 0); status != expected {
+		t.Fatalf("%s: expected status %d, got %d: %v", operation, expected, status, err)
+	}
+}
+
+func expectData(t *testing.T, backend gitbackedrest.APIBackend, path, expected string) {
+	t.Helper()
+	result, err := backend.GET(t.Context(), path)
+	expectStatus(t, "GET "+path, err, 0)
+	if string(result.Data) != expected {
+		t.Fatalf("GET %s: expected %q, got %q", path, expected, result.Data)
+	}
+}
+
+func testCRUD(t *testing.T, backend gitbackedrest.APIBackend) {
+	ctx := t.Context()
+
+	_, err := backend.GET(ctx, "/doc")
+	expectStatus(t, "GET missing", err, http.StatusNotFound)
+	_, err = backend.PUT(ctx, "/doc", []byte("v0"))
+	expectStatus(t, "PUT missing", err, http.StatusNotFound)
+	_, err = backend.DELETE(ctx, "/doc")
+	expectStatus(t, "DELETE missing", err, http.StatusNotFound)
+
+	_, err = backend.POST(ctx, "/doc", []byte("v1"))
+	expectStatus(t, "POST", err, 0)
+	expectData(t, backend, "/doc", "v1")
+	_, err = backend.POST(ctx, "/doc", []byte("again"))
+	expectStatus(t, "POST existing", err, http.StatusConflict)
+	expectData(t, backend, "/doc", "v1")
+
+	_, err = backend.PUT(ctx, "/doc", []byte("v2"))
+	expectStatus(t, "PUT", err, 0)
+	expectData(t, backend, "/doc", "v2")
+
+	_, err = backend.DELETE(ctx, "/doc")
+	expectStatus(t, "DELETE", err, 0)
+	_, err = backend.GET(ctx, "/doc")
+	expectStatus(t, "GET deleted", err, http.StatusNotFound)
+	_, err = backend.PUT(ctx, "/doc", []byte("v3"))
+	expectStatus(t, "PUT deleted", err, http.StatusNotFound)
+
+	_, err = backend.POST(ctx, "/doc", []byte("v4"))
+	expectStatus(t, "POST deleted", err, 0)
+	expectData(t, backend, "/doc", "v4")
+}
+
+func testNestedPaths(t *testing.T, backend gitbackedrest.APIBackend) {
+	ctx := t.Context()
+
+	for _, path := range []string{"/users/alice/profile", "/users/alice/settings", "/users/bob/profile"} {
+		_, err := backend.POST(ctx, path, []byte(path))
+		expectStatus(t, "POST "+path, err, 0)
+	}
+	_, err := backend.DELETE(ctx, "/users/alice/profile")
+	expectStatus(t, "DELETE", err, 0)
+	expectData(t, backend, "/users/alice/settings", "/users/alice/settings")
+	_, err = backend.DELETE(ctx, "/users/alice/settings")
+	expectStatus(t, "DELETE", err, 0)
+	expectData(t, backend, "/users/bob/profile", "/users/bob/profile")
+
+	// Deleting every resource under a path leaves nothing that can be read there
+	_, err = backend.GET(ctx, "/users/alice")
+	expectStatus(t, "GET emptied collection", err, http.StatusNotFound)
+	_, err = backend.POST(ctx, "/users/alice/profile", []byte("again"))
+	expectStatus(t, "POST into emptied collection", err, 0)
+	expectData(t, backend, "/users/alice/profile", "again")
+}
+
+func testContent(t *testing.T, backend gitbackedrest.APIBackend) {
+	ctx := t.Context()
+
+	binary := make([]byte, 256)
+	for i := range binary {
+		binary[i] = byte(i)
+	}
+	for name, data := range map[string][]byte{
+		"/empty":  {},
+		"/binary": binary,
+		"/large":  bytes.Repeat([]byte("0123456789"), 100_000),
+	} {
+		_, err := backend.POST(ctx, name, data)
+		expectStatus(t, "POST "+name, err, 0)
+		result, err := backend.GET(ctx, name)
+		expectStatus(t, "GET "+name, err, 0)
+		if !bytes.Equal(result.Data, data) {
+			t.Errorf("GET %s: expected %d bytes back unchanged, got %d", name, len(data), len(result.Data))
+		}
+	}
+}
+
+func testList(t *testing.T, backend gitbackedrest.Lister) {
+	ctx := t.Context()
+	api := backend.(gitbackedrest.APIBackend)
+
+	for _, path := range []string{"/users/b", "/users/a", "/groups/a", "/users/c", "/usersx"} {
+		_, err := api.POST(ctx, path, []byte("{}"))
+		expectStatus(t, "POST "+path, err, 0)
+	}
+	_, err := api.DELETE(ctx, "/users/c")
+	expectStatus(t, "DELETE", err, 0)
+
+	for prefix, expected := range map[string][]string{
+		"/users/": {"/users/a", "/users/b"},
+		"/users":  {"/users/a", "/users/b", "/usersx"},
+		"/":       {"/groups/a", "/users/a", "/users/b", "/usersx"},
+		"/none/":  nil,
+	} {
+		paths, err := backend.List(ctx, prefix)
+		expectStatus(t, "List "+prefix, err, 0)
+		if !slices.Equal(paths, expected) {
+			t.Errorf("List %s: expected %v, got %v", prefix, expected, paths)
+		}
+	}
+}
+
+func testConcurrentCreates(t *testing.T, backend gitbackedrest.APIBackend) {
+	ctx := t.Context()
+
+	var (
+		wg        sync.WaitGroup
+		mtx       sync.Mutex
+		succeeded []string
+		failures  []error
+	)
+	for i := range 10 {
+		wg.Go(func() {
+			body := fmt.Sprintf("writer %d", i)
+			_, err := backend.POST(ctx, "/contended", []byte(body))
+			mtx.Lock()
+			defer mtx.Unlock()
+			switch {
+			case err == nil:
+				succeeded = append(succeeded, body)
+			case gitbackedrest.GetHTTPStatusCode(err, 0) != http.StatusConflict:
+				failures = append(failures, err)
+			}
+		})
+	}
+	wg.Wait()
+
+	if len(failures) > 0 {
+		t.Errorf("expected losing creates to conflict, got %v", failures)
+	}
+	if len(succeeded) != 1 {
+		t.Fatalf("expected exactly one create to succeed, got %d", len(succeeded))
+	}
+	expectData(t, backend, "/contended", succeeded[0])
+}
+
+func testConcurrentUpdates(t *testing.T, backend gitbackedrest.APIBackend) {
+	ctx := t.Context()
+
+	var wg sync.WaitGroup
+	errs := make([]error, 10)
+	for i := range errs {
+		wg.Go(func() {
+			path := fmt.Sprintf("/docs/%d", i)
+			if _, err := backend.POST(ctx, path, []byte("v1")); err != nil {
+				errs[i] = err
+				return
+			}
+			_, errs[i] = backend.PUT(ctx, path, []byte("v2"))
+		})
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		expectStatus(t, fmt.Sprintf("writer %d", i), err, 0)
+		expectData(t, backend, fmt.Sprintf("/docs/%d", i), "v2")
+	}
+}
+
+// testConcurrentReads gets, lists and reads the history of resources while they are written, so that run with
+// -race it checks that a backend is safe for concurrent use. Each read must see a resource missing or with
+// content that was written to it.
+func testConcurrentReads(t *testing.T, backend gitbackedrest.APIBackend) {
+	ctx := t.Context()
+	lister, _ := backend.(gitbackedrest.Lister)
+	historian, _ := backend.(gitbackedrest.Historian)
+
+	var (
+		wg       sync.WaitGroup
+		mtx      sync.Mutex
+		failures []error
+	)
+	fail := func(err error) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		failures = append(failures, err)
+	}
+	paths := []string{"/docs/0", "/docs/1", "/docs/2", "/docs/3"}
+	for _, path := range paths {
+		wg.Go(func() {
+			for _, write := range []struct {
+				method string
+				body   string
+			}{{"POST", "v1"}, {"PUT", "v2"}, {"DELETE", ""}, {"POST", "v3"}} {
+				var err error
+				switch write.method {
+				case "POST":
+					_, err = backend.POST(ctx, path, []byte(write.body))
+				case "PUT":
+					_, err = backend.PUT(ctx, path, []byte(write.body))
+				case "DELETE":
+					_, err = backend.DELETE(ctx, path)
+				}
+				if err != nil {
+					fail(fmt.Errorf("%s %s: %w", write.method, path, err))
+				}
+			}
+		})
+	}
+	for range 4 {
+		wg.Go(func() {
+			for i := range 20 {
+				path := paths[i%len(paths)]
+				result, err := backend.GET(ctx, path)
+				switch {
+				case gitbackedrest.GetHTTPStatusCode(err, 0) == http.StatusNotFound:
+				case err != nil:
+					fail(fmt.Errorf("GET %s: %w", path, err))
+				case !slices.Contains([]string{"v1", "v2", "v3"}, string(result.Data)):
+					fail(fmt.Errorf("GET %s: unexpected content %q", path, result.Data))
+				}
+				if lister != nil {
+					if _, err := lister.List(ctx, "/docs/"); err != nil {
+						fail(fmt.Errorf("List: %w", err))
+					}
+				}
+				if historian != nil {
+					// Backends that can have history turned off report it as not implemented
+					_, err := historian.History(ctx, path)
+					if err != nil && gitbackedrest.GetHTTPStatusCode(err, 0) != http.StatusNotImplemented {
+						fail(fmt.Errorf("History %s: %w", path, err))
+					}
+				}
+			}
+		})
+	}
+	wg.Wait()
+
+	if len(failures) > 0 {
+		t.Fatalf("expected reads and writes to succeed, got %v", failures)
+	}
+	for _, path := range paths {
+		expectData(t, backend, path, "v3")
+	}
+}
+
+func testConditionalWrites(t *testing.T, backend gitbackedrest.APIBackend, writer gitbackedrest.ConditionalWriter) {
+	ctx := t.Context()
+
+	_, err := writer.PUTIfMatch(ctx, "/doc", []byte("v0"), "*")
+	expectStatus(t, "PUT missing", err, http.StatusPreconditionFailed)
+	_, err = writer.DELETEIfMatch(ctx, "/doc", "*")
+	expectStatus(t, "DELETE missing", err, http.StatusPreconditionFailed)
+
+	_, err = backend.POST(ctx, "/doc", []byte("v1"))
+	expectStatus(t, "POST", err, 0)
+	_, err = writer.PUTIfMatch(ctx, "/doc", []byte("v2"), gitbackedrest.ETag([]byte("v0")))
+	expectStatus(t, "PUT stale tag", err, http.StatusPreconditionFailed)
+	expectData(t, backend, "/doc", "v1")
+	_, err = writer.PUTIfMatch(ctx, "/doc", []byte("v2"), gitbackedrest.ETag([]byte("v1")))
+	expectStatus(t, "PUT current tag", err, 0)
+	expectData(t, backend, "/doc", "v2")
+
+	_, err = writer.DELETEIfMatch(ctx, "/doc", gitbackedrest.ETag([]byte("v1")))
+	expectStatus(t, "DELETE stale tag", err, http.StatusPreconditionFailed)
+	expectData(t, backend, "/doc", "v2")
+	_, err = writer.DELETEIfMatch(ctx, "/doc", gitbackedrest.ETag([]byte("v2")))
+	expectStatus(t, "DELETE current tag", err, 0)
+	_, err = backend.GET(ctx, "/doc")
+	expectStatus(t, "GET deleted", err, http.StatusNotFound)
+}
+
+// testConcurrentConditionalWrites makes several updates conditional on the same content at once, of which only
+// one may succeed, since the others' precondition no longer holds once it has
+func testConcurrentConditionalWrites(t *testing.T, backend gitbackedrest.APIBackend, writer gitbackedrest.ConditionalWriter) {
+	ctx := t.Context()
+
+	_, err := backend.POST(ctx, "/contended", []byte("v0"))
+	expectStatus(t, "POST", err, 0)
+	etag := gitbackedrest.ETag([]byte("v0"))
+
+	var (
+		wg        sync.WaitGroup
+		mtx       sync.Mutex
+		succeeded []string
+		failures  []error
+	)
+	for i := range 10 {
+		wg.Go(func() {
+			body := fmt.Sprintf("writer %d", i)
+			_, err := writer.PUTIfMatch(ctx, "/contended", []byte(body), etag)
+			mtx.Lock()
+			defer mtx.Unlock()
+			switch {
+			case err == nil:
+				succeeded = append(succeeded, body)
+			case !gitbackedrest.HasHTTPStatusCode(err, http.StatusPreconditionFailed, http.StatusConflict):
+				failures = append(failures, err)
+			}
+		})
+	}
+	wg.Wait()
+
+	if len(failures) > 0 {
+		t.Errorf("expected losing writes to fail their precondition, got %v", failures)
+	}
+	if len(succeeded) != 1 {
+		t.Fatalf("expected exactly one conditional write to succeed, got %d", len(succeeded))
+	}
+	expectData(t, backend, "/contended", succeeded[0])
+}
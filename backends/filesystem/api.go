@@ -0,0 +1,423 @@
+// Package filesystem implements a backend storing each resource as a file in a directory tree.
+//
+// Writes are atomic: content is written to a temporary file which is then moved into place, so readers see
+// either the old or the new content, never part of a write. Creates link the temporary file to the resource's
+// path, which fails if a file is already there, so only one of several concurrent creates succeeds. Updates
+// and deletes hold a lock on the resource's path while they check it exists, or for conditional writes that its
+// content matches, and change it. Directories are created as needed, and removed once the last resource in
+// them is deleted.
+//
+// A resource cannot have the same path as a directory of other resources, so for example /users and
+// /users/alice cannot both exist. Only one process may write to a directory at a time.
+package filesystem
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+var (
+	_ gitbackedrest.APIBackend        = (*Backend)(nil)
+	_ gitbackedrest.Lister            = (*Backend)(nil)
+	_ gitbackedrest.HealthChecker     = (*Backend)(nil)
+	_ gitbackedrest.ConditionalWriter = (*Backend)(nil)
+)
+
+// tmpDir is the directory within the backend's directory that holds files being written
+const tmpDir = ".gbr-tmp"
+
+// Option configures a Backend
+type Option func(*Backend)
+
+// WithLogger sets the logger for the backend's operations
+func WithLogger(logger *slog.Logger) Option {
+	return func(b *Backend) {
+		b.logger = logger
+	}
+}
+
+// WithFsync sets whether writes are flushed to disk before they return. Defaults to true, so that
+// acknowledged writes survive a power loss. Without it, they survive the process crashing but not the machine.
+func WithFsync(enabled bool) Option {
+	return func(b *Backend) {
+		b.fsync = enabled
+	}
+}
+
+// Backend implements APIBackend on a directory tree
+type Backend struct {
+	// root confines every file operation to the backend's directory, including through symlinks
+	root   *os.Root
+	logger *slog.Logger
+	fsync  bool
+
+	locks pathLocks
+	// treeMtx is held for reading while a write creates directories and moves a file into them, and for
+	// writing while emptied directories are removed, so that a directory is never removed from under a write
+	treeMtx sync.RWMutex
+}
+
+// NewBackend creates a backend storing resources under dir, which is created if needed.
+// Call Close when finished.
+func NewBackend(dir string, opts ...Option) (*Backend, error) {
+	b := &Backend{
+		fsync: true,
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating data directory: %w", err)
+	}
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, fmt.Errorf("opening data directory: %w", err)
+	}
+	// Temporary files left by a crash were never moved into place, so they can be discarded
+	if err := root.RemoveAll(tmpDir); err != nil {
+		root.Close()
+		return nil, fmt.Errorf("removing temporary files: %w", err)
+	}
+	if err := root.Mkdir(tmpDir, 0o700); err != nil {
+		root.Close()
+		return nil, fmt.Errorf("creating temporary directory: %w", err)
+	}
+	b.root = root
+	return b, nil
+}
+
+// GET implements gitbackedrest.APIBackend.
+func (b *Backend) GET(ctx context.Context, p string) (*gitbackedrest.GetResult, error) {
+	_, phase := gitbackedrest.StartPhase(ctx, b.logger, "GET")
+	defer phase.End()
+
+	name, err := resourceName(p)
+	if err != nil {
+		return nil, err
+	}
+	data, err := b.root.ReadFile(name)
+	if isMissing(err) {
+		return nil, notFoundError()
+	}
+	if err != nil {
+		return nil, internalError("reading file", err)
+	}
+	return &gitbackedrest.GetResult{
+		Data:    data,
+		Retries: 0, // Filesystem operations aren't retried
+	}, nil
+}
+
+// POST implements gitbackedrest.APIBackend.
+func (b *Backend) POST(ctx context.Context, p string, body []byte) (*gitbackedrest.Result, error) {
+	_, phase := gitbackedrest.StartPhase(ctx, b.logger, "POST")
+	defer phase.End()
+
+	name, err := resourceName(p)
+	if err != nil {
+		return nil, err
+	}
+	unlock := b.locks.lock(name)
+	defer unlock()
+
+	tmp, err := b.writeTemp(body)
+	if err != nil {
+		return nil, err
+	}
+	// Once linked, the temporary name is no longer needed
+	defer b.root.Remove(tmp)
+
+	b.treeMtx.RLock()
+	defer b.treeMtx.RUnlock()
+
+	dir := path.Dir(name)
+	if err := b.root.MkdirAll(dir, 0o755); err != nil {
+		if errors.Is(err, syscall.ENOTDIR) || errors.Is(err, fs.ErrExist) {
+			return nil, conflictError(fmt.Errorf("a parent of %s is a resource", p))
+		}
+		return nil, internalError("creating directory", err)
+	}
+	// Unlike a rename, a link fails if the destination exists, even if it was created by another process
+	if err := b.root.Link(tmp, name); err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return nil, conflictError(errors.New("resource already exists"))
+		}
+		return nil, internalError("linking file", err)
+	}
+	if err := b.syncDir(dir); err != nil {
+		return nil, err
+	}
+	return &gitbackedrest.Result{
+		Retries: 0,
+	}, nil
+}
+
+// PUT implements gitbackedrest.APIBackend.
+func (b *Backend) PUT(ctx context.Context, p string, body []byte) (*gitbackedrest.Result, error) {
+	_, phase := gitbackedrest.StartPhase(ctx, b.logger, "PUT")
+	defer phase.End()
+
+	return b.put(p, body, "")
+}
+
+// PUTIfMatch implements gitbackedrest.ConditionalWriter.
+func (b *Backend) PUTIfMatch(ctx context.Context, p string, body []byte, ifMatch string) (*gitbackedrest.Result, error) {
+	_, phase := gitbackedrest.StartPhase(ctx, b.logger, "PUT")
+	defer phase.End()
+
+	return b.put(p, body, ifMatch)
+}
+
+// put replaces the resource at p, if it exists and matches ifMatch
+func (b *Backend) put(p string, body []byte, ifMatch string) (*gitbackedrest.Result, error) {
+	name, err := resourceName(p)
+	if err != nil {
+		return nil, err
+	}
+	unlock := b.locks.lock(name)
+	defer unlock()
+
+	if err := b.checkCurrent(name, ifMatch); err != nil {
+		return nil, err
+	}
+	tmp, err := b.writeTemp(body)
+	if err != nil {
+		return nil, err
+	}
+
+	b.treeMtx.RLock()
+	defer b.treeMtx.RUnlock()
+
+	if err := b.root.Rename(tmp, name); err != nil {
+		b.root.Remove(tmp)
+		return nil, internalError("replacing file", err)
+	}
+	if err := b.syncDir(path.Dir(name)); err != nil {
+		return nil, err
+	}
+	return &gitbackedrest.Result{
+		Retries: 0,
+	}, nil
+}
+
+// DELETE implements gitbackedrest.APIBackend.
+func (b *Backend) DELETE(ctx context.Context, p string) (*gitbackedrest.Result, error) {
+	_, phase := gitbackedrest.StartPhase(ctx, b.logger, "DELETE")
+	defer phase.End()
+
+	return b.delete(p, "")
+}
+
+// DELETEIfMatch implements gitbackedrest.ConditionalWriter.
+func (b *Backend) DELETEIfMatch(ctx context.Context, p, ifMatch string) (*gitbackedrest.Result, error) {
+	_, phase := gitbackedrest.StartPhase(ctx, b.logger, "DELETE")
+	defer phase.End()
+
+	return b.delete(p, ifMatch)
+}
+
+// delete removes the resource at p, if it exists and matches ifMatch
+func (b *Backend) delete(p, ifMatch string) (*gitbackedrest.Result, error) {
+	name, err := resourceName(p)
+	if err != nil {
+		return nil, err
+	}
+	unlock := b.locks.lock(name)
+	defer unlock()
+
+	if err := b.checkCurrent(name, ifMatch); err != nil {
+		return nil, err
+	}
+	if err := b.root.Remove(name); err != nil {
+		if isMissing(err) {
+			return nil, notFoundError()
+		}
+		return nil, internalError("removing file", err)
+	}
+	if err := b.removeEmptyDirs(path.Dir(name)); err != nil {
+		return nil, err
+	}
+	return &gitbackedrest.Result{
+		Retries: 0,
+	}, nil
+}
+
+// List implements gitbackedrest.Lister, walking the directories that can hold resources beginning with prefix.
+func (b *Backend) List(ctx context.Context, prefix string) ([]string, error) {
+	_, phase := gitbackedrest.StartPhase(ctx, b.logger, "List")
+	defer phase.End()
+
+	// Resources beginning with /users/a are in users, and those beginning with /users/ are in users too
+	start := path.Dir(strings.TrimPrefix(prefix, "/"))
+	if !fs.ValidPath(start) {
+		// No resource can begin with a prefix that isn't a valid path
+		return nil, nil
+	}
+	var paths []string
+	err := fs.WalkDir(b.root.FS(), start, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if name == tmpDir {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if p := "/" + name; d.Type().IsRegular() && strings.HasPrefix(p, prefix) {
+			paths = append(paths, p)
+		}
+		return nil
+	})
+	if err != nil && !isMissing(err) {
+		return nil, internalError("listing files", err)
+	}
+	slices.Sort(paths)
+	return paths, nil
+}
+
+// CheckHealth implements gitbackedrest.HealthChecker by checking the directory is still accessible.
+func (b *Backend) CheckHealth(ctx context.Context) error {
+	if _, err := b.root.Stat(tmpDir); err != nil {
+		return fmt.Errorf("checking data directory: %w", err)
+	}
+	return nil
+}
+
+// Close closes the backend's directory
+func (b *Backend) Close() error {
+	return b.root.Close()
+}
+
+// checkExists returns a not found error unless name is a resource
+func (b *Backend) checkExists(name string) error {
+	info, err := b.root.Lstat(name)
+	if isMissing(err) || (err == nil && !info.Mode().IsRegular()) {
+		return notFoundError()
+	}
+	if err != nil {
+		return internalError("checking file", err)
+	}
+	return nil
+}
+
+// checkCurrent returns an error unless name is a resource whose content matches ifMatch. An empty ifMatch
+// matches any content. The caller must hold the lock for name, so the content can't change before it is written.
+func (b *Backend) checkCurrent(name, ifMatch string) error {
+	err := b.checkExists(name)
+	if ifMatch == "" {
+		return err
+	}
+	if errors.Is(err, gitbackedrest.ErrNotFound) {
+		return gitbackedrest.CheckIfMatch(ifMatch, nil, false)
+	}
+	if err != nil {
+		return err
+	}
+	data, err := b.root.ReadFile(name)
+	if err != nil {
+		return internalError("reading file", err)
+	}
+	return gitbackedrest.CheckIfMatch(ifMatch, data, true)
+}
+
+// writeTemp writes data to a new file in the temporary directory, returning its name
+func (b *Backend) writeTemp(data []byte) (string, error) {
+	name := path.Join(tmpDir, rand.Text())
+	f, err := b.root.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return "", internalError("creating temporary file", err)
+	}
+	_, err = f.Write(data)
+	if err == nil && b.fsync {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		b.root.Remove(name)
+		return "", internalError("writing temporary file", err)
+	}
+	return name, nil
+}
+
+// syncDir flushes a directory, so that files moved into it are durable
+func (b *Backend) syncDir(dir string) error {
+	if !b.fsync {
+		return nil
+	}
+	d, err := b.root.Open(dir)
+	if err != nil {
+		return internalError("opening directory", err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return internalError("syncing directory", err)
+	}
+	return nil
+}
+
+// removeEmptyDirs removes dir and then each of its parents until one is not empty
+func (b *Backend) removeEmptyDirs(dir string) error {
+	b.treeMtx.Lock()
+	defer b.treeMtx.Unlock()
+
+	for ; dir != "."; dir = path.Dir(dir) {
+		// Removing a directory that is not empty fails, which ends the cleanup. One that is already gone was
+		// removed by a concurrent delete, and is skipped so that the directory synced below exists.
+		if err := b.root.Remove(dir); err != nil && !isMissing(err) {
+			break
+		}
+	}
+	return b.syncDir(dir)
+}
+
+// isMissing reports whether err is from a file or one of its parents not existing, or from it being a directory
+func isMissing(err error) bool {
+	return errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ENOTDIR) || errors.Is(err, syscall.EISDIR)
+}
+
+func notFoundError() error {
+	return gitbackedrest.NewUserError(
+		"Not Found",
+		gitbackedrest.NewHTTPError(
+			http.StatusNotFound,
+			errors.New("resource not found"),
+		),
+	)
+}
+
+func conflictError(err error) error {
+	return gitbackedrest.NewUserError(
+		"Conflict",
+		gitbackedrest.NewHTTPError(
+			http.StatusConflict,
+			err,
+		),
+	)
+}
+
+func internalError(action string, err error) error {
+	return gitbackedrest.NewUserError(
+		"Internal Server Error",
+		gitbackedrest.NewHTTPError(
+			http.StatusInternalServerError,
+			fmt.Errorf("%s: %w", action, err),
+		),
+	)
+}
@@ -0,0 +1,193 @@
+package filesystem
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+	"github.com/theothertomelliott/git-backed-rest/backends/backendtest"
+)
+
+func newTestBackend(t *testing.T, dir string) *Backend {
+	t.Helper()
+	backend, err := NewBackend(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { backend.Close() })
+	return backend
+}
+
+func expectStatus(t *testing.T, err error, expected int) {
+	t.Helper()
+	if expected == 0 {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return
+	}
+	if status := gitbackedrest.GetHTTPStatusCode(err, 0); status != expected {
+		t.Fatalf("expected status %d, got %d: %v", expected, status, err)
+	}
+}
+
+// files returns the files and directories in dir, other than the temporary directory
+func files(t *testing.T, dir string) []string {
+	t.Helper()
+	var names []string
+	err := filepath.WalkDir(dir, func(name string, d os.DirEntry, err error) error {
+		if err != nil || name == dir {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, name)
+		if rel == tmpDir {
+			return filepath.SkipDir
+		}
+		names = append(names, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestConformance(t *testing.T) {
+	backendtest.Run(t, func(t *testing.T) gitbackedrest.APIBackend {
+		return newTestBackend(t, t.TempDir())
+	})
+}
+
+func TestFiles(t *testing.T) {
+	dir := t.TempDir()
+	backend := newTestBackend(t, dir)
+	ctx := t.Context()
+
+	_, err := backend.POST(ctx, "/users/alice/profile", []byte(`{"name":"alice"}`))
+	expectStatus(t, err, 0)
+	_, err = backend.POST(ctx, "/users/bob", []byte(`{"name":"bob"}`))
+	expectStatus(t, err, 0)
+	_, err = backend.PUT(ctx, "/users/bob", []byte(`{"name":"robert"}`))
+	expectStatus(t, err, 0)
+
+	data, err := os.ReadFile(filepath.Join(dir, "users", "bob"))
+	if err != nil || string(data) != `{"name":"robert"}` {
+		t.Errorf("expected the resource in its file, got %q, %v", data, err)
+	}
+	if entries, err := os.ReadDir(filepath.Join(dir, tmpDir)); err != nil || len(entries) != 0 {
+		t.Errorf("expected no temporary files left, got %v, %v", entries, err)
+	}
+
+	// Deleting the last resource in a directory removes it and any parents it leaves empty
+	_, err = backend.DELETE(ctx, "/users/alice/profile")
+	expectStatus(t, err, 0)
+	if got := files(t, dir); !slices.Equal(got, []string{"users", "users/bob"}) {
+		t.Errorf("expected the emptied directory to be removed, got %v", got)
+	}
+	_, err = backend.DELETE(ctx, "/users/bob")
+	expectStatus(t, err, 0)
+	if got := files(t, dir); len(got) != 0 {
+		t.Errorf("expected every directory to be removed, got %v", got)
+	}
+	if _, err := os.Stat(dir); err != nil {
+		t.Errorf("expected the backend's directory to be kept: %v", err)
+	}
+}
+
+func TestDirectoryConflicts(t *testing.T) {
+	backend := newTestBackend(t, t.TempDir())
+	ctx := t.Context()
+
+	_, err := backend.POST(ctx, "/users/alice", []byte("{}"))
+	expectStatus(t, err, 0)
+
+	_, err = backend.POST(ctx, "/users/alice/profile", []byte("{}"))
+	expectStatus(t, err, http.StatusConflict)
+	_, err = backend.POST(ctx, "/users", []byte("{}"))
+	expectStatus(t, err, http.StatusConflict)
+
+	// A directory is not a resource
+	_, err = backend.GET(ctx, "/users")
+	expectStatus(t, err, http.StatusNotFound)
+	_, err = backend.PUT(ctx, "/users", []byte("{}"))
+	expectStatus(t, err, http.StatusNotFound)
+	_, err = backend.DELETE(ctx, "/users")
+	expectStatus(t, err, http.StatusNotFound)
+	_, err = backend.GET(ctx, "/users/alice/profile")
+	expectStatus(t, err, http.StatusNotFound)
+}
+
+func TestInvalidPaths(t *testing.T) {
+	dir := t.TempDir()
+	backend := newTestBackend(t, filepath.Join(dir, "data"))
+	ctx := t.Context()
+
+	for _, path := range []string{
+		"/",
+		"",
+		"/users/",
+		"/users//alice",
+		"/./users",
+		"/users/../../escaped",
+		"/..",
+		"/users\\alice",
+		"/users/\x00",
+		"/" + tmpDir + "/file",
+	} {
+		_, err := backend.POST(ctx, path, []byte("{}"))
+		expectStatus(t, err, http.StatusBadRequest)
+		_, err = backend.GET(ctx, path)
+		expectStatus(t, err, http.StatusBadRequest)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("expected nothing written outside the backend's directory, got %v", entries)
+	}
+
+	// Dot-prefixed names are ordinary resources
+	_, err := backend.POST(ctx, "/.idempotency/key", []byte("{}"))
+	expectStatus(t, err, 0)
+}
+
+func TestSymlinkEscape(t *testing.T) {
+	dir := t.TempDir()
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(dir, "link")); err != nil {
+		t.Skipf("creating symlink: %v", err)
+	}
+	backend := newTestBackend(t, dir)
+	ctx := t.Context()
+
+	if _, err := backend.GET(ctx, "/link/secret"); err == nil {
+		t.Error("expected reading through a symlink out of the directory to fail")
+	}
+	if _, err := backend.POST(ctx, "/link/planted", []byte("{}")); err == nil {
+		t.Error("expected writing through a symlink out of the directory to fail")
+	}
+	if _, err := os.Stat(filepath.Join(outside, "planted")); !os.IsNotExist(err) {
+		t.Errorf("expected nothing written outside the directory, got %v", err)
+	}
+}
+
+func TestLeftoverTemporaryFiles(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, tmpDir), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, tmpDir, "partial"), []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	backend := newTestBackend(t, dir)
+
+	if entries, err := os.ReadDir(filepath.Join(dir, tmpDir)); err != nil || len(entries) != 0 {
+		t.Errorf("expected temporary files from before to be removed, got %v, %v", entries, err)
+	}
+	if err := backend.CheckHealth(t.Context()); err != nil {
+		t.Errorf("expected healthy backend: %v", err)
+	}
+}
@@ -0,0 +1,36 @@
+package filesystem
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+// Scheme is the URL scheme for filesystem backends, e.g. file:///var/lib/store
+const Scheme = "file"
+
+func init() {
+	gitbackedrest.Register(Scheme, open)
+}
+
+// open creates a backend from a file:// URL naming its directory, such as file:///var/lib/store or file://data
+// for a relative directory. The fsync query parameter sets whether writes are flushed to disk.
+func open(ctx context.Context, u *url.URL, cfg gitbackedrest.OpenConfig) (gitbackedrest.APIBackend, error) {
+	opts := []Option{WithLogger(cfg.Logger)}
+	if value := u.Query().Get("fsync"); value != "" {
+		fsync, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("parsing fsync: %w", err)
+		}
+		opts = append(opts, WithFsync(fsync))
+	}
+
+	dir := u.Host + u.Path
+	if dir == "" {
+		return nil, fmt.Errorf("a directory is required, e.g. %s:///var/lib/store", Scheme)
+	}
+	return NewBackend(dir, opts...)
+}
@@ -0,0 +1,26 @@
+package filesystem
+
+import (
+	"testing"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+func TestOpen(t *testing.T) {
+	dir := t.TempDir()
+	opened, err := gitbackedrest.Open(t.Context(), "file://"+dir+"?fsync=false")
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := opened.(*Backend)
+	t.Cleanup(func() { backend.Close() })
+	if backend.fsync || backend.root.Name() != dir {
+		t.Errorf("unexpected backend settings: %+v", backend)
+	}
+
+	for _, url := range []string{"file://", "file://" + t.TempDir() + "?fsync=sometimes"} {
+		if _, err := gitbackedrest.Open(t.Context(), url); err == nil {
+			t.Errorf("expected an error for %s", url)
+		}
+	}
+}
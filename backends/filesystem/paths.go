@@ -0,0 +1,82 @@
+package filesystem
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+// resourceName returns the name of the file holding the resource at p, relative to the backend's directory.
+// Paths that could refer to anything other than a file within the directory are rejected, as are paths
+// within the temporary directory.
+func resourceName(p string) (string, error) {
+	name := strings.TrimPrefix(p, "/")
+	if name == "" {
+		return "", invalidPathError(p, errors.New("path is empty"))
+	}
+	if strings.ContainsAny(name, "\x00\\") {
+		return "", invalidPathError(p, errors.New("path contains a NUL or backslash"))
+	}
+	segments := strings.Split(name, "/")
+	for _, segment := range segments {
+		switch segment {
+		case "", ".", "..":
+			return "", invalidPathError(p, fmt.Errorf("path has a %q segment", segment))
+		}
+	}
+	if segments[0] == tmpDir {
+		return "", invalidPathError(p, fmt.Errorf("%s is reserved", tmpDir))
+	}
+	return name, nil
+}
+
+func invalidPathError(p string, err error) error {
+	return gitbackedrest.NewUserError(
+		"Invalid path",
+		gitbackedrest.NewHTTPError(
+			http.StatusBadRequest,
+			fmt.Errorf("%q: %w", p, err),
+		),
+	)
+}
+
+// pathLocks serializes writes to each path, without blocking writes to other paths
+type pathLocks struct {
+	mtx   sync.Mutex
+	locks map[string]*pathLock
+}
+
+type pathLock struct {
+	sync.Mutex
+	// holders counts the writes holding or waiting for the lock, so it can be dropped once there are none
+	holders int
+}
+
+// lock locks name, returning a function to unlock it
+func (l *pathLocks) lock(name string) (unlock func()) {
+	l.mtx.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*pathLock)
+	}
+	pl, ok := l.locks[name]
+	if !ok {
+		pl = &pathLock{}
+		l.locks[name] = pl
+	}
+	pl.holders++
+	l.mtx.Unlock()
+
+	pl.Lock()
+	return func() {
+		pl.Unlock()
+		l.mtx.Lock()
+		defer l.mtx.Unlock()
+		if pl.holders--; pl.holders == 0 {
+			delete(l.locks, name)
+		}
+	}
+}
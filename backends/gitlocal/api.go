@@ -0,0 +1,482 @@
+// Package gitlocal implements a backend on a bare Git repository on local disk, reading and writing its
+// objects in-process through go-git's filesystem storage.
+//
+// Each write commits to the branch and moves it with a compare-and-swap of the ref, which fails if another
+// process, such as a git push into the same repository, moved the branch first. The write is then retried
+// against the new commit. Writes within one process are serialized, so they never conflict with each other.
+//
+// Optionally, the branch is pushed to a mirror remote in the background after each write, so the repository
+// can be backed up or browsed elsewhere without slowing writes down.
+package gitlocal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v5"
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/object"
+	"github.com/go-git/go-git/v6/plumbing/storer"
+	"github.com/go-git/go-git/v6/plumbing/transport"
+	"github.com/go-git/go-git/v6/storage"
+	"github.com/go-git/go-git/v6/storage/filesystem"
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+
+	_ "github.com/go-git/go-git/v6/plumbing/transport/file"
+	_ "github.com/go-git/go-git/v6/plumbing/transport/git"
+	_ "github.com/go-git/go-git/v6/plumbing/transport/http"
+	_ "github.com/go-git/go-git/v6/plumbing/transport/ssh"
+)
+
+var (
+	_ gitbackedrest.APIBackend        = (*Backend)(nil)
+	_ gitbackedrest.ConditionalWriter = (*Backend)(nil)
+)
+
+const (
+	// metricsBackend is the value of the "backend" label for this backend's metrics
+	metricsBackend = "gitlocal"
+
+	// maxWriteAttempts limits how many times a write is retried when another process moves the branch
+	maxWriteAttempts = 10
+)
+
+// Option configures optional behavior of a Backend
+type Option func(*Backend)
+
+// WithLogger sets the logger used by the backend.
+// If not set, the default slog logger is used.
+func WithLogger(logger *slog.Logger) Option {
+	return func(b *Backend) {
+		b.logger = logger
+	}
+}
+
+// WithMetrics records mirror push metrics for the backend.
+func WithMetrics(metrics *gitbackedrest.BackendMetrics) Option {
+	return func(b *Backend) {
+		b.metrics = metrics
+	}
+}
+
+// WithBranch sets the branch resources are read from and committed to.
+// If not set, "main" is used.
+func WithBranch(branch string) Option {
+	return func(b *Backend) {
+		b.branch = plumbing.NewBranchReferenceName(branch)
+	}
+}
+
+// WithMirror pushes the branch to the remote at url in the background after each write.
+// If auth is nil, SSH remotes use the SSH agent and other remotes are accessed without authentication.
+func WithMirror(url string, auth transport.AuthMethod) Option {
+	return func(b *Backend) {
+		b.mirrorURL = url
+		b.mirrorAuth = auth
+	}
+}
+
+// WithMirrorRetryInterval sets how long to wait before retrying a failed push to the mirror.
+// If not set, failed pushes are retried after 10 seconds.
+func WithMirrorRetryInterval(interval time.Duration) Option {
+	return func(b *Backend) {
+		b.mirrorRetryInterval = interval
+	}
+}
+
+// NewBackend creates a backend on the bare repository at dir, initializing one if there is none.
+// Call Close when finished, to stop mirroring.
+func NewBackend(dir string, opts ...Option) (*Backend, error) {
+	b := &Backend{
+		dir:                 dir,
+		branch:              plumbing.NewBranchReferenceName("main"),
+		logger:              slog.Default(),
+		mirrorRetryInterval: 10 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	repo, err := git.PlainOpen(dir)
+	if errors.Is(err, git.ErrRepositoryNotExists) {
+		repo, err = git.PlainInit(dir, true, git.WithDefaultBranch(b.branch))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening repository: %w", err)
+	}
+	store, ok := repo.Storer.(*filesystem.Storage)
+	if !ok {
+		return nil, fmt.Errorf("unexpected storage %T for repository", repo.Storer)
+	}
+	b.store = store
+
+	if b.mirrorURL != "" {
+		if b.mirror, err = newMirror(b); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// Backend implements APIBackend on a local Git repository
+type Backend struct {
+	dir     string
+	store   *filesystem.Storage
+	branch  plumbing.ReferenceName
+	logger  *slog.Logger
+	metrics *gitbackedrest.BackendMetrics
+
+	// storeMtx is held for reading while objects are read or written, and for writing while the index of
+	// packfiles is rebuilt
+	storeMtx sync.RWMutex
+	// writeMtx serializes writes, so they only retry when another process moves the branch
+	writeMtx sync.Mutex
+
+	mirrorURL           string
+	mirrorAuth          transport.AuthMethod
+	mirrorRetryInterval time.Duration
+	mirror              *mirror
+}
+
+// log returns the backend's logger annotated with the request ID from ctx
+func (b *Backend) log(ctx context.Context) *slog.Logger {
+	return gitbackedrest.Logger(ctx, b.logger)
+}
+
+// withStore runs fn with the store locked for reading. If fn doesn't find an object, it is run again after
+// reindexing the repository's packfiles, since another process may have added new ones.
+func (b *Backend) withStore(fn func() error) error {
+	b.storeMtx.RLock()
+	err := fn()
+	b.storeMtx.RUnlock()
+	if !errors.Is(err, plumbing.ErrObjectNotFound) {
+		return err
+	}
+
+	b.storeMtx.Lock()
+	b.store.Reindex()
+	b.storeMtx.Unlock()
+
+	b.storeMtx.RLock()
+	defer b.storeMtx.RUnlock()
+	return fn()
+}
+
+// head returns the branch's ref and commit, or nils if nothing has been committed yet. b.storeMtx must be held.
+func (b *Backend) head() (*plumbing.Reference, *object.Commit, error) {
+	ref, err := b.store.Reference(b.branch)
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading %s: %w", b.branch, err)
+	}
+	commit, err := object.GetCommit(b.store, ref.Hash())
+	if err != nil {
+		return nil, nil, fmt.Errorf("getting commit %s: %w", ref.Hash(), err)
+	}
+	return ref, commit, nil
+}
+
+// headTree returns the tree of the branch's commit, or nil if nothing has been committed yet.
+// b.storeMtx must be held.
+func (b *Backend) headTree() (*object.Tree, error) {
+	_, commit, err := b.head()
+	if err != nil || commit == nil {
+		return nil, err
+	}
+	return commit.Tree()
+}
+
+// GET implements gitbackedrest.APIBackend.
+func (b *Backend) GET(ctx context.Context, path string) (*gitbackedrest.GetResult, error) {
+	_, phase := gitbackedrest.StartPhase(ctx, b.logger, "GET")
+	defer phase.End()
+
+	name, err := resourceName(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		data  []byte
+		found bool
+	)
+	err = b.withStore(func() error {
+		tree, err := b.headTree()
+		if err != nil {
+			return err
+		}
+		hash, err := findFile(b.store, tree, name)
+		if err != nil || hash == plumbing.ZeroHash {
+			return err
+		}
+		found = true
+		data, err = readBlob(b.store, hash)
+		return err
+	})
+	if err != nil {
+		return nil, internalError("getting resource", err)
+	}
+	if !found {
+		return nil, notFoundError()
+	}
+	return &gitbackedrest.GetResult{
+		Data:    data,
+		Retries: 0, // GET doesn't retry
+	}, nil
+}
+
+// POST implements gitbackedrest.APIBackend.
+func (b *Backend) POST(ctx context.Context, path string, body []byte) (*gitbackedrest.Result, error) {
+	ctx, phase := gitbackedrest.StartPhase(ctx, b.logger, "POST")
+	defer phase.End()
+
+	return b.write(ctx, path, gitbackedrest.EventCreate, body, "")
+}
+
+// PUT implements gitbackedrest.APIBackend.
+func (b *Backend) PUT(ctx context.Context, path string, body []byte) (*gitbackedrest.Result, error) {
+	ctx, phase := gitbackedrest.StartPhase(ctx, b.logger, "PUT")
+	defer phase.End()
+
+	return b.write(ctx, path, gitbackedrest.EventUpdate, body, "")
+}
+
+// DELETE implements gitbackedrest.APIBackend.
+func (b *Backend) DELETE(ctx context.Context, path string) (*gitbackedrest.Result, error) {
+	ctx, phase := gitbackedrest.StartPhase(ctx, b.logger, "DELETE")
+	defer phase.End()
+
+	return b.write(ctx, path, gitbackedrest.EventDelete, nil, "")
+}
+
+// PUTIfMatch implements gitbackedrest.ConditionalWriter.
+func (b *Backend) PUTIfMatch(ctx context.Context, path string, body []byte, ifMatch string) (*gitbackedrest.Result, error) {
+	ctx, phase := gitbackedrest.StartPhase(ctx, b.logger, "PUT")
+	defer phase.End()
+
+	return b.write(ctx, path, gitbackedrest.EventUpdate, body, ifMatch)
+}
+
+// DELETEIfMatch implements gitbackedrest.ConditionalWriter.
+func (b *Backend) DELETEIfMatch(ctx context.Context, path, ifMatch string) (*gitbackedrest.Result, error) {
+	ctx, phase := gitbackedrest.StartPhase(ctx, b.logger, "DELETE")
+	defer phase.End()
+
+	return b.write(ctx, path, gitbackedrest.EventDelete, nil, ifMatch)
+}
+
+// write commits a change to the resource at path, retrying if another process moves the branch.
+// If ifMatch isn't empty, the resource must match it in the commit the change is made on top of.
+func (b *Backend) write(ctx context.Context, path string, kind gitbackedrest.EventType, body []byte, ifMatch string) (*gitbackedrest.Result, error) {
+	name, err := resourceName(path)
+	if err != nil {
+		return nil, err
+	}
+
+	b.writeMtx.Lock()
+	defer b.writeMtx.Unlock()
+
+	retries := -1
+	operation := func() (plumbing.Hash, error) {
+		retries++
+		var commit plumbing.Hash
+		err := b.withStore(func() error {
+			var err error
+			commit, err = b.commit(ctx, name, kind, body, ifMatch)
+			return err
+		})
+		if errors.Is(err, storage.ErrReferenceHasChanged) {
+			b.log(ctx).WarnContext(ctx, "branch moved during write, will retry", "path", path, "attempt", retries+1)
+			return plumbing.ZeroHash, err
+		}
+		if err != nil {
+			return plumbing.ZeroHash, backoff.Permanent(err)
+		}
+		return commit, nil
+	}
+
+	commit, err := backoff.Retry(ctx, operation,
+		backoff.WithBackOff(backoff.NewExponentialBackOff()),
+		backoff.WithMaxTries(maxWriteAttempts),
+	)
+	if errors.Is(err, errStaleLock) {
+		b.log(ctx).ErrorContext(ctx, "branch is locked, writes are blocked", "path", path, "error", err)
+		return nil, gitbackedrest.NewUserError(
+			"Service Unavailable",
+			gitbackedrest.NewHTTPError(
+				http.StatusServiceUnavailable,
+				fmt.Errorf("%w: %w", gitbackedrest.ErrUnavailable, err),
+			),
+		)
+	}
+	if err != nil {
+		if gitbackedrest.HasHTTPStatusCode(err, http.StatusNotFound, http.StatusConflict, http.StatusPreconditionFailed) {
+			return nil, err
+		}
+		return nil, internalError(fmt.Sprintf("%s operation failed", kind), err)
+	}
+	b.log(ctx).DebugContext(ctx, "committed write", "path", path, "branch", b.branch, "commit", commit)
+
+	if b.mirror != nil {
+		b.mirror.notify()
+	}
+	return &gitbackedrest.Result{
+		Retries: retries,
+	}, nil
+}
+
+// commit creates a commit changing the file at name on top of the branch, and moves the branch to it
+// unless it has moved since it was read. Since the ref is only moved from the commit the file was read
+// from, checking ifMatch against that commit is atomic with the write. b.storeMtx must be held.
+func (b *Backend) commit(ctx context.Context, name string, kind gitbackedrest.EventType, body []byte, ifMatch string) (plumbing.Hash, error) {
+	_, phase := gitbackedrest.StartPhase(ctx, b.logger, "commit")
+	defer phase.End()
+
+	store := b.store
+	ref, parent, err := b.head()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	var tree *object.Tree
+	var parents []plumbing.Hash
+	if parent != nil {
+		if tree, err = parent.Tree(); err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("getting tree: %w", err)
+		}
+		parents = []plumbing.Hash{parent.Hash}
+	}
+
+	current, err := findFile(store, tree, name)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	if ifMatch != "" {
+		var data []byte
+		if current != plumbing.ZeroHash {
+			if data, err = readBlob(store, current); err != nil {
+				return plumbing.ZeroHash, err
+			}
+		}
+		if err := gitbackedrest.CheckIfMatch(ifMatch, data, current != plumbing.ZeroHash); err != nil {
+			return plumbing.ZeroHash, err
+		}
+	}
+	if kind == gitbackedrest.EventCreate && current != plumbing.ZeroHash {
+		return plumbing.ZeroHash, conflictError(errors.New("resource already exists"))
+	}
+	if kind != gitbackedrest.EventCreate && current == plumbing.ZeroHash {
+		return plumbing.ZeroHash, notFoundError()
+	}
+
+	blob := plumbing.ZeroHash
+	if kind != gitbackedrest.EventDelete {
+		if blob, err = writeBlob(store, body); err != nil {
+			return plumbing.ZeroHash, err
+		}
+	}
+	treeHash, err := updateTree(store, tree, strings.Split(name, "/"), blob)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	signature := object.Signature{
+		Name:  "git-backed-rest",
+		Email: "no-reply@telliott.me",
+		When:  time.Now(),
+	}
+	commit := &object.Commit{
+		Author:       signature,
+		Committer:    signature,
+		Message:      fmt.Sprintf("%s /%s", kind, name),
+		TreeHash:     treeHash,
+		ParentHashes: parents,
+	}
+	encoded := store.NewEncodedObject()
+	if err := commit.Encode(encoded); err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("encoding commit: %w", err)
+	}
+	hash, err := store.SetEncodedObject(encoded)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("storing commit: %w", err)
+	}
+
+	old := plumbing.ZeroHash
+	if ref != nil {
+		old = ref.Hash()
+	}
+	if err := b.updateRef(old, hash); err != nil {
+		if errors.Is(err, storage.ErrReferenceHasChanged) {
+			return plumbing.ZeroHash, err
+		}
+		return plumbing.ZeroHash, fmt.Errorf("updating %s: %w", b.branch, err)
+	}
+	return hash, nil
+}
+
+// readBlob returns the content of a blob
+func readBlob(store storer.EncodedObjectStorer, hash plumbing.Hash) ([]byte, error) {
+	blob, err := object.GetBlob(store, hash)
+	if err != nil {
+		return nil, fmt.Errorf("getting blob %s: %w", hash, err)
+	}
+	reader, err := blob.Reader()
+	if err != nil {
+		return nil, fmt.Errorf("getting blob reader: %w", err)
+	}
+	defer reader.Close()
+
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("reading blob content: %w", err)
+	}
+	return content, nil
+}
+
+// Close stops mirroring, after a last push of any writes not yet mirrored.
+// Operations should not be started after Close is called.
+func (b *Backend) Close() error {
+	if b.mirror != nil {
+		b.mirror.close()
+	}
+	return nil
+}
+
+func notFoundError() error {
+	return gitbackedrest.NewUserError(
+		"Not Found",
+		gitbackedrest.NewHTTPError(
+			http.StatusNotFound,
+			errors.New("resource not found"),
+		),
+	)
+}
+
+func conflictError(err error) error {
+	return gitbackedrest.NewUserError(
+		"Conflict",
+		gitbackedrest.NewHTTPError(
+			http.StatusConflict,
+			err,
+		),
+	)
+}
+
+func internalError(action string, err error) error {
+	return gitbackedrest.NewUserError(
+		"Internal Server Error",
+		gitbackedrest.NewHTTPError(
+			http.StatusInternalServerError,
+			fmt.Errorf("%s: %w", action, err),
+		),
+	)
+}
@@ -0,0 +1,200 @@
+package gitlocal
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/object"
+	"github.com/go-git/go-git/v6/storage"
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+	"github.com/theothertomelliott/git-backed-rest/backends/backendtest"
+)
+
+func newTestBackend(t *testing.T, dir string, opts ...Option) *Backend {
+	t.Helper()
+	backend, err := NewBackend(dir, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { backend.Close() })
+	return backend
+}
+
+func expectStatus(t *testing.T, err error, expected int) {
+	t.Helper()
+	if expected == 0 {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return
+	}
+	if status := gitbackedrest.GetHTTPStatusCode(err, 0); status != expected {
+		t.Fatalf("expected status %d, got %d: %v", expected, status, err)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	backendtest.Run(t, func(t *testing.T) gitbackedrest.APIBackend {
+		return newTestBackend(t, t.TempDir())
+	})
+}
+
+func TestRepository(t *testing.T) {
+	dir := t.TempDir()
+	backend := newTestBackend(t, dir, WithBranch("data"))
+	ctx := t.Context()
+
+	for _, path := range []string{"/users/alice", "/users-archive", "/users/bob/profile"} {
+		_, err := backend.POST(ctx, path, []byte(path))
+		expectStatus(t, err, 0)
+	}
+	_, err := backend.PUT(ctx, "/users/alice", []byte("updated"))
+	expectStatus(t, err, 0)
+	_, err = backend.DELETE(ctx, "/users/bob/profile")
+	expectStatus(t, err, 0)
+
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := repo.Reference(plumbing.NewBranchReferenceName("data"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log, err := repo.Log(&git.LogOptions{From: ref.Hash()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var messages []string
+	log.ForEach(func(c *object.Commit) error {
+		messages = append(messages, c.Message)
+		return nil
+	})
+	expected := []string{"delete /users/bob/profile", "update /users/alice", "create /users/bob/profile", "create /users-archive", "create /users/alice"}
+	if fmt.Sprint(messages) != fmt.Sprint(expected) {
+		t.Errorf("expected commits %v, got %v", expected, messages)
+	}
+
+	// The repository is valid for the git CLI, which requires trees to be sorted
+	if _, err := exec.LookPath("git"); err == nil {
+		if out, err := exec.Command("git", "-C", dir, "fsck", "--strict").CombinedOutput(); err != nil {
+			t.Errorf("git fsck: %v\n%s", err, out)
+		}
+		out, err := exec.Command("git", "-C", dir, "ls-tree", "-r", "--name-only", "data").Output()
+		if err != nil || string(out) != "users-archive\nusers/alice\n" {
+			t.Errorf("expected the emptied directory to be removed, got %q, %v", out, err)
+		}
+	}
+}
+
+func TestDirectoryConflicts(t *testing.T) {
+	backend := newTestBackend(t, t.TempDir())
+	ctx := t.Context()
+
+	_, err := backend.POST(ctx, "/users/alice", []byte("{}"))
+	expectStatus(t, err, 0)
+
+	_, err = backend.POST(ctx, "/users/alice/profile", []byte("{}"))
+	expectStatus(t, err, http.StatusConflict)
+	_, err = backend.POST(ctx, "/users", []byte("{}"))
+	expectStatus(t, err, http.StatusConflict)
+	_, err = backend.GET(ctx, "/users")
+	expectStatus(t, err, http.StatusNotFound)
+	_, err = backend.PUT(ctx, "/users", []byte("{}"))
+	expectStatus(t, err, http.StatusNotFound)
+	_, err = backend.GET(ctx, "/users/alice/profile")
+	expectStatus(t, err, http.StatusNotFound)
+
+	for _, path := range []string{"/", "/users/", "/users//alice", "/../escaped", "/users/./alice", "/.git/config"} {
+		_, err = backend.POST(ctx, path, []byte("{}"))
+		expectStatus(t, err, http.StatusBadRequest)
+	}
+}
+
+// TestCompareAndSwap writes through two backends on the same repository, standing in for two processes,
+// so that each moves the branch from under the other
+func TestCompareAndSwap(t *testing.T) {
+	dir := t.TempDir()
+	backends := []*Backend{newTestBackend(t, dir), newTestBackend(t, dir)}
+	ctx := t.Context()
+
+	var wg sync.WaitGroup
+	errs := make([]error, 20)
+	for i := range errs {
+		wg.Go(func() {
+			_, errs[i] = backends[i%2].POST(ctx, fmt.Sprintf("/docs/%d", i), []byte("{}"))
+		})
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("writer %d: %v", i, err)
+		}
+	}
+	paths, err := backends[0].List(ctx, "/docs/")
+	expectStatus(t, err, 0)
+	if len(paths) != len(errs) {
+		t.Errorf("expected every write to be kept, got %v", paths)
+	}
+
+	// Only one of two creates of the same path succeeds, even across processes
+	_, err = backends[0].POST(ctx, "/contended", []byte("first"))
+	expectStatus(t, err, 0)
+	_, err = backends[1].POST(ctx, "/contended", []byte("second"))
+	expectStatus(t, err, http.StatusConflict)
+}
+
+func TestUpdateRef(t *testing.T) {
+	dir := t.TempDir()
+	backend := newTestBackend(t, dir)
+	_, err := backend.POST(t.Context(), "/doc", []byte("{}"))
+	expectStatus(t, err, 0)
+	ref, err := backend.store.Reference(backend.branch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := backend.updateRef(plumbing.ZeroHash, plumbing.ZeroHash); !errors.Is(err, storage.ErrReferenceHasChanged) {
+		t.Errorf("expected a stale update to fail, got %v", err)
+	}
+
+	lockPath := filepath.Join(dir, "refs", "heads", "main.lock")
+	if err := os.WriteFile(lockPath, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.updateRef(ref.Hash(), ref.Hash()); !errors.Is(err, storage.ErrReferenceHasChanged) {
+		t.Errorf("expected an update to fail while the ref is locked, got %v", err)
+	}
+
+	// A lock left by a crash blocks writes until it is removed, rather than being retried
+	stale := time.Now().Add(-2 * staleLockAge)
+	if err := os.Chtimes(lockPath, stale, stale); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.updateRef(ref.Hash(), ref.Hash()); !errors.Is(err, errStaleLock) {
+		t.Errorf("expected an update to fail with a stale lock, got %v", err)
+	}
+	_, err = backend.PUT(t.Context(), "/doc", []byte("{}"))
+	expectStatus(t, err, http.StatusServiceUnavailable)
+	if err := backend.CheckHealth(t.Context()); !errors.Is(err, errStaleLock) {
+		t.Errorf("expected the backend to be unhealthy with a stale lock, got %v", err)
+	}
+	os.Remove(lockPath)
+
+	if err := backend.updateRef(ref.Hash(), ref.Hash()); err != nil {
+		t.Errorf("expected the update to succeed once unlocked, got %v", err)
+	}
+	if _, err := os.Stat(lockPath); !os.IsNotExist(err) {
+		t.Errorf("expected the lock to be released, got %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+package gitlocal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/object"
+	"github.com/go-git/go-git/v6/utils/merkletrie"
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+var _ gitbackedrest.ChangeFeed = (*Backend)(nil)
+
+// Changes implements gitbackedrest.ChangeFeed.
+// The version is the hash of the branch's commit, and events are derived by diffing the tree at since
+// against the tree at the current commit. Multiple commits between the two are reported as their net effect.
+func (b *Backend) Changes(ctx context.Context, since string) ([]gitbackedrest.Event, string, error) {
+	ctx, phase := gitbackedrest.StartPhase(ctx, b.logger, "Changes")
+	defer phase.End()
+
+	if since != "" && !plumbing.IsHash(since) {
+		return nil, "", gitbackedrest.NewUserError(
+			"Invalid resume token",
+			gitbackedrest.NewHTTPError(
+				http.StatusBadRequest,
+				fmt.Errorf("not a commit hash: %q", since),
+			),
+		)
+	}
+
+	var (
+		events       []gitbackedrest.Event
+		version      string
+		sinceMissing bool
+	)
+	err := b.withStore(func() error {
+		events = nil
+		ref, commit, err := b.head()
+		if err != nil {
+			return err
+		}
+		version = plumbing.ZeroHash.String()
+		if ref != nil {
+			version = ref.Hash().String()
+		}
+		if since == "" || since == version {
+			return nil
+		}
+
+		// Before the first commit the tree is empty
+		oldTree, newTree := &object.Tree{}, &object.Tree{}
+		if since != plumbing.ZeroHash.String() {
+			old, err := object.GetCommit(b.store, plumbing.NewHash(since))
+			sinceMissing = errors.Is(err, plumbing.ErrObjectNotFound)
+			if err != nil {
+				return err
+			}
+			if oldTree, err = old.Tree(); err != nil {
+				return fmt.Errorf("getting tree of %s: %w", since, err)
+			}
+		}
+		if commit != nil {
+			if newTree, err = commit.Tree(); err != nil {
+				return fmt.Errorf("getting tree of %s: %w", version, err)
+			}
+		}
+		events, err = diffTrees(ctx, oldTree, newTree, version)
+		return err
+	})
+	if sinceMissing {
+		return nil, "", gitbackedrest.NewUserError(
+			"Resume token is no longer valid",
+			gitbackedrest.NewHTTPError(
+				http.StatusGone,
+				fmt.Errorf("commit %s not found", since),
+			),
+		)
+	}
+	if err != nil {
+		return nil, "", internalError("getting changes", err)
+	}
+	return events, version, nil
+}
+
+func diffTrees(ctx context.Context, oldTree, newTree *object.Tree, version string) ([]gitbackedrest.Event, error) {
+	changes, err := object.DiffTreeContext(ctx, oldTree, newTree)
+	if err != nil {
+		return nil, fmt.Errorf("diffing trees: %w", err)
+	}
+
+	events := make([]gitbackedrest.Event, 0, len(changes))
+	for _, change := range changes {
+		action, err := change.Action()
+		if err != nil {
+			return nil, fmt.Errorf("getting change action: %w", err)
+		}
+
+		var event gitbackedrest.Event
+		switch action {
+		case merkletrie.Insert:
+			event = gitbackedrest.Event{Type: gitbackedrest.EventCreate, Path: "/" + change.To.Name}
+		case merkletrie.Modify:
+			event = gitbackedrest.Event{Type: gitbackedrest.EventUpdate, Path: "/" + change.To.Name}
+		case merkletrie.Delete:
+			event = gitbackedrest.Event{Type: gitbackedrest.EventDelete, Path: "/" + change.From.Name}
+		default:
+			return nil, errors.New("unknown change action")
+		}
+		event.Version = version
+		events = append(events, event)
+	}
+	return events, nil
+}
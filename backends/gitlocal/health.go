@@ -0,0 +1,55 @@
+package gitlocal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/go-git/go-git/v6/plumbing"
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+var (
+	_ gitbackedrest.HealthChecker = (*Backend)(nil)
+	_ gitbackedrest.Diagnoser     = (*Backend)(nil)
+)
+
+// CheckHealth implements gitbackedrest.HealthChecker by reading the branch's ref, and checking it isn't locked
+// by a stale lock file, which blocks every write. A failing mirror doesn't make the backend unhealthy, since
+// writes still succeed locally.
+func (b *Backend) CheckHealth(ctx context.Context) error {
+	if _, err := b.currentRef(); err != nil {
+		return err
+	}
+	return checkLock(b.refPath() + ".lock")
+}
+
+// Diagnostics implements gitbackedrest.Diagnoser.
+// It reports the repository's directory, the current hash of the branch and the state of the mirror.
+func (b *Backend) Diagnostics(ctx context.Context) map[string]any {
+	diagnostics := map[string]any{
+		"dir":    b.dir,
+		"branch": b.branch.Short(),
+	}
+	if hash, err := b.currentRef(); err != nil {
+		diagnostics["ref_error"] = err.Error()
+	} else {
+		diagnostics["ref"] = hash
+	}
+	if b.mirror != nil {
+		diagnostics["mirror"] = b.mirror.diagnostics()
+	}
+	return diagnostics
+}
+
+// currentRef returns the current hash of the branch, or the zero hash if nothing has been committed
+func (b *Backend) currentRef() (string, error) {
+	ref, err := b.store.Reference(b.branch)
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return plumbing.ZeroHash.String(), nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("reading %s: %w", b.branch, err)
+	}
+	return ref.Hash().String(), nil
+}
@@ -0,0 +1,150 @@
+package gitlocal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/object"
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+var _ gitbackedrest.Historian = (*Backend)(nil)
+
+// History implements gitbackedrest.Historian by walking the first-parent history of the branch,
+// reporting each commit that changed the file.
+func (b *Backend) History(ctx context.Context, path string) ([]gitbackedrest.Version, error) {
+	_, phase := gitbackedrest.StartPhase(ctx, b.logger, "History")
+	defer phase.End()
+
+	name, err := resourceName(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []gitbackedrest.Version
+	err = b.withStore(func() error {
+		versions = nil
+		_, commit, err := b.head()
+		if err != nil || commit == nil {
+			return err
+		}
+		current, err := b.fileHash(commit, name)
+		if err != nil {
+			return err
+		}
+
+		for commit != nil {
+			var parent *object.Commit
+			previous := plumbing.ZeroHash
+			if commit.NumParents() > 0 {
+				if parent, err = commit.Parent(0); err != nil {
+					return fmt.Errorf("getting parent of %s: %w", commit.Hash, err)
+				}
+				if previous, err = b.fileHash(parent, name); err != nil {
+					return err
+				}
+			}
+
+			if current != previous {
+				message, _, _ := strings.Cut(commit.Message, "\n")
+				versions = append(versions, gitbackedrest.Version{
+					ID:      commit.Hash.String(),
+					Time:    commit.Author.When,
+					Author:  commit.Author.Name,
+					Message: message,
+					Deleted: current == plumbing.ZeroHash,
+				})
+			}
+			commit, current = parent, previous
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, internalError("reading history", err)
+	}
+	return versions, nil
+}
+
+// GETVersion implements gitbackedrest.Historian, reading the file from the tree of a commit.
+func (b *Backend) GETVersion(ctx context.Context, path, version string) (*gitbackedrest.GetResult, error) {
+	_, phase := gitbackedrest.StartPhase(ctx, b.logger, "GETVersion")
+	defer phase.End()
+
+	name, err := resourceName(path)
+	if err != nil {
+		return nil, err
+	}
+	if !plumbing.IsHash(version) {
+		return nil, gitbackedrest.NewUserError(
+			"Invalid version",
+			gitbackedrest.NewHTTPError(
+				http.StatusBadRequest,
+				fmt.Errorf("not a commit hash: %q", version),
+			),
+		)
+	}
+
+	var (
+		data          []byte
+		commitMissing bool
+		found         bool
+	)
+	err = b.withStore(func() error {
+		commit, err := object.GetCommit(b.store, plumbing.NewHash(version))
+		if errors.Is(err, plumbing.ErrObjectNotFound) {
+			// Reported as not found once the packfiles have been reindexed
+			commitMissing = true
+			return err
+		}
+		commitMissing = false
+		if err != nil {
+			return fmt.Errorf("getting commit %s: %w", version, err)
+		}
+		hash, err := b.fileHash(commit, name)
+		if err != nil || hash == plumbing.ZeroHash {
+			return err
+		}
+		found = true
+		data, err = readBlob(b.store, hash)
+		return err
+	})
+	if commitMissing {
+		return nil, gitbackedrest.NewUserError(
+			"Version not found",
+			gitbackedrest.NewHTTPError(
+				http.StatusNotFound,
+				fmt.Errorf("commit %s not found", version),
+			),
+		)
+	}
+	if err != nil {
+		return nil, internalError("reading history", err)
+	}
+	if !found {
+		return nil, gitbackedrest.NewUserError(
+			"Not Found",
+			gitbackedrest.NewHTTPError(
+				http.StatusNotFound,
+				fmt.Errorf("resource not found in version %s", version),
+			),
+		)
+	}
+	return &gitbackedrest.GetResult{
+		Data:    data,
+		Retries: 0, // GET doesn't retry
+	}, nil
+}
+
+// fileHash returns the hash of the file at name in the commit's tree, or the zero hash if there is none.
+// b.storeMtx must be held.
+func (b *Backend) fileHash(commit *object.Commit, name string) (plumbing.Hash, error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("getting tree of %s: %w", commit.Hash, err)
+	}
+	return findFile(b.store, tree, name)
+}
@@ -0,0 +1,93 @@
+package gitlocal
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/go-git/go-git/v6/plumbing"
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+func TestHistory(t *testing.T) {
+	backend := newTestBackend(t, t.TempDir())
+	ctx := t.Context()
+
+	_, err := backend.POST(ctx, "/users/alice", []byte("v1"))
+	expectStatus(t, err, 0)
+	_, err = backend.POST(ctx, "/users/bob", []byte("other"))
+	expectStatus(t, err, 0)
+	_, err = backend.PUT(ctx, "/users/alice", []byte("v2"))
+	expectStatus(t, err, 0)
+	_, err = backend.DELETE(ctx, "/users/alice")
+	expectStatus(t, err, 0)
+
+	versions, err := backend.History(ctx, "/users/alice")
+	expectStatus(t, err, 0)
+	var messages []string
+	for _, v := range versions {
+		messages = append(messages, v.Message)
+	}
+	if expected := []string{"delete /users/alice", "update /users/alice", "create /users/alice"}; !slices.Equal(messages, expected) {
+		t.Fatalf("expected versions %v, got %v", expected, messages)
+	}
+	if !versions[0].Deleted || versions[1].Deleted || versions[1].Author != "git-backed-rest" {
+		t.Errorf("unexpected versions: %+v", versions)
+	}
+
+	result, err := backend.GETVersion(ctx, "/users/alice", versions[2].ID)
+	expectStatus(t, err, 0)
+	if string(result.Data) != "v1" {
+		t.Errorf("expected the first version, got %q", result.Data)
+	}
+	_, err = backend.GETVersion(ctx, "/users/alice", versions[0].ID)
+	expectStatus(t, err, http.StatusNotFound)
+	_, err = backend.GETVersion(ctx, "/users/alice", "latest")
+	expectStatus(t, err, http.StatusBadRequest)
+	_, err = backend.GETVersion(ctx, "/users/alice", plumbing.ZeroHash.String())
+	expectStatus(t, err, http.StatusNotFound)
+}
+
+func TestChanges(t *testing.T) {
+	backend := newTestBackend(t, t.TempDir())
+	ctx := t.Context()
+
+	events, start, err := backend.Changes(ctx, "")
+	expectStatus(t, err, 0)
+	if len(events) != 0 || start != plumbing.ZeroHash.String() {
+		t.Fatalf("expected no events at the empty version, got %v at %s", events, start)
+	}
+
+	_, err = backend.POST(ctx, "/a", []byte("1"))
+	expectStatus(t, err, 0)
+	_, err = backend.POST(ctx, "/b/c", []byte("1"))
+	expectStatus(t, err, 0)
+	events, middle, err := backend.Changes(ctx, start)
+	expectStatus(t, err, 0)
+	expected := []gitbackedrest.Event{
+		{Type: gitbackedrest.EventCreate, Path: "/a", Version: middle},
+		{Type: gitbackedrest.EventCreate, Path: "/b/c", Version: middle},
+	}
+	if !slices.Equal(events, expected) {
+		t.Errorf("expected %v, got %v", expected, events)
+	}
+
+	_, err = backend.PUT(ctx, "/a", []byte("2"))
+	expectStatus(t, err, 0)
+	_, err = backend.DELETE(ctx, "/b/c")
+	expectStatus(t, err, 0)
+	events, latest, err := backend.Changes(ctx, middle)
+	expectStatus(t, err, 0)
+	expected = []gitbackedrest.Event{
+		{Type: gitbackedrest.EventUpdate, Path: "/a", Version: latest},
+		{Type: gitbackedrest.EventDelete, Path: "/b/c", Version: latest},
+	}
+	if !slices.Equal(events, expected) {
+		t.Errorf("expected %v, got %v", expected, events)
+	}
+
+	_, _, err = backend.Changes(ctx, "not-a-hash")
+	expectStatus(t, err, http.StatusBadRequest)
+	_, _, err = backend.Changes(ctx, "1111111111111111111111111111111111111111")
+	expectStatus(t, err, http.StatusGone)
+}
@@ -0,0 +1,50 @@
+package gitlocal
+
+import (
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/go-git/go-git/v6/plumbing/object"
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+var _ gitbackedrest.Lister = (*Backend)(nil)
+
+// List implements gitbackedrest.Lister, walking the tree of the branch's commit.
+func (b *Backend) List(ctx context.Context, prefix string) ([]string, error) {
+	_, phase := gitbackedrest.StartPhase(ctx, b.logger, "List")
+	defer phase.End()
+
+	var paths []string
+	err := b.withStore(func() error {
+		paths = nil
+		tree, err := b.headTree()
+		if err != nil || tree == nil {
+			return err
+		}
+
+		walker := object.NewTreeWalker(tree, true, nil)
+		defer walker.Close()
+		for {
+			name, entry, err := walker.Next()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			path := "/" + name
+			if entry.Mode.IsFile() && strings.HasPrefix(path, prefix) {
+				paths = append(paths, path)
+			}
+		}
+	})
+	if err != nil {
+		return nil, internalError("listing resources", err)
+	}
+	slices.Sort(paths)
+	return paths, nil
+}
@@ -0,0 +1,210 @@
+package gitlocal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/config"
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/transport"
+	"github.com/go-git/go-git/v6/plumbing/transport/ssh"
+	"github.com/go-git/go-git/v6/storage/filesystem"
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+// mirrorPushTimeout limits how long a push to the mirror may take
+const mirrorPushTimeout = time.Minute
+
+// mirror pushes the branch to a remote in the background whenever it moves. Pushes are forced, so the
+// mirror's branch always matches the local one.
+//
+// The mirror reads the repository through its own storage, which only its goroutine uses, so a push that takes
+// a while never holds the backend's storeMtx and blocks reads and writes behind a reindex.
+type mirror struct {
+	backend *Backend
+	store   *filesystem.Storage
+	remote  *git.Remote
+
+	// pending holds a signal that the branch may have moved since the last push
+	pending   chan struct{}
+	done      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+
+	mtx         sync.Mutex
+	pushed      plumbing.Hash
+	lastErr     error
+	lastErrTime time.Time
+}
+
+func newMirror(b *Backend) (*mirror, error) {
+	if b.mirrorAuth == nil {
+		// SSH remotes need credentials, which default to the SSH agent's as with the git CLI
+		ep, err := transport.NewEndpoint(b.mirrorURL)
+		if err != nil {
+			return nil, fmt.Errorf("parsing mirror URL: %w", err)
+		}
+		if ep.Scheme == "ssh" {
+			user := ep.User.Username()
+			if user == "" {
+				user = "git"
+			}
+			if b.mirrorAuth, err = ssh.NewSSHAgentAuth(user); err != nil {
+				return nil, fmt.Errorf("creating SSH agent auth for mirror: %w", err)
+			}
+		}
+	}
+
+	repo, err := git.PlainOpen(b.dir)
+	if err != nil {
+		return nil, fmt.Errorf("opening repository for mirror: %w", err)
+	}
+	store, ok := repo.Storer.(*filesystem.Storage)
+	if !ok {
+		return nil, fmt.Errorf("unexpected storage %T for repository", repo.Storer)
+	}
+	remote, err := repo.CreateRemoteAnonymous(&config.RemoteConfig{
+		Name: "anonymous",
+		URLs: []string{b.mirrorURL},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating mirror remote: %w", err)
+	}
+
+	m := &mirror{
+		backend: b,
+		store:   store,
+		remote:  remote,
+		pending: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	// Catch the mirror up with writes made while it wasn't running
+	m.notify()
+	m.wg.Go(m.run)
+	return m, nil
+}
+
+// notify signals that the branch has moved, without waiting for the push
+func (m *mirror) notify() {
+	select {
+	case m.pending <- struct{}{}:
+	default:
+		// A push is already pending, and will include this write
+	}
+}
+
+// run pushes each time the branch moves, retrying failed pushes after the retry interval, until closed
+func (m *mirror) run() {
+	var retry <-chan time.Time
+	for {
+		select {
+		case <-m.pending:
+		case <-retry:
+		case <-m.done:
+			// A last push, so that writes made just before closing are mirrored
+			m.push()
+			return
+		}
+		retry = nil
+		if err := m.push(); err != nil {
+			retry = time.After(m.backend.mirrorRetryInterval)
+		}
+	}
+}
+
+// push pushes the branch's commit to the mirror, unless it has already been pushed
+func (m *mirror) push() error {
+	b := m.backend
+	ctx, cancel := context.WithTimeout(context.Background(), mirrorPushTimeout)
+	defer cancel()
+	ctx, phase := gitbackedrest.StartPhase(ctx, b.logger, "mirrorPush")
+	defer phase.End()
+
+	ref, err := m.store.Reference(b.branch)
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return nil
+	}
+	if err == nil {
+		m.mtx.Lock()
+		pushed := m.pushed
+		m.mtx.Unlock()
+		if ref.Hash() == pushed {
+			return nil
+		}
+
+		start := time.Now()
+		err = m.pushCommit(ctx, ref.Hash())
+		if errors.Is(err, plumbing.ErrObjectNotFound) {
+			// Another process may have packed the objects into a packfile this storage hasn't indexed yet
+			m.store.Reindex()
+			err = m.pushCommit(ctx, ref.Hash())
+		}
+		b.metrics.ObserveOperation(metricsBackend, gitbackedrest.OperationPush, start, err)
+	}
+	phase.SetError(err)
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if err != nil {
+		m.lastErr = err
+		m.lastErrTime = time.Now()
+		b.log(ctx).WarnContext(ctx, "pushing to mirror failed, will retry", "mirror", m.url(), "retry_interval", b.mirrorRetryInterval, "error", err)
+		return err
+	}
+	m.pushed = ref.Hash()
+	m.lastErr = nil
+	b.log(ctx).DebugContext(ctx, "pushed to mirror", "mirror", m.url(), "commit", ref.Hash())
+	return nil
+}
+
+// pushCommit force-pushes commit to the mirror's branch
+func (m *mirror) pushCommit(ctx context.Context, commit plumbing.Hash) error {
+	err := m.remote.PushContext(ctx, &git.PushOptions{
+		RemoteName: "anonymous",
+		RefSpecs:   []config.RefSpec{config.RefSpec(fmt.Sprintf("+%s:%s", commit, m.backend.branch))},
+		Auth:       m.backend.mirrorAuth,
+	})
+	if errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return nil
+	}
+	return err
+}
+
+// url returns the mirror's URL without any password
+func (m *mirror) url() string {
+	u, err := url.Parse(m.backend.mirrorURL)
+	if err != nil {
+		// Such as an scp-like SSH address, which has no password
+		return m.backend.mirrorURL
+	}
+	return u.Redacted()
+}
+
+// diagnostics reports the last commit pushed to the mirror and the last push error
+func (m *mirror) diagnostics() map[string]any {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	diagnostics := map[string]any{
+		"url":    m.url(),
+		"pushed": m.pushed.String(),
+	}
+	if m.lastErr != nil {
+		diagnostics["last_push_error"] = m.lastErr.Error()
+		diagnostics["last_push_error_time"] = m.lastErrTime
+	}
+	return diagnostics
+}
+
+// close stops the mirror after a last push
+func (m *mirror) close() {
+	m.closeOnce.Do(func() {
+		close(m.done)
+		m.wg.Wait()
+	})
+}
@@ -0,0 +1,77 @@
+package gitlocal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing"
+)
+
+func TestMirror(t *testing.T) {
+	mirrorDir := t.TempDir()
+	mirrorRepo, err := git.PlainInit(mirrorDir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backend, err := NewBackend(t.TempDir(), WithMirror(mirrorDir, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := t.Context()
+	for _, path := range []string{"/a", "/b", "/c"} {
+		_, err := backend.POST(ctx, path, []byte(path))
+		expectStatus(t, err, 0)
+	}
+	// Closing pushes anything not yet mirrored
+	backend.Close()
+
+	local, err := backend.store.Reference(backend.branch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mirrored, err := mirrorRepo.Reference(plumbing.NewBranchReferenceName("main"), false)
+	if err != nil {
+		t.Fatalf("expected the branch to be mirrored: %v", err)
+	}
+	if mirrored.Hash() != local.Hash() {
+		t.Errorf("expected the mirror at %s, got %s", local.Hash(), mirrored.Hash())
+	}
+}
+
+func TestMirrorFailure(t *testing.T) {
+	// The mirror doesn't exist, so every push fails
+	backend := newTestBackend(t, t.TempDir(),
+		WithMirror(t.TempDir()+"/missing.git", nil),
+		WithMirrorRetryInterval(10*time.Millisecond),
+	)
+	ctx := t.Context()
+
+	_, err := backend.POST(ctx, "/doc", []byte("{}"))
+	expectStatus(t, err, 0)
+	expectData := func() {
+		t.Helper()
+		result, err := backend.GET(ctx, "/doc")
+		expectStatus(t, err, 0)
+		if string(result.Data) != "{}" {
+			t.Errorf("unexpected data %q", result.Data)
+		}
+	}
+	expectData()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mirror := backend.Diagnostics(ctx)["mirror"].(map[string]any)
+		if _, failed := mirror["last_push_error"]; failed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected a push error in diagnostics, got %v", mirror)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := backend.CheckHealth(ctx); err != nil {
+		t.Errorf("expected a failing mirror not to affect health, got %v", err)
+	}
+}
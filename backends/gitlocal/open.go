@@ -0,0 +1,72 @@
+package gitlocal
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/go-git/go-git/v6/plumbing/transport"
+	githttp "github.com/go-git/go-git/v6/plumbing/transport/http"
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+// Scheme is the URL scheme for local repository backends, e.g. gitlocal:///var/lib/store.git?branch=main
+const Scheme = "gitlocal"
+
+func init() {
+	gitbackedrest.Register(Scheme, open)
+}
+
+// open creates a backend from a gitlocal:// URL naming the repository's directory, such as
+// gitlocal:///var/lib/store.git or gitlocal://store.git for a relative directory. The query parameters branch,
+// mirror and mirror_retry_interval configure the backend. For an HTTP(S) mirror, a password in its URL is
+// used as a token.
+func open(ctx context.Context, u *url.URL, cfg gitbackedrest.OpenConfig) (gitbackedrest.APIBackend, error) {
+	opts := []Option{
+		WithLogger(cfg.Logger),
+		WithMetrics(cfg.Metrics),
+	}
+
+	query := u.Query()
+	if branch := query.Get("branch"); branch != "" {
+		opts = append(opts, WithBranch(branch))
+	}
+	if value := query.Get("mirror_retry_interval"); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("parsing mirror_retry_interval: %w", err)
+		}
+		opts = append(opts, WithMirrorRetryInterval(interval))
+	}
+	if value := query.Get("mirror"); value != "" {
+		mirror, auth := mirrorEndpoint(value)
+		opts = append(opts, WithMirror(mirror, auth))
+	}
+
+	dir := u.Host + u.Path
+	if dir == "" {
+		return nil, fmt.Errorf("a directory is required, e.g. %s:///var/lib/store.git", Scheme)
+	}
+	return NewBackend(dir, opts...)
+}
+
+// mirrorEndpoint returns a mirror's URL and authentication, moving a password in an HTTP(S) URL
+// into basic authentication
+func mirrorEndpoint(raw string) (string, transport.AuthMethod) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		// Such as an scp-like SSH address
+		return raw, nil
+	}
+	password, ok := u.User.Password()
+	if !ok || (u.Scheme != "https" && u.Scheme != "http") {
+		return raw, nil
+	}
+	auth := &githttp.BasicAuth{
+		Username: u.User.Username(),
+		Password: password,
+	}
+	u.User = nil
+	return u.String(), auth
+}
@@ -0,0 +1,97 @@
+package gitlocal
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/storage"
+)
+
+// staleLockAge is how old a ref's lock file must be before it is assumed to be left by a crashed writer, rather
+// than held by one that is updating the ref, which takes milliseconds
+const staleLockAge = time.Minute
+
+// errStaleLock is returned for a lock file older than staleLockAge. Writes can't continue until it is removed,
+// which is only safe once no other process is writing to the repository.
+var errStaleLock = errors.New("ref is locked by a stale lock file, remove it if no git process is running")
+
+// updateRef moves the branch from old to new, failing with storage.ErrReferenceHasChanged if it isn't at old,
+// or is locked by another writer. The zero hash is a branch that doesn't exist yet.
+//
+// It follows the git CLI's protocol: the new value is written to a lock file beside the ref, which is created
+// exclusively so that only one writer can hold it, and which is renamed over the ref once the ref has been
+// checked. Readers therefore always see a complete ref, and updates by the git CLI are never lost.
+func (b *Backend) updateRef(old, new plumbing.Hash) error {
+	path := b.refPath()
+	lockPath := path + ".lock"
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("creating ref directory: %w", err)
+	}
+	lock, err := os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if errors.Is(err, fs.ErrExist) {
+		if err := checkLock(lockPath); err != nil {
+			return err
+		}
+		return fmt.Errorf("%w: %s is locked", storage.ErrReferenceHasChanged, lockPath)
+	}
+	if err != nil {
+		return fmt.Errorf("locking ref: %w", err)
+	}
+	// The lock is only ours until it is renamed over the ref. After that, a file at lockPath is another
+	// writer's lock, so it is only removed when the update fails.
+	release := func(err error) error {
+		lock.Close()
+		os.Remove(lockPath)
+		return err
+	}
+
+	current, err := b.store.Reference(b.branch)
+	switch {
+	case errors.Is(err, plumbing.ErrReferenceNotFound):
+		current = plumbing.NewHashReference(b.branch, plumbing.ZeroHash)
+	case err != nil:
+		return release(fmt.Errorf("reading %s: %w", b.branch, err))
+	}
+	if current.Hash() != old {
+		return release(storage.ErrReferenceHasChanged)
+	}
+
+	if _, err := lock.WriteString(new.String() + "\n"); err != nil {
+		return release(fmt.Errorf("writing ref: %w", err))
+	}
+	if err := lock.Sync(); err != nil {
+		return release(fmt.Errorf("writing ref: %w", err))
+	}
+	if err := lock.Close(); err != nil {
+		os.Remove(lockPath)
+		return fmt.Errorf("writing ref: %w", err)
+	}
+	if err := os.Rename(lockPath, path); err != nil {
+		os.Remove(lockPath)
+		return fmt.Errorf("replacing ref: %w", err)
+	}
+	return nil
+}
+
+// refPath returns the path of the file holding the branch's ref
+func (b *Backend) refPath() string {
+	return filepath.Join(b.store.Filesystem().Root(), filepath.FromSlash(b.branch.String()))
+}
+
+// checkLock returns errStaleLock if the lock file at lockPath is older than staleLockAge
+func checkLock(lockPath string) error {
+	info, err := os.Stat(lockPath)
+	if err != nil {
+		// Released since it was found, or unreadable, in which case the write is retried
+		return nil
+	}
+	if age := time.Since(info.ModTime()); age > staleLockAge {
+		return fmt.Errorf("%w: %s is %s old", errStaleLock, lockPath, age.Round(time.Second))
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package gitlocal
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/filemode"
+	"github.com/go-git/go-git/v6/plumbing/object"
+	"github.com/go-git/go-git/v6/plumbing/storer"
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+// resourceName returns the path of the file holding the resource at path, relative to the root tree.
+// Paths that can't be stored in a Git tree are rejected.
+func resourceName(path string) (string, error) {
+	name := strings.TrimPrefix(path, "/")
+	if name == "" {
+		return "", invalidPathError(path, errors.New("path is empty"))
+	}
+	if strings.ContainsRune(name, 0) {
+		return "", invalidPathError(path, errors.New("path contains a NUL"))
+	}
+	for segment := range strings.SplitSeq(name, "/") {
+		switch segment {
+		case "", ".", "..", ".git":
+			return "", invalidPathError(path, fmt.Errorf("path has a %q segment", segment))
+		}
+	}
+	return name, nil
+}
+
+func invalidPathError(path string, err error) error {
+	return gitbackedrest.NewUserError(
+		"Invalid path",
+		gitbackedrest.NewHTTPError(
+			http.StatusBadRequest,
+			fmt.Errorf("%q: %w", path, err),
+		),
+	)
+}
+
+// findFile returns the hash of the file at name in tree, or the zero hash if there is none.
+// A nil tree is empty.
+func findFile(store storer.EncodedObjectStorer, tree *object.Tree, name string) (plumbing.Hash, error) {
+	segments := strings.Split(name, "/")
+	for i, segment := range segments {
+		if tree == nil {
+			return plumbing.ZeroHash, nil
+		}
+		index := slices.IndexFunc(tree.Entries, func(e object.TreeEntry) bool { return e.Name == segment })
+		if index < 0 {
+			return plumbing.ZeroHash, nil
+		}
+		entry := tree.Entries[index]
+		if i == len(segments)-1 {
+			if !entry.Mode.IsFile() {
+				return plumbing.ZeroHash, nil
+			}
+			return entry.Hash, nil
+		}
+		if entry.Mode != filemode.Dir {
+			return plumbing.ZeroHash, nil
+		}
+		var err error
+		if tree, err = object.GetTree(store, entry.Hash); err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("getting tree %s: %w", entry.Hash, err)
+		}
+	}
+	return plumbing.ZeroHash, nil
+}
+
+// writeBlob stores content as a blob, returning its hash
+func writeBlob(store storer.EncodedObjectStorer, content []byte) (plumbing.Hash, error) {
+	blob := store.NewEncodedObject()
+	blob.SetType(plumbing.BlobObject)
+	blob.SetSize(int64(len(content)))
+
+	writer, err := blob.Writer()
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("getting blob writer: %w", err)
+	}
+	if _, err := writer.Write(content); err != nil {
+		writer.Close()
+		return plumbing.ZeroHash, fmt.Errorf("writing blob content: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("closing blob writer: %w", err)
+	}
+
+	hash, err := store.SetEncodedObject(blob)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("storing blob: %w", err)
+	}
+	return hash, nil
+}
+
+// updateTree stores a copy of tree with the file at segments set to blob, or removed if blob is the zero hash,
+// and returns its hash. A nil tree is empty. Directories left empty by a removal are removed too.
+func updateTree(store storer.EncodedObjectStorer, tree *object.Tree, segments []string, blob plumbing.Hash) (plumbing.Hash, error) {
+	entries, err := updateEntries(store, tree, segments, blob)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return writeTree(store, entries)
+}
+
+func updateEntries(store storer.EncodedObjectStorer, tree *object.Tree, segments []string, blob plumbing.Hash) ([]object.TreeEntry, error) {
+	var entries []object.TreeEntry
+	if tree != nil {
+		entries = slices.Clone(tree.Entries)
+	}
+	name := segments[0]
+	index := slices.IndexFunc(entries, func(e object.TreeEntry) bool { return e.Name == name })
+
+	entry := object.TreeEntry{Name: name, Mode: filemode.Regular, Hash: blob}
+	if len(segments) > 1 {
+		var subtree *object.Tree
+		if index >= 0 {
+			if entries[index].Mode != filemode.Dir {
+				return nil, conflictError(fmt.Errorf("a parent of /%s is a resource", strings.Join(segments, "/")))
+			}
+			var err error
+			if subtree, err = object.GetTree(store, entries[index].Hash); err != nil {
+				return nil, fmt.Errorf("getting tree %s: %w", entries[index].Hash, err)
+			}
+		}
+		subentries, err := updateEntries(store, subtree, segments[1:], blob)
+		if err != nil {
+			return nil, err
+		}
+		entry = object.TreeEntry{Name: name, Mode: filemode.Dir}
+		if len(subentries) > 0 {
+			if entry.Hash, err = writeTree(store, subentries); err != nil {
+				return nil, err
+			}
+		}
+	} else if index >= 0 && entries[index].Mode == filemode.Dir {
+		return nil, conflictError(errors.New("resource has the same path as a collection of resources"))
+	}
+
+	switch {
+	case entry.Hash == plumbing.ZeroHash && index >= 0:
+		entries = slices.Delete(entries, index, index+1)
+	case entry.Hash == plumbing.ZeroHash:
+	case index >= 0:
+		entries[index] = entry
+	default:
+		entries = append(entries, entry)
+		sortEntries(entries)
+	}
+	return entries, nil
+}
+
+// writeTree stores a tree of entries, returning its hash
+func writeTree(store storer.EncodedObjectStorer, entries []object.TreeEntry) (plumbing.Hash, error) {
+	encoded := store.NewEncodedObject()
+	if err := (&object.Tree{Entries: entries}).Encode(encoded); err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("encoding tree: %w", err)
+	}
+	hash, err := store.SetEncodedObject(encoded)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("storing tree: %w", err)
+	}
+	return hash, nil
+}
+
+// sortEntries sorts entries in Git's order, which compares the names of directories as if they ended in "/"
+func sortEntries(entries []object.TreeEntry) {
+	key := func(e object.TreeEntry) string {
+		if e.Mode == filemode.Dir {
+			return e.Name + "/"
+		}
+		return e.Name
+	}
+	slices.SortFunc(entries, func(a, b object.TreeEntry) int {
+		return strings.Compare(key(a), key(b))
+	})
+}
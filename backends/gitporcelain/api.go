@@ -4,17 +4,48 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io/fs"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/exec"
-	"runtime/trace"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
 	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
 )
 
-var _ gitbackedrest.APIBackend = (*Backend)(nil)
+var (
+	_ gitbackedrest.APIBackend        = (*Backend)(nil)
+	_ gitbackedrest.Lister            = (*Backend)(nil)
+	_ gitbackedrest.ConditionalWriter = (*Backend)(nil)
+)
+
+// errStaleRef is returned when a push is rejected because the remote has changed since the last pull
+var errStaleRef = errors.New("remote has changed since pull")
+
+// Option configures optional behavior of a Backend
+type Option func(*Backend)
+
+// WithLogger sets the logger used by the backend.
+// If not set, the default slog logger is used.
+func WithLogger(logger *slog.Logger) Option {
+	return func(b *Backend) {
+		b.logger = logger
+	}
+}
 
-func NewBackend(remote string, repoPath string) (*Backend, error) {
+// WithMetrics records fetch and push metrics for the backend.
+func WithMetrics(metrics *gitbackedrest.BackendMetrics) Option {
+	return func(b *Backend) {
+		b.metrics = metrics
+	}
+}
+
+func NewBackend(remote string, repoPath string, opts ...Option) (*Backend, error) {
 	if err := os.MkdirAll(repoPath, os.ModePerm); err != nil {
 		return nil, fmt.Errorf("creating repo path %s: %w", repoPath, err)
 	}
@@ -24,20 +55,56 @@ func NewBackend(remote string, repoPath string) (*Backend, error) {
 		return nil, fmt.Errorf("cloning repo %s: %w", remote, err)
 	}
 
-	return &Backend{
+	b := &Backend{
 		remote:   remote,
 		repoPath: repoPath,
-	}, nil
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b, nil
 }
 
 type Backend struct {
 	remote   string
 	repoPath string
+	logger   *slog.Logger
+	metrics  *gitbackedrest.BackendMetrics
+
+	// writeMtx serializes writes to the working copy, from pulling to pushing
+	writeMtx sync.Mutex
+
+	pushErrMtx      sync.Mutex
+	lastPushErr     error
+	lastPushErrTime time.Time
+}
+
+// log returns the backend's logger annotated with the request ID from ctx
+func (b *Backend) log(ctx context.Context) *slog.Logger {
+	return gitbackedrest.Logger(ctx, b.logger)
 }
 
 // DELETE implements gitbackedrest.APIBackend.
 func (b *Backend) DELETE(ctx context.Context, path string) (*gitbackedrest.Result, error) {
-	defer trace.StartRegion(ctx, "DELETE").End()
+	ctx, phase := gitbackedrest.StartPhase(ctx, b.logger, "DELETE")
+	defer phase.End()
+
+	return b.delete(ctx, path, "")
+}
+
+// DELETEIfMatch implements gitbackedrest.ConditionalWriter, checking ifMatch as PUTIfMatch does.
+func (b *Backend) DELETEIfMatch(ctx context.Context, path, ifMatch string) (*gitbackedrest.Result, error) {
+	ctx, phase := gitbackedrest.StartPhase(ctx, b.logger, "DELETE")
+	defer phase.End()
+
+	return b.delete(ctx, path, ifMatch)
+}
+
+// delete removes the file for path and pushes the change. If ifMatch isn't empty, the file is only
+// removed if its content matches it.
+func (b *Backend) delete(ctx context.Context, path, ifMatch string) (*gitbackedrest.Result, error) {
+	b.writeMtx.Lock()
+	defer b.writeMtx.Unlock()
 
 	if err := b.pull(ctx); err != nil {
 		return nil, gitbackedrest.NewUserError(
@@ -77,6 +144,10 @@ func (b *Backend) DELETE(ctx context.Context, path string) (*gitbackedrest.Resul
 		)
 	}
 
+	if err := checkIfMatch(filePath, ifMatch); err != nil {
+		return nil, err
+	}
+
 	if err := os.Remove(filePath); err != nil {
 		return nil, gitbackedrest.NewUserError(
 			"Internal Server Error",
@@ -88,13 +159,7 @@ func (b *Backend) DELETE(ctx context.Context, path string) (*gitbackedrest.Resul
 	}
 
 	if err := b.commitAndPush(ctx, fmt.Sprintf("delete %s", path)); err != nil {
-		return nil, gitbackedrest.NewUserError(
-			"Internal Server Error",
-			gitbackedrest.NewHTTPError(
-				http.StatusInternalServerError,
-				fmt.Errorf("committing and pushing: %w", err),
-			),
-		)
+		return nil, pushError(err, ifMatch)
 	}
 
 	return &gitbackedrest.Result{
@@ -104,7 +169,8 @@ func (b *Backend) DELETE(ctx context.Context, path string) (*gitbackedrest.Resul
 
 // GET implements gitbackedrest.APIBackend.
 func (b *Backend) GET(ctx context.Context, path string) (*gitbackedrest.GetResult, error) {
-	defer trace.StartRegion(ctx, "GET").End()
+	ctx, phase := gitbackedrest.StartPhase(ctx, b.logger, "GET")
+	defer phase.End()
 
 	if err := b.pull(ctx); err != nil {
 		return nil, gitbackedrest.NewUserError(
@@ -163,7 +229,11 @@ func (b *Backend) GET(ctx context.Context, path string) (*gitbackedrest.GetResul
 
 // POST implements gitbackedrest.APIBackend.
 func (b *Backend) POST(ctx context.Context, path string, body []byte) (*gitbackedrest.Result, error) {
-	defer trace.StartRegion(ctx, "POST").End()
+	ctx, phase := gitbackedrest.StartPhase(ctx, b.logger, "POST")
+	defer phase.End()
+
+	b.writeMtx.Lock()
+	defer b.writeMtx.Unlock()
 
 	if err := b.pull(ctx); err != nil {
 		return nil, gitbackedrest.NewUserError(
@@ -223,7 +293,27 @@ func (b *Backend) POST(ctx context.Context, path string, body []byte) (*gitbacke
 
 // PUT implements gitbackedrest.APIBackend.
 func (b *Backend) PUT(ctx context.Context, path string, body []byte) (*gitbackedrest.Result, error) {
-	defer trace.StartRegion(ctx, "PUT").End()
+	ctx, phase := gitbackedrest.StartPhase(ctx, b.logger, "PUT")
+	defer phase.End()
+
+	return b.put(ctx, path, body, "")
+}
+
+// PUTIfMatch implements gitbackedrest.ConditionalWriter. ifMatch is checked against the working copy after
+// pulling, and the commit is pushed on top of the commit pulled, so the push is rejected if the remote has
+// changed since the check.
+func (b *Backend) PUTIfMatch(ctx context.Context, path string, body []byte, ifMatch string) (*gitbackedrest.Result, error) {
+	ctx, phase := gitbackedrest.StartPhase(ctx, b.logger, "PUT")
+	defer phase.End()
+
+	return b.put(ctx, path, body, ifMatch)
+}
+
+// put replaces the file for path and pushes the change. If ifMatch isn't empty, the file is only
+// replaced if its content matches it.
+func (b *Backend) put(ctx context.Context, path string, body []byte, ifMatch string) (*gitbackedrest.Result, error) {
+	b.writeMtx.Lock()
+	defer b.writeMtx.Unlock()
 
 	if err := b.pull(ctx); err != nil {
 		return nil, gitbackedrest.NewUserError(
@@ -263,6 +353,10 @@ func (b *Backend) PUT(ctx context.Context, path string, body []byte) (*gitbacked
 		)
 	}
 
+	if err := checkIfMatch(filePath, ifMatch); err != nil {
+		return nil, err
+	}
+
 	if err := os.WriteFile(filePath, body, os.ModePerm); err != nil {
 		return nil, gitbackedrest.NewUserError(
 			"Internal Server Error",
@@ -274,25 +368,111 @@ func (b *Backend) PUT(ctx context.Context, path string, body []byte) (*gitbacked
 	}
 
 	if err := b.commitAndPush(ctx, fmt.Sprintf("write %s", path)); err != nil {
+		return nil, pushError(err, ifMatch)
+	}
+
+	return &gitbackedrest.Result{
+		Retries: 0, // GitPorcelain doesn't retry
+	}, nil
+}
+
+// List implements gitbackedrest.Lister, walking the working copy after pulling.
+func (b *Backend) List(ctx context.Context, prefix string) ([]string, error) {
+	ctx, phase := gitbackedrest.StartPhase(ctx, b.logger, "List")
+	defer phase.End()
+
+	if err := b.pull(ctx); err != nil {
 		return nil, gitbackedrest.NewUserError(
 			"Internal Server Error",
 			gitbackedrest.NewHTTPError(
 				http.StatusInternalServerError,
-				fmt.Errorf("committing and pushing: %w", err),
+				fmt.Errorf("pulling: %w", err),
 			),
 		)
 	}
 
-	return &gitbackedrest.Result{
-		Retries: 0, // GitPorcelain doesn't retry
-	}, nil
+	var paths []string
+	err := filepath.WalkDir(b.repoPath, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if entry.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(b.repoPath, filePath)
+		if err != nil {
+			return err
+		}
+		if path := "/" + filepath.ToSlash(rel); strings.HasPrefix(path, prefix) {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, gitbackedrest.NewUserError(
+			"Internal Server Error",
+			gitbackedrest.NewHTTPError(
+				http.StatusInternalServerError,
+				fmt.Errorf("walking working copy: %w", err),
+			),
+		)
+	}
+	slices.Sort(paths)
+	return paths, nil
+}
+
+// checkIfMatch checks ifMatch against the content of the existing file at filePath, if ifMatch isn't empty
+func checkIfMatch(filePath, ifMatch string) error {
+	if ifMatch == "" {
+		return nil
+	}
+	current, err := os.ReadFile(filePath)
+	if err != nil {
+		return gitbackedrest.NewUserError(
+			"Internal Server Error",
+			gitbackedrest.NewHTTPError(
+				http.StatusInternalServerError,
+				fmt.Errorf("reading file: %w", err),
+			),
+		)
+	}
+	return gitbackedrest.CheckIfMatch(ifMatch, current, true)
+}
+
+// pushError reports a failure to commit and push a write. A conditional write whose push was rejected because
+// the remote changed may have been overtaken by another write to the resource, so it is reported as a conflict.
+func pushError(err error, ifMatch string) error {
+	if ifMatch != "" && errors.Is(err, errStaleRef) {
+		return gitbackedrest.NewUserError(
+			"Conflict",
+			gitbackedrest.NewHTTPError(
+				http.StatusConflict,
+				fmt.Errorf("committing and pushing: %w", err),
+			),
+		)
+	}
+	return gitbackedrest.NewUserError(
+		"Internal Server Error",
+		gitbackedrest.NewHTTPError(
+			http.StatusInternalServerError,
+			fmt.Errorf("committing and pushing: %w", err),
+		),
+	)
 }
 
 func (b *Backend) pull(ctx context.Context) error {
-	defer trace.StartRegion(ctx, "pull").End()
+	ctx, phase := gitbackedrest.StartPhase(ctx, b.logger, "pull")
+	defer phase.End()
 
 	cmd := b.gitCommand(ctx, "pull")
-	if err := cmd.Run(); err != nil {
+	start := time.Now()
+	err := cmd.Run()
+	b.metrics.ObserveOperation(metricsBackend, gitbackedrest.OperationFetch, start, err)
+	if err != nil {
+		b.log(ctx).WarnContext(ctx, "git pull failed", "repo", b.repoPath, "error", err)
 		return fmt.Errorf("pulling: %w", err)
 	}
 	return nil
@@ -305,7 +485,8 @@ func (b *Backend) gitCommand(ctx context.Context, args ...string) *exec.Cmd {
 }
 
 func (b *Backend) commitAndPush(ctx context.Context, message string) error {
-	defer trace.StartRegion(ctx, "commitAndPush").End()
+	ctx, phase := gitbackedrest.StartPhase(ctx, b.logger, "commitAndPush")
+	defer phase.End()
 
 	cmd := b.gitCommand(ctx, "add", "--all")
 	if err := cmd.Run(); err != nil {
@@ -316,12 +497,41 @@ func (b *Backend) commitAndPush(ctx context.Context, message string) error {
 		return fmt.Errorf("committing: %w", err)
 	}
 	cmd = b.gitCommand(ctx, "push")
-	if err := cmd.Run(); err != nil {
+	start := time.Now()
+	output, err := cmd.CombinedOutput()
+	b.metrics.ObserveOperation(metricsBackend, gitbackedrest.OperationPush, start, err)
+	if err != nil {
+		b.metrics.PushRejected(metricsBackend, pushRejectionReason(string(output)))
+		b.recordPushError(fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output))))
+		b.log(ctx).WarnContext(ctx, "git push failed", "repo", b.repoPath, "error", err, "output", string(output))
+
+		// Drop the unpushed commit, so the next pull fast-forwards rather than merging it
+		if resetOutput, resetErr := b.gitCommand(ctx, "reset", "--hard", "@{upstream}").CombinedOutput(); resetErr != nil {
+			b.log(ctx).ErrorContext(ctx, "git reset failed", "repo", b.repoPath, "error", resetErr, "output", string(resetOutput))
+		}
+		if pushRejectionReason(string(output)) == "stale_ref" {
+			return fmt.Errorf("pushing: %w: %w", errStaleRef, err)
+		}
 		return fmt.Errorf("pushing: %w", err)
 	}
+	b.log(ctx).DebugContext(ctx, "pushed commit", "repo", b.repoPath, "message", message)
 	return nil
 }
 
 func (b *Backend) Close() error {
 	return nil
 }
+
+// metricsBackend is the value of the "backend" label for this backend's metrics
+const metricsBackend = "gitporcelain"
+
+// pushRejectionReason classifies the output of a failed git push for the push rejections metric.
+// Reasons are one of stale_ref or other. A remote that fails to lock the branch because it has
+// just moved, as happens with concurrent pushes, is also a stale ref.
+func pushRejectionReason(output string) string {
+	if strings.Contains(output, "non-fast-forward") || strings.Contains(output, "fetch first") ||
+		strings.Contains(output, "cannot lock ref") {
+		return "stale_ref"
+	}
+	return "other"
+}
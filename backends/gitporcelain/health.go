@@ -0,0 +1,63 @@
+package gitporcelain
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+var (
+	_ gitbackedrest.HealthChecker = (*Backend)(nil)
+	_ gitbackedrest.Diagnoser     = (*Backend)(nil)
+)
+
+// CheckHealth implements gitbackedrest.HealthChecker by checking the local clone has a valid HEAD.
+func (b *Backend) CheckHealth(ctx context.Context) error {
+	_, err := b.currentRef(ctx)
+	return err
+}
+
+// Diagnostics implements gitbackedrest.Diagnoser.
+// It reports the current hash of HEAD in the local clone and the last push error.
+func (b *Backend) Diagnostics(ctx context.Context) map[string]any {
+	diagnostics := map[string]any{
+		"remote":    b.remote,
+		"repo_path": b.repoPath,
+	}
+
+	if hash, err := b.currentRef(ctx); err != nil {
+		diagnostics["ref_error"] = err.Error()
+	} else {
+		diagnostics["ref"] = hash
+	}
+
+	b.pushErrMtx.Lock()
+	if b.lastPushErr != nil {
+		diagnostics["last_push_error"] = b.lastPushErr.Error()
+		diagnostics["last_push_error_time"] = b.lastPushErrTime
+	}
+	b.pushErrMtx.Unlock()
+
+	return diagnostics
+}
+
+// currentRef returns the hash of HEAD in the local clone
+func (b *Backend) currentRef(ctx context.Context) (string, error) {
+	output, err := b.gitCommand(ctx, "rev-parse", "--verify", "HEAD").Output()
+	if err != nil {
+		return "", fmt.Errorf("checking repo %s: %w", b.repoPath, err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// recordPushError records a failed push for diagnostics
+func (b *Backend) recordPushError(err error) {
+	b.pushErrMtx.Lock()
+	defer b.pushErrMtx.Unlock()
+
+	b.lastPushErr = err
+	b.lastPushErrTime = time.Now()
+}
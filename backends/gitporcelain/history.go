@@ -0,0 +1,111 @@
+package gitporcelain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+var _ gitbackedrest.Historian = (*Backend)(nil)
+
+// commitPattern matches abbreviated or full commit hashes, so versions can't be interpreted as git options or revisions
+var commitPattern = regexp.MustCompile(`^[0-9a-f]{4,64}$`)
+
+// History implements gitbackedrest.Historian using the commit log of the file.
+func (b *Backend) History(ctx context.Context, path string) ([]gitbackedrest.Version, error) {
+	ctx, phase := gitbackedrest.StartPhase(ctx, b.logger, "History")
+	defer phase.End()
+
+	if err := b.pull(ctx); err != nil {
+		return nil, historyError(fmt.Errorf("pulling: %w", err))
+	}
+
+	// Each commit is a record of unit-separated fields, followed by the file's status in that commit
+	output, err := b.gitCommand(ctx, "log", "--format=%x1e%H%x1f%aI%x1f%an%x1f%s", "--name-status", "--", strings.TrimPrefix(path, "/")).Output()
+	if err != nil {
+		return nil, historyError(fmt.Errorf("reading log: %w", err))
+	}
+
+	var versions []gitbackedrest.Version
+	for record := range strings.SplitSeq(string(output), "\x1e") {
+		if strings.TrimSpace(record) == "" {
+			continue
+		}
+		header, status, _ := strings.Cut(record, "\n")
+		fields := strings.Split(header, "\x1f")
+		if len(fields) != 4 {
+			return nil, historyError(fmt.Errorf("unexpected log entry: %q", header))
+		}
+		when, err := time.Parse(time.RFC3339, fields[1])
+		if err != nil {
+			return nil, historyError(fmt.Errorf("parsing commit time: %w", err))
+		}
+		versions = append(versions, gitbackedrest.Version{
+			ID:      fields[0],
+			Time:    when,
+			Author:  fields[2],
+			Message: fields[3],
+			Deleted: strings.HasPrefix(strings.TrimSpace(status), "D"),
+		})
+	}
+	return versions, nil
+}
+
+// GETVersion implements gitbackedrest.Historian, reading the file as of a commit.
+func (b *Backend) GETVersion(ctx context.Context, path, version string) (*gitbackedrest.GetResult, error) {
+	ctx, phase := gitbackedrest.StartPhase(ctx, b.logger, "GETVersion")
+	defer phase.End()
+
+	if !commitPattern.MatchString(version) {
+		return nil, gitbackedrest.NewUserError(
+			"Invalid version",
+			gitbackedrest.NewHTTPError(
+				http.StatusBadRequest,
+				fmt.Errorf("not a commit hash: %q", version),
+			),
+		)
+	}
+	if err := b.pull(ctx); err != nil {
+		return nil, historyError(fmt.Errorf("pulling: %w", err))
+	}
+
+	if err := b.gitCommand(ctx, "cat-file", "-e", version+"^{commit}").Run(); err != nil {
+		return nil, gitbackedrest.NewUserError(
+			"Version not found",
+			gitbackedrest.NewHTTPError(
+				http.StatusNotFound,
+				fmt.Errorf("commit %s not found: %w", version, err),
+			),
+		)
+	}
+	data, err := b.gitCommand(ctx, "show", version+":"+strings.TrimPrefix(path, "/")).Output()
+	if err != nil {
+		return nil, gitbackedrest.NewUserError(
+			"Not Found",
+			gitbackedrest.NewHTTPError(
+				http.StatusNotFound,
+				errors.New("resource not found in version"),
+			),
+		)
+	}
+	return &gitbackedrest.GetResult{
+		Data:    data,
+		Retries: 0, // GitPorcelain doesn't retry
+	}, nil
+}
+
+func historyError(err error) error {
+	return gitbackedrest.NewUserError(
+		"Internal Server Error",
+		gitbackedrest.NewHTTPError(
+			http.StatusInternalServerError,
+			fmt.Errorf("reading history: %w", err),
+		),
+	)
+}
@@ -0,0 +1,165 @@
+package gitporcelain
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+// createLocalRemote creates a bare repository with an initial commit, returning its path
+func createLocalRemote(t *testing.T) string {
+	t.Helper()
+
+	for _, key := range []string{"GIT_AUTHOR_NAME", "GIT_COMMITTER_NAME"} {
+		t.Setenv(key, "git-backed-rest")
+	}
+	for _, key := range []string{"GIT_AUTHOR_EMAIL", "GIT_COMMITTER_EMAIL"} {
+		t.Setenv(key, "no-reply@telliott.me")
+	}
+
+	remote := filepath.Join(t.TempDir(), "remote.git")
+	seed := filepath.Join(t.TempDir(), "seed")
+	for _, args := range [][]string{
+		{"init", "--bare", "--initial-branch=main", remote},
+		{"clone", remote, seed},
+		{"-C", seed, "commit", "--allow-empty", "-m", "initial commit"},
+		{"-C", seed, "push", "origin", "HEAD:main"},
+	} {
+		if output, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, output)
+		}
+	}
+	return remote
+}
+
+func TestHistory(t *testing.T) {
+	ctx := t.Context()
+
+	backend, err := NewBackend(createLocalRemote(t), filepath.Join(t.TempDir(), "clone"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+
+	versions, err := backend.History(ctx, "/doc1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 0 {
+		t.Fatalf("expected no versions for a new resource, got %v", versions)
+	}
+
+	if _, err := backend.POST(ctx, "/doc1", []byte("content1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.PUT(ctx, "/doc1", []byte("content2")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.DELETE(ctx, "/doc1"); err != nil {
+		t.Fatal(err)
+	}
+
+	versions, err = backend.History(ctx, "/doc1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 3 {
+		t.Fatalf("expected 3 versions, got %v", versions)
+	}
+	if !versions[0].Deleted || versions[1].Deleted || versions[2].Deleted {
+		t.Errorf("expected only the newest version to be a deletion, got %v", versions)
+	}
+
+	for i, expected := range []string{"", "content2", "content1"} {
+		result, err := backend.GETVersion(ctx, "/doc1", versions[i].ID)
+		if expected == "" {
+			if !errors.Is(err, gitbackedrest.ErrNotFound) {
+				t.Errorf("expected not found for the deleted version, got %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(result.Data) != expected {
+			t.Errorf("version %d: expected %q, got %q", i, expected, result.Data)
+		}
+	}
+
+	if _, err := backend.GETVersion(ctx, "/doc1", "--output=/tmp/x"); gitbackedrest.GetHTTPStatusCode(err, 0) != 400 {
+		t.Errorf("expected bad request for an invalid version, got %v", err)
+	}
+}
+
+func TestConditionalWrites(t *testing.T) {
+	ctx := t.Context()
+
+	// Two replicas sharing a remote
+	remote := createLocalRemote(t)
+	var replicas []*Backend
+	for range 2 {
+		backend, err := NewBackend(remote, filepath.Join(t.TempDir(), "clone"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer backend.Close()
+		replicas = append(replicas, backend)
+	}
+	a, b := replicas[0], replicas[1]
+
+	if _, err := a.POST(ctx, "/doc", []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.PUTIfMatch(ctx, "/doc", []byte("v2"), gitbackedrest.ETag([]byte("v1"))); err != nil {
+		t.Fatal(err)
+	}
+	// The other replica's write is seen, even though this replica last read v1
+	if _, err := a.PUTIfMatch(ctx, "/doc", []byte("v3"), gitbackedrest.ETag([]byte("v1"))); !errors.Is(err, gitbackedrest.ErrPreconditionFailed) {
+		t.Errorf("expected precondition failure for a stale tag, got %v", err)
+	}
+	if _, err := a.DELETEIfMatch(ctx, "/missing", "*"); !errors.Is(err, gitbackedrest.ErrNotFound) {
+		t.Errorf("expected not found deleting a missing resource, got %v", err)
+	}
+
+	// Concurrent writes with the same tag from both replicas: only one succeeds
+	var wg sync.WaitGroup
+	errs := make([]error, len(replicas))
+	for i, backend := range replicas {
+		wg.Go(func() {
+			_, errs[i] = backend.PUTIfMatch(ctx, "/doc", fmt.Appendf(nil, "from %d", i), gitbackedrest.ETag([]byte("v2")))
+		})
+	}
+	wg.Wait()
+	var succeeded int
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			succeeded++
+		case !gitbackedrest.HasHTTPStatusCode(err, http.StatusPreconditionFailed, http.StatusConflict):
+			t.Errorf("expected the losing write to fail with 412 or 409, got %v", err)
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("expected exactly one write to succeed, got %d", succeeded)
+	}
+
+	// Both replicas can still write once a push has been rejected
+	for _, backend := range replicas {
+		result, err := backend.GET(ctx, "/doc")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := backend.DELETEIfMatch(ctx, "/doc", gitbackedrest.ETag(result.Data)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := backend.POST(ctx, "/doc", result.Data); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package gitporcelain
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+// schemePrefix is prepended to a Git remote's scheme to form a backend URL scheme,
+// e.g. gitcli+file:///srv/repos/config.git
+const schemePrefix = "gitcli+"
+
+func init() {
+	for _, scheme := range []string{"https", "http", "ssh", "file"} {
+		gitbackedrest.Register(schemePrefix+scheme, open)
+	}
+}
+
+// open clones the remote given by a gitcli+<transport> URL. The working copy is created at the
+// path query parameter, or in a new temporary directory if it is not set.
+func open(ctx context.Context, u *url.URL, cfg gitbackedrest.OpenConfig) (gitbackedrest.APIBackend, error) {
+	query := u.Query()
+	repoPath := query.Get("path")
+	if repoPath == "" {
+		var err error
+		if repoPath, err = os.MkdirTemp("", "gitbackedrest-"); err != nil {
+			return nil, fmt.Errorf("creating working copy directory: %w", err)
+		}
+	}
+
+	remote := *u
+	remote.Scheme = strings.TrimPrefix(u.Scheme, schemePrefix)
+	query.Del("path")
+	remote.RawQuery = query.Encode()
+
+	return NewBackend(remote.String(), repoPath,
+		WithLogger(cfg.Logger),
+		WithMetrics(cfg.Metrics),
+	)
+}
@@ -4,8 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
-	"runtime/trace"
 	"sync"
 	"time"
 
@@ -20,10 +20,67 @@ import (
 	_ "github.com/go-git/go-git/v6/plumbing/transport/ssh"
 )
 
-var _ gitbackedrest.APIBackend = (*Backend)(nil)
+var (
+	_ gitbackedrest.APIBackend        = (*Backend)(nil)
+	_ gitbackedrest.ConditionalWriter = (*Backend)(nil)
+)
+
+// Option configures optional behavior of a Backend
+type Option func(*Backend)
+
+// WithLogger sets the logger used by the backend.
+// If not set, the default slog logger is used.
+func WithLogger(logger *slog.Logger) Option {
+	return func(b *Backend) {
+		b.logger = logger
+	}
+}
+
+// WithMetrics records fetch, push and cache metrics for the backend.
+func WithMetrics(metrics *gitbackedrest.BackendMetrics) Option {
+	return func(b *Backend) {
+		b.metrics = metrics
+	}
+}
+
+// WithBranch sets the branch resources are read from and committed to.
+// If not set, "main" is used.
+func WithBranch(branch string) Option {
+	return func(b *Backend) {
+		b.branch = branch
+	}
+}
 
-func NewBackend(endpoint string) (*Backend, error) {
-	return NewBackendWithAuth(endpoint, nil)
+// RetryPolicy controls how writes are retried when a push is rejected because the branch has moved.
+// Zero values use the defaults of backoff.ExponentialBackOff, and no limit on the number of tries.
+type RetryPolicy struct {
+	// InitialInterval is the delay before the first retry
+	InitialInterval time.Duration
+	// MaxInterval caps the delay between retries
+	MaxInterval time.Duration
+	// MaxElapsedTime is the total time after which a write fails
+	MaxElapsedTime time.Duration
+	// MaxTries is the maximum number of attempts, including the first
+	MaxTries uint
+}
+
+// WithRetryPolicy sets the policy for retrying writes.
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(b *Backend) {
+		b.retry = policy
+	}
+}
+
+// WithCacheInterval sets how often objects fetched into the backend's in-memory store are discarded.
+// If not set, the store is cleared every 10 seconds.
+func WithCacheInterval(interval time.Duration) Option {
+	return func(b *Backend) {
+		b.cacheInterval = interval
+	}
+}
+
+func NewBackend(endpoint string, opts ...Option) (*Backend, error) {
+	return NewBackendWithAuth(endpoint, nil, opts...)
 }
 
 // NewBackendWithAuth creates a new Backend with authentication.
@@ -32,7 +89,7 @@ func NewBackend(endpoint string) (*Backend, error) {
 //     (GitHub, GitLab, and Bitbucket use BasicAuth with tokens as the password)
 //   - *http.TokenAuth for bearer token authentication
 //   - nil for no authentication
-func NewBackendWithAuth(endpoint string, auth transport.AuthMethod) (*Backend, error) {
+func NewBackendWithAuth(endpoint string, auth transport.AuthMethod, opts ...Option) (*Backend, error) {
 	ep, err := transport.NewEndpoint(endpoint)
 	if err != nil {
 		return nil, fmt.Errorf("creating transport endpoint: %w", err)
@@ -44,12 +101,18 @@ func NewBackendWithAuth(endpoint string, auth transport.AuthMethod) (*Backend, e
 	}
 
 	b := &Backend{
-		endpoint:   endpoint,
-		auth:       auth,
-		lockWrites: true,
+		endpoint:      endpoint,
+		auth:          auth,
+		lockWrites:    true,
+		branch:        "main",
+		cacheInterval: 10 * time.Second,
 
 		ep:        ep,
 		transport: c,
+		closed:    make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(b)
 	}
 
 	err = b.newSession()
@@ -75,17 +138,32 @@ func NewBackendWithAuth(endpoint string, auth transport.AuthMethod) (*Backend, e
 type Backend struct {
 	endpoint string
 	auth     transport.AuthMethod
+	logger   *slog.Logger
+	metrics  *gitbackedrest.BackendMetrics
+
+	branch        string
+	retry         RetryPolicy
+	cacheInterval time.Duration
 
 	transport transport.Transport
 	ep        *transport.Endpoint
 	storeMtx  sync.Mutex
 	store     *memory.Storage
 
-	session    transport.Session
-	sessionMtx sync.RWMutex
+	session      transport.Session
+	sessionStart time.Time
+	sessionMtx   sync.RWMutex
+
+	pushErrMtx      sync.Mutex
+	lastPushErr     error
+	lastPushErrTime time.Time
 
 	writeMtx   sync.Mutex
 	lockWrites bool
+
+	// closed stops the cache cleanup loop when the backend is closed
+	closed    chan struct{}
+	closeOnce sync.Once
 }
 
 func (b *Backend) newSession() error {
@@ -101,10 +179,19 @@ func (b *Backend) newSession() error {
 
 	b.store = store
 	b.session = sess
+	b.sessionStart = time.Now()
 
 	go func() {
-		// Clean up objects every 10s
-		for range time.Tick(10 * time.Second) {
+		ticker := time.NewTicker(b.cacheInterval)
+		defer ticker.Stop()
+
+		// Clean up objects periodically
+		for {
+			select {
+			case <-b.closed:
+				return
+			case <-ticker.C:
+			}
 			b.sessionMtx.Lock()
 
 			// Clear storage objects
@@ -122,6 +209,31 @@ func (b *Backend) newSession() error {
 	return nil
 }
 
+// retryOptions returns the options for retrying writes according to the backend's retry policy
+func (b *Backend) retryOptions() []backoff.RetryOption {
+	policy := backoff.NewExponentialBackOff()
+	if b.retry.InitialInterval > 0 {
+		policy.InitialInterval = b.retry.InitialInterval
+	}
+	if b.retry.MaxInterval > 0 {
+		policy.MaxInterval = b.retry.MaxInterval
+	}
+
+	opts := []backoff.RetryOption{backoff.WithBackOff(policy)}
+	if b.retry.MaxElapsedTime > 0 {
+		opts = append(opts, backoff.WithMaxElapsedTime(b.retry.MaxElapsedTime))
+	}
+	if b.retry.MaxTries > 0 {
+		opts = append(opts, backoff.WithMaxTries(b.retry.MaxTries))
+	}
+	return opts
+}
+
+// log returns the backend's logger annotated with the request ID from ctx
+func (b *Backend) log(ctx context.Context) *slog.Logger {
+	return gitbackedrest.Logger(ctx, b.logger)
+}
+
 // GetEndpoint returns the endpoint used by the backend.
 func (b *Backend) GetEndpoint() string {
 	return b.endpoint
@@ -129,8 +241,22 @@ func (b *Backend) GetEndpoint() string {
 
 // DELETE implements gitbackedrest.APIBackend.
 func (b *Backend) DELETE(ctx context.Context, path string) (*gitbackedrest.Result, error) {
-	defer trace.StartRegion(ctx, "DELETE").End()
+	ctx, phase := gitbackedrest.StartPhase(ctx, b.logger, "DELETE")
+	defer phase.End()
 
+	return b.delete(ctx, path, "")
+}
+
+// DELETEIfMatch implements gitbackedrest.ConditionalWriter.
+func (b *Backend) DELETEIfMatch(ctx context.Context, path, ifMatch string) (*gitbackedrest.Result, error) {
+	ctx, phase := gitbackedrest.StartPhase(ctx, b.logger, "DELETE")
+	defer phase.End()
+
+	return b.delete(ctx, path, ifMatch)
+}
+
+// delete removes the file at path, if it matches ifMatch, retrying if the branch moves
+func (b *Backend) delete(ctx context.Context, path, ifMatch string) (*gitbackedrest.Result, error) {
 	b.sessionMtx.RLock()
 	defer b.sessionMtx.RUnlock()
 
@@ -142,20 +268,20 @@ func (b *Backend) DELETE(ctx context.Context, path string) (*gitbackedrest.Resul
 	retries := -1
 	operation := func() (plumbing.Hash, error) {
 		retries++
-		commit, err := b.updateFile(ctx, path, nil, false)
+		commit, err := b.updateFile(ctx, path, nil, false, ifMatch)
 		if err != nil {
-			if gitbackedrest.HasHTTPStatusCode(err, http.StatusNotFound, http.StatusInternalServerError) {
+			if gitbackedrest.HasHTTPStatusCode(err, http.StatusNotFound, http.StatusPreconditionFailed, http.StatusInternalServerError) {
 				return plumbing.ZeroHash, backoff.Permanent(err)
 			}
-			fmt.Println("Error, will retry:", err)
+			b.log(ctx).WarnContext(ctx, "write failed, will retry", "path", path, "attempt", retries+1, "error", err)
 			return plumbing.ZeroHash, err
 		}
 		return commit, nil
 	}
 
-	_, err := backoff.Retry(ctx, operation, backoff.WithBackOff(backoff.NewExponentialBackOff()))
+	_, err := backoff.Retry(ctx, operation, b.retryOptions()...)
 	if err != nil {
-		if gitbackedrest.HasHTTPStatusCode(err, http.StatusNotFound) {
+		if gitbackedrest.HasHTTPStatusCode(err, http.StatusNotFound, http.StatusPreconditionFailed) {
 			return nil, err
 		}
 		return nil, gitbackedrest.NewUserError(
@@ -174,7 +300,8 @@ func (b *Backend) DELETE(ctx context.Context, path string) (*gitbackedrest.Resul
 
 // GET implements gitbackedrest.APIBackend.
 func (b *Backend) GET(ctx context.Context, path string) (*gitbackedrest.GetResult, error) {
-	defer trace.StartRegion(ctx, "GET").End()
+	ctx, phase := gitbackedrest.StartPhase(ctx, b.logger, "GET")
+	defer phase.End()
 
 	b.sessionMtx.RLock()
 	defer b.sessionMtx.RUnlock()
@@ -206,7 +333,8 @@ func (b *Backend) GET(ctx context.Context, path string) (*gitbackedrest.GetResul
 
 // POST implements gitbackedrest.APIBackend.
 func (b *Backend) POST(ctx context.Context, path string, body []byte) (*gitbackedrest.Result, error) {
-	defer trace.StartRegion(ctx, "POST").End()
+	ctx, phase := gitbackedrest.StartPhase(ctx, b.logger, "POST")
+	defer phase.End()
 
 	b.sessionMtx.RLock()
 	defer b.sessionMtx.RUnlock()
@@ -219,17 +347,18 @@ func (b *Backend) POST(ctx context.Context, path string, body []byte) (*gitbacke
 	retries := -1
 	operation := func() (plumbing.Hash, error) {
 		retries++
-		commit, err := b.updateFile(ctx, path, body, true)
+		commit, err := b.updateFile(ctx, path, body, true, "")
 		if err != nil {
 			if gitbackedrest.HasHTTPStatusCode(err, http.StatusConflict, http.StatusInternalServerError) {
 				return plumbing.ZeroHash, backoff.Permanent(err)
 			}
+			b.log(ctx).WarnContext(ctx, "write failed, will retry", "path", path, "attempt", retries+1, "error", err)
 			return plumbing.ZeroHash, err
 		}
 		return commit, nil
 	}
 
-	_, err := backoff.Retry(ctx, operation, backoff.WithBackOff(backoff.NewExponentialBackOff()))
+	_, err := backoff.Retry(ctx, operation, b.retryOptions()...)
 	if err != nil {
 		if gitbackedrest.HasHTTPStatusCode(err, http.StatusConflict) {
 			return nil, err
@@ -250,8 +379,22 @@ func (b *Backend) POST(ctx context.Context, path string, body []byte) (*gitbacke
 
 // PUT implements gitbackedrest.APIBackend.
 func (b *Backend) PUT(ctx context.Context, path string, body []byte) (*gitbackedrest.Result, error) {
-	defer trace.StartRegion(ctx, "PUT").End()
+	ctx, phase := gitbackedrest.StartPhase(ctx, b.logger, "PUT")
+	defer phase.End()
 
+	return b.put(ctx, path, body, "")
+}
+
+// PUTIfMatch implements gitbackedrest.ConditionalWriter.
+func (b *Backend) PUTIfMatch(ctx context.Context, path string, body []byte, ifMatch string) (*gitbackedrest.Result, error) {
+	ctx, phase := gitbackedrest.StartPhase(ctx, b.logger, "PUT")
+	defer phase.End()
+
+	return b.put(ctx, path, body, ifMatch)
+}
+
+// put replaces the file at path, if it matches ifMatch, retrying if the branch moves
+func (b *Backend) put(ctx context.Context, path string, body []byte, ifMatch string) (*gitbackedrest.Result, error) {
 	b.sessionMtx.RLock()
 	defer b.sessionMtx.RUnlock()
 
@@ -263,20 +406,20 @@ func (b *Backend) PUT(ctx context.Context, path string, body []byte) (*gitbacked
 	retries := -1
 	operation := func() (plumbing.Hash, error) {
 		retries++
-		commit, err := b.updateFile(ctx, path, body, false)
+		commit, err := b.updateFile(ctx, path, body, false, ifMatch)
 		if err != nil {
-			if gitbackedrest.HasHTTPStatusCode(err, http.StatusNotFound, http.StatusInternalServerError) {
+			if gitbackedrest.HasHTTPStatusCode(err, http.StatusNotFound, http.StatusPreconditionFailed, http.StatusInternalServerError) {
 				return plumbing.ZeroHash, backoff.Permanent(err)
 			}
-			fmt.Println("Error, will retry:", err)
+			b.log(ctx).WarnContext(ctx, "write failed, will retry", "path", path, "attempt", retries+1, "error", err)
 			return plumbing.ZeroHash, err
 		}
 		return commit, nil
 	}
 
-	_, err := backoff.Retry(ctx, operation, backoff.WithBackOff(backoff.NewExponentialBackOff()))
+	_, err := backoff.Retry(ctx, operation, b.retryOptions()...)
 	if err != nil {
-		if gitbackedrest.HasHTTPStatusCode(err, http.StatusNotFound) {
+		if gitbackedrest.HasHTTPStatusCode(err, http.StatusNotFound, http.StatusPreconditionFailed) {
 			return nil, err
 		}
 		return nil, gitbackedrest.NewUserError(
@@ -293,6 +436,15 @@ func (b *Backend) PUT(ctx context.Context, path string, body []byte) (*gitbacked
 	}, nil
 }
 
+// Close waits for in-progress operations to finish, then stops the backend's background cache cleanup.
+// Operations should not be started after Close is called.
 func (b *Backend) Close() error {
+	b.closeOnce.Do(func() {
+		// Operations hold a read lock on the session for their duration
+		b.sessionMtx.Lock()
+		defer b.sessionMtx.Unlock()
+
+		close(b.closed)
+	})
 	return nil
 }
@@ -5,9 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
-	"runtime/trace"
 	"sort"
 	"strings"
 	"time"
@@ -55,7 +53,10 @@ func (b *Backend) simpleGET(ctx context.Context, path string) ([]byte, error) {
 	return b.readBlob(blob)
 }
 
-func (b *Backend) updateFile(ctx context.Context, path string, body []byte, mustNotExist bool) (plumbing.Hash, error) {
+// updateFile commits a change to the file at path on top of the branch and pushes it. If ifMatch isn't empty,
+// the file must match it in the commit the change is made on top of. The push only moves the branch from that
+// commit, so the check is atomic with the write.
+func (b *Backend) updateFile(ctx context.Context, path string, body []byte, mustNotExist bool, ifMatch string) (plumbing.Hash, error) {
 	path = strings.TrimPrefix(path, "/")
 
 	conn, err := b.getReadConnection(ctx)
@@ -76,6 +77,21 @@ func (b *Backend) updateFile(ctx context.Context, path string, body []byte, must
 	// Handle checks for file existence
 	objectHash := b.getObjectAtPath(tree, path)
 	objectExists := objectHash != plumbing.ZeroHash
+	if ifMatch != "" {
+		var current []byte
+		if objectExists {
+			blob, err := b.getObjectByHash(ctx, conn, objectHash)
+			if err != nil {
+				return plumbing.ZeroHash, fmt.Errorf("getting object: %w", err)
+			}
+			if current, err = b.readBlob(blob); err != nil {
+				return plumbing.ZeroHash, fmt.Errorf("reading object: %w", err)
+			}
+		}
+		if err := gitbackedrest.CheckIfMatch(ifMatch, current, objectExists); err != nil {
+			return plumbing.ZeroHash, err
+		}
+	}
 	// For POST, the object must not exist
 	if mustNotExist && objectExists {
 		return plumbing.ZeroHash, gitbackedrest.NewUserError(
@@ -137,7 +153,7 @@ func (b *Backend) updateFile(ctx context.Context, path string, body []byte, must
 	}
 
 	// Push the new commit
-	if err := b.pushCommit(ctx, mainHash, newCommitHash, blobHash, "main"); err != nil {
+	if err := b.pushCommit(ctx, mainHash, newCommitHash, blobHash, b.branch); err != nil {
 		var httpErr *gitbackedrest.HTTPError
 		if errors.As(err, &httpErr) && httpErr.Code == http.StatusConflict {
 			return plumbing.ZeroHash, err
@@ -273,7 +289,8 @@ func (b *Backend) addToTree(tree *object.Tree, path string, blobHash plumbing.Ha
 }
 
 func (b *Backend) getMainHash(ctx context.Context, conn transport.Connection) (plumbing.Hash, error) {
-	defer trace.StartRegion(ctx, "getMainHash").End()
+	ctx, phase := gitbackedrest.StartPhase(ctx, b.logger, "getMainHash")
+	defer phase.End()
 
 	refs, err := conn.GetRemoteRefs(ctx)
 	if err != nil {
@@ -282,7 +299,7 @@ func (b *Backend) getMainHash(ctx context.Context, conn transport.Connection) (p
 
 	var refHash plumbing.Hash
 	for _, ref := range refs {
-		if ref.Name().IsBranch() && ref.Name().String() == "refs/heads/main" {
+		if ref.Name() == plumbing.NewBranchReferenceName(b.branch) {
 			refHash = ref.Hash()
 			break
 		}
@@ -292,7 +309,8 @@ func (b *Backend) getMainHash(ctx context.Context, conn transport.Connection) (p
 }
 
 func (b *Backend) fetchTree(ctx context.Context, conn transport.Connection, hash plumbing.Hash) (*object.Tree, error) {
-	defer trace.StartRegion(ctx, "fetchTree").End()
+	ctx, phase := gitbackedrest.StartPhase(ctx, b.logger, "fetchTree")
+	defer phase.End()
 
 	// Build fetch request
 	fetchReq := &transport.FetchRequest{
@@ -307,7 +325,9 @@ func (b *Backend) fetchTree(ctx context.Context, conn transport.Connection, hash
 	b.storeMtx.Lock()
 	defer b.storeMtx.Unlock()
 
+	start := time.Now()
 	err := conn.Fetch(ctx, fetchReq)
+	b.metrics.ObserveOperation(metricsBackend, gitbackedrest.OperationFetch, start, err)
 	if err != nil {
 		return nil, fmt.Errorf("fetch: %w", err)
 	}
@@ -336,6 +356,7 @@ func (b *Backend) getObjectByHash(ctx context.Context, conn transport.Connection
 	blob, err := b.store.EncodedObject(plumbing.BlobObject, hash)
 
 	if err == nil {
+		b.metrics.CacheLookup(metricsBackend, objectCache, true)
 		return blob, nil
 	}
 
@@ -343,10 +364,17 @@ func (b *Backend) getObjectByHash(ctx context.Context, conn transport.Connection
 		return nil, fmt.Errorf("getting blob object: %w", err)
 
 	}
+	b.metrics.CacheLookup(metricsBackend, objectCache, false)
+
+	start := time.Now()
 	err = conn.Fetch(ctx, &transport.FetchRequest{
 		Wants: []plumbing.Hash{hash},
 	})
-	if err != nil && !strings.Contains(err.Error(), "empty packfile") {
+	if err != nil && strings.Contains(err.Error(), "empty packfile") {
+		err = nil
+	}
+	b.metrics.ObserveOperation(metricsBackend, gitbackedrest.OperationFetch, start, err)
+	if err != nil {
 		return nil, fmt.Errorf("fetching blob: %w", err)
 	}
 
@@ -372,10 +400,11 @@ func (b *Backend) readBlob(blob plumbing.EncodedObject) ([]byte, error) {
 	return content, nil
 }
 
-func (r *Backend) getWriteConnection(ctx context.Context) (transport.Connection, error) {
-	defer trace.StartRegion(ctx, "getWriteConnection").End()
+func (b *Backend) getWriteConnection(ctx context.Context) (transport.Connection, error) {
+	ctx, phase := gitbackedrest.StartPhase(ctx, b.logger, "getWriteConnection")
+	defer phase.End()
 
-	conn, err := r.session.Handshake(ctx, transport.ReceivePackService, "")
+	conn, err := b.session.Handshake(ctx, transport.ReceivePackService, "")
 	if err != nil {
 		return nil, err
 	}
@@ -383,10 +412,11 @@ func (r *Backend) getWriteConnection(ctx context.Context) (transport.Connection,
 	return conn, nil
 }
 
-func (r *Backend) getReadConnection(ctx context.Context) (transport.Connection, error) {
-	defer trace.StartRegion(ctx, "getReadConnection").End()
+func (b *Backend) getReadConnection(ctx context.Context) (transport.Connection, error) {
+	ctx, phase := gitbackedrest.StartPhase(ctx, b.logger, "getReadConnection")
+	defer phase.End()
 
-	conn, err := r.session.Handshake(ctx, transport.UploadPackService, "")
+	conn, err := b.session.Handshake(ctx, transport.UploadPackService, "")
 	if err != nil {
 		return nil, err
 	}
@@ -394,7 +424,8 @@ func (r *Backend) getReadConnection(ctx context.Context) (transport.Connection,
 }
 
 func (b *Backend) createBlobHash(ctx context.Context, content []byte) (plumbing.Hash, error) {
-	defer trace.StartRegion(ctx, "createBlob").End()
+	ctx, phase := gitbackedrest.StartPhase(ctx, b.logger, "createBlob")
+	defer phase.End()
 
 	b.storeMtx.Lock()
 	defer b.storeMtx.Unlock()
@@ -428,7 +459,8 @@ func (b *Backend) createBlobHash(ctx context.Context, content []byte) (plumbing.
 
 // createCommit creates a new commit object with the given parent, tree, and message
 func (b *Backend) createCommit(ctx context.Context, parentHash, treeHash plumbing.Hash, message string) (plumbing.Hash, error) {
-	defer trace.StartRegion(ctx, "createCommit").End()
+	ctx, phase := gitbackedrest.StartPhase(ctx, b.logger, "createCommit")
+	defer phase.End()
 
 	signature := object.Signature{
 		Name:  "git-backed-rest",
@@ -464,7 +496,8 @@ func (b *Backend) createCommit(ctx context.Context, parentHash, treeHash plumbin
 
 // pushCommit pushes a commit to the remote repository
 func (b *Backend) pushCommit(ctx context.Context, oldHash, commitHash, changedFileHash plumbing.Hash, branchName string) error {
-	defer trace.StartRegion(ctx, "pushCommit").End()
+	ctx, phase := gitbackedrest.StartPhase(ctx, b.logger, "pushCommit")
+	defer phase.End()
 	conn, err := b.getWriteConnection(ctx)
 	if err != nil {
 		return fmt.Errorf("getting write connection: %w", err)
@@ -474,11 +507,13 @@ func (b *Backend) pushCommit(ctx context.Context, oldHash, commitHash, changedFi
 	refName := plumbing.NewBranchReferenceName(branchName)
 
 	// Build packfile with new objects
-	packfileReader, err := b.buildPackfile(commitHash, changedFileHash)
+	packfileReader, err := b.buildPackfile(ctx, commitHash, changedFileHash)
 	if err != nil {
 		return fmt.Errorf("building packfile: %w", err)
 	}
 
+	pack := &countingReader{ReadCloser: packfileReader}
+
 	// Create push request
 	pushReq := &transport.PushRequest{
 		Commands: []*packp.Command{
@@ -488,23 +523,27 @@ func (b *Backend) pushCommit(ctx context.Context, oldHash, commitHash, changedFi
 				New:  commitHash,
 			},
 		},
-		Packfile: packfileReader,
+		Packfile: pack,
 		Atomic:   true,
 	}
 
 	// Send the push
-	//	log.Printf("Sending push request old=%v new=%v", oldHash, commitHash)
+	start := time.Now()
 	err = conn.Push(ctx, pushReq)
+	b.metrics.ObserveOperation(metricsBackend, gitbackedrest.OperationPush, start, err)
 	if err != nil {
+		b.metrics.PushRejected(metricsBackend, pushRejectionReason(err))
+		b.recordPushError(err)
 		return fmt.Errorf("sending push request: %w", err)
 	}
-	log.Printf("Successful push request old=%v new=%v", oldHash, commitHash)
+	b.metrics.ObservePackfileSize(metricsBackend, pack.bytes)
+	b.log(ctx).DebugContext(ctx, "pushed commit", "branch", branchName, "old", oldHash, "new", commitHash)
 
 	return nil
 }
 
 // buildPackfile creates a packfile containing all objects reachable from newCommit but not from oldCommit
-func (b *Backend) buildPackfile(newCommit, changedFileHash plumbing.Hash) (io.ReadCloser, error) {
+func (b *Backend) buildPackfile(ctx context.Context, newCommit, changedFileHash plumbing.Hash) (io.ReadCloser, error) {
 	// Use packfile encoder to build the packfile
 	pr, pw := io.Pipe()
 
@@ -526,16 +565,11 @@ func (b *Backend) buildPackfile(newCommit, changedFileHash plumbing.Hash) (io.Re
 			return nil
 		})
 
-		for _, hash := range objects {
-			if hash == changedFileHash {
-				fmt.Println("Found changed file hash", hash)
-			}
-		}
-
 		if err != nil {
 			pw.CloseWithError(fmt.Errorf("walking commit: %w", err))
 			return
 		}
+		b.log(ctx).DebugContext(ctx, "built packfile", "commit", newCommit, "objects", len(objects), "changed_file", changedFileHash)
 
 		// Encode the packfile
 		if _, err := encoder.Encode(objects, 0); err != nil {
@@ -235,6 +235,41 @@ func TestDelete(t *testing.T) {
 	}
 }
 
+func TestConditionalWrites(t *testing.T) {
+	ctx := t.Context()
+
+	backend, err := NewBackend(createLocalRepo(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+
+	if _, err := backend.POST(ctx, "doc1", []byte("content1")); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = backend.PUTIfMatch(ctx, "doc1", []byte("content2"), gitbackedrest.ETag([]byte("other")))
+	if status := gitbackedrest.GetHTTPStatusCode(err, 0); status != http.StatusPreconditionFailed {
+		t.Fatalf("PUT with stale tag: expected precondition failed status, got %d: %v", status, err)
+	}
+	if _, err := backend.PUTIfMatch(ctx, "doc1", []byte("content2"), gitbackedrest.ETag([]byte("content1"))); err != nil {
+		t.Fatalf("PUT with current tag: %v", err)
+	}
+
+	_, err = backend.DELETEIfMatch(ctx, "doc1", gitbackedrest.ETag([]byte("content1")))
+	if status := gitbackedrest.GetHTTPStatusCode(err, 0); status != http.StatusPreconditionFailed {
+		t.Fatalf("DELETE with stale tag: expected precondition failed status, got %d: %v", status, err)
+	}
+	if _, err := backend.DELETEIfMatch(ctx, "doc1", gitbackedrest.ETag([]byte("content2"))); err != nil {
+		t.Fatalf("DELETE with current tag: %v", err)
+	}
+
+	_, err = backend.PUTIfMatch(ctx, "doc1", []byte("content3"), "*")
+	if status := gitbackedrest.GetHTTPStatusCode(err, 0); status != http.StatusPreconditionFailed {
+		t.Fatalf("PUT deleted: expected precondition failed status, got %d: %v", status, err)
+	}
+}
+
 func init() {
 	runtime.SetBlockProfileRate(1)
 
@@ -0,0 +1,126 @@
+package gitprotocol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/object"
+	"github.com/go-git/go-git/v6/utils/merkletrie"
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+var _ gitbackedrest.ChangeFeed = (*Backend)(nil)
+
+// Changes implements gitbackedrest.ChangeFeed.
+// The version is the hash of the main commit, and events are derived by diffing the
+// tree at since against the tree at the current main commit. Multiple commits between
+// the two are reported as their net effect.
+func (b *Backend) Changes(ctx context.Context, since string) ([]gitbackedrest.Event, string, error) {
+	ctx, phase := gitbackedrest.StartPhase(ctx, b.logger, "Changes")
+	defer phase.End()
+
+	if since != "" && !plumbing.IsHash(since) {
+		return nil, "", gitbackedrest.NewUserError(
+			"Invalid resume token",
+			gitbackedrest.NewHTTPError(
+				http.StatusBadRequest,
+				fmt.Errorf("not a commit hash: %q", since),
+			),
+		)
+	}
+
+	b.sessionMtx.RLock()
+	defer b.sessionMtx.RUnlock()
+
+	conn, err := b.getReadConnection(ctx)
+	if err != nil {
+		return nil, "", changesError(fmt.Errorf("getting connection: %w", err))
+	}
+
+	mainHash, err := b.getMainHash(ctx, conn)
+	if err != nil {
+		return nil, "", changesError(fmt.Errorf("getting main: %w", err))
+	}
+
+	if since == "" || since == mainHash.String() {
+		return nil, mainHash.String(), nil
+	}
+
+	oldTree, err := b.fetchTree(ctx, conn, plumbing.NewHash(since))
+	if err != nil {
+		return nil, "", gitbackedrest.NewUserError(
+			"Resume token is no longer valid",
+			gitbackedrest.NewHTTPError(
+				http.StatusGone,
+				fmt.Errorf("fetching tree for %s: %w", since, err),
+			),
+		)
+	}
+
+	// Each connection serves a single fetch
+	conn, err = b.getReadConnection(ctx)
+	if err != nil {
+		return nil, "", changesError(fmt.Errorf("getting connection: %w", err))
+	}
+
+	newTree, err := b.fetchTree(ctx, conn, mainHash)
+	if err != nil {
+		return nil, "", changesError(fmt.Errorf("fetching tree: %w", err))
+	}
+
+	events, err := b.diffTrees(ctx, oldTree, newTree, mainHash.String())
+	if err != nil {
+		return nil, "", changesError(err)
+	}
+	return events, mainHash.String(), nil
+}
+
+func (b *Backend) diffTrees(ctx context.Context, oldTree, newTree *object.Tree, version string) ([]gitbackedrest.Event, error) {
+	ctx, phase := gitbackedrest.StartPhase(ctx, b.logger, "diffTrees")
+	defer phase.End()
+
+	// Subtrees are loaded lazily from the store during the diff
+	b.storeMtx.Lock()
+	defer b.storeMtx.Unlock()
+
+	changes, err := object.DiffTreeContext(ctx, oldTree, newTree)
+	if err != nil {
+		return nil, fmt.Errorf("diffing trees: %w", err)
+	}
+
+	events := make([]gitbackedrest.Event, 0, len(changes))
+	for _, change := range changes {
+		action, err := change.Action()
+		if err != nil {
+			return nil, fmt.Errorf("getting change action: %w", err)
+		}
+
+		var event gitbackedrest.Event
+		switch action {
+		case merkletrie.Insert:
+			event = gitbackedrest.Event{Type: gitbackedrest.EventCreate, Path: "/" + change.To.Name}
+		case merkletrie.Modify:
+			event = gitbackedrest.Event{Type: gitbackedrest.EventUpdate, Path: "/" + change.To.Name}
+		case merkletrie.Delete:
+			event = gitbackedrest.Event{Type: gitbackedrest.EventDelete, Path: "/" + change.From.Name}
+		default:
+			return nil, errors.New("unknown change action")
+		}
+		event.Version = version
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+func changesError(err error) error {
+	return gitbackedrest.NewUserError(
+		"Internal Server Error",
+		gitbackedrest.NewHTTPError(
+			http.StatusInternalServerError,
+			fmt.Errorf("getting changes: %w", err),
+		),
+	)
+}
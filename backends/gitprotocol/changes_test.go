@@ -0,0 +1,133 @@
+package gitprotocol
+
+import (
+	"testing"
+	"time"
+
+	git "github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/object"
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+func TestChanges(t *testing.T) {
+	ctx := t.Context()
+
+	backend, err := NewBackend(createLocalRepo(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+
+	events, initial, err := backend.Changes(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Fatalf("expected no events without a resume token, got %v", events)
+	}
+
+	if _, err := backend.POST(ctx, "doc1", []byte("content1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.POST(ctx, "doc2", []byte("content2")); err != nil {
+		t.Fatal(err)
+	}
+
+	events, afterCreate, err := backend.Changes(ctx, initial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectEvents(t, events, map[string]gitbackedrest.EventType{
+		"/doc1": gitbackedrest.EventCreate,
+		"/doc2": gitbackedrest.EventCreate,
+	})
+	for _, event := range events {
+		if event.Version != afterCreate {
+			t.Errorf("expected version %s, got %s", afterCreate, event.Version)
+		}
+	}
+
+	if _, err := backend.PUT(ctx, "doc1", []byte("updated")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.DELETE(ctx, "doc2"); err != nil {
+		t.Fatal(err)
+	}
+
+	events, _, err = backend.Changes(ctx, afterCreate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectEvents(t, events, map[string]gitbackedrest.EventType{
+		"/doc1": gitbackedrest.EventUpdate,
+		"/doc2": gitbackedrest.EventDelete,
+	})
+
+	_, _, err = backend.Changes(ctx, "not-a-hash")
+	if statusCode := gitbackedrest.GetHTTPStatusCode(err, 0); statusCode != 400 {
+		t.Errorf("expected bad request for invalid token, got %d", statusCode)
+	}
+}
+
+func expectEvents(t *testing.T, events []gitbackedrest.Event, expected map[string]gitbackedrest.EventType) {
+	t.Helper()
+
+	if len(events) != len(expected) {
+		t.Fatalf("expected %d events, got %v", len(expected), events)
+	}
+	for _, event := range events {
+		if expected[event.Path] != event.Type {
+			t.Errorf("unexpected event: %+v", event)
+		}
+	}
+}
+
+// createLocalRepo creates a bare repository on local disk with a single empty commit
+// on main, returning a file:// endpoint for it.
+func createLocalRepo(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tree := repo.Storer.NewEncodedObject()
+	if err := (&object.Tree{}).Encode(tree); err != nil {
+		t.Fatal(err)
+	}
+	treeHash, err := repo.Storer.SetEncodedObject(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signature := object.Signature{
+		Name:  "git-backed-rest",
+		Email: "no-reply@telliott.me",
+		When:  time.Now(),
+	}
+	commit := repo.Storer.NewEncodedObject()
+	if err := (&object.Commit{
+		Author:    signature,
+		Committer: signature,
+		Message:   "initial commit",
+		TreeHash:  treeHash,
+	}).Encode(commit); err != nil {
+		t.Fatal(err)
+	}
+	commitHash, err := repo.Storer.SetEncodedObject(commit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.Storer.SetReference(plumbing.NewHashReference("refs/heads/main", commitHash)); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, "refs/heads/main")); err != nil {
+		t.Fatal(err)
+	}
+
+	return "file://" + dir
+}
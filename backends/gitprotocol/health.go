@@ -0,0 +1,81 @@
+package gitprotocol
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+var (
+	_ gitbackedrest.HealthChecker = (*Backend)(nil)
+	_ gitbackedrest.Diagnoser     = (*Backend)(nil)
+)
+
+// CheckHealth implements gitbackedrest.HealthChecker by listing the remote's refs.
+func (b *Backend) CheckHealth(ctx context.Context) error {
+	_, err := b.currentRef(ctx)
+	return err
+}
+
+// Diagnostics implements gitbackedrest.Diagnoser.
+// It reports the current hash of main, the number of objects in the in-memory store,
+// the age of the transport session and the last push error.
+func (b *Backend) Diagnostics(ctx context.Context) map[string]any {
+	diagnostics := map[string]any{
+		"endpoint": b.endpoint,
+	}
+
+	if hash, err := b.currentRef(ctx); err != nil {
+		diagnostics["ref_error"] = err.Error()
+	} else {
+		diagnostics["ref"] = hash
+	}
+
+	b.sessionMtx.RLock()
+	diagnostics["session_age"] = time.Since(b.sessionStart).Round(time.Second).String()
+	b.storeMtx.Lock()
+	diagnostics["cache"] = map[string]int{
+		"objects": len(b.store.ObjectStorage.Objects),
+		"commits": len(b.store.ObjectStorage.Commits),
+		"trees":   len(b.store.ObjectStorage.Trees),
+		"blobs":   len(b.store.ObjectStorage.Blobs),
+	}
+	b.storeMtx.Unlock()
+	b.sessionMtx.RUnlock()
+
+	b.pushErrMtx.Lock()
+	if b.lastPushErr != nil {
+		diagnostics["last_push_error"] = b.lastPushErr.Error()
+		diagnostics["last_push_error_time"] = b.lastPushErrTime
+	}
+	b.pushErrMtx.Unlock()
+
+	return diagnostics
+}
+
+// currentRef returns the current hash of main on the remote
+func (b *Backend) currentRef(ctx context.Context) (string, error) {
+	b.sessionMtx.RLock()
+	defer b.sessionMtx.RUnlock()
+
+	conn, err := b.getReadConnection(ctx)
+	if err != nil {
+		return "", fmt.Errorf("getting connection: %w", err)
+	}
+	hash, err := b.getMainHash(ctx, conn)
+	if err != nil {
+		return "", fmt.Errorf("getting main: %w", err)
+	}
+	return hash.String(), nil
+}
+
+// recordPushError records a failed push for diagnostics
+func (b *Backend) recordPushError(err error) {
+	b.pushErrMtx.Lock()
+	defer b.pushErrMtx.Unlock()
+
+	b.lastPushErr = err
+	b.lastPushErrTime = time.Now()
+}
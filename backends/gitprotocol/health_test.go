@@ -0,0 +1,34 @@
+package gitprotocol
+
+import (
+	"testing"
+)
+
+func TestHealth(t *testing.T) {
+	ctx := t.Context()
+
+	backend, err := NewBackend(createLocalRepo(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+
+	if err := backend.CheckHealth(ctx); err != nil {
+		t.Fatalf("expected backend to be healthy, got %v", err)
+	}
+
+	if _, err := backend.POST(ctx, "doc1", []byte("content1")); err != nil {
+		t.Fatal(err)
+	}
+
+	diagnostics := backend.Diagnostics(ctx)
+	if ref, _ := diagnostics["ref"].(string); len(ref) != 40 {
+		t.Errorf("expected a ref hash, got %v", diagnostics)
+	}
+	if cache, _ := diagnostics["cache"].(map[string]int); cache["blobs"] == 0 {
+		t.Errorf("expected cached blobs, got %v", diagnostics)
+	}
+	if _, ok := diagnostics["last_push_error"]; ok {
+		t.Errorf("expected no push error, got %v", diagnostics)
+	}
+}
@@ -0,0 +1,189 @@
+package gitprotocol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/object"
+	"github.com/go-git/go-git/v6/plumbing/transport"
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+var _ gitbackedrest.Historian = (*Backend)(nil)
+
+// History implements gitbackedrest.Historian by walking the first-parent history of the main branch,
+// reporting each commit that changed the file. Only commits and trees are fetched.
+func (b *Backend) History(ctx context.Context, path string) ([]gitbackedrest.Version, error) {
+	ctx, phase := gitbackedrest.StartPhase(ctx, b.logger, "History")
+	defer phase.End()
+
+	b.sessionMtx.RLock()
+	defer b.sessionMtx.RUnlock()
+
+	conn, err := b.getReadConnection(ctx)
+	if err != nil {
+		return nil, historyError(fmt.Errorf("getting connection: %w", err))
+	}
+	mainHash, err := b.getMainHash(ctx, conn)
+	if err != nil {
+		return nil, historyError(fmt.Errorf("getting main: %w", err))
+	}
+	if mainHash == plumbing.ZeroHash {
+		return nil, nil
+	}
+	if _, err := b.loadTree(ctx, conn, mainHash); err != nil {
+		return nil, historyError(fmt.Errorf("fetching tree: %w", err))
+	}
+
+	// Parent commits and their trees are read from the store
+	b.storeMtx.Lock()
+	defer b.storeMtx.Unlock()
+
+	path = strings.TrimPrefix(path, "/")
+	commit, err := object.GetCommit(b.store, mainHash)
+	if err != nil {
+		return nil, historyError(fmt.Errorf("getting commit: %w", err))
+	}
+	current, err := fileHash(commit, path)
+	if err != nil {
+		return nil, historyError(err)
+	}
+
+	var versions []gitbackedrest.Version
+	for commit != nil {
+		var parent *object.Commit
+		previous := plumbing.ZeroHash
+		if commit.NumParents() > 0 {
+			if parent, err = commit.Parent(0); err != nil {
+				return nil, historyError(fmt.Errorf("getting parent of %s: %w", commit.Hash, err))
+			}
+			if previous, err = fileHash(parent, path); err != nil {
+				return nil, historyError(err)
+			}
+		}
+
+		if current != previous {
+			message, _, _ := strings.Cut(commit.Message, "\n")
+			versions = append(versions, gitbackedrest.Version{
+				ID:      commit.Hash.String(),
+				Time:    commit.Author.When,
+				Author:  commit.Author.Name,
+				Message: message,
+				Deleted: current == plumbing.ZeroHash,
+			})
+		}
+		commit, current = parent, previous
+	}
+	return versions, nil
+}
+
+// GETVersion implements gitbackedrest.Historian, reading the file from the tree of a commit.
+func (b *Backend) GETVersion(ctx context.Context, path, version string) (*gitbackedrest.GetResult, error) {
+	ctx, phase := gitbackedrest.StartPhase(ctx, b.logger, "GETVersion")
+	defer phase.End()
+
+	if !plumbing.IsHash(version) {
+		return nil, gitbackedrest.NewUserError(
+			"Invalid version",
+			gitbackedrest.NewHTTPError(
+				http.StatusBadRequest,
+				fmt.Errorf("not a commit hash: %q", version),
+			),
+		)
+	}
+
+	b.sessionMtx.RLock()
+	defer b.sessionMtx.RUnlock()
+
+	conn, err := b.getReadConnection(ctx)
+	if err != nil {
+		return nil, historyError(fmt.Errorf("getting connection: %w", err))
+	}
+	tree, err := b.loadTree(ctx, conn, plumbing.NewHash(version))
+	if err != nil {
+		return nil, gitbackedrest.NewUserError(
+			"Version not found",
+			gitbackedrest.NewHTTPError(
+				http.StatusNotFound,
+				fmt.Errorf("fetching tree for %s: %w", version, err),
+			),
+		)
+	}
+
+	entry, err := tree.FindEntry(strings.TrimPrefix(path, "/"))
+	if err != nil || !entry.Mode.IsFile() {
+		return nil, gitbackedrest.NewUserError(
+			"Not Found",
+			gitbackedrest.NewHTTPError(
+				http.StatusNotFound,
+				errors.New("resource not found in version"),
+			),
+		)
+	}
+
+	// Each connection serves a single fetch
+	conn, err = b.getReadConnection(ctx)
+	if err != nil {
+		return nil, historyError(fmt.Errorf("getting connection: %w", err))
+	}
+	blob, err := b.getObjectByHash(ctx, conn, entry.Hash)
+	if err != nil {
+		return nil, historyError(err)
+	}
+	data, err := b.readBlob(blob)
+	if err != nil {
+		return nil, historyError(err)
+	}
+	return &gitbackedrest.GetResult{
+		Data:    data,
+		Retries: 0, // GET doesn't retry
+	}, nil
+}
+
+// loadTree returns the tree of a commit, fetching it only if it is not already in the store
+func (b *Backend) loadTree(ctx context.Context, conn transport.Connection, hash plumbing.Hash) (*object.Tree, error) {
+	b.storeMtx.Lock()
+	commit, err := object.GetCommit(b.store, hash)
+	if err == nil {
+		var tree *object.Tree
+		tree, err = commit.Tree()
+		b.storeMtx.Unlock()
+		return tree, err
+	}
+	b.storeMtx.Unlock()
+
+	return b.fetchTree(ctx, conn, hash)
+}
+
+// fileHash returns the hash of the file at path in the commit's tree, or the zero hash if there is none
+func fileHash(commit *object.Commit, path string) (plumbing.Hash, error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("getting tree of %s: %w", commit.Hash, err)
+	}
+	entry, err := tree.FindEntry(path)
+	if errors.Is(err, object.ErrEntryNotFound) || errors.Is(err, object.ErrDirectoryNotFound) {
+		return plumbing.ZeroHash, nil
+	}
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("finding %s in %s: %w", path, commit.Hash, err)
+	}
+	if !entry.Mode.IsFile() {
+		return plumbing.ZeroHash, nil
+	}
+	return entry.Hash, nil
+}
+
+func historyError(err error) error {
+	return gitbackedrest.NewUserError(
+		"Internal Server Error",
+		gitbackedrest.NewHTTPError(
+			http.StatusInternalServerError,
+			fmt.Errorf("reading history: %w", err),
+		),
+	)
+}
@@ -0,0 +1,70 @@
+package gitprotocol
+
+import (
+	"errors"
+	"testing"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+func TestHistory(t *testing.T) {
+	ctx := t.Context()
+
+	backend, err := NewBackend(createLocalRepo(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+
+	versions, err := backend.History(ctx, "/doc1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 0 {
+		t.Fatalf("expected no versions for a new resource, got %v", versions)
+	}
+
+	if _, err := backend.POST(ctx, "doc1", []byte("content1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.POST(ctx, "doc2", []byte("other")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.PUT(ctx, "doc1", []byte("content2")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.DELETE(ctx, "doc1"); err != nil {
+		t.Fatal(err)
+	}
+
+	versions, err = backend.History(ctx, "/doc1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 3 {
+		t.Fatalf("expected 3 versions, got %v", versions)
+	}
+	if !versions[0].Deleted || versions[1].Deleted || versions[2].Deleted {
+		t.Errorf("expected only the newest version to be a deletion, got %v", versions)
+	}
+
+	for i, expected := range []string{"", "content2", "content1"} {
+		result, err := backend.GETVersion(ctx, "/doc1", versions[i].ID)
+		if expected == "" {
+			if !errors.Is(err, gitbackedrest.ErrNotFound) {
+				t.Errorf("expected not found for the deleted version, got %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(result.Data) != expected {
+			t.Errorf("version %d: expected %q, got %q", i, expected, result.Data)
+		}
+	}
+
+	if _, err := backend.GETVersion(ctx, "/doc1", "HEAD"); gitbackedrest.GetHTTPStatusCode(err, 0) != 400 {
+		t.Errorf("expected bad request for an invalid version, got %v", err)
+	}
+}
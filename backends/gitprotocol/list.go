@@ -0,0 +1,79 @@
+package gitprotocol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/object"
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+var _ gitbackedrest.Lister = (*Backend)(nil)
+
+// List implements gitbackedrest.Lister, walking the tree of the main commit.
+// Only trees are fetched, so listing does not download file contents.
+func (b *Backend) List(ctx context.Context, prefix string) ([]string, error) {
+	ctx, phase := gitbackedrest.StartPhase(ctx, b.logger, "List")
+	defer phase.End()
+
+	b.sessionMtx.RLock()
+	defer b.sessionMtx.RUnlock()
+
+	conn, err := b.getReadConnection(ctx)
+	if err != nil {
+		return nil, listError(fmt.Errorf("getting connection: %w", err))
+	}
+
+	mainHash, err := b.getMainHash(ctx, conn)
+	if err != nil {
+		return nil, listError(fmt.Errorf("getting main: %w", err))
+	}
+	if mainHash == plumbing.ZeroHash {
+		// Nothing has been committed yet
+		return nil, nil
+	}
+
+	tree, err := b.fetchTree(ctx, conn, mainHash)
+	if err != nil {
+		return nil, listError(fmt.Errorf("fetching tree: %w", err))
+	}
+
+	// Subtrees are loaded lazily from the store during the walk
+	b.storeMtx.Lock()
+	defer b.storeMtx.Unlock()
+
+	var paths []string
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
+	for {
+		name, entry, err := walker.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, listError(fmt.Errorf("walking tree: %w", err))
+		}
+		path := "/" + name
+		if entry.Mode.IsFile() && strings.HasPrefix(path, prefix) {
+			paths = append(paths, path)
+		}
+	}
+	slices.Sort(paths)
+	return paths, nil
+}
+
+func listError(err error) error {
+	return gitbackedrest.NewUserError(
+		"Internal Server Error",
+		gitbackedrest.NewHTTPError(
+			http.StatusInternalServerError,
+			fmt.Errorf("listing resources: %w", err),
+		),
+	)
+}
@@ -0,0 +1,48 @@
+package gitprotocol
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestList(t *testing.T) {
+	ctx := t.Context()
+
+	backend, err := NewBackend(createLocalRepo(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+
+	paths, err := backend.List(ctx, "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 0 {
+		t.Fatalf("expected no paths in an empty repo, got %v", paths)
+	}
+
+	for _, path := range []string{"user-alice", "user-bob", "group-admins"} {
+		if _, err := backend.POST(ctx, path, []byte(path)); err != nil {
+			t.Fatalf("POST %s: %v", path, err)
+		}
+	}
+
+	for _, test := range []struct {
+		prefix   string
+		expected []string
+	}{
+		{"/", []string{"/group-admins", "/user-alice", "/user-bob"}},
+		{"/user-", []string{"/user-alice", "/user-bob"}},
+		{"/user-b", []string{"/user-bob"}},
+		{"/missing/", nil},
+	} {
+		paths, err := backend.List(ctx, test.prefix)
+		if err != nil {
+			t.Fatalf("List %s: %v", test.prefix, err)
+		}
+		if !slices.Equal(paths, test.expected) {
+			t.Errorf("List %s: expected %v, got %v", test.prefix, test.expected, paths)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package gitprotocol
+
+import (
+	"io"
+	"strings"
+)
+
+const (
+	// metricsBackend is the value of the "backend" label for this backend's metrics
+	metricsBackend = "gitprotocol"
+
+	// objectCache is the "cache" label for lookups of objects in the backend's in-memory store
+	objectCache = "objects"
+)
+
+// pushRejectionReason classifies a push error for the push rejections metric.
+// Reasons are one of stale_ref, unpack, packfile or other.
+func pushRejectionReason(err error) string {
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "non-fast-forward"),
+		strings.Contains(msg, "fetch first"),
+		strings.Contains(msg, "stale info"),
+		strings.Contains(msg, "cannot lock ref"),
+		strings.Contains(msg, "failed to update ref"):
+		return "stale_ref"
+	case strings.Contains(msg, "unpack"):
+		return "unpack"
+	case strings.Contains(msg, "encoding packfile"):
+		return "packfile"
+	default:
+		return "other"
+	}
+}
+
+// countingReader counts the bytes read from a packfile as it is sent
+type countingReader struct {
+	io.ReadCloser
+	bytes int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.bytes += int64(n)
+	return n, err
+}
@@ -0,0 +1,69 @@
+package gitprotocol
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+func TestMetrics(t *testing.T) {
+	ctx := t.Context()
+
+	registry := prometheus.NewRegistry()
+	backend, err := NewBackend(createLocalRepo(t), WithMetrics(gitbackedrest.NewBackendMetrics(registry)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+
+	if _, err := backend.POST(ctx, "doc1", []byte("content1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.GET(ctx, "doc1"); err != nil {
+		t.Fatal(err)
+	}
+
+	samples := gatherSamples(t, registry)
+	for _, series := range []string{
+		"backend_operation_duration_seconds{backend=gitprotocol,operation=fetch,result=success}",
+		"backend_operation_duration_seconds{backend=gitprotocol,operation=push,result=success}",
+		"backend_packfile_size_bytes{backend=gitprotocol}",
+		"backend_cache_lookups_total{backend=gitprotocol,cache=objects,result=hit}",
+	} {
+		if samples[series] == 0 {
+			t.Errorf("expected samples for %s, got %v", series, samples)
+		}
+	}
+}
+
+// gatherSamples returns the number of observations (for histograms) or value (for counters) of each series in reg
+func gatherSamples(t *testing.T, reg prometheus.Gatherer) map[string]float64 {
+	t.Helper()
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	samples := make(map[string]float64)
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			series := family.GetName() + "{"
+			for i, label := range metric.GetLabel() {
+				if i > 0 {
+					series += ","
+				}
+				series += label.GetName() + "=" + label.GetValue()
+			}
+			series += "}"
+
+			if h := metric.GetHistogram(); h != nil {
+				samples[series] = float64(h.GetSampleCount())
+			} else if c := metric.GetCounter(); c != nil {
+				samples[series] = c.GetValue()
+			}
+		}
+	}
+	return samples
+}
@@ -0,0 +1,88 @@
+package gitprotocol
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v6/plumbing/transport"
+	githttp "github.com/go-git/go-git/v6/plumbing/transport/http"
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+// schemePrefix is prepended to a Git transport's scheme to form a backend URL scheme,
+// e.g. git+https://github.com/acme/config?branch=main
+const schemePrefix = "git+"
+
+func init() {
+	for _, scheme := range []string{"https", "http", "ssh", "file"} {
+		gitbackedrest.Register(schemePrefix+scheme, open)
+	}
+}
+
+// open creates a backend from a git+<transport> URL. The query parameters branch, cache_interval,
+// retry_initial_interval, retry_max_interval, retry_max_elapsed_time and retry_max_tries configure the backend.
+// For HTTP(S), a password in the URL is used as a token; otherwise authentication is chosen
+// as for GetAuthForEndpoint.
+func open(ctx context.Context, u *url.URL, cfg gitbackedrest.OpenConfig) (gitbackedrest.APIBackend, error) {
+	opts := []Option{
+		WithLogger(cfg.Logger),
+		WithMetrics(cfg.Metrics),
+	}
+
+	query := u.Query()
+	var retry RetryPolicy
+	durations := map[string]*time.Duration{
+		"retry_initial_interval": &retry.InitialInterval,
+		"retry_max_interval":     &retry.MaxInterval,
+		"retry_max_elapsed_time": &retry.MaxElapsedTime,
+	}
+	var cacheInterval time.Duration
+	durations["cache_interval"] = &cacheInterval
+	for key, target := range durations {
+		if value := query.Get(key); value != "" {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return nil, fmt.Errorf("parsing %s: %w", key, err)
+			}
+			*target = d
+		}
+	}
+	if value := query.Get("retry_max_tries"); value != "" {
+		tries, err := strconv.ParseUint(value, 10, 0)
+		if err != nil {
+			return nil, fmt.Errorf("parsing retry_max_tries: %w", err)
+		}
+		retry.MaxTries = uint(tries)
+	}
+	opts = append(opts, WithRetryPolicy(retry))
+	if cacheInterval > 0 {
+		opts = append(opts, WithCacheInterval(cacheInterval))
+	}
+	if branch := query.Get("branch"); branch != "" {
+		opts = append(opts, WithBranch(branch))
+	}
+
+	endpoint := *u
+	endpoint.Scheme = strings.TrimPrefix(u.Scheme, schemePrefix)
+	endpoint.RawQuery = ""
+
+	var auth transport.AuthMethod
+	if password, ok := u.User.Password(); ok && (endpoint.Scheme == "https" || endpoint.Scheme == "http") {
+		auth = &githttp.BasicAuth{
+			Username: u.User.Username(),
+			Password: password,
+		}
+		endpoint.User = nil
+	} else {
+		var err error
+		if auth, err = GetAuthForEndpoint(endpoint.String()); err != nil {
+			return nil, err
+		}
+	}
+
+	return NewBackendWithAuth(endpoint.String(), auth, opts...)
+}
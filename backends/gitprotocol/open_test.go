@@ -0,0 +1,38 @@
+package gitprotocol
+
+import (
+	"testing"
+	"time"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+func TestOpen(t *testing.T) {
+	ctx := t.Context()
+
+	opened, err := gitbackedrest.Open(ctx, "git+"+createLocalRepo(t)+"?branch=main&cache_interval=1m&retry_max_tries=3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := opened.(*Backend)
+	defer backend.Close()
+
+	if backend.cacheInterval != time.Minute || backend.retry.MaxTries != 3 || backend.branch != "main" {
+		t.Errorf("unexpected settings: cache interval %v, retry %+v, branch %q", backend.cacheInterval, backend.retry, backend.branch)
+	}
+
+	if _, err := backend.POST(ctx, "doc", []byte("content")); err != nil {
+		t.Fatal(err)
+	}
+	result, err := backend.GET(ctx, "doc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result.Data) != "content" {
+		t.Errorf("expected %q, got %q", "content", result.Data)
+	}
+
+	if _, err := gitbackedrest.Open(ctx, "git+file:///tmp/repo.git?cache_interval=soon"); err == nil {
+		t.Error("expected an error for an invalid duration")
+	}
+}
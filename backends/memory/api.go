@@ -3,41 +3,148 @@ package memory
 import (
 	"context"
 	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
 	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
 )
 
-var _ gitbackedrest.APIBackend = (*Backend)(nil)
+var (
+	_ gitbackedrest.APIBackend        = (*Backend)(nil)
+	_ gitbackedrest.Lister            = (*Backend)(nil)
+	_ gitbackedrest.Historian         = (*Backend)(nil)
+	_ gitbackedrest.ConditionalWriter = (*Backend)(nil)
+)
+
+// defaultMaxVersions is how many versions of each resource are kept for history unless WithMaxVersions is set
+const defaultMaxVersions = 10
 
-func NewBackend() *Backend {
-	return &Backend{
-		data: make(map[string][]byte),
+// NewBackend creates a backend held only in memory
+func NewBackend(opts ...Option) *Backend {
+	b := &Backend{
+		versions: make(map[string][]version),
+	}
+	for _, opt := range opts {
+		opt(b)
 	}
+	if b.maxVersions == 0 {
+		b.maxVersions = defaultMaxVersions
+	}
+	return b
 }
 
+// Backend implements APIBackend in memory, optionally persisted to a local directory.
+// It is safe for concurrent use.
 type Backend struct {
-	data map[string][]byte
+	mtx sync.RWMutex
+	// versions holds every version of each path, oldest first. The last is the resource's current state.
+	versions map[string][]version
+	// seq is the sequence number of the latest version of any resource
+	seq uint64
+
+	// maxVersions limits the versions kept of each resource, if positive. If negative, every version is kept.
+	maxVersions int
+
+	// store persists writes, or is nil for a backend held only in memory
+	store        *store
+	logger       *slog.Logger
+	syncMode     SyncMode
+	syncInterval time.Duration
+	compactAfter int
+}
+
+// version is a state of a resource, and the kind of write that made it
+type version struct {
+	Seq  uint64                  `json:"seq"`
+	Time time.Time               `json:"time"`
+	Kind gitbackedrest.EventType `json:"kind"`
+	Data []byte                  `json:"data,omitempty"`
+}
+
+func (v version) deleted() bool {
+	return v.Kind == gitbackedrest.EventDelete
+}
+
+// current returns the current content of the resource at path, if it exists. b.mtx must be held.
+func (b *Backend) current(path string) ([]byte, bool) {
+	versions := b.versions[path]
+	if len(versions) == 0 || versions[len(versions)-1].deleted() {
+		return nil, false
+	}
+	return versions[len(versions)-1].Data, true
+}
+
+// appendVersion records v as the latest version of path. b.mtx must be held.
+func (b *Backend) appendVersion(path string, v version) {
+	b.setVersions(path, append(b.versions[path], v))
+}
+
+// setVersions sets the versions of path, dropping the oldest beyond the limit. A resource whose only version
+// left is its deletion is forgotten, so deleted resources don't use memory forever. b.mtx must be held.
+func (b *Backend) setVersions(path string, versions []version) {
+	if b.maxVersions > 0 && len(versions) > b.maxVersions {
+		versions = slices.Delete(versions, 0, len(versions)-b.maxVersions)
+	}
+	if len(versions) == 1 && versions[0].deleted() {
+		delete(b.versions, path)
+		return
+	}
+	b.versions[path] = versions
 }
 
 func (b *Backend) GET(ctx context.Context, path string) (*gitbackedrest.GetResult, error) {
-	if value, ok := b.data[path]; ok {
+	b.mtx.RLock()
+	defer b.mtx.RUnlock()
+
+	if value, ok := b.current(path); ok {
 		return &gitbackedrest.GetResult{
 			Data:    value,
 			Retries: 0,
 		}, nil
 	}
-	return nil, gitbackedrest.NewUserError(
-		"Not Found",
-		gitbackedrest.NewHTTPError(
-			http.StatusNotFound,
-			errors.New("resource not found"),
-		),
-	)
+	return nil, notFoundError()
 }
 
 func (b *Backend) POST(ctx context.Context, path string, body []byte) (*gitbackedrest.Result, error) {
-	if _, ok := b.data[path]; ok {
+	return b.write(path, gitbackedrest.EventCreate, body, "")
+}
+
+func (b *Backend) PUT(ctx context.Context, path string, body []byte) (*gitbackedrest.Result, error) {
+	return b.write(path, gitbackedrest.EventUpdate, body, "")
+}
+
+func (b *Backend) DELETE(ctx context.Context, path string) (*gitbackedrest.Result, error) {
+	return b.write(path, gitbackedrest.EventDelete, nil, "")
+}
+
+// PUTIfMatch implements gitbackedrest.ConditionalWriter.
+func (b *Backend) PUTIfMatch(ctx context.Context, path string, body []byte, ifMatch string) (*gitbackedrest.Result, error) {
+	return b.write(path, gitbackedrest.EventUpdate, body, ifMatch)
+}
+
+// DELETEIfMatch implements gitbackedrest.ConditionalWriter.
+func (b *Backend) DELETEIfMatch(ctx context.Context, path, ifMatch string) (*gitbackedrest.Result, error) {
+	return b.write(path, gitbackedrest.EventDelete, nil, ifMatch)
+}
+
+// write checks that the resource at path exists, or doesn't for a create, and matches ifMatch if it isn't
+// empty, then records its new version
+func (b *Backend) write(path string, kind gitbackedrest.EventType, body []byte, ifMatch string) (*gitbackedrest.Result, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	current, exists := b.current(path)
+	if ifMatch != "" {
+		if err := gitbackedrest.CheckIfMatch(ifMatch, current, exists); err != nil {
+			return nil, err
+		}
+	}
+	if kind == gitbackedrest.EventCreate && exists {
 		return nil, gitbackedrest.NewUserError(
 			"Conflict",
 			gitbackedrest.NewHTTPError(
@@ -46,40 +153,68 @@ func (b *Backend) POST(ctx context.Context, path string, body []byte) (*gitbacke
 			),
 		)
 	}
-	b.data[path] = body
+	if kind != gitbackedrest.EventCreate && !exists {
+		return nil, notFoundError()
+	}
+
+	v := version{
+		Seq:  b.seq + 1,
+		Time: time.Now().UTC(),
+		Kind: kind,
+		Data: body,
+	}
+	if b.store != nil {
+		// The write is only applied once it is logged, so a failed write leaves no trace
+		if err := b.store.append(record{Path: path, version: v}); err != nil {
+			return nil, gitbackedrest.NewUserError(
+				"Internal Server Error",
+				gitbackedrest.NewHTTPError(
+					http.StatusInternalServerError,
+					fmt.Errorf("logging write: %w", err),
+				),
+			)
+		}
+	}
+	b.seq = v.Seq
+	b.appendVersion(path, v)
+
+	if b.store != nil {
+		b.store.startCompaction(b.snapshot, b.logger)
+	}
 	return &gitbackedrest.Result{
 		Retries: 0,
 	}, nil
 }
 
-func (b *Backend) PUT(ctx context.Context, path string, body []byte) (*gitbackedrest.Result, error) {
-	if _, ok := b.data[path]; !ok {
-		return nil, gitbackedrest.NewUserError(
-			"Not Found",
-			gitbackedrest.NewHTTPError(
-				http.StatusNotFound,
-				errors.New("resource not found"),
-			),
-		)
+// List implements gitbackedrest.Lister.
+func (b *Backend) List(ctx context.Context, prefix string) ([]string, error) {
+	b.mtx.RLock()
+	defer b.mtx.RUnlock()
+
+	var paths []string
+	for path := range b.versions {
+		if _, ok := b.current(path); ok && strings.HasPrefix(path, prefix) {
+			paths = append(paths, path)
+		}
 	}
-	b.data[path] = body
-	return &gitbackedrest.Result{
-		Retries: 0,
-	}, nil
+	slices.Sort(paths)
+	return paths, nil
 }
 
-func (b *Backend) DELETE(ctx context.Context, path string) (*gitbackedrest.Result, error) {
-	if _, ok := b.data[path]; !ok {
-		return nil, gitbackedrest.NewUserError(
-			"Not Found",
-			gitbackedrest.NewHTTPError(
-				http.StatusNotFound,
-				errors.New("resource not found"),
-			),
-		)
+// Close flushes and closes the backend's files, if it is persisted. Writes fail once it is closed.
+func (b *Backend) Close() error {
+	if b.store == nil {
+		return nil
 	}
-	delete(b.data, path)
-	return &gitbackedrest.Result{
-		Retries: 0,
-	}, nil
+	return b.store.close()
+}
+
+func notFoundError() error {
+	return gitbackedrest.NewUserError(
+		"Not Found",
+		gitbackedrest.NewHTTPError(
+			http.StatusNotFound,
+			errors.New("resource not found"),
+		),
+	)
 }
@@ -0,0 +1,38 @@
+package memory
+
+import (
+	"testing"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+	"github.com/theothertomelliott/git-backed-rest/backends/backendtest"
+)
+
+func expectStatus(t *testing.T, err error, expected int) {
+	t.Helper()
+	if expected == 0 {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return
+	}
+	if status := gitbackedrest.GetHTTPStatusCode(err, 0); status != expected {
+		t.Fatalf("expected status %d, got %d: %v", expected, status, err)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	backendtest.Run(t, func(t *testing.T) gitbackedrest.APIBackend {
+		return NewBackend()
+	})
+}
+
+func TestPersistentConformance(t *testing.T) {
+	backendtest.Run(t, func(t *testing.T) gitbackedrest.APIBackend {
+		backend, err := NewPersistentBackend(t.TempDir(), WithSync(SyncNever))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { backend.Close() })
+		return backend
+	})
+}
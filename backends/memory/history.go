@@ -0,0 +1,74 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+// History implements gitbackedrest.Historian. Each write is a version, identified by its sequence number.
+func (b *Backend) History(ctx context.Context, path string) ([]gitbackedrest.Version, error) {
+	b.mtx.RLock()
+	defer b.mtx.RUnlock()
+
+	var history []gitbackedrest.Version
+	for _, v := range slices.Backward(b.versions[path]) {
+		history = append(history, gitbackedrest.Version{
+			ID:      strconv.FormatUint(v.Seq, 10),
+			Time:    v.Time,
+			Message: fmt.Sprintf("%s %s", v.Kind, path),
+			Deleted: v.deleted(),
+		})
+	}
+	return history, nil
+}
+
+// GETVersion implements gitbackedrest.Historian.
+func (b *Backend) GETVersion(ctx context.Context, path, id string) (*gitbackedrest.GetResult, error) {
+	seq, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return nil, gitbackedrest.NewUserError(
+			"Invalid version",
+			gitbackedrest.NewHTTPError(
+				http.StatusBadRequest,
+				fmt.Errorf("not a version number: %q", id),
+			),
+		)
+	}
+
+	b.mtx.RLock()
+	defer b.mtx.RUnlock()
+
+	versions := b.versions[path]
+	i, found := slices.BinarySearchFunc(versions, seq, func(v version, seq uint64) int {
+		return cmp.Compare(v.Seq, seq)
+	})
+	if !found {
+		return nil, gitbackedrest.NewUserError(
+			"Version not found",
+			gitbackedrest.NewHTTPError(
+				http.StatusNotFound,
+				fmt.Errorf("version %d of %s not found", seq, path),
+			),
+		)
+	}
+	if versions[i].deleted() {
+		return nil, gitbackedrest.NewUserError(
+			"Not Found",
+			gitbackedrest.NewHTTPError(
+				http.StatusNotFound,
+				errors.New("resource deleted in version"),
+			),
+		)
+	}
+	return &gitbackedrest.GetResult{
+		Data:    versions[i].Data,
+		Retries: 0,
+	}, nil
+}
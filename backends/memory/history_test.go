@@ -0,0 +1,125 @@
+package memory
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestHistory(t *testing.T) {
+	ctx := t.Context()
+	backend := NewBackend()
+
+	_, err := backend.POST(ctx, "/doc", []byte("v1"))
+	expectStatus(t, err, 0)
+	_, err = backend.POST(ctx, "/other", []byte("other"))
+	expectStatus(t, err, 0)
+	_, err = backend.PUT(ctx, "/doc", []byte("v2"))
+	expectStatus(t, err, 0)
+	_, err = backend.DELETE(ctx, "/doc")
+	expectStatus(t, err, 0)
+	_, err = backend.POST(ctx, "/doc", []byte("v3"))
+	expectStatus(t, err, 0)
+
+	versions, err := backend.History(ctx, "/doc")
+	expectStatus(t, err, 0)
+	expected := []struct {
+		id, message, data string
+	}{
+		{"5", "create /doc", "v3"},
+		{"4", "delete /doc", ""},
+		{"3", "update /doc", "v2"},
+		{"1", "create /doc", "v1"},
+	}
+	if len(versions) != len(expected) {
+		t.Fatalf("expected %d versions, got %+v", len(expected), versions)
+	}
+	for i, version := range versions {
+		if version.ID != expected[i].id || version.Message != expected[i].message || version.Deleted != (expected[i].data == "") {
+			t.Errorf("version %d: expected %+v, got %+v", i, expected[i], version)
+		}
+		result, err := backend.GETVersion(ctx, "/doc", version.ID)
+		if expected[i].data == "" {
+			expectStatus(t, err, http.StatusNotFound)
+			continue
+		}
+		expectStatus(t, err, 0)
+		if string(result.Data) != expected[i].data {
+			t.Errorf("version %d: expected %q, got %q", i, expected[i].data, result.Data)
+		}
+	}
+
+	_, err = backend.GETVersion(ctx, "/doc", "2")
+	expectStatus(t, err, http.StatusNotFound)
+	_, err = backend.GETVersion(ctx, "/doc", "latest")
+	expectStatus(t, err, http.StatusBadRequest)
+
+	versions, err = backend.History(ctx, "/missing")
+	expectStatus(t, err, 0)
+	if len(versions) != 0 {
+		t.Errorf("expected no versions of a resource never written, got %+v", versions)
+	}
+}
+
+func TestMaxVersions(t *testing.T) {
+	ctx := t.Context()
+	backend := NewBackend(WithMaxVersions(2))
+
+	_, err := backend.POST(ctx, "/doc", []byte("v1"))
+	expectStatus(t, err, 0)
+	for _, data := range []string{"v2", "v3"} {
+		_, err = backend.PUT(ctx, "/doc", []byte(data))
+		expectStatus(t, err, 0)
+	}
+
+	versions, err := backend.History(ctx, "/doc")
+	expectStatus(t, err, 0)
+	if len(versions) != 2 || versions[0].ID != "3" || versions[1].ID != "2" {
+		t.Errorf("expected the 2 latest versions, got %+v", versions)
+	}
+	_, err = backend.GETVersion(ctx, "/doc", "1")
+	expectStatus(t, err, http.StatusNotFound)
+
+	// A deleted resource is forgotten once its deletion is the only version left
+	_, err = backend.DELETE(ctx, "/doc")
+	expectStatus(t, err, 0)
+	_, err = backend.POST(ctx, "/other", []byte("v1"))
+	expectStatus(t, err, 0)
+	if _, ok := backend.versions["/doc"]; !ok {
+		t.Error("expected the deleted resource's history to be kept")
+	}
+	backend = NewBackend(WithMaxVersions(1))
+	_, err = backend.POST(ctx, "/doc", []byte("v1"))
+	expectStatus(t, err, 0)
+	_, err = backend.DELETE(ctx, "/doc")
+	expectStatus(t, err, 0)
+	if versions, ok := backend.versions["/doc"]; ok {
+		t.Errorf("expected the deleted resource to be forgotten, got %+v", versions)
+	}
+}
+
+func TestDefaultMaxVersions(t *testing.T) {
+	ctx := t.Context()
+	for _, test := range []struct {
+		name     string
+		opts     []Option
+		expected int
+	}{
+		{"default", nil, defaultMaxVersions},
+		{"unlimited", []Option{WithMaxVersions(-1)}, defaultMaxVersions + 5},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			backend := NewBackend(test.opts...)
+			_, err := backend.POST(ctx, "/doc", []byte("v"))
+			expectStatus(t, err, 0)
+			for range defaultMaxVersions + 4 {
+				_, err = backend.PUT(ctx, "/doc", []byte("v"))
+				expectStatus(t, err, 0)
+			}
+			versions, err := backend.History(ctx, "/doc")
+			expectStatus(t, err, 0)
+			if len(versions) != test.expected {
+				t.Errorf("expected %d versions, got %d", test.expected, len(versions))
+			}
+		})
+	}
+}
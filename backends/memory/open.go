@@ -0,0 +1,51 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+// Scheme is the URL scheme for in-memory backends, e.g. mem:// or mem:///var/lib/store to persist to a directory
+const Scheme = "mem"
+
+func init() {
+	gitbackedrest.Register(Scheme, open)
+}
+
+// open creates a backend from a mem:// URL. A path, such as mem:///var/lib/store or mem://data for a relative
+// directory, persists the backend there, with the sync, sync_interval and compact_after query parameters.
+// The max_versions query parameter limits the history kept of each resource.
+func open(ctx context.Context, u *url.URL, cfg gitbackedrest.OpenConfig) (gitbackedrest.APIBackend, error) {
+	query := u.Query()
+	opts := []Option{WithLogger(cfg.Logger), WithSync(SyncMode(query.Get("sync")))}
+	for key, option := range map[string]func(int) Option{
+		"compact_after": WithCompactAfter,
+		"max_versions":  WithMaxVersions,
+	} {
+		if value := query.Get(key); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("parsing %s: %w", key, err)
+			}
+			opts = append(opts, option(n))
+		}
+	}
+	if value := query.Get("sync_interval"); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("parsing sync_interval: %w", err)
+		}
+		opts = append(opts, WithSyncInterval(interval))
+	}
+
+	dir := u.Host + u.Path
+	if dir == "" {
+		return NewBackend(opts...), nil
+	}
+	return NewPersistentBackend(dir, opts...)
+}
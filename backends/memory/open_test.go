@@ -0,0 +1,34 @@
+package memory
+
+import (
+	"testing"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+func TestOpen(t *testing.T) {
+	opened, err := gitbackedrest.Open(t.Context(), "mem://")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backend := opened.(*Backend); backend.store != nil {
+		t.Error("expected mem:// to be held only in memory")
+	}
+
+	dir := t.TempDir()
+	opened, err = gitbackedrest.Open(t.Context(), "mem://"+dir+"?sync=periodic&sync_interval=10ms&compact_after=10&max_versions=5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := opened.(*Backend)
+	t.Cleanup(func() { backend.Close() })
+	if backend.store == nil || backend.store.dir != dir || backend.syncMode != SyncPeriodic || backend.compactAfter != 10 || backend.maxVersions != 5 {
+		t.Errorf("unexpected backend settings: %+v", backend)
+	}
+
+	for _, query := range []string{"sync=sometimes", "sync_interval=soon", "compact_after=many"} {
+		if _, err := gitbackedrest.Open(t.Context(), "mem://"+t.TempDir()+"?"+query); err == nil {
+			t.Errorf("expected an error for %s", query)
+		}
+	}
+}
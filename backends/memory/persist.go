@@ -0,0 +1,409 @@
+package memory
+
+// Persistence
+//
+// A persistent backend keeps its state in a directory holding two files:
+//
+//   - snapshot.json holds every version of every resource as of a sequence number.
+//   - log.jsonl holds the versions written since, one JSON record per line, appended before each write
+//     is applied in memory.
+//
+// On start, the snapshot is loaded and the log replayed over it. Once the log holds enough records, a copy of the
+// state is written to a new snapshot in the background, which replaces the old one by rename. The log is then
+// replaced, also by rename, with one holding only the records written since the copy was taken. A crash between
+// the two leaves records already in the snapshot, which are skipped by their sequence numbers. A crash while
+// appending can leave a partial last line, which is discarded on start since its write never succeeded.
+//
+// Only one process may use a directory at a time.
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	snapshotFile = "snapshot.json"
+	logFile      = "log.jsonl"
+
+	// defaultCompactAfter is how many records the log holds before it is compacted into the snapshot
+	defaultCompactAfter = 1000
+	// defaultSyncInterval is how often SyncPeriodic flushes the log
+	defaultSyncInterval = time.Second
+)
+
+// SyncMode selects when a persistent backend flushes its log to disk
+type SyncMode string
+
+const (
+	// SyncAlways flushes the log before each write returns, so acknowledged writes survive a power loss
+	SyncAlways SyncMode = "always"
+	// SyncPeriodic flushes the log every sync interval, so a power loss may lose the writes of the last interval
+	SyncPeriodic SyncMode = "periodic"
+	// SyncNever leaves flushing to the operating system. Writes survive the process crashing, but not the machine.
+	SyncNever SyncMode = "never"
+)
+
+// Option configures a Backend
+type Option func(*Backend)
+
+// WithLogger sets the logger for a persistent backend's background work, such as compaction.
+// Defaults to the default slog logger.
+func WithLogger(logger *slog.Logger) Option {
+	return func(b *Backend) {
+		b.logger = logger
+	}
+}
+
+// WithMaxVersions limits how many versions of each resource are kept for history, dropping the oldest.
+// Defaults to 10. A negative limit keeps every version, so memory grows with every write.
+func WithMaxVersions(n int) Option {
+	return func(b *Backend) {
+		b.maxVersions = n
+	}
+}
+
+// WithSync sets when a persistent backend flushes its log to disk. Defaults to SyncAlways.
+func WithSync(mode SyncMode) Option {
+	return func(b *Backend) {
+		b.syncMode = mode
+	}
+}
+
+// WithSyncInterval sets how often the log is flushed with SyncPeriodic. Defaults to one second.
+func WithSyncInterval(interval time.Duration) Option {
+	return func(b *Backend) {
+		b.syncInterval = interval
+	}
+}
+
+// WithCompactAfter sets how many records the log holds before it is compacted into a snapshot.
+// Defaults to 1000.
+func WithCompactAfter(records int) Option {
+	return func(b *Backend) {
+		b.compactAfter = records
+	}
+}
+
+// NewPersistentBackend creates a backend persisted to dir, loading any state already there.
+// The directory is created if needed. Call Close when finished to flush the log.
+func NewPersistentBackend(dir string, opts ...Option) (*Backend, error) {
+	b := NewBackend(opts...)
+	if b.logger == nil {
+		b.logger = slog.Default()
+	}
+	if b.syncMode == "" {
+		b.syncMode = SyncAlways
+	}
+	if b.syncInterval <= 0 {
+		b.syncInterval = defaultSyncInterval
+	}
+	if b.compactAfter <= 0 {
+		b.compactAfter = defaultCompactAfter
+	}
+	switch b.syncMode {
+	case SyncAlways, SyncPeriodic, SyncNever:
+	default:
+		return nil, fmt.Errorf("unknown sync mode %q, expected %q, %q or %q", b.syncMode, SyncAlways, SyncPeriodic, SyncNever)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating data directory: %w", err)
+	}
+	if err := b.loadSnapshot(filepath.Join(dir, snapshotFile)); err != nil {
+		return nil, err
+	}
+	log, records, err := b.replayLog(filepath.Join(dir, logFile))
+	if err != nil {
+		return nil, err
+	}
+
+	b.store = &store{
+		dir:          dir,
+		log:          log,
+		records:      records,
+		sync:         b.syncMode,
+		compactAfter: b.compactAfter,
+		done:         make(chan struct{}),
+	}
+	if b.syncMode == SyncPeriodic {
+		b.store.wg.Go(func() { b.store.syncLoop(b.syncInterval, b.logger) })
+	}
+	return b, nil
+}
+
+// snapshot is the content of the snapshot file
+type snapshot struct {
+	// Seq is the sequence number of the latest version in the snapshot
+	Seq       uint64               `json:"seq"`
+	Resources map[string][]version `json:"resources"`
+}
+
+// record is a line of the log
+type record struct {
+	Path string `json:"path"`
+	version
+}
+
+// snapshot returns a copy of the backend's state for the snapshot file. b.mtx must be held.
+func (b *Backend) snapshot() snapshot {
+	resources := make(map[string][]version, len(b.versions))
+	for path, versions := range b.versions {
+		// Versions are dropped in place once there are too many, so the slices can't be shared
+		resources[path] = slices.Clone(versions)
+	}
+	return snapshot{Seq: b.seq, Resources: resources}
+}
+
+// loadSnapshot loads the backend's state from the snapshot file at path, if there is one
+func (b *Backend) loadSnapshot(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading snapshot: %w", err)
+	}
+	var s snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("parsing snapshot %s: %w", path, err)
+	}
+	b.seq = s.Seq
+	// The limit may have been lowered since the snapshot was written
+	for path, versions := range s.Resources {
+		b.setVersions(path, versions)
+	}
+	return nil
+}
+
+// replayLog applies the records of the log at path that are newer than the snapshot, and opens it for appending.
+// A partial last record, left by a crash during a write, is truncated. It returns the number of records in the log.
+func (b *Backend) replayLog(path string) (*os.File, int, error) {
+	log, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, 0, fmt.Errorf("opening log: %w", err)
+	}
+
+	var (
+		reader  = bufio.NewReader(log)
+		offset  int64
+		records int
+	)
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(bytes.TrimSpace(data)) > 0 {
+				b.logger.Warn("discarding partial record at end of log", "path", path, "line", line)
+				if err := log.Truncate(offset); err != nil {
+					log.Close()
+					return nil, 0, fmt.Errorf("truncating partial record: %w", err)
+				}
+			}
+			break
+		}
+		if err != nil {
+			log.Close()
+			return nil, 0, fmt.Errorf("reading log: %w", err)
+		}
+		offset += int64(len(data))
+
+		var r record
+		if err := json.Unmarshal(data, &r); err != nil {
+			log.Close()
+			return nil, 0, fmt.Errorf("parsing log %s line %d: %w", path, line, err)
+		}
+		records++
+		if r.Seq <= b.seq {
+			// Already in the snapshot
+			continue
+		}
+		b.seq = r.Seq
+		b.appendVersion(r.Path, r.version)
+	}
+	return log, records, nil
+}
+
+// store is the files of a persistent backend
+type store struct {
+	dir          string
+	sync         SyncMode
+	compactAfter int
+
+	// mtx guards the fields below, which are also used by the periodic sync and compaction
+	mtx     sync.Mutex
+	log     *os.File
+	records int
+	closed  bool
+	// compacting is set while a compaction runs, and pending holds the lines appended since it took its copy
+	// of the state, which the log is replaced with once it finishes
+	compacting bool
+	pending    [][]byte
+
+	done        chan struct{}
+	wg          sync.WaitGroup
+	compactions sync.WaitGroup
+}
+
+// append writes r to the log, flushing it if the sync mode is SyncAlways
+func (s *store) append(r record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.closed {
+		return errors.New("backend is closed")
+	}
+	line := append(data, '\n')
+	if _, err := s.log.Write(line); err != nil {
+		return err
+	}
+	s.records++
+	if s.compacting {
+		s.pending = append(s.pending, line)
+	}
+	if s.sync == SyncAlways {
+		return s.log.Sync()
+	}
+	return nil
+}
+
+// startCompaction compacts the log in the background if it holds enough records and isn't already being
+// compacted. state is called to copy the backend's state, so b.mtx must be held, but the copy is written
+// after it is released so that reads and writes aren't blocked.
+func (s *store) startCompaction(state func() snapshot, logger *slog.Logger) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.closed || s.compacting || s.records < s.compactAfter {
+		return
+	}
+	s.compacting = true
+	copied := state()
+	s.compactions.Go(func() {
+		// The writes are already durable in the log, so a failed compaction is retried after the next write
+		if err := s.compact(copied); err != nil {
+			logger.Warn("compacting memory backend log", "error", err)
+		}
+	})
+}
+
+// compact replaces the snapshot with state, then replaces the log with the lines appended since state was copied.
+// If either fails, the log is left holding every record.
+func (s *store) compact(state snapshot) error {
+	data, err := json.Marshal(state)
+	if err == nil {
+		err = writeFileAtomic(s.dir, snapshotFile, data)
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	pending := s.pending
+	s.compacting, s.pending = false, nil
+	if err != nil {
+		return fmt.Errorf("writing snapshot: %w", err)
+	}
+
+	// The new log is written while appends wait, which only takes as long as the lines appended since the copy
+	log, err := os.CreateTemp(s.dir, logFile+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("creating log: %w", err)
+	}
+	_, err = log.Write(bytes.Join(pending, nil))
+	if err == nil {
+		err = log.Sync()
+	}
+	if err == nil {
+		err = os.Rename(log.Name(), filepath.Join(s.dir, logFile))
+	}
+	if err != nil {
+		log.Close()
+		os.Remove(log.Name())
+		return fmt.Errorf("replacing log: %w", err)
+	}
+	s.log.Close()
+	s.log = log
+	s.records = len(pending)
+	return syncDir(s.dir)
+}
+
+// syncLoop flushes the log every interval until the store is closed
+func (s *store) syncLoop(interval time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.mtx.Lock()
+			err := s.log.Sync()
+			s.mtx.Unlock()
+			if err != nil {
+				logger.Warn("syncing memory backend log", "error", err)
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// close stops the periodic sync, then flushes and closes the log
+func (s *store) close() error {
+	s.mtx.Lock()
+	if s.closed {
+		s.mtx.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.mtx.Unlock()
+
+	close(s.done)
+	s.wg.Wait()
+	s.compactions.Wait()
+	return errors.Join(s.log.Sync(), s.log.Close())
+}
+
+// writeFileAtomic replaces the file name in dir with data, so that readers see either the old or new content
+// even after a crash
+func writeFileAtomic(dir, name string, data []byte) error {
+	tmp, err := os.CreateTemp(dir, name+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("creating temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing %s: %w", name, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("syncing %s: %w", name, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("closing %s: %w", name, err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, name)); err != nil {
+		return fmt.Errorf("replacing %s: %w", name, err)
+	}
+	return syncDir(dir)
+}
+
+// syncDir flushes a directory, so that renames within it are durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
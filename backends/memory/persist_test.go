@@ -0,0 +1,220 @@
+package memory
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// reopen closes backend and opens the directory again
+func reopen(t *testing.T, backend *Backend, dir string, opts ...Option) *Backend {
+	t.Helper()
+	if err := backend.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := NewPersistentBackend(dir, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { reopened.Close() })
+	return reopened
+}
+
+func expectData(t *testing.T, backend *Backend, path, expected string) {
+	t.Helper()
+	result, err := backend.GET(t.Context(), path)
+	if expected == "" {
+		expectStatus(t, err, http.StatusNotFound)
+		return
+	}
+	expectStatus(t, err, 0)
+	if string(result.Data) != expected {
+		t.Errorf("%s: expected %q, got %q", path, expected, result.Data)
+	}
+}
+
+func TestPersistence(t *testing.T) {
+	for _, mode := range []SyncMode{SyncAlways, SyncPeriodic, SyncNever} {
+		t.Run(string(mode), func(t *testing.T) {
+			ctx := t.Context()
+			dir := filepath.Join(t.TempDir(), "store")
+			opts := []Option{WithSync(mode), WithSyncInterval(time.Millisecond), WithCompactAfter(3)}
+			backend, err := NewPersistentBackend(dir, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Five writes compact the first three into the snapshot and leave two in the log
+			_, err = backend.POST(ctx, "/a", []byte("a1"))
+			expectStatus(t, err, 0)
+			_, err = backend.POST(ctx, "/b", []byte("b1"))
+			expectStatus(t, err, 0)
+			_, err = backend.PUT(ctx, "/a", []byte("a2"))
+			expectStatus(t, err, 0)
+			_, err = backend.DELETE(ctx, "/b")
+			expectStatus(t, err, 0)
+			_, err = backend.POST(ctx, "/c", []byte("c1"))
+			expectStatus(t, err, 0)
+
+			backend.store.compactions.Wait()
+			if _, err := os.Stat(filepath.Join(dir, snapshotFile)); err != nil {
+				t.Errorf("expected a snapshot: %v", err)
+			}
+			if lines := readLines(t, filepath.Join(dir, logFile)); len(lines) != 2 {
+				t.Errorf("expected 2 records in the log, got %d", len(lines))
+			}
+
+			backend = reopen(t, backend, dir, opts...)
+			expectData(t, backend, "/a", "a2")
+			expectData(t, backend, "/b", "")
+			expectData(t, backend, "/c", "c1")
+			versions, err := backend.History(ctx, "/a")
+			expectStatus(t, err, 0)
+			if len(versions) != 2 {
+				t.Errorf("expected 2 versions of /a, got %+v", versions)
+			}
+
+			// Sequence numbers continue from the persisted state
+			_, err = backend.POST(ctx, "/d", []byte("d1"))
+			expectStatus(t, err, 0)
+			versions, err = backend.History(ctx, "/d")
+			expectStatus(t, err, 0)
+			if len(versions) != 1 || versions[0].ID != "6" {
+				t.Errorf("expected version 6 of /d, got %+v", versions)
+			}
+		})
+	}
+}
+
+func readLines(t *testing.T, path string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Fields(string(data))
+}
+
+func TestPartialRecord(t *testing.T) {
+	ctx := t.Context()
+	dir := t.TempDir()
+	backend, err := NewPersistentBackend(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = backend.POST(ctx, "/a", []byte("a1"))
+	expectStatus(t, err, 0)
+	backend.Close()
+
+	// A crash part way through appending a record leaves it without a newline
+	log, err := os.OpenFile(filepath.Join(dir, logFile), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.WriteString(`{"path":"/b","seq":2,"ti`)
+	log.Close()
+
+	backend = reopen(t, backend, dir)
+	expectData(t, backend, "/a", "a1")
+	expectData(t, backend, "/b", "")
+
+	_, err = backend.POST(ctx, "/b", []byte("b1"))
+	expectStatus(t, err, 0)
+	backend = reopen(t, backend, dir)
+	expectData(t, backend, "/b", "b1")
+}
+
+func TestCorruptLog(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, logFile), []byte("not json\n{}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewPersistentBackend(dir); err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Errorf("expected an error for the corrupt line, got %v", err)
+	}
+}
+
+func TestInterruptedCompaction(t *testing.T) {
+	ctx := t.Context()
+	dir := t.TempDir()
+	backend, err := NewPersistentBackend(dir, WithCompactAfter(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = backend.POST(ctx, "/a", []byte("a1"))
+	expectStatus(t, err, 0)
+	log, err := os.ReadFile(filepath.Join(dir, logFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = backend.PUT(ctx, "/a", []byte("a2"))
+	expectStatus(t, err, 0)
+	backend.Close()
+
+	// A crash after the snapshot was replaced but before the log was replaced leaves records in both
+	if err := os.WriteFile(filepath.Join(dir, logFile), log, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	backend = reopen(t, backend, dir)
+	expectData(t, backend, "/a", "a2")
+	versions, err := backend.History(ctx, "/a")
+	expectStatus(t, err, 0)
+	if len(versions) != 2 {
+		t.Errorf("expected records in the snapshot to be skipped, got %+v", versions)
+	}
+}
+
+func TestClosed(t *testing.T) {
+	backend, err := NewPersistentBackend(t.TempDir(), WithSync(SyncPeriodic))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Close(); err != nil {
+		t.Errorf("expected closing again to succeed, got %v", err)
+	}
+	_, err = backend.POST(t.Context(), "/a", []byte("a1"))
+	expectStatus(t, err, http.StatusInternalServerError)
+	expectData(t, backend, "/a", "")
+
+	if _, err := NewPersistentBackend(t.TempDir(), WithSync("sometimes")); err == nil {
+		t.Error("expected an error for an unknown sync mode")
+	}
+}
+
+// TestConcurrentCompaction writes while compactions run, which must keep the writes made after each copy
+// of the state in the log
+func TestConcurrentCompaction(t *testing.T) {
+	ctx := t.Context()
+	dir := t.TempDir()
+	opts := []Option{WithSync(SyncNever), WithCompactAfter(5)}
+	backend, err := NewPersistentBackend(dir, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Go(func() {
+			for j := range 25 {
+				_, err := backend.POST(ctx, fmt.Sprintf("/%d/%d", i, j), []byte("{}"))
+				expectStatus(t, err, 0)
+			}
+		})
+	}
+	wg.Wait()
+
+	backend = reopen(t, backend, dir, opts...)
+	paths, err := backend.List(ctx, "/")
+	expectStatus(t, err, 0)
+	if len(paths) != 200 {
+		t.Errorf("expected 200 resources after reopening, got %d", len(paths))
+	}
+}
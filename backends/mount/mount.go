@@ -0,0 +1,330 @@
+// Package mount provides a backend that routes requests to other backends by path prefix,
+// so that, for example, /config/ can be stored in Git while /cache/ is stored in S3.
+//
+// Every request addresses a single path, so is handled entirely by the one backend mounted
+// at the longest matching prefix. Capabilities that would span mounts are not combined:
+// the mount backend does not implement gitbackedrest.ChangeFeed, since versions from different
+// backends cannot be compared, so servers fall back to reporting changes made through them.
+package mount
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+var (
+	_ gitbackedrest.APIBackend        = (*Backend)(nil)
+	_ gitbackedrest.HealthChecker     = (*Backend)(nil)
+	_ gitbackedrest.Diagnoser         = (*Backend)(nil)
+	_ gitbackedrest.Lister            = (*Backend)(nil)
+	_ gitbackedrest.Historian         = (*Backend)(nil)
+	_ gitbackedrest.ConditionalWriter = (*Backend)(nil)
+)
+
+// Mount attaches a backend at a path prefix
+type Mount struct {
+	// Prefix is the path under which the backend is mounted, e.g. "/config/".
+	// A prefix of "/" matches every path not matched by a longer prefix.
+	Prefix string
+	// Backend serves requests for paths under Prefix
+	Backend gitbackedrest.APIBackend
+	// StripPrefix removes Prefix from paths before they are passed to Backend,
+	// so /config/app.json is stored as /app.json.
+	StripPrefix bool
+}
+
+// Backend implements APIBackend by delegating to the backend with the longest prefix matching each path
+type Backend struct {
+	// mounts are ordered by descending prefix length, so the first match is the longest
+	mounts []Mount
+}
+
+// NewBackend creates a backend routing to the given mounts.
+// Prefixes are normalized to begin and end with "/", and must be unique.
+func NewBackend(mounts ...Mount) (*Backend, error) {
+	b := &Backend{}
+	seen := make(map[string]bool)
+	for i, m := range mounts {
+		if m.Backend == nil {
+			return nil, fmt.Errorf("mount %d: backend is required", i)
+		}
+		m.Prefix = normalizePrefix(m.Prefix)
+		if seen[m.Prefix] {
+			return nil, fmt.Errorf("mount %d: prefix %s is already mounted", i, m.Prefix)
+		}
+		seen[m.Prefix] = true
+		b.mounts = append(b.mounts, m)
+	}
+	sort.SliceStable(b.mounts, func(i, j int) bool {
+		return len(b.mounts[i].Prefix) > len(b.mounts[j].Prefix)
+	})
+	return b, nil
+}
+
+func normalizePrefix(prefix string) string {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return "/"
+	}
+	return "/" + prefix + "/"
+}
+
+// Mounts returns the backend's mounts, ordered from longest to shortest prefix
+func (b *Backend) Mounts() []Mount {
+	return append([]Mount(nil), b.mounts...)
+}
+
+// backendPath returns the path passed to the mount's backend for a path under its prefix
+func (m Mount) backendPath(path string) string {
+	if !m.StripPrefix {
+		return path
+	}
+	return "/" + strings.TrimPrefix(strings.TrimPrefix(path, strings.TrimSuffix(m.Prefix, "/")), "/")
+}
+
+// route returns the mount for path and the path to pass to its backend
+func (b *Backend) route(path string) (Mount, string, error) {
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	for _, m := range b.mounts {
+		// A path equal to the prefix without its trailing slash is the root of the mount
+		if !strings.HasPrefix(path, m.Prefix) && path+"/" != m.Prefix {
+			continue
+		}
+		backendPath := m.backendPath(path)
+		if backendPath == "/" {
+			// The root of a mount with its prefix stripped is the root of its backend, which is not a resource
+			return Mount{}, "", gitbackedrest.NewUserError(
+				"Not Found",
+				gitbackedrest.NewHTTPError(
+					http.StatusNotFound,
+					fmt.Errorf("%s is the root of the backend mounted at %s", path, m.Prefix),
+				),
+			)
+		}
+		return m, backendPath, nil
+	}
+	return Mount{}, "", gitbackedrest.NewUserError(
+		"Not Found",
+		gitbackedrest.NewHTTPError(
+			http.StatusNotFound,
+			fmt.Errorf("no backend is mounted for %s", path),
+		),
+	)
+}
+
+// GET implements gitbackedrest.APIBackend.
+func (b *Backend) GET(ctx context.Context, path string) (*gitbackedrest.GetResult, error) {
+	m, path, err := b.route(path)
+	if err != nil {
+		return nil, err
+	}
+	return m.Backend.GET(ctx, path)
+}
+
+// POST implements gitbackedrest.APIBackend.
+func (b *Backend) POST(ctx context.Context, path string, body []byte) (*gitbackedrest.Result, error) {
+	m, path, err := b.route(path)
+	if err != nil {
+		return nil, err
+	}
+	return m.Backend.POST(ctx, path, body)
+}
+
+// PUT implements gitbackedrest.APIBackend.
+func (b *Backend) PUT(ctx context.Context, path string, body []byte) (*gitbackedrest.Result, error) {
+	m, path, err := b.route(path)
+	if err != nil {
+		return nil, err
+	}
+	return m.Backend.PUT(ctx, path, body)
+}
+
+// DELETE implements gitbackedrest.APIBackend.
+func (b *Backend) DELETE(ctx context.Context, path string) (*gitbackedrest.Result, error) {
+	m, path, err := b.route(path)
+	if err != nil {
+		return nil, err
+	}
+	return m.Backend.DELETE(ctx, path)
+}
+
+// List implements gitbackedrest.Lister, combining the listings of every mount that may hold
+// resources under prefix. Paths shadowed by a longer mount are omitted, since they cannot be read.
+// It fails if any of those mounts cannot list its resources.
+func (b *Backend) List(ctx context.Context, prefix string) ([]string, error) {
+	if !strings.HasPrefix(prefix, "/") {
+		prefix = "/" + prefix
+	}
+
+	var paths []string
+	for _, m := range b.mounts {
+		var backendPrefix string
+		switch {
+		case strings.HasPrefix(prefix, m.Prefix):
+			// Listing within the mount
+			backendPrefix = m.backendPath(prefix)
+		case strings.HasPrefix(m.Prefix, prefix):
+			// The whole mount is under the prefix
+			backendPrefix = m.Prefix
+			if m.StripPrefix {
+				backendPrefix = "/"
+			}
+		default:
+			continue
+		}
+
+		lister, ok := m.Backend.(gitbackedrest.Lister)
+		if !ok {
+			return nil, gitbackedrest.NewUserError(
+				"Listing is not supported by this backend",
+				gitbackedrest.NewHTTPError(
+					http.StatusNotImplemented,
+					fmt.Errorf("backend mounted at %s cannot list resources", m.Prefix),
+				),
+			)
+		}
+		mounted, err := lister.List(ctx, backendPrefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range mounted {
+			if m.StripPrefix {
+				path = strings.TrimSuffix(m.Prefix, "/") + path
+			}
+			if !strings.HasPrefix(path, prefix) {
+				continue
+			}
+			if owner, _, err := b.route(path); err != nil || owner.Prefix != m.Prefix {
+				continue
+			}
+			paths = append(paths, path)
+		}
+	}
+	slices.Sort(paths)
+	return paths, nil
+}
+
+// conditionalWriter returns the conditional writes of the backend mounted for path and the path to pass to it
+func (b *Backend) conditionalWriter(path string) (gitbackedrest.ConditionalWriter, string, error) {
+	m, path, err := b.route(path)
+	if err != nil {
+		return nil, "", err
+	}
+	writer, ok := m.Backend.(gitbackedrest.ConditionalWriter)
+	if !ok {
+		return nil, "", gitbackedrest.NewUserError(
+			"Conditional writes are not supported by this backend",
+			gitbackedrest.NewHTTPError(
+				http.StatusNotImplemented,
+				fmt.Errorf("backend mounted at %s cannot check If-Match atomically with writes", m.Prefix),
+			),
+		)
+	}
+	return writer, path, nil
+}
+
+// PUTIfMatch implements gitbackedrest.ConditionalWriter, if the backend mounted for path does.
+func (b *Backend) PUTIfMatch(ctx context.Context, path string, body []byte, ifMatch string) (*gitbackedrest.Result, error) {
+	writer, path, err := b.conditionalWriter(path)
+	if err != nil {
+		return nil, err
+	}
+	return writer.PUTIfMatch(ctx, path, body, ifMatch)
+}
+
+// DELETEIfMatch implements gitbackedrest.ConditionalWriter, if the backend mounted for path does.
+func (b *Backend) DELETEIfMatch(ctx context.Context, path, ifMatch string) (*gitbackedrest.Result, error) {
+	writer, path, err := b.conditionalWriter(path)
+	if err != nil {
+		return nil, err
+	}
+	return writer.DELETEIfMatch(ctx, path, ifMatch)
+}
+
+// historian returns the history of the backend mounted for path and the path to pass to it
+func (b *Backend) historian(path string) (gitbackedrest.Historian, string, error) {
+	m, path, err := b.route(path)
+	if err != nil {
+		return nil, "", err
+	}
+	historian, ok := m.Backend.(gitbackedrest.Historian)
+	if !ok {
+		return nil, "", gitbackedrest.NewUserError(
+			"History is not supported by this backend",
+			gitbackedrest.NewHTTPError(
+				http.StatusNotImplemented,
+				fmt.Errorf("backend mounted at %s has no history", m.Prefix),
+			),
+		)
+	}
+	return historian, path, nil
+}
+
+// History implements gitbackedrest.Historian, if the backend mounted for path keeps history.
+func (b *Backend) History(ctx context.Context, path string) ([]gitbackedrest.Version, error) {
+	historian, path, err := b.historian(path)
+	if err != nil {
+		return nil, err
+	}
+	return historian.History(ctx, path)
+}
+
+// GETVersion implements gitbackedrest.Historian, if the backend mounted for path keeps history.
+func (b *Backend) GETVersion(ctx context.Context, path, version string) (*gitbackedrest.GetResult, error) {
+	historian, path, err := b.historian(path)
+	if err != nil {
+		return nil, err
+	}
+	return historian.GETVersion(ctx, path, version)
+}
+
+// CheckHealth implements gitbackedrest.HealthChecker by checking every mounted backend that supports it.
+func (b *Backend) CheckHealth(ctx context.Context) error {
+	var errs []error
+	for _, m := range b.mounts {
+		if checker, ok := m.Backend.(gitbackedrest.HealthChecker); ok {
+			if err := checker.CheckHealth(ctx); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", m.Prefix, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Diagnostics implements gitbackedrest.Diagnoser, reporting the diagnostics of each mounted backend by prefix.
+func (b *Backend) Diagnostics(ctx context.Context) map[string]any {
+	diagnostics := make(map[string]any)
+	for _, m := range b.mounts {
+		mount := map[string]any{
+			"backend":      fmt.Sprintf("%T", m.Backend),
+			"strip_prefix": m.StripPrefix,
+		}
+		if diagnoser, ok := m.Backend.(gitbackedrest.Diagnoser); ok {
+			mount["diagnostics"] = diagnoser.Diagnostics(ctx)
+		}
+		diagnostics[m.Prefix] = mount
+	}
+	return map[string]any{"mounts": diagnostics}
+}
+
+// Close closes every mounted backend that has a Close method.
+func (b *Backend) Close() error {
+	var errs []error
+	for _, m := range b.mounts {
+		if closer, ok := m.Backend.(interface{ Close() error }); ok {
+			if err := closer.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", m.Prefix, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
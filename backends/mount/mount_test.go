@@ -0,0 +1,170 @@
+package mount
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"testing"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+	"github.com/theothertomelliott/git-backed-rest/backends/memory"
+)
+
+func TestRouting(t *testing.T) {
+	ctx := context.Background()
+
+	root, config, nested := memory.NewBackend(), memory.NewBackend(), memory.NewBackend()
+	backend, err := NewBackend(
+		Mount{Prefix: "/", Backend: root},
+		Mount{Prefix: "config", Backend: config, StripPrefix: true},
+		Mount{Prefix: "/config/secrets/", Backend: nested},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/config/app.json", "/config/secrets/key", "/configuration", "/other"} {
+		if _, err := backend.POST(ctx, path, []byte(path)); err != nil {
+			t.Fatalf("POST %s: %v", path, err)
+		}
+		result, err := backend.GET(ctx, path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		if string(result.Data) != path {
+			t.Errorf("GET %s: expected %q, got %q", path, path, result.Data)
+		}
+	}
+
+	for _, test := range []struct {
+		backend *memory.Backend
+		path    string
+	}{
+		{config, "/app.json"},
+		{nested, "/config/secrets/key"},
+		{root, "/configuration"},
+		{root, "/other"},
+	} {
+		if _, err := test.backend.GET(ctx, test.path); err != nil {
+			t.Errorf("expected %s to be stored in the mounted backend: %v", test.path, err)
+		}
+	}
+}
+
+func TestStrippedMountRoot(t *testing.T) {
+	ctx := context.Background()
+
+	config := memory.NewBackend()
+	backend, err := NewBackend(
+		Mount{Prefix: "/", Backend: memory.NewBackend()},
+		Mount{Prefix: "/config/", Backend: config, StripPrefix: true},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The prefix itself would be the path / in the mounted backend
+	for _, path := range []string{"/config", "/config/"} {
+		_, err := backend.POST(ctx, path, []byte("content"))
+		if code := gitbackedrest.GetHTTPStatusCode(err, 0); code != http.StatusNotFound {
+			t.Errorf("POST %s: expected status code %d, got %d", path, http.StatusNotFound, code)
+		}
+		_, err = backend.GET(ctx, path)
+		if code := gitbackedrest.GetHTTPStatusCode(err, 0); code != http.StatusNotFound {
+			t.Errorf("GET %s: expected status code %d, got %d", path, http.StatusNotFound, code)
+		}
+	}
+	if paths, _ := config.List(ctx, "/"); len(paths) != 0 {
+		t.Errorf("expected nothing to be written to the mounted backend, got %v", paths)
+	}
+}
+
+func TestNoMatchingMount(t *testing.T) {
+	backend, err := NewBackend(Mount{Prefix: "/config/", Backend: memory.NewBackend()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = backend.GET(context.Background(), "/cache/item")
+	if code := gitbackedrest.GetHTTPStatusCode(err, 0); code != http.StatusNotFound {
+		t.Errorf("expected status code %d, got %d", http.StatusNotFound, code)
+	}
+}
+
+func TestInvalidMounts(t *testing.T) {
+	if _, err := NewBackend(Mount{Prefix: "/config/"}); err == nil {
+		t.Error("expected an error for a mount without a backend")
+	}
+	if _, err := NewBackend(
+		Mount{Prefix: "/config", Backend: memory.NewBackend()},
+		Mount{Prefix: "/config/", Backend: memory.NewBackend()},
+	); err == nil {
+		t.Error("expected an error for duplicate prefixes")
+	}
+}
+
+// unhealthyBackend is a backend whose health check always fails
+type unhealthyBackend struct {
+	*memory.Backend
+}
+
+func (unhealthyBackend) CheckHealth(ctx context.Context) error {
+	return errors.New("unreachable")
+}
+
+func TestCheckHealth(t *testing.T) {
+	backend, err := NewBackend(
+		Mount{Prefix: "/config/", Backend: memory.NewBackend()},
+		Mount{Prefix: "/cache/", Backend: unhealthyBackend{memory.NewBackend()}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := backend.CheckHealth(context.Background()); err == nil || err.Error() != "/cache/: unreachable" {
+		t.Errorf("expected the failing mount to be reported, got %v", err)
+	}
+}
+
+func TestList(t *testing.T) {
+	ctx := context.Background()
+
+	root, config, nested := memory.NewBackend(), memory.NewBackend(), memory.NewBackend()
+	backend, err := NewBackend(
+		Mount{Prefix: "/", Backend: root},
+		Mount{Prefix: "config", Backend: config, StripPrefix: true},
+		Mount{Prefix: "/config/secrets/", Backend: nested},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/config/app.json", "/config/secrets/key", "/configuration", "/other"} {
+		if _, err := backend.POST(ctx, path, []byte(path)); err != nil {
+			t.Fatalf("POST %s: %v", path, err)
+		}
+	}
+	// Shadowed by the /config/ mount, so it cannot be read through the mount backend
+	if _, err := root.POST(ctx, "/config/hidden", []byte("hidden")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		prefix   string
+		expected []string
+	}{
+		{"/", []string{"/config/app.json", "/config/secrets/key", "/configuration", "/other"}},
+		{"/config/", []string{"/config/app.json", "/config/secrets/key"}},
+		{"/config/secrets/", []string{"/config/secrets/key"}},
+		{"/config", []string{"/config/app.json", "/config/secrets/key", "/configuration"}},
+		{"/missing/", nil},
+	} {
+		paths, err := backend.List(ctx, test.prefix)
+		if err != nil {
+			t.Fatalf("List %s: %v", test.prefix, err)
+		}
+		if !slices.Equal(paths, test.expected) {
+			t.Errorf("List %s: expected %v, got %v", test.prefix, test.expected, paths)
+		}
+	}
+}
@@ -1,79 +1,153 @@
 package s3
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"path"
-	"runtime/trace"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
-	"github.com/aws/smithy-go"
 
 	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
 )
 
-var _ gitbackedrest.APIBackend = (*Backend)(nil)
+var (
+	_ gitbackedrest.APIBackend        = (*Backend)(nil)
+	_ gitbackedrest.HealthChecker     = (*Backend)(nil)
+	_ gitbackedrest.Lister            = (*Backend)(nil)
+	_ gitbackedrest.Historian         = (*Backend)(nil)
+	_ gitbackedrest.ConditionalWriter = (*Backend)(nil)
+)
 
 // Config holds configuration for S3-compatible storage
 type Config struct {
-	// Endpoint is the S3-compatible endpoint URL (e.g., https://<account-id>.r2.cloudflarestorage.com)
+	// Endpoint is the S3-compatible endpoint URL (e.g., https://<account-id>.r2.cloudflarestorage.com).
+	// If empty, the AWS S3 endpoint for the region is used.
 	Endpoint string
-	// AccessKeyID is the access key for authentication
+	// AccessKeyID is the access key for authentication. If empty, credentials are found with the
+	// AWS credential chain: environment variables, shared config profiles and web identity tokens.
 	AccessKeyID string
-	// SecretAccessKey is the secret key for authentication
+	// SecretAccessKey is the secret key for authentication, required with AccessKeyID
 	SecretAccessKey string
+	// SessionToken is the session token for temporary credentials given with AccessKeyID
+	SessionToken string
+	// Profile is the shared config profile to load credentials and region from, instead of AWS_PROFILE
+	Profile string
 	// Bucket is the bucket name to use
 	Bucket string
 	// Prefix is an optional path prefix within the bucket (e.g., "test/store1")
 	// This allows multiple stores to coexist in the same bucket
 	Prefix string
-	// Region is the AWS region (can be "auto" for R2)
+	// Region is the AWS region (can be "auto" for R2). If empty, it is taken from AWS_REGION or the profile,
+	// and defaults to "auto" when an endpoint is given.
 	Region string
+	// UsePathStyle addresses buckets in the URL path rather than the host name, as needed by MinIO and Ceph
+	UsePathStyle bool
+	// CABundle is the path of a PEM file of certificate authorities to trust as well as the system's,
+	// for endpoints with private certificates
+	CABundle string
+	// RequestTimeout limits each attempt of a request, including reading the response. Zero means no limit.
+	RequestTimeout time.Duration
+	// MaxAttempts is the most times a request is attempted, including retries. Defaults to 3.
+	MaxAttempts int
+	// MaxBackoff is the longest delay between retries. Defaults to 20 seconds.
+	MaxBackoff time.Duration
+	// Logger is used to log backend operations. If nil, the default slog logger is used.
+	Logger *slog.Logger
+	// Metrics records the latency of S3 API calls by operation. If nil, no metrics are recorded.
+	Metrics *gitbackedrest.BackendMetrics
+	// BulkConcurrency limits how many requests bulk operations, such as DeletePrefix and Export, make at once.
+	// Defaults to 8.
+	BulkConcurrency int
+	// ConditionalWrites selects how writes are made atomic. By default, conditional requests are used
+	// where the provider supports them, and lock objects otherwise.
+	ConditionalWrites ConditionalWriteMode
+	// History selects how previous versions of resources are kept. By default, bucket versioning is used
+	// if it is enabled, and history copies otherwise.
+	History HistoryMode
+	// HistoryMaxVersions limits how many history copies of each resource are kept, dropping the oldest after
+	// each write. Defaults to 10, and a negative limit keeps every copy. Versions kept by bucket versioning
+	// are expired by the bucket's lifecycle rules instead.
+	HistoryMaxVersions int
+	// ObjectSettings sets the server-side encryption and storage class of written objects.
+	// With SSE-C, the customer key is also sent to read objects.
+	ObjectSettings ObjectSettings
+	// PrefixObjectSettings overrides ObjectSettings for resources under path prefixes, such as "/archive/".
+	// The longest matching prefix is used, and its settings replace ObjectSettings entirely.
+	PrefixObjectSettings map[string]ObjectSettings
 }
 
 // Backend implements APIBackend using S3-compatible storage
 type Backend struct {
-	client *s3.Client
-	bucket string
-	prefix string
+	client  *s3.Client
+	bucket  string
+	prefix  string
+	logger  *slog.Logger
+	metrics *gitbackedrest.BackendMetrics
+
+	conditionalWrites ConditionalWriteMode
+	// useLocks is set when writes use lock objects rather than conditional requests
+	useLocks atomic.Bool
+	lockTTL  time.Duration
+
+	bulkConcurrency int
+
+	// historyMtx guards history, which is resolved from HistoryAuto on first use
+	historyMtx sync.Mutex
+	history    HistoryMode
+	// historyMaxVersions limits the history copies kept of each resource, if not negative
+	historyMaxVersions int
+
+	objects objectPolicy
 }
 
 // NewBackend creates a new S3-compatible backend
 func NewBackend(cfg Config) (*Backend, error) {
-	if cfg.Endpoint == "" {
-		return nil, fmt.Errorf("endpoint is required")
-	}
-	if cfg.AccessKeyID == "" {
-		return nil, fmt.Errorf("access key ID is required")
+	if err := cfg.validate(); err != nil {
+		return nil, err
 	}
-	if cfg.SecretAccessKey == "" {
-		return nil, fmt.Errorf("secret access key is required")
+	if cfg.BulkConcurrency <= 0 {
+		cfg.BulkConcurrency = defaultBulkConcurrency
 	}
-	if cfg.Bucket == "" {
-		return nil, fmt.Errorf("bucket is required")
+	if cfg.HistoryMaxVersions == 0 {
+		cfg.HistoryMaxVersions = defaultHistoryMaxVersions
 	}
-	if cfg.Region == "" {
-		cfg.Region = "auto"
+	awsConfig, err := loadAWSConfig(context.Background(), cfg)
+	if err != nil {
+		return nil, err
 	}
 
-	client := s3.NewFromConfig(aws.Config{
-		Region:       cfg.Region,
-		Credentials:  credentials.NewStaticCredentialsProvider(cfg.AccessKeyID, cfg.SecretAccessKey, ""),
-		BaseEndpoint: aws.String(cfg.Endpoint),
+	b := &Backend{
+		bucket:             cfg.Bucket,
+		prefix:             cfg.Prefix,
+		logger:             cfg.Logger,
+		metrics:            cfg.Metrics,
+		conditionalWrites:  cfg.ConditionalWrites,
+		lockTTL:            defaultLockTTL,
+		history:            cfg.History,
+		historyMaxVersions: cfg.HistoryMaxVersions,
+		bulkConcurrency:    cfg.BulkConcurrency,
+		objects:            newObjectPolicy(cfg.ObjectSettings, cfg.PrefixObjectSettings),
+	}
+	b.useLocks.Store(cfg.ConditionalWrites == ConditionalWritesLock)
+	b.client = s3.NewFromConfig(awsConfig, func(o *s3.Options) {
+		if cfg.Endpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.Endpoint)
+		}
+		o.UsePathStyle = cfg.UsePathStyle
+		o.APIOptions = append(o.APIOptions, b.addPhaseMiddleware, b.addObjectOptionsMiddleware)
 	})
 
-	return &Backend{
-		client: client,
-		bucket: cfg.Bucket,
-		prefix: cfg.Prefix,
-	}, nil
+	return b, nil
 }
 
 // buildKey constructs the full S3 key from the path and prefix
@@ -86,8 +160,12 @@ func (b *Backend) buildKey(p string) string {
 
 // GET implements gitbackedrest.APIBackend.
 func (b *Backend) GET(ctx context.Context, p string) (*gitbackedrest.GetResult, error) {
-	defer trace.StartRegion(ctx, "GET").End()
+	ctx, phase := gitbackedrest.StartPhase(ctx, b.logger, "GET")
+	defer phase.End()
 
+	if err := checkPath(p); err != nil {
+		return nil, err
+	}
 	key := b.buildKey(p)
 
 	output, err := b.client.GetObject(ctx, &s3.GetObjectInput{
@@ -133,154 +211,109 @@ func (b *Backend) GET(ctx context.Context, p string) (*gitbackedrest.GetResult,
 
 // POST implements gitbackedrest.APIBackend.
 func (b *Backend) POST(ctx context.Context, p string, body []byte) (*gitbackedrest.Result, error) {
-	defer trace.StartRegion(ctx, "POST").End()
+	ctx, phase := gitbackedrest.StartPhase(ctx, b.logger, "POST")
+	defer phase.End()
 
-	key := b.buildKey(p)
+	return b.write(ctx, p, writeCreate, body, "")
+}
 
-	// Check if object already exists
-	_, err := b.client.HeadObject(ctx, &s3.HeadObjectInput{
-		Bucket: aws.String(b.bucket),
-		Key:    aws.String(key),
-	})
-	if err == nil {
-		// Object exists, return conflict
-		return nil, gitbackedrest.NewUserError(
-			"Conflict",
-			gitbackedrest.NewHTTPError(
-				http.StatusConflict,
-				errors.New("resource already exists"),
-			),
-		)
-	}
+// PUT implements gitbackedrest.APIBackend.
+func (b *Backend) PUT(ctx context.Context, p string, body []byte) (*gitbackedrest.Result, error) {
+	ctx, phase := gitbackedrest.StartPhase(ctx, b.logger, "PUT")
+	defer phase.End()
 
-	// Upload the object
-	_, err = b.client.PutObject(ctx, &s3.PutObjectInput{
-		Bucket: aws.String(b.bucket),
-		Key:    aws.String(key),
-		Body:   bytes.NewReader(body),
-	})
-	if err != nil {
-		return nil, gitbackedrest.NewUserError(
-			"Internal Server Error",
-			gitbackedrest.NewHTTPError(
-				http.StatusInternalServerError,
-				fmt.Errorf("putting object: %w", err),
-			),
-		)
-	}
+	return b.write(ctx, p, writeUpdate, body, "")
+}
 
-	return &gitbackedrest.Result{
-		Retries: 0, // S3 POST doesn't retry
-	}, nil
+// DELETE implements gitbackedrest.APIBackend.
+func (b *Backend) DELETE(ctx context.Context, p string) (*gitbackedrest.Result, error) {
+	ctx, phase := gitbackedrest.StartPhase(ctx, b.logger, "DELETE")
+	defer phase.End()
+
+	return b.write(ctx, p, writeDelete, nil, "")
 }
 
-// PUT implements gitbackedrest.APIBackend.
-func (b *Backend) PUT(ctx context.Context, p string, body []byte) (*gitbackedrest.Result, error) {
-	defer trace.StartRegion(ctx, "PUT").End()
+// PUTIfMatch implements gitbackedrest.ConditionalWriter, reading the object to check its content and then
+// writing it with a conditional request on the S3 ETag read, or while holding its lock.
+func (b *Backend) PUTIfMatch(ctx context.Context, p string, body []byte, ifMatch string) (*gitbackedrest.Result, error) {
+	ctx, phase := gitbackedrest.StartPhase(ctx, b.logger, "PUT")
+	defer phase.End()
 
-	key := b.buildKey(p)
+	return b.write(ctx, p, writeUpdate, body, ifMatch)
+}
 
-	// Check if object exists
-	_, err := b.client.HeadObject(ctx, &s3.HeadObjectInput{
-		Bucket: aws.String(b.bucket),
-		Key:    aws.String(key),
-	})
-	if err != nil {
-		var noSuchKey *types.NoSuchKey
-		var apiError smithy.APIError
-		if errors.As(err, &noSuchKey) || (errors.As(err, &apiError) && apiError.ErrorCode() == "NotFound") {
-			// Object doesn't exist, return not found
-			return nil, gitbackedrest.NewUserError(
-				"Not Found",
-				gitbackedrest.NewHTTPError(
-					http.StatusNotFound,
-					errors.New("resource not found"),
-				),
-			)
-		}
-		// Some other error occurred
-		return nil, gitbackedrest.NewUserError(
-			"Internal Server Error",
-			gitbackedrest.NewHTTPError(
-				http.StatusInternalServerError,
-				fmt.Errorf("checking object existence: %w", err),
-			),
-		)
+// DELETEIfMatch implements gitbackedrest.ConditionalWriter, checking ifMatch as PUTIfMatch does.
+func (b *Backend) DELETEIfMatch(ctx context.Context, p, ifMatch string) (*gitbackedrest.Result, error) {
+	ctx, phase := gitbackedrest.StartPhase(ctx, b.logger, "DELETE")
+	defer phase.End()
+
+	return b.write(ctx, p, writeDelete, nil, ifMatch)
+}
+
+// pathForKey returns the resource path for an S3 key under the backend's prefix
+func (b *Backend) pathForKey(key string) string {
+	if b.prefix != "" {
+		key = strings.TrimPrefix(key, path.Clean(b.prefix)+"/")
+	}
+	if !strings.HasPrefix(key, "/") {
+		key = "/" + key
 	}
+	return key
+}
 
-	// Update the object
-	_, err = b.client.PutObject(ctx, &s3.PutObjectInput{
-		Bucket: aws.String(b.bucket),
-		Key:    aws.String(key),
-		Body:   bytes.NewReader(body),
-	})
-	if err != nil {
-		return nil, gitbackedrest.NewUserError(
-			"Internal Server Error",
+// isInternalPath reports whether path p holds the backend's own objects, such as locks and history copies,
+// rather than a resource
+func isInternalPath(p string) bool {
+	return strings.HasPrefix(p, "/"+lockPrefix+"/") || strings.HasPrefix(p, "/"+historyPrefix+"/")
+}
+
+// checkPath rejects paths that would refer to the backend's own objects once their key is built
+func checkPath(p string) error {
+	if isInternalPath(path.Clean("/" + p)) {
+		return gitbackedrest.NewUserError(
+			"Invalid path",
 			gitbackedrest.NewHTTPError(
-				http.StatusInternalServerError,
-				fmt.Errorf("putting object: %w", err),
+				http.StatusBadRequest,
+				fmt.Errorf("%q: %s/ and %s/ are reserved", p, lockPrefix, historyPrefix),
 			),
 		)
 	}
-
-	return &gitbackedrest.Result{
-		Retries: 0, // S3 PUT doesn't retry
-	}, nil
+	return nil
 }
 
-// DELETE implements gitbackedrest.APIBackend.
-func (b *Backend) DELETE(ctx context.Context, p string) (*gitbackedrest.Result, error) {
-	defer trace.StartRegion(ctx, "DELETE").End()
-
-	key := b.buildKey(p)
+// List implements gitbackedrest.Lister, listing the keys under the backend's prefix.
+func (b *Backend) List(ctx context.Context, p string) ([]string, error) {
+	ctx, phase := gitbackedrest.StartPhase(ctx, b.logger, "List")
+	defer phase.End()
 
-	// Check if object exists
-	_, err := b.client.HeadObject(ctx, &s3.HeadObjectInput{
-		Bucket: aws.String(b.bucket),
-		Key:    aws.String(key),
+	var paths []string
+	err := b.walkKeys(ctx, b.keyPrefix(p), func(keys []string) error {
+		for _, key := range b.resourceKeys(keys) {
+			paths = append(paths, b.pathForKey(key))
+		}
+		return nil
 	})
 	if err != nil {
-		var noSuchKey *types.NoSuchKey
-		var apiError smithy.APIError
-		if errors.As(err, &noSuchKey) || (errors.As(err, &apiError) && apiError.ErrorCode() == "NotFound") {
-			// Object doesn't exist, return not found
-			return nil, gitbackedrest.NewUserError(
-				"Not Found",
-				gitbackedrest.NewHTTPError(
-					http.StatusNotFound,
-					errors.New("resource not found"),
-				),
-			)
-		}
-		// Some other error occurred
 		return nil, gitbackedrest.NewUserError(
 			"Internal Server Error",
 			gitbackedrest.NewHTTPError(
 				http.StatusInternalServerError,
-				fmt.Errorf("checking object existence: %w", err),
+				err,
 			),
 		)
 	}
+	return paths, nil
+}
 
-	// Delete the object
-	_, err = b.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+// CheckHealth implements gitbackedrest.HealthChecker by checking the bucket is reachable.
+func (b *Backend) CheckHealth(ctx context.Context) error {
+	_, err := b.client.HeadBucket(ctx, &s3.HeadBucketInput{
 		Bucket: aws.String(b.bucket),
-		Key:    aws.String(key),
 	})
 	if err != nil {
-		return nil, gitbackedrest.NewUserError(
-			"Internal Server Error",
-			gitbackedrest.NewHTTPError(
-				http.StatusInternalServerError,
-				fmt.Errorf("deleting object: %w", err),
-			),
-		)
+		return fmt.Errorf("checking bucket %s: %w", b.bucket, err)
 	}
-
-	return &gitbackedrest.Result{
-		Retries: 0, // S3 DELETE doesn't retry
-	}, nil
+	return nil
 }
 
 // Close cleans up resources (currently no-op but allows for future cleanup)
@@ -288,38 +321,17 @@ func (b *Backend) Close() error {
 	return nil
 }
 
-// CleanupPrefix deletes all objects under the backend's prefix
+// CleanupPrefix deletes all objects under the backend's prefix, including locks and history.
 // Useful for test cleanup
 func (b *Backend) CleanupPrefix(ctx context.Context) error {
-	defer trace.StartRegion(ctx, "CleanupPrefix").End()
+	ctx, phase := gitbackedrest.StartPhase(ctx, b.logger, "CleanupPrefix")
+	defer phase.End()
 
 	if b.prefix == "" {
 		return fmt.Errorf("refusing to cleanup empty prefix (would delete entire bucket)")
 	}
 
-	// List all objects under the prefix
-	reg := trace.StartRegion(ctx, "ListObjectsV2")
-	listOutput, err := b.client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
-		Bucket: aws.String(b.bucket),
-		Prefix: aws.String(b.prefix),
-	})
-	reg.End()
-	if err != nil {
-		return fmt.Errorf("listing objects: %w", err)
-	}
-
-	// Delete each object
-	reg = trace.StartRegion(ctx, "DeleteObjects")
-	for _, obj := range listOutput.Contents {
-		_, err := b.client.DeleteObject(ctx, &s3.DeleteObjectInput{
-			Bucket: aws.String(b.bucket),
-			Key:    obj.Key,
-		})
-		if err != nil {
-			return fmt.Errorf("deleting object %s: %w", *obj.Key, err)
-		}
-	}
-	reg.End()
-
-	return nil
+	// The trailing slash stops the cleanup matching sibling prefixes, such as test/store10 for test/store1
+	_, err := b.deleteUnder(ctx, path.Clean(b.prefix)+"/", func(keys []string) []string { return keys })
+	return err
 }
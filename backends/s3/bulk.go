@@ -0,0 +1,260 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+// defaultBulkConcurrency is how many requests bulk operations make at once if Config.BulkConcurrency is not set
+const defaultBulkConcurrency = 8
+
+// maxDeleteBatch is the most keys S3 deletes in one DeleteObjects request
+const maxDeleteBatch = 1000
+
+// KeyError is the failure of a bulk operation for one object
+type KeyError struct {
+	Key string
+	Err error
+}
+
+// BulkError reports the objects a bulk operation failed for. The operation succeeded for every other object.
+type BulkError struct {
+	// Failed lists the objects that failed, in no particular order
+	Failed []KeyError
+}
+
+func (e *BulkError) Error() string {
+	first := e.Failed[0]
+	if len(e.Failed) == 1 {
+		return fmt.Sprintf("%s: %v", first.Key, first.Err)
+	}
+	return fmt.Sprintf("%d objects failed, including %s: %v", len(e.Failed), first.Key, first.Err)
+}
+
+// Unwrap returns the error for each failed object
+func (e *BulkError) Unwrap() []error {
+	errs := make([]error, len(e.Failed))
+	for i, failed := range e.Failed {
+		errs[i] = failed.Err
+	}
+	return errs
+}
+
+// bulkError returns a BulkError for failed, or nil if nothing failed
+func bulkError(failed []KeyError) error {
+	if len(failed) == 0 {
+		return nil
+	}
+	return &BulkError{Failed: failed}
+}
+
+// keyPrefix returns the key prefix for the resources under path p. A trailing slash is kept, since joining
+// would drop it and match siblings such as /users2/ for /users/.
+func (b *Backend) keyPrefix(p string) string {
+	keyPrefix := b.buildKey(p)
+	if strings.HasSuffix(p, "/") && !strings.HasSuffix(keyPrefix, "/") {
+		keyPrefix += "/"
+	}
+	return keyPrefix
+}
+
+// walkKeys calls fn with each page of the keys under keyPrefix, following continuation tokens until the
+// listing is complete or fn returns an error
+func (b *Backend) walkKeys(ctx context.Context, keyPrefix string, fn func(keys []string) error) error {
+	paginator := s3.NewListObjectsV2Paginator(b.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(b.bucket),
+		Prefix: aws.String(keyPrefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("listing objects: %w", err)
+		}
+		keys := make([]string, 0, len(page.Contents))
+		for _, obj := range page.Contents {
+			keys = append(keys, aws.ToString(obj.Key))
+		}
+		if err := fn(keys); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resourceKeys filters keys to those of resources, dropping the backend's own objects such as locks
+func (b *Backend) resourceKeys(keys []string) []string {
+	return slices.DeleteFunc(keys, func(key string) bool {
+		return isInternalPath(b.pathForKey(key))
+	})
+}
+
+// pool runs tasks with at most a fixed number at once
+type pool struct {
+	wg  sync.WaitGroup
+	sem chan struct{}
+}
+
+func newPool(concurrency int) *pool {
+	return &pool{sem: make(chan struct{}, concurrency)}
+}
+
+// Go runs task once fewer than the pool's concurrency are running, returning early if ctx is done first
+func (p *pool) Go(ctx context.Context, task func()) error {
+	select {
+	case p.sem <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	p.wg.Go(func() {
+		defer func() { <-p.sem }()
+		task()
+	})
+	return nil
+}
+
+// Wait waits for all running tasks to finish
+func (p *pool) Wait() {
+	p.wg.Wait()
+}
+
+// deleteKeys deletes keys with DeleteObjects, up to maxDeleteBatch in each request, returning the number
+// deleted and the keys that failed. Keys that do not exist are counted as deleted.
+func (b *Backend) deleteKeys(ctx context.Context, keys []string) (int, []KeyError) {
+	var (
+		deleted int
+		failed  []KeyError
+	)
+	for batch := range slices.Chunk(keys, maxDeleteBatch) {
+		objects := make([]types.ObjectIdentifier, len(batch))
+		for i, key := range batch {
+			objects[i] = types.ObjectIdentifier{Key: aws.String(key)}
+		}
+		output, err := b.client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(b.bucket),
+			Delete: &types.Delete{
+				Objects: objects,
+				Quiet:   aws.Bool(true),
+			},
+		})
+		if err != nil {
+			for _, key := range batch {
+				failed = append(failed, KeyError{Key: key, Err: fmt.Errorf("deleting objects: %w", err)})
+			}
+			continue
+		}
+		// Quiet responses only list failures
+		for _, keyErr := range output.Errors {
+			failed = append(failed, KeyError{
+				Key: aws.ToString(keyErr.Key),
+				Err: fmt.Errorf("deleting object: %s: %s", aws.ToString(keyErr.Code), aws.ToString(keyErr.Message)),
+			})
+		}
+		deleted += len(batch) - len(output.Errors)
+	}
+	return deleted, failed
+}
+
+// deleteUnder deletes the objects under keyPrefix that filter keeps from each page of the listing, deleting
+// pages in parallel with listing the next. It returns the number of objects deleted.
+func (b *Backend) deleteUnder(ctx context.Context, keyPrefix string, filter func(keys []string) []string) (int, error) {
+	var (
+		mtx     sync.Mutex
+		deleted int
+		failed  []KeyError
+	)
+	workers := newPool(b.bulkConcurrency)
+	err := b.walkKeys(ctx, keyPrefix, func(keys []string) error {
+		keys = filter(keys)
+		if len(keys) == 0 {
+			return nil
+		}
+		return workers.Go(ctx, func() {
+			n, errs := b.deleteKeys(ctx, keys)
+			mtx.Lock()
+			defer mtx.Unlock()
+			deleted += n
+			failed = append(failed, errs...)
+		})
+	})
+	workers.Wait()
+	return deleted, errors.Join(err, bulkError(failed))
+}
+
+// DeletePrefix deletes every resource under path p, such as "/users/", returning the number deleted.
+// Objects are deleted in batches, several at once, so the deletes are not atomic, and are neither recorded in
+// history nor checked against concurrent writes. Deleting stops if the listing fails. If some objects
+// could not be deleted, the error is a *BulkError listing them.
+func (b *Backend) DeletePrefix(ctx context.Context, p string) (int, error) {
+	ctx, phase := gitbackedrest.StartPhase(ctx, b.logger, "DeletePrefix")
+	defer phase.End()
+
+	return b.deleteUnder(ctx, b.keyPrefix(p), b.resourceKeys)
+}
+
+// Export calls fn with the path and content of every resource under path p, in the order they are listed.
+// Resources are read several at once, but fn is called from one goroutine at a time. Exporting stops if fn
+// returns an error. Resources deleted after being listed are skipped, and if others could not be read,
+// the error is a *BulkError listing them once every other resource has been exported.
+func (b *Backend) Export(ctx context.Context, p string, fn func(path string, data []byte) error) error {
+	ctx, phase := gitbackedrest.StartPhase(ctx, b.logger, "Export")
+	defer phase.End()
+
+	var failed []KeyError
+	err := b.walkKeys(ctx, b.keyPrefix(p), func(keys []string) error {
+		keys = b.resourceKeys(keys)
+
+		// Read the page in parallel, then call fn in order
+		contents := make([][]byte, len(keys))
+		errs := make([]error, len(keys))
+		readers := newPool(b.bulkConcurrency)
+		for i, key := range keys {
+			err := readers.Go(ctx, func() {
+				contents[i], errs[i] = b.readObject(ctx, key)
+			})
+			if err != nil {
+				readers.Wait()
+				return err
+			}
+		}
+		readers.Wait()
+
+		for i, key := range keys {
+			switch {
+			case isNotFound(errs[i]):
+				continue
+			case errs[i] != nil:
+				failed = append(failed, KeyError{Key: key, Err: fmt.Errorf("getting object: %w", errs[i])})
+				continue
+			}
+			if err := fn(b.pathForKey(key), contents[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return errors.Join(err, bulkError(failed))
+}
+
+// readObject returns the content of the object at key
+func (b *Backend) readObject(ctx context.Context, key string) ([]byte, error) {
+	output, err := b.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer output.Body.Close()
+	return io.ReadAll(output.Body)
+}
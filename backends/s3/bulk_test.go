@@ -0,0 +1,192 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/theothertomelliott/git-backed-rest/backends/s3/s3test"
+)
+
+// putKeys writes an object for each key directly, bypassing the backend's writes
+func putKeys(t *testing.T, backend *Backend, keys ...string) {
+	t.Helper()
+
+	workers := newPool(16)
+	errs := make([]error, len(keys))
+	for i, key := range keys {
+		workers.Go(t.Context(), func() {
+			_, errs[i] = backend.client.PutObject(t.Context(), &s3.PutObjectInput{
+				Bucket: aws.String(fakeBucket),
+				Key:    aws.String(key),
+				Body:   strings.NewReader(key),
+			})
+		})
+	}
+	workers.Wait()
+	if err := errors.Join(errs...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCleanupPrefix(t *testing.T) {
+	backend, server := newFakeBackend(t, ConditionalWritesAuto)
+
+	var keys []string
+	for i := range 2500 {
+		keys = append(keys, fmt.Sprintf("store/doc%04d", i))
+	}
+	putKeys(t, backend, append(keys, "store/.locks/doc/lock", "store10/doc", "other")...)
+
+	if err := backend.CleanupPrefix(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	remaining := server.Keys(fakeBucket)
+	slices.Sort(remaining)
+	if !slices.Equal(remaining, []string{"other", "store10/doc"}) {
+		t.Errorf("expected only objects outside the prefix to remain, got %d keys", len(remaining))
+	}
+	if requests := server.Requests(s3test.OpListObjectsV2); requests != 3 {
+		t.Errorf("expected 3 pages to be listed, got %d", requests)
+	}
+	if requests := server.Requests(s3test.OpDeleteObjects); requests != 3 {
+		t.Errorf("expected 3 batches to be deleted, got %d", requests)
+	}
+	if requests := server.Requests(s3test.OpDeleteObject); requests != 0 {
+		t.Errorf("expected no single deletes, got %d", requests)
+	}
+}
+
+func TestDeletePrefix(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		prefix    string
+		failKey   string
+		deleted   int
+		remaining []string
+	}{
+		{
+			name:      "collection",
+			prefix:    "/users/",
+			deleted:   2,
+			remaining: []string{"store/.locks/users/a/lock", "store/users2/c"},
+		},
+		{
+			name:      "prefix without slash",
+			prefix:    "/users",
+			deleted:   3,
+			remaining: []string{"store/.locks/users/a/lock"},
+		},
+		{
+			name:      "partial failure",
+			prefix:    "/users/",
+			failKey:   "store/users/b",
+			deleted:   1,
+			remaining: []string{"store/.locks/users/a/lock", "store/users/b", "store/users2/c"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			backend, server := newFakeBackend(t, ConditionalWritesAuto)
+			putKeys(t, backend, "store/users/a", "store/users/b", "store/users2/c", "store/.locks/users/a/lock")
+			if test.failKey != "" {
+				server.InjectKeyError(test.failKey, s3test.ErrAccessDenied)
+			}
+
+			deleted, err := backend.DeletePrefix(t.Context(), test.prefix)
+			var bulkErr *BulkError
+			switch {
+			case test.failKey == "" && err != nil:
+				t.Fatal(err)
+			case test.failKey != "" && !errors.As(err, &bulkErr):
+				t.Fatalf("expected a BulkError, got %v", err)
+			case test.failKey != "" && (len(bulkErr.Failed) != 1 || bulkErr.Failed[0].Key != test.failKey):
+				t.Errorf("expected %s to fail, got %+v", test.failKey, bulkErr.Failed)
+			}
+			if deleted != test.deleted {
+				t.Errorf("expected %d deleted, got %d", test.deleted, deleted)
+			}
+
+			remaining := server.Keys(fakeBucket)
+			slices.Sort(remaining)
+			if !slices.Equal(remaining, test.remaining) {
+				t.Errorf("expected %v to remain, got %v", test.remaining, remaining)
+			}
+		})
+	}
+}
+
+func TestExport(t *testing.T) {
+	backend, server := newFakeBackend(t, ConditionalWritesAuto)
+	backend.bulkConcurrency = 2
+
+	var expected []string
+	for i := range 20 {
+		expected = append(expected, fmt.Sprintf("/docs/%02d", i))
+	}
+	putKeys(t, backend, "store/docs/00", "store/.locks/docs/00/lock", "store/other")
+	for _, p := range expected[1:] {
+		putKeys(t, backend, "store"+p)
+	}
+
+	var exported []string
+	err := backend.Export(t.Context(), "/docs/", func(path string, data []byte) error {
+		if string(data) != "store"+path {
+			t.Errorf("%s: unexpected content %q", path, data)
+		}
+		exported = append(exported, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(exported, expected) {
+		t.Errorf("expected %v in order, got %v", expected, exported)
+	}
+
+	// Unreadable resources are reported once the rest are exported
+	server.InjectKeyError("store/docs/05", s3test.ErrAccessDenied)
+	exported = nil
+	err = backend.Export(t.Context(), "/docs/", func(path string, data []byte) error {
+		exported = append(exported, path)
+		return nil
+	})
+	var bulkErr *BulkError
+	if !errors.As(err, &bulkErr) || len(bulkErr.Failed) != 1 || bulkErr.Failed[0].Key != "store/docs/05" {
+		t.Errorf("expected store/docs/05 to fail, got %v", err)
+	}
+	if len(exported) != len(expected)-1 {
+		t.Errorf("expected the other %d resources to be exported, got %v", len(expected)-1, exported)
+	}
+
+	// Errors from fn stop the export
+	stop := errors.New("stop")
+	exported = nil
+	err = backend.Export(t.Context(), "/docs/", func(path string, data []byte) error {
+		exported = append(exported, path)
+		return stop
+	})
+	if !errors.Is(err, stop) || len(exported) != 1 {
+		t.Errorf("expected export to stop after the first resource, got %v after %v", err, exported)
+	}
+}
+
+func TestExportCanceled(t *testing.T) {
+	backend, _ := newFakeBackend(t, ConditionalWritesAuto)
+	putKeys(t, backend, "store/docs/a", "store/docs/b")
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	err := backend.Export(ctx, "/docs/", func(path string, data []byte) error {
+		t.Errorf("unexpected export of %s", path)
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
@@ -0,0 +1,235 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+// ConditionalWriteMode selects how the backend stops concurrent writes to a resource from overwriting
+// each other, such as two POSTs both creating it or a PUT recreating it after a DELETE.
+type ConditionalWriteMode string
+
+const (
+	// ConditionalWritesAuto uses conditional requests, switching to lock objects if the provider
+	// rejects them as not implemented.
+	ConditionalWritesAuto ConditionalWriteMode = ""
+	// ConditionalWritesNative always uses conditional requests: If-None-Match: * to create a resource,
+	// and If-Match with its ETag to update or delete it.
+	ConditionalWritesNative ConditionalWriteMode = "native"
+	// ConditionalWritesLock holds a lock object for the duration of each write. Use this for providers
+	// that ignore conditional headers instead of rejecting them.
+	ConditionalWritesLock ConditionalWriteMode = "lock"
+)
+
+// maxConditionalAttempts limits how many times an update or delete is attempted when the object
+// is changed between reading its ETag and writing it
+const maxConditionalAttempts = 5
+
+// writeKind is the kind of change made by a write
+type writeKind int
+
+const (
+	writeCreate writeKind = iota
+	writeUpdate
+	writeDelete
+)
+
+// write creates, updates or deletes the object for path p, atomically with respect to other writes.
+// If ifMatch isn't empty, an update or delete is only made if the object's content matches it when written.
+func (b *Backend) write(ctx context.Context, p string, kind writeKind, body []byte, ifMatch string) (*gitbackedrest.Result, error) {
+	if err := checkPath(p); err != nil {
+		return nil, err
+	}
+	key := b.buildKey(p)
+	mode, err := b.historyMode(ctx)
+	if err != nil {
+		return nil, internalError("writing object", err)
+	}
+
+	retries, stamp, err := b.writeAtomically(ctx, p, key, kind, body, ifMatch)
+	if err != nil {
+		return nil, internalError("writing object", err)
+	}
+	if mode == HistoryCopy {
+		b.recordHistory(ctx, p, kind, body, stamp)
+	}
+	return &gitbackedrest.Result{Retries: retries}, nil
+}
+
+// writeAtomically makes a write with conditional requests or lock objects, returning the number of retries
+// and the write's history stamp
+func (b *Backend) writeAtomically(ctx context.Context, p, key string, kind writeKind, body []byte, ifMatch string) (int, int64, error) {
+	if !b.useLocks.Load() {
+		retries, stamp, err := b.writeConditional(ctx, key, kind, body, ifMatch)
+		if err == nil || b.conditionalWrites != ConditionalWritesAuto || !isNotImplemented(err) {
+			return retries, stamp, err
+		}
+		gitbackedrest.Logger(ctx, b.logger).WarnContext(ctx, "provider does not support conditional writes, falling back to lock objects", "error", err)
+		b.useLocks.Store(true)
+	}
+	return b.writeLocked(ctx, p, key, kind, body, ifMatch)
+}
+
+// writeConditional makes a write with conditional requests, returning the number of retries and the
+// write's history stamp. Updates and deletes read the object's ETag and retry if it changes before the write,
+// checking ifMatch against the content read each time.
+func (b *Backend) writeConditional(ctx context.Context, key string, kind writeKind, body []byte, ifMatch string) (int, int64, error) {
+	if kind == writeCreate {
+		stamp := nextHistoryStamp(0)
+		_, err := b.client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:      aws.String(b.bucket),
+			Key:         aws.String(key),
+			Body:        bytes.NewReader(body),
+			IfNoneMatch: aws.String("*"),
+			Metadata:    historyStampMetadata(stamp),
+		})
+		if isPreconditionFailed(err) {
+			return 0, 0, conflictError(errors.New("resource already exists"))
+		}
+		return 0, stamp, err
+	}
+
+	for attempt := 0; ; attempt++ {
+		etag, previous, err := b.currentVersion(ctx, key, ifMatch)
+		if err != nil {
+			return attempt, 0, err
+		}
+		// The write only succeeds if it replaces the version read, so its stamp orders it after that version
+		stamp := nextHistoryStamp(previous)
+
+		if kind == writeDelete {
+			_, err = b.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+				Bucket:  aws.String(b.bucket),
+				Key:     aws.String(key),
+				IfMatch: etag,
+			})
+		} else {
+			_, err = b.client.PutObject(ctx, &s3.PutObjectInput{
+				Bucket:   aws.String(b.bucket),
+				Key:      aws.String(key),
+				Body:     bytes.NewReader(body),
+				IfMatch:  etag,
+				Metadata: historyStampMetadata(stamp),
+			})
+		}
+		switch {
+		case err == nil:
+			return attempt, stamp, nil
+		case isNotFound(err) && ifMatch != "":
+			return attempt, 0, gitbackedrest.CheckIfMatch(ifMatch, nil, false)
+		case isNotFound(err):
+			// Deleted since it was read
+			return attempt, 0, notFoundError()
+		case !isPreconditionFailed(err):
+			return attempt, 0, err
+		case attempt+1 == maxConditionalAttempts:
+			return attempt, 0, conflictError(fmt.Errorf("resource modified concurrently %d times", maxConditionalAttempts))
+		}
+		gitbackedrest.Logger(ctx, b.logger).DebugContext(ctx, "object changed during write, will retry", "key", key, "attempt", attempt+1)
+	}
+}
+
+// writeLocked makes a write while holding the lock for path p, returning the number of retries
+// taken to acquire the lock and the write's history stamp
+func (b *Backend) writeLocked(ctx context.Context, p, key string, kind writeKind, body []byte, ifMatch string) (int, int64, error) {
+	unlock, retries, err := b.lock(ctx, p)
+	if err != nil {
+		return retries, 0, err
+	}
+	defer unlock()
+
+	if kind != writeCreate && ifMatch != "" {
+		_, previous, err := b.currentVersion(ctx, key, ifMatch)
+		if err != nil {
+			return retries, 0, err
+		}
+		stamp := nextHistoryStamp(previous)
+		return retries, stamp, b.writeUnconditionally(ctx, key, kind, body, stamp)
+	}
+
+	head, err := b.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(key),
+	})
+	exists := err == nil
+	if err != nil && !isNotFound(err) {
+		return retries, 0, fmt.Errorf("checking object existence: %w", err)
+	}
+	switch {
+	case kind == writeCreate && exists:
+		return retries, 0, conflictError(errors.New("resource already exists"))
+	case kind != writeCreate && !exists:
+		return retries, 0, notFoundError()
+	}
+	var previous int64
+	if exists {
+		previous = historyStamp(head.Metadata)
+	}
+	stamp := nextHistoryStamp(previous)
+	return retries, stamp, b.writeUnconditionally(ctx, key, kind, body, stamp)
+}
+
+// writeUnconditionally puts or deletes the object at key, for a caller holding its lock
+func (b *Backend) writeUnconditionally(ctx context.Context, key string, kind writeKind, body []byte, stamp int64) error {
+	var err error
+	if kind == writeDelete {
+		_, err = b.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(b.bucket),
+			Key:    aws.String(key),
+		})
+	} else {
+		_, err = b.client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:   aws.String(b.bucket),
+			Key:      aws.String(key),
+			Body:     bytes.NewReader(body),
+			Metadata: historyStampMetadata(stamp),
+		})
+	}
+	return err
+}
+
+// currentVersion returns the S3 ETag and history stamp of the object at key. If ifMatch isn't empty, the object
+// is read to check its content matches, since S3 ETags aren't the ETags the server reports.
+func (b *Backend) currentVersion(ctx context.Context, key, ifMatch string) (*string, int64, error) {
+	if ifMatch == "" {
+		head, err := b.client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(b.bucket),
+			Key:    aws.String(key),
+		})
+		if isNotFound(err) {
+			return nil, 0, notFoundError()
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("checking object existence: %w", err)
+		}
+		return head.ETag, historyStamp(head.Metadata), nil
+	}
+
+	output, err := b.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(key),
+	})
+	if isNotFound(err) {
+		return nil, 0, gitbackedrest.CheckIfMatch(ifMatch, nil, false)
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("getting object: %w", err)
+	}
+	defer output.Body.Close()
+	data, err := io.ReadAll(output.Body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("reading object body: %w", err)
+	}
+	if err := gitbackedrest.CheckIfMatch(ifMatch, data, true); err != nil {
+		return nil, 0, err
+	}
+	return output.ETag, historyStamp(output.Metadata), nil
+}
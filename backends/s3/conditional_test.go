@@ -0,0 +1,298 @@
+package s3
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+	"github.com/theothertomelliott/git-backed-rest/backends/backendtest"
+	"github.com/theothertomelliott/git-backed-rest/backends/s3/s3test"
+)
+
+const fakeBucket = "test-bucket"
+
+// newFakeBackend creates a backend using a fake S3 server started with opts
+func newFakeBackend(t *testing.T, mode ConditionalWriteMode, opts ...s3test.Option) (*Backend, *s3test.Server) {
+	t.Helper()
+
+	server := s3test.NewServer([]string{fakeBucket}, opts...)
+	t.Cleanup(server.Close)
+
+	backend, err := NewBackend(Config{
+		Endpoint:          server.URL,
+		AccessKeyID:       "test",
+		SecretAccessKey:   "test",
+		Bucket:            fakeBucket,
+		Prefix:            "store",
+		Region:            "us-east-1",
+		ConditionalWrites: mode,
+		// Tests of history set the mode themselves, so other tests see only the objects they write
+		History: HistoryOff,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { backend.Close() })
+	return backend, server
+}
+
+func expectStatus(t *testing.T, err error, expected int) {
+	t.Helper()
+	if expected == 0 {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return
+	}
+	if status := gitbackedrest.GetHTTPStatusCode(err, 0); status != expected {
+		t.Fatalf("expected status %d, got %d: %v", expected, status, err)
+	}
+}
+
+func TestConditionalWrites(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		mode     ConditionalWriteMode
+		opts     []s3test.Option
+		useLocks bool
+	}{
+		{name: "native", mode: ConditionalWritesNative},
+		{name: "auto with support", mode: ConditionalWritesAuto},
+		{name: "auto without support", mode: ConditionalWritesAuto, opts: []s3test.Option{s3test.WithoutConditionalWrites()}, useLocks: true},
+		{name: "lock", mode: ConditionalWritesLock, useLocks: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := t.Context()
+			backend, server := newFakeBackend(t, test.mode, test.opts...)
+
+			_, err := backend.PUT(ctx, "/doc", []byte("v1"))
+			expectStatus(t, err, http.StatusNotFound)
+			_, err = backend.DELETE(ctx, "/doc")
+			expectStatus(t, err, http.StatusNotFound)
+
+			_, err = backend.POST(ctx, "/doc", []byte("v1"))
+			expectStatus(t, err, 0)
+			_, err = backend.POST(ctx, "/doc", []byte("v2"))
+			expectStatus(t, err, http.StatusConflict)
+
+			_, err = backend.PUT(ctx, "/doc", []byte("v2"))
+			expectStatus(t, err, 0)
+			result, err := backend.GET(ctx, "/doc")
+			expectStatus(t, err, 0)
+			if string(result.Data) != "v2" {
+				t.Errorf("expected v2, got %q", result.Data)
+			}
+
+			paths, err := backend.List(ctx, "/")
+			expectStatus(t, err, 0)
+			if len(paths) != 1 || paths[0] != "/doc" {
+				t.Errorf("expected only /doc to be listed, got %v", paths)
+			}
+
+			_, err = backend.DELETE(ctx, "/doc")
+			expectStatus(t, err, 0)
+			_, err = backend.GET(ctx, "/doc")
+			expectStatus(t, err, http.StatusNotFound)
+
+			if backend.useLocks.Load() != test.useLocks {
+				t.Errorf("expected lock objects to be used: %v", test.useLocks)
+			}
+			if keys := server.Keys(fakeBucket); len(keys) != 0 {
+				t.Errorf("expected no objects to remain, got %v", keys)
+			}
+		})
+	}
+}
+
+func TestConditionalWritesNotSupported(t *testing.T) {
+	backend, _ := newFakeBackend(t, ConditionalWritesNative, s3test.WithoutConditionalWrites())
+
+	_, err := backend.POST(t.Context(), "/doc", []byte("v1"))
+	expectStatus(t, err, http.StatusInternalServerError)
+	if backend.useLocks.Load() {
+		t.Error("native mode should not fall back to lock objects")
+	}
+}
+
+func TestConcurrentCreate(t *testing.T) {
+	for _, mode := range []ConditionalWriteMode{ConditionalWritesNative, ConditionalWritesLock} {
+		t.Run(string(mode), func(t *testing.T) {
+			backend, _ := newFakeBackend(t, mode)
+
+			const writers = 8
+			var wg sync.WaitGroup
+			errs := make([]error, writers)
+			for i := range writers {
+				wg.Go(func() {
+					_, errs[i] = backend.POST(t.Context(), "/doc", []byte{byte('a' + i)})
+				})
+			}
+			wg.Wait()
+
+			var created int
+			for _, err := range errs {
+				switch {
+				case err == nil:
+					created++
+				case !gitbackedrest.HasHTTPStatusCode(err, http.StatusConflict):
+					t.Errorf("unexpected error: %v", err)
+				}
+			}
+			if created != 1 {
+				t.Errorf("expected exactly one create to succeed, got %d", created)
+			}
+		})
+	}
+}
+
+func TestUpdateAfterConcurrentDelete(t *testing.T) {
+	// Deletes the object while an update is in flight, between reading its ETag and writing it
+	var backend *Backend
+	var done atomic.Bool
+	hook := func(r *http.Request) {
+		if r.Method == http.MethodPut && r.Header.Get("If-Match") != "" && done.CompareAndSwap(false, true) {
+			if _, err := backend.DELETE(r.Context(), "/doc"); err != nil {
+				t.Errorf("concurrent delete: %v", err)
+			}
+		}
+	}
+	backend, _ = newFakeBackend(t, ConditionalWritesNative, s3test.WithRequestHook(hook))
+
+	ctx := t.Context()
+	_, err := backend.POST(ctx, "/doc", []byte("v1"))
+	expectStatus(t, err, 0)
+
+	_, err = backend.PUT(ctx, "/doc", []byte("v2"))
+	expectStatus(t, err, http.StatusNotFound)
+	_, err = backend.GET(ctx, "/doc")
+	expectStatus(t, err, http.StatusNotFound)
+}
+
+func TestUpdateRetriesConcurrentUpdate(t *testing.T) {
+	// Replaces the object while an update is in flight, between reading its ETag and writing it
+	var backend *Backend
+	var done atomic.Bool
+	hook := func(r *http.Request) {
+		if r.Method == http.MethodPut && r.Header.Get("If-Match") != "" && done.CompareAndSwap(false, true) {
+			if _, err := backend.PUT(r.Context(), "/doc", []byte("concurrent")); err != nil {
+				t.Errorf("concurrent update: %v", err)
+			}
+		}
+	}
+	backend, _ = newFakeBackend(t, ConditionalWritesNative, s3test.WithRequestHook(hook))
+
+	ctx := t.Context()
+	_, err := backend.POST(ctx, "/doc", []byte("v1"))
+	expectStatus(t, err, 0)
+
+	result, err := backend.PUT(ctx, "/doc", []byte("v2"))
+	expectStatus(t, err, 0)
+	if result.Retries != 1 {
+		t.Errorf("expected 1 retry, got %d", result.Retries)
+	}
+	got, err := backend.GET(ctx, "/doc")
+	expectStatus(t, err, 0)
+	if string(got.Data) != "v2" {
+		t.Errorf("expected v2, got %q", got.Data)
+	}
+}
+
+func TestLockObjects(t *testing.T) {
+	ctx := t.Context()
+	backend, server := newFakeBackend(t, ConditionalWritesLock)
+	backend.lockTTL = 200 * time.Millisecond
+
+	putLock := func(expires time.Time) string {
+		key := backend.lockDir("/doc") + lockName(expires)
+		_, err := backend.client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(fakeBucket),
+			Key:    aws.String(key),
+			Body:   strings.NewReader(""),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+
+	// An expired lock left by a crashed writer is removed
+	putLock(time.Now().Add(-time.Minute))
+	_, err := backend.POST(ctx, "/doc", []byte("v1"))
+	expectStatus(t, err, 0)
+	if keys := server.Keys(fakeBucket); len(keys) != 1 {
+		t.Errorf("expected only the resource to remain, got %v", keys)
+	}
+
+	// A lock held by another writer blocks writes until it expires
+	putLock(time.Now().Add(time.Minute))
+	_, err = backend.PUT(ctx, "/doc", []byte("v2"))
+	expectStatus(t, err, http.StatusServiceUnavailable)
+
+	// Locks are per path, so do not block writes beneath it
+	_, err = backend.POST(ctx, "/doc/child", []byte("child"))
+	expectStatus(t, err, 0)
+}
+
+func TestInternalPaths(t *testing.T) {
+	ctx := t.Context()
+	backend, server := newHistoryBackend(t, HistoryCopy)
+
+	_, err := backend.POST(ctx, "/doc", []byte("v1"))
+	expectStatus(t, err, 0)
+	copies := backend.historyDir("/doc")
+
+	for _, p := range []string{
+		"/" + historyPrefix + "/doc/x",
+		"/" + lockPrefix + "/doc/x",
+		"/other/../" + historyPrefix + "/doc/x",
+	} {
+		_, err = backend.GET(ctx, p)
+		expectStatus(t, err, http.StatusBadRequest)
+		_, err = backend.POST(ctx, p, []byte("v1"))
+		expectStatus(t, err, http.StatusBadRequest)
+		_, err = backend.PUT(ctx, p, []byte("v1"))
+		expectStatus(t, err, http.StatusBadRequest)
+		_, err = backend.DELETE(ctx, p)
+		expectStatus(t, err, http.StatusBadRequest)
+		_, err = backend.PUTIfMatch(ctx, p, []byte("v1"), "*")
+		expectStatus(t, err, http.StatusBadRequest)
+		_, err = backend.DELETEIfMatch(ctx, p, "*")
+		expectStatus(t, err, http.StatusBadRequest)
+	}
+
+	// The history copy is untouched
+	var kept int
+	for _, key := range server.Keys(fakeBucket) {
+		if strings.HasPrefix(key, copies) {
+			kept++
+		}
+	}
+	if kept != 1 {
+		t.Errorf("expected the history copy to remain, got %v", server.Keys(fakeBucket))
+	}
+}
+
+func TestConformance(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		mode ConditionalWriteMode
+		opts []s3test.Option
+	}{
+		{name: "conditional writes", mode: ConditionalWritesNative},
+		{name: "lock objects", mode: ConditionalWritesLock, opts: []s3test.Option{s3test.WithoutConditionalWrites()}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			backendtest.Run(t, func(t *testing.T) gitbackedrest.APIBackend {
+				backend, _ := newFakeBackend(t, test.mode, test.opts...)
+				return backend
+			})
+		})
+	}
+}
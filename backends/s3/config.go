@@ -0,0 +1,127 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+)
+
+// validate checks the settings of cfg that can be checked without loading AWS configuration,
+// returning an error naming everything that is missing or invalid
+func (cfg Config) validate() error {
+	var problems []string
+	if cfg.Bucket == "" {
+		problems = append(problems, "bucket is required")
+	}
+	if cfg.AccessKeyID != "" && cfg.SecretAccessKey == "" {
+		problems = append(problems, "secret access key is required with an access key ID")
+	}
+	if cfg.AccessKeyID == "" && cfg.SecretAccessKey != "" {
+		problems = append(problems, "access key ID is required with a secret access key")
+	}
+	if cfg.SessionToken != "" && cfg.AccessKeyID == "" {
+		problems = append(problems, "session token requires an access key ID and secret access key")
+	}
+	if cfg.RequestTimeout < 0 {
+		problems = append(problems, fmt.Sprintf("request timeout must not be negative, got %v", cfg.RequestTimeout))
+	}
+	if cfg.MaxAttempts < 0 {
+		problems = append(problems, fmt.Sprintf("max attempts must not be negative, got %d", cfg.MaxAttempts))
+	}
+	if cfg.MaxBackoff < 0 {
+		problems = append(problems, fmt.Sprintf("max backoff must not be negative, got %v", cfg.MaxBackoff))
+	}
+	switch cfg.ConditionalWrites {
+	case ConditionalWritesAuto, ConditionalWritesNative, ConditionalWritesLock:
+	default:
+		problems = append(problems, fmt.Sprintf("unknown conditional write mode %q, expected %q or %q", cfg.ConditionalWrites, ConditionalWritesNative, ConditionalWritesLock))
+	}
+	switch cfg.History {
+	case HistoryAuto, HistoryVersioning, HistoryCopy, HistoryOff:
+	default:
+		problems = append(problems, fmt.Sprintf("unknown history mode %q, expected %q, %q or %q", cfg.History, HistoryVersioning, HistoryCopy, HistoryOff))
+	}
+	problems = append(problems, cfg.ObjectSettings.problems()...)
+	for _, prefix := range slices.Sorted(maps.Keys(cfg.PrefixObjectSettings)) {
+		if !strings.HasPrefix(prefix, "/") {
+			problems = append(problems, fmt.Sprintf("object settings prefix %q must start with /", prefix))
+		}
+		for _, problem := range cfg.PrefixObjectSettings[prefix].problems() {
+			problems = append(problems, fmt.Sprintf("%s for prefix %s", problem, prefix))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid S3 config: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// loadAWSConfig resolves the AWS configuration for cfg. Credentials are static if an access key ID is given,
+// and otherwise found by the SDK's default chain: environment variables, the shared config and credentials
+// files (using cfg.Profile if set), web identity tokens, and container or instance roles.
+func loadAWSConfig(ctx context.Context, cfg Config) (aws.Config, error) {
+	var opts []func(*config.LoadOptions) error
+	if cfg.Region != "" {
+		opts = append(opts, config.WithRegion(cfg.Region))
+	}
+	if cfg.Profile != "" {
+		opts = append(opts, config.WithSharedConfigProfile(cfg.Profile))
+	}
+	if cfg.AccessKeyID != "" {
+		opts = append(opts, config.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(cfg.AccessKeyID, cfg.SecretAccessKey, cfg.SessionToken),
+		))
+	}
+
+	httpClient := awshttp.NewBuildableClient()
+	if cfg.RequestTimeout > 0 {
+		httpClient = httpClient.WithTimeout(cfg.RequestTimeout)
+	}
+	opts = append(opts, config.WithHTTPClient(httpClient))
+	if cfg.CABundle != "" {
+		// Read here so a missing file is reported by name
+		bundle, err := os.ReadFile(cfg.CABundle)
+		if err != nil {
+			return aws.Config{}, fmt.Errorf("reading CA bundle: %w", err)
+		}
+		opts = append(opts, config.WithCustomCABundle(bytes.NewReader(bundle)))
+	}
+
+	if cfg.MaxAttempts > 0 || cfg.MaxBackoff > 0 {
+		opts = append(opts, config.WithRetryer(func() aws.Retryer {
+			return retry.NewStandard(func(o *retry.StandardOptions) {
+				if cfg.MaxAttempts > 0 {
+					o.MaxAttempts = cfg.MaxAttempts
+				}
+				if cfg.MaxBackoff > 0 {
+					o.MaxBackoff = cfg.MaxBackoff
+				}
+			})
+		}))
+	}
+
+	awsConfig, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("loading AWS config: %w", err)
+	}
+
+	if awsConfig.Region == "" {
+		if cfg.Endpoint == "" {
+			return aws.Config{}, errors.New("invalid S3 config: region is required without an endpoint, set it in the config, AWS_REGION or the shared config profile")
+		}
+		// Providers such as R2 ignore the region
+		awsConfig.Region = "auto"
+	}
+	return awsConfig, nil
+}
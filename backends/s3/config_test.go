@@ -0,0 +1,331 @@
+package s3
+
+import (
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/theothertomelliott/git-backed-rest/backends/s3/s3test"
+)
+
+// isolateAWSConfig stops the AWS configuration of the environment running the tests from affecting them
+func isolateAWSConfig(t *testing.T) {
+	t.Helper()
+
+	dir := t.TempDir()
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	for _, name := range []string{
+		"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_PROFILE", "AWS_DEFAULT_PROFILE",
+		"AWS_REGION", "AWS_DEFAULT_REGION", "AWS_ROLE_ARN", "AWS_WEB_IDENTITY_TOKEN_FILE", "AWS_CA_BUNDLE",
+		"AWS_MAX_ATTEMPTS", "AWS_ENDPOINT_URL", "AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL_STS",
+	} {
+		t.Setenv(name, "")
+	}
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConfigValidation(t *testing.T) {
+	isolateAWSConfig(t)
+
+	for _, test := range []struct {
+		name     string
+		config   Config
+		expected []string
+	}{
+		{
+			name:     "missing bucket",
+			config:   Config{Endpoint: "http://localhost:9000"},
+			expected: []string{"bucket is required"},
+		},
+		{
+			name:     "access key without secret",
+			config:   Config{Endpoint: "http://localhost:9000", Bucket: "data", AccessKeyID: "key"},
+			expected: []string{"secret access key is required with an access key ID"},
+		},
+		{
+			name:     "secret without access key",
+			config:   Config{Endpoint: "http://localhost:9000", Bucket: "data", SecretAccessKey: "secret"},
+			expected: []string{"access key ID is required with a secret access key"},
+		},
+		{
+			name:     "session token without access key",
+			config:   Config{Endpoint: "http://localhost:9000", Bucket: "data", SessionToken: "token"},
+			expected: []string{"session token requires an access key ID and secret access key"},
+		},
+		{
+			name:   "every problem",
+			config: Config{RequestTimeout: -time.Second, MaxAttempts: -1, MaxBackoff: -time.Second, History: "forever"},
+			expected: []string{
+				"bucket is required",
+				"request timeout must not be negative",
+				"max attempts must not be negative",
+				"max backoff must not be negative",
+				`unknown history mode "forever"`,
+			},
+		},
+		{
+			name:     "region without endpoint",
+			config:   Config{Bucket: "data"},
+			expected: []string{"region is required without an endpoint"},
+		},
+		{
+			name: "object settings",
+			config: Config{
+				Endpoint:       "http://localhost:9000",
+				Bucket:         "data",
+				ObjectSettings: ObjectSettings{Encryption: EncryptionS3, KMSKeyID: "alias/data", CustomerKey: []byte("short")},
+				PrefixObjectSettings: map[string]ObjectSettings{
+					"/archive/": {Encryption: EncryptionCustomer, StorageClass: "ICE"},
+					"cache":     {Encryption: "rot13"},
+				},
+			},
+			expected: []string{
+				`KMS key ID requires "sse-kms" encryption`,
+				`customer key requires "sse-c" encryption`,
+				`"sse-c" encryption requires a 32-byte customer key, got 0 bytes for prefix /archive/`,
+				`unknown storage class "ICE" for prefix /archive/`,
+				`object settings prefix "cache" must start with /`,
+				`unknown encryption "rot13"`,
+			},
+		},
+		{
+			name:     "missing CA bundle",
+			config:   Config{Endpoint: "https://localhost:9000", Bucket: "data", CABundle: filepath.Join(t.TempDir(), "missing.pem")},
+			expected: []string{"reading CA bundle", "missing.pem"},
+		},
+		{
+			name:     "missing profile",
+			config:   Config{Endpoint: "http://localhost:9000", Bucket: "data", Profile: "missing"},
+			expected: []string{"missing"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewBackend(test.config)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			for _, expected := range test.expected {
+				if !strings.Contains(err.Error(), expected) {
+					t.Errorf("expected error to contain %q, got %q", expected, err)
+				}
+			}
+		})
+	}
+}
+
+// newSTSServer starts a fake STS endpoint that exchanges any web identity token for the given access key
+func newSTSServer(t *testing.T, accessKeyID string) string {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("Action") != "AssumeRoleWithWebIdentity" || r.Form.Get("WebIdentityToken") != "web-token" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/xml")
+		fmt.Fprintf(w, `<AssumeRoleWithWebIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleWithWebIdentityResult>
+    <Credentials>
+      <AccessKeyId>%s</AccessKeyId>
+      <SecretAccessKey>secret</SecretAccessKey>
+      <SessionToken>token</SessionToken>
+      <Expiration>%s</Expiration>
+    </Credentials>
+  </AssumeRoleWithWebIdentityResult>
+</AssumeRoleWithWebIdentityResponse>`, accessKeyID, time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func TestCredentialChain(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		config   Config
+		setup    func(t *testing.T)
+		expected string
+	}{
+		{
+			name:     "static",
+			config:   Config{AccessKeyID: "STATIC", SecretAccessKey: "secret", SessionToken: "token"},
+			expected: "STATIC",
+		},
+		{
+			name: "environment",
+			setup: func(t *testing.T) {
+				t.Setenv("AWS_ACCESS_KEY_ID", "ENV")
+				t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+			},
+			expected: "ENV",
+		},
+		{
+			name:   "profile",
+			config: Config{Profile: "other"},
+			setup: func(t *testing.T) {
+				t.Setenv("AWS_SHARED_CREDENTIALS_FILE", writeFile(t, "credentials",
+					"[default]\naws_access_key_id = DEFAULT\naws_secret_access_key = secret\n\n[other]\naws_access_key_id = PROFILE\naws_secret_access_key = secret\n"))
+			},
+			expected: "PROFILE",
+		},
+		{
+			name: "web identity",
+			setup: func(t *testing.T) {
+				t.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", writeFile(t, "token", "web-token"))
+				t.Setenv("AWS_ROLE_ARN", "arn:aws:iam::123456789012:role/test")
+				t.Setenv("AWS_ENDPOINT_URL_STS", newSTSServer(t, "WEBIDENTITY"))
+			},
+			expected: "WEBIDENTITY",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			isolateAWSConfig(t)
+			if test.setup != nil {
+				test.setup(t)
+			}
+
+			var (
+				mtx  sync.Mutex
+				keys []string
+			)
+			server := s3test.NewServer([]string{fakeBucket}, s3test.WithRequestHook(func(r *http.Request) {
+				_, credential, _ := strings.Cut(r.Header.Get("Authorization"), "Credential=")
+				key, _, _ := strings.Cut(credential, "/")
+				mtx.Lock()
+				defer mtx.Unlock()
+				keys = append(keys, key)
+			}))
+			t.Cleanup(server.Close)
+
+			config := test.config
+			config.Endpoint = server.URL
+			config.Bucket = fakeBucket
+			config.Region = "us-east-1"
+			backend, err := NewBackend(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := backend.CheckHealth(t.Context()); err != nil {
+				t.Fatal(err)
+			}
+
+			mtx.Lock()
+			defer mtx.Unlock()
+			if len(keys) != 1 || keys[0] != test.expected {
+				t.Errorf("expected requests signed with %s, got %v", test.expected, keys)
+			}
+		})
+	}
+}
+
+func TestRegionFromProfile(t *testing.T) {
+	isolateAWSConfig(t)
+	t.Setenv("AWS_CONFIG_FILE", writeFile(t, "config", "[profile eu]\nregion = eu-west-2\n"))
+
+	backend, err := NewBackend(Config{Bucket: "data", Profile: "eu"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if region := backend.client.Options().Region; region != "eu-west-2" {
+		t.Errorf("expected region from profile, got %q", region)
+	}
+}
+
+func TestClientSettings(t *testing.T) {
+	isolateAWSConfig(t)
+
+	newBackend := func(t *testing.T, server *s3test.Server, cfg Config) *Backend {
+		t.Helper()
+		cfg.Endpoint = server.URL
+		cfg.Bucket = fakeBucket
+		cfg.AccessKeyID = "key"
+		cfg.SecretAccessKey = "secret"
+		backend, err := NewBackend(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return backend
+	}
+
+	t.Run("path style", func(t *testing.T) {
+		var paths []string
+		server := s3test.NewServer([]string{fakeBucket}, s3test.WithRequestHook(func(r *http.Request) {
+			paths = append(paths, r.URL.Path)
+		}))
+		t.Cleanup(server.Close)
+
+		// Without path style, a host name endpoint would be addressed as test-bucket.localhost
+		server.URL = strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+		backend := newBackend(t, server, Config{UsePathStyle: true})
+		if err := backend.CheckHealth(t.Context()); err != nil {
+			t.Fatal(err)
+		}
+		if len(paths) != 1 || !strings.HasPrefix(paths[0], "/"+fakeBucket) {
+			t.Errorf("expected the bucket in the path, got %v", paths)
+		}
+	})
+
+	t.Run("CA bundle", func(t *testing.T) {
+		server := s3test.NewServer([]string{fakeBucket}, s3test.WithTLS())
+		t.Cleanup(server.Close)
+
+		untrusted := newBackend(t, server, Config{MaxAttempts: 1})
+		if err := untrusted.CheckHealth(t.Context()); err == nil {
+			t.Error("expected an error for an untrusted certificate")
+		}
+
+		bundle := writeFile(t, "ca.pem", string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})))
+		trusted := newBackend(t, server, Config{CABundle: bundle})
+		if err := trusted.CheckHealth(t.Context()); err != nil {
+			t.Errorf("expected the bundle to be trusted, got %v", err)
+		}
+	})
+
+	t.Run("request timeout", func(t *testing.T) {
+		server := s3test.NewServer([]string{fakeBucket}, s3test.WithLatency(time.Second))
+		t.Cleanup(server.Close)
+
+		backend := newBackend(t, server, Config{RequestTimeout: 20 * time.Millisecond, MaxAttempts: 1})
+		start := time.Now()
+		if err := backend.CheckHealth(t.Context()); err == nil {
+			t.Error("expected the request to time out")
+		}
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Errorf("expected the request to time out quickly, took %v", elapsed)
+		}
+	})
+
+	t.Run("retries", func(t *testing.T) {
+		server := s3test.NewServer([]string{fakeBucket})
+		t.Cleanup(server.Close)
+
+		backend := newBackend(t, server, Config{MaxAttempts: 5, MaxBackoff: time.Millisecond})
+		server.InjectError(s3test.OpHeadBucket, 4, s3test.ErrSlowDown)
+		if err := backend.CheckHealth(t.Context()); err != nil {
+			t.Errorf("expected the request to succeed on the fifth attempt, got %v", err)
+		}
+
+		backend = newBackend(t, server, Config{MaxAttempts: 2, MaxBackoff: time.Millisecond})
+		server.InjectError(s3test.OpHeadBucket, 2, s3test.ErrSlowDown)
+		if err := backend.CheckHealth(t.Context()); err == nil {
+			t.Error("expected the request to fail after two attempts")
+		}
+	})
+}
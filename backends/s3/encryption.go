@@ -0,0 +1,218 @@
+package s3
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// Encryption selects the server-side encryption of written objects
+type Encryption string
+
+const (
+	// EncryptionDefault leaves encryption to the bucket's default
+	EncryptionDefault Encryption = ""
+	// EncryptionS3 encrypts objects with keys managed by S3 (SSE-S3)
+	EncryptionS3 Encryption = "sse-s3"
+	// EncryptionKMS encrypts objects with a KMS key (SSE-KMS)
+	EncryptionKMS Encryption = "sse-kms"
+	// EncryptionCustomer encrypts objects with a key given in each request (SSE-C)
+	EncryptionCustomer Encryption = "sse-c"
+)
+
+// customerKeyAlgorithm is the only algorithm S3 supports for SSE-C
+const customerKeyAlgorithm = "AES256"
+
+// ObjectSettings are the server-side encryption and storage class of the objects the backend writes
+type ObjectSettings struct {
+	// Encryption selects the server-side encryption. By default, the bucket's default encryption applies.
+	Encryption Encryption
+	// KMSKeyID is the ID, ARN or alias of the KMS key for EncryptionKMS.
+	// If empty, the AWS managed key for S3 is used.
+	KMSKeyID string
+	// CustomerKey is the 256-bit key for EncryptionCustomer. S3 does not keep the key,
+	// so objects can only be read with the key they were written with.
+	CustomerKey []byte
+	// StorageClass is the storage class of written objects, such as STANDARD_IA.
+	// If empty, the provider's default (STANDARD for AWS S3) is used.
+	StorageClass types.StorageClass
+}
+
+// problems returns what is invalid in s
+func (s ObjectSettings) problems() []string {
+	var problems []string
+	switch s.Encryption {
+	case EncryptionDefault, EncryptionS3, EncryptionKMS, EncryptionCustomer:
+	default:
+		problems = append(problems, fmt.Sprintf("unknown encryption %q, expected %q, %q or %q", s.Encryption, EncryptionS3, EncryptionKMS, EncryptionCustomer))
+	}
+	if s.KMSKeyID != "" && s.Encryption != EncryptionKMS {
+		problems = append(problems, fmt.Sprintf("KMS key ID requires %q encryption", EncryptionKMS))
+	}
+	if s.Encryption == EncryptionCustomer && len(s.CustomerKey) != 32 {
+		problems = append(problems, fmt.Sprintf("%q encryption requires a 32-byte customer key, got %d bytes", EncryptionCustomer, len(s.CustomerKey)))
+	}
+	if len(s.CustomerKey) > 0 && s.Encryption != EncryptionCustomer {
+		problems = append(problems, fmt.Sprintf("customer key requires %q encryption", EncryptionCustomer))
+	}
+	if s.StorageClass != "" && !slices.Contains(s.StorageClass.Values(), s.StorageClass) {
+		problems = append(problems, fmt.Sprintf("unknown storage class %q", s.StorageClass))
+	}
+	return problems
+}
+
+// objectOptions are the request parameters for ObjectSettings
+type objectOptions struct {
+	encryption   types.ServerSideEncryption
+	kmsKeyID     *string
+	storageClass types.StorageClass
+	// customerKey and customerKeyMD5 are base64 encoded, as sent in SSE-C headers
+	customerKey    *string
+	customerKeyMD5 *string
+}
+
+func newObjectOptions(s ObjectSettings) objectOptions {
+	options := objectOptions{storageClass: s.StorageClass}
+	switch s.Encryption {
+	case EncryptionS3:
+		options.encryption = types.ServerSideEncryptionAes256
+	case EncryptionKMS:
+		options.encryption = types.ServerSideEncryptionAwsKms
+		if s.KMSKeyID != "" {
+			options.kmsKeyID = aws.String(s.KMSKeyID)
+		}
+	case EncryptionCustomer:
+		sum := md5.Sum(s.CustomerKey)
+		options.customerKey = aws.String(base64.StdEncoding.EncodeToString(s.CustomerKey))
+		options.customerKeyMD5 = aws.String(base64.StdEncoding.EncodeToString(sum[:]))
+	}
+	return options
+}
+
+// customerAlgorithm returns the SSE-C algorithm header, or nil without SSE-C
+func (o objectOptions) customerAlgorithm() *string {
+	if o.customerKey == nil {
+		return nil
+	}
+	return aws.String(customerKeyAlgorithm)
+}
+
+// objectPolicy chooses the objectOptions for each key from the backend's settings and its per-prefix overrides
+type objectPolicy struct {
+	defaults objectOptions
+	// prefixes are ordered longest first, so the first match is the most specific
+	prefixes []prefixOptions
+}
+
+type prefixOptions struct {
+	prefix  string
+	options objectOptions
+}
+
+func newObjectPolicy(defaults ObjectSettings, prefixes map[string]ObjectSettings) objectPolicy {
+	policy := objectPolicy{defaults: newObjectOptions(defaults)}
+	for prefix, settings := range prefixes {
+		policy.prefixes = append(policy.prefixes, prefixOptions{prefix: prefix, options: newObjectOptions(settings)})
+	}
+	slices.SortFunc(policy.prefixes, func(a, b prefixOptions) int {
+		return len(b.prefix) - len(a.prefix)
+	})
+	return policy
+}
+
+// forPath returns the options for the objects of resource path p
+func (policy objectPolicy) forPath(p string) objectOptions {
+	for _, prefix := range policy.prefixes {
+		if strings.HasPrefix(p, prefix.prefix) {
+			return prefix.options
+		}
+	}
+	return policy.defaults
+}
+
+// objectOptions returns the options for an S3 key. Lock objects and history copies use the options of the
+// resource they belong to, so a resource's previous content is encrypted like its current content.
+func (b *Backend) objectOptions(key string) objectOptions {
+	p := b.pathForKey(key)
+	for _, internal := range []string{lockPrefix, historyPrefix} {
+		if rest, ok := strings.CutPrefix(p, "/"+internal+"/"); ok {
+			p = "/" + rest
+			break
+		}
+	}
+	return b.objects.forPath(p)
+}
+
+// copySourceKey returns the key of a CopySource in the backend's bucket, which has the form
+// bucket/key?versionId=id with the key URL encoded
+func (b *Backend) copySourceKey(source *string) (string, bool) {
+	if source == nil {
+		return "", false
+	}
+	unescaped, err := url.PathUnescape(aws.ToString(source))
+	if err != nil {
+		return "", false
+	}
+	unescaped, _, _ = strings.Cut(unescaped, "?versionId=")
+	bucket, key, ok := strings.Cut(strings.TrimPrefix(unescaped, "/"), "/")
+	if !ok || bucket != b.bucket {
+		return "", false
+	}
+	return key, true
+}
+
+// applyObjectOptions sets the encryption and storage class parameters of an S3 request for the key it addresses.
+// Writes get every setting. Reads and multipart parts only take the SSE-C key, which S3 needs to decrypt or to
+// continue encrypting an upload.
+func (b *Backend) applyObjectOptions(params any) {
+	switch in := params.(type) {
+	case *s3.PutObjectInput:
+		o := b.objectOptions(aws.ToString(in.Key))
+		in.ServerSideEncryption, in.SSEKMSKeyId, in.StorageClass = o.encryption, o.kmsKeyID, o.storageClass
+		in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = o.customerAlgorithm(), o.customerKey, o.customerKeyMD5
+	case *s3.CreateMultipartUploadInput:
+		o := b.objectOptions(aws.ToString(in.Key))
+		in.ServerSideEncryption, in.SSEKMSKeyId, in.StorageClass = o.encryption, o.kmsKeyID, o.storageClass
+		in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = o.customerAlgorithm(), o.customerKey, o.customerKeyMD5
+	case *s3.UploadPartInput:
+		o := b.objectOptions(aws.ToString(in.Key))
+		in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = o.customerAlgorithm(), o.customerKey, o.customerKeyMD5
+	case *s3.CompleteMultipartUploadInput:
+		o := b.objectOptions(aws.ToString(in.Key))
+		in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = o.customerAlgorithm(), o.customerKey, o.customerKeyMD5
+	case *s3.CopyObjectInput:
+		o := b.objectOptions(aws.ToString(in.Key))
+		in.ServerSideEncryption, in.SSEKMSKeyId, in.StorageClass = o.encryption, o.kmsKeyID, o.storageClass
+		in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = o.customerAlgorithm(), o.customerKey, o.customerKeyMD5
+		if key, ok := b.copySourceKey(in.CopySource); ok {
+			src := b.objectOptions(key)
+			in.CopySourceSSECustomerAlgorithm, in.CopySourceSSECustomerKey, in.CopySourceSSECustomerKeyMD5 = src.customerAlgorithm(), src.customerKey, src.customerKeyMD5
+		}
+	case *s3.GetObjectInput:
+		o := b.objectOptions(aws.ToString(in.Key))
+		in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = o.customerAlgorithm(), o.customerKey, o.customerKeyMD5
+	case *s3.HeadObjectInput:
+		o := b.objectOptions(aws.ToString(in.Key))
+		in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = o.customerAlgorithm(), o.customerKey, o.customerKeyMD5
+	}
+}
+
+// addObjectOptionsMiddleware applies the backend's encryption and storage class settings to every request,
+// so that no write or read of an object can miss them.
+func (b *Backend) addObjectOptionsMiddleware(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("GitBackedRestObjectOptions", func(
+		ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler,
+	) (middleware.InitializeOutput, middleware.Metadata, error) {
+		b.applyObjectOptions(in.Parameters)
+		return next.HandleInitialize(ctx, in)
+	}), middleware.Before)
+}
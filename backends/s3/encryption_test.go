@@ -0,0 +1,142 @@
+package s3
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"github.com/theothertomelliott/git-backed-rest/backends/s3/s3test"
+)
+
+var (
+	testCustomerKey = bytes.Repeat([]byte{7}, 32)
+
+	// testObjectPolicy uses SSE-C by default, and SSE-KMS with infrequent access storage under /archive/
+	testObjectPolicy = newObjectPolicy(
+		ObjectSettings{Encryption: EncryptionCustomer, CustomerKey: testCustomerKey},
+		map[string]ObjectSettings{
+			"/archive/": {Encryption: EncryptionKMS, KMSKeyID: "alias/archive", StorageClass: types.StorageClassStandardIa},
+		},
+	)
+)
+
+// withoutObjectOptions makes a request without the backend's encryption settings
+func withoutObjectOptions(o *s3.Options) {
+	o.APIOptions = nil
+}
+
+func TestObjectSettings(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		mode    ConditionalWriteMode
+		history HistoryMode
+		opts    []s3test.Option
+	}{
+		{name: "conditional writes", mode: ConditionalWritesNative, history: HistoryOff},
+		{name: "lock objects", mode: ConditionalWritesLock, history: HistoryOff, opts: []s3test.Option{s3test.WithoutConditionalWrites()}},
+		{name: "history copies", mode: ConditionalWritesNative, history: HistoryCopy},
+		{name: "versioning", mode: ConditionalWritesNative, history: HistoryVersioning, opts: []s3test.Option{s3test.WithVersioning()}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := t.Context()
+			backend, server := newFakeBackend(t, test.mode, test.opts...)
+			backend.history = test.history
+			backend.objects = testObjectPolicy
+
+			_, err := backend.POST(ctx, "/docs/a", []byte("v1"))
+			expectStatus(t, err, 0)
+			_, err = backend.PUT(ctx, "/docs/a", []byte("v2"))
+			expectStatus(t, err, 0)
+			_, err = backend.POST(ctx, "/archive/b", []byte("old"))
+			expectStatus(t, err, 0)
+
+			result, err := backend.GET(ctx, "/docs/a")
+			expectStatus(t, err, 0)
+			if string(result.Data) != "v2" {
+				t.Errorf("expected v2, got %q", result.Data)
+			}
+			if test.history != HistoryOff {
+				versions, err := backend.History(ctx, "/docs/a")
+				expectStatus(t, err, 0)
+				if len(versions) != 2 {
+					t.Fatalf("expected 2 versions, got %+v", versions)
+				}
+				result, err := backend.GETVersion(ctx, "/docs/a", versions[1].ID)
+				expectStatus(t, err, 0)
+				if string(result.Data) != "v1" {
+					t.Errorf("expected v1, got %q", result.Data)
+				}
+			}
+
+			// Every object, including history copies, is stored with the settings of its resource
+			for _, key := range server.Keys(fakeBucket) {
+				head, err := backend.client.HeadObject(ctx, &s3.HeadObjectInput{
+					Bucket: aws.String(fakeBucket),
+					Key:    aws.String(key),
+				}, withoutObjectOptions)
+				if strings.Contains(key, "/archive/") {
+					if err != nil {
+						t.Errorf("%s: %v", key, err)
+						continue
+					}
+					if head.ServerSideEncryption != types.ServerSideEncryptionAwsKms || aws.ToString(head.SSEKMSKeyId) != "alias/archive" || head.StorageClass != types.StorageClassStandardIa {
+						t.Errorf("%s: unexpected encryption %q, key %q and storage class %q", key, head.ServerSideEncryption, aws.ToString(head.SSEKMSKeyId), head.StorageClass)
+					}
+					continue
+				}
+				if statusCode(err) != http.StatusBadRequest {
+					t.Errorf("%s: expected reading without the customer key to fail, got %v", key, err)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyObjectOptions(t *testing.T) {
+	backend := &Backend{bucket: fakeBucket, prefix: "store", objects: testObjectPolicy}
+
+	copyInput := &s3.CopyObjectInput{
+		Bucket:     aws.String(fakeBucket),
+		Key:        aws.String("store/archive/doc"),
+		CopySource: aws.String(fakeBucket + "/store/docs/doc%3Fname?versionId=v1"),
+	}
+	backend.applyObjectOptions(copyInput)
+	if copyInput.ServerSideEncryption != types.ServerSideEncryptionAwsKms || copyInput.StorageClass != types.StorageClassStandardIa || copyInput.SSECustomerKey != nil {
+		t.Errorf("expected the copy to use the archive settings, got %q, %q", copyInput.ServerSideEncryption, copyInput.StorageClass)
+	}
+	if copyInput.CopySourceSSECustomerKey == nil || copyInput.CopySourceSSECustomerAlgorithm == nil {
+		t.Error("expected the customer key of the copy source")
+	}
+
+	otherBucket := &s3.CopyObjectInput{
+		Bucket:     aws.String(fakeBucket),
+		Key:        aws.String("store/docs/doc"),
+		CopySource: aws.String("other/store/docs/doc"),
+	}
+	backend.applyObjectOptions(otherBucket)
+	if otherBucket.SSECustomerKey == nil || otherBucket.CopySourceSSECustomerKey != nil {
+		t.Error("expected only the destination to use the customer key for a copy from another bucket")
+	}
+
+	upload := &s3.CreateMultipartUploadInput{Bucket: aws.String(fakeBucket), Key: aws.String("store/archive/big")}
+	backend.applyObjectOptions(upload)
+	if upload.ServerSideEncryption != types.ServerSideEncryptionAwsKms || upload.StorageClass != types.StorageClassStandardIa {
+		t.Errorf("expected the upload to use the archive settings, got %q, %q", upload.ServerSideEncryption, upload.StorageClass)
+	}
+
+	part := &s3.UploadPartInput{Bucket: aws.String(fakeBucket), Key: aws.String("store/docs/big")}
+	backend.applyObjectOptions(part)
+	if part.SSECustomerKey == nil || part.SSECustomerKeyMD5 == nil {
+		t.Error("expected the customer key for a part")
+	}
+	complete := &s3.CompleteMultipartUploadInput{Bucket: aws.String(fakeBucket), Key: aws.String("store/docs/big")}
+	backend.applyObjectOptions(complete)
+	if complete.SSECustomerKey == nil {
+		t.Error("expected the customer key to complete an upload")
+	}
+}
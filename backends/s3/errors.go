@@ -0,0 +1,81 @@
+package s3
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+// statusCode returns the HTTP status code of an S3 response error, or 0 if err is not one
+func statusCode(err error) int {
+	var responseErr interface{ HTTPStatusCode() int }
+	if errors.As(err, &responseErr) {
+		return responseErr.HTTPStatusCode()
+	}
+	return 0
+}
+
+// isNotFound reports whether err is a missing object. HeadObject responses have no body,
+// so are identified by their NotFound code or status.
+func isNotFound(err error) bool {
+	var noSuchKey *types.NoSuchKey
+	var notFound *types.NotFound
+	var apiError smithy.APIError
+	return errors.As(err, &noSuchKey) ||
+		errors.As(err, &notFound) ||
+		(errors.As(err, &apiError) && apiError.ErrorCode() == "NotFound") ||
+		statusCode(err) == http.StatusNotFound
+}
+
+// isPreconditionFailed reports whether a conditional write failed because the object had changed.
+// S3 responds 409 rather than 412 when a concurrent conditional write to the same key is in progress.
+func isPreconditionFailed(err error) bool {
+	code := statusCode(err)
+	return code == http.StatusPreconditionFailed || code == http.StatusConflict
+}
+
+// isNotImplemented reports whether the provider rejected a request as using an unsupported feature
+func isNotImplemented(err error) bool {
+	var apiError smithy.APIError
+	return (errors.As(err, &apiError) && apiError.ErrorCode() == "NotImplemented") ||
+		statusCode(err) == http.StatusNotImplemented
+}
+
+func notFoundError() error {
+	return gitbackedrest.NewUserError(
+		"Not Found",
+		gitbackedrest.NewHTTPError(
+			http.StatusNotFound,
+			errors.New("resource not found"),
+		),
+	)
+}
+
+func conflictError(err error) error {
+	return gitbackedrest.NewUserError(
+		"Conflict",
+		gitbackedrest.NewHTTPError(
+			http.StatusConflict,
+			err,
+		),
+	)
+}
+
+// internalError wraps an unexpected error from S3, unless it already carries a status code
+func internalError(action string, err error) error {
+	if gitbackedrest.GetHTTPStatusCode(err, 0) != 0 {
+		return err
+	}
+	return gitbackedrest.NewUserError(
+		"Internal Server Error",
+		gitbackedrest.NewHTTPError(
+			http.StatusInternalServerError,
+			fmt.Errorf("%s: %w", action, err),
+		),
+	)
+}
@@ -0,0 +1,50 @@
+package s3
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/theothertomelliott/git-backed-rest/backends/s3/s3test"
+)
+
+func TestProviderErrors(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		operation string
+		count     int
+		err       s3test.Error
+		expected  int
+	}{
+		{name: "transient error is retried", operation: s3test.OpGetObject, count: 1, err: s3test.ErrSlowDown},
+		{name: "persistent error", operation: s3test.OpGetObject, count: -1, err: s3test.ErrInternal, expected: http.StatusInternalServerError},
+		{name: "access denied", operation: s3test.OpGetObject, count: 1, err: s3test.ErrAccessDenied, expected: http.StatusInternalServerError},
+		{name: "write failure", operation: s3test.OpPutObject, count: -1, err: s3test.ErrAccessDenied, expected: http.StatusInternalServerError},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := t.Context()
+			backend, server := newFakeBackend(t, ConditionalWritesAuto)
+			_, err := backend.POST(ctx, "/doc", []byte("v1"))
+			expectStatus(t, err, 0)
+
+			server.InjectError(test.operation, test.count, test.err)
+			if test.operation == s3test.OpPutObject {
+				_, err = backend.PUT(ctx, "/doc", []byte("v2"))
+			} else {
+				_, err = backend.GET(ctx, "/doc")
+			}
+			expectStatus(t, err, test.expected)
+		})
+	}
+}
+
+func TestCheckHealth(t *testing.T) {
+	backend, server := newFakeBackend(t, ConditionalWritesAuto)
+	if err := backend.CheckHealth(t.Context()); err != nil {
+		t.Fatalf("expected a healthy bucket, got %v", err)
+	}
+
+	server.InjectError(s3test.OpHeadBucket, 1, s3test.ErrAccessDenied)
+	if err := backend.CheckHealth(t.Context()); err == nil {
+		t.Error("expected an error when the bucket is inaccessible")
+	}
+}
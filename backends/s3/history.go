@@ -0,0 +1,376 @@
+package s3
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+// HistoryMode selects how the backend keeps previous versions of resources
+type HistoryMode string
+
+const (
+	// HistoryAuto uses bucket versioning if it is enabled, and history copies otherwise.
+	// The bucket's versioning status is checked on first use.
+	HistoryAuto HistoryMode = ""
+	// HistoryVersioning reads history from bucket versioning, which must be enabled on the bucket
+	HistoryVersioning HistoryMode = "versioning"
+	// HistoryCopy writes a copy of each version under .history/ in the backend's prefix once it has been written.
+	//
+	// Unlike a write-ahead copy, written before the version, history only lists versions that became current,
+	// without reconciling copies with the object when history is read. In exchange, a crash between the write
+	// and its copy leaves the version out of history. Copies are ordered by a stamp taken as the write is made,
+	// greater than the stamp of the version it replaces, so updates and deletes are listed in the order they
+	// took effect. A create is stamped by the writer's clock, so it is only ordered after the delete before it
+	// if the replicas' clocks agree.
+	HistoryCopy HistoryMode = "copy"
+	// HistoryOff keeps no history
+	HistoryOff HistoryMode = "off"
+)
+
+const (
+	// historyPrefix is the directory within the backend's prefix that holds history copies
+	historyPrefix = ".history"
+
+	// defaultHistoryMaxVersions is how many history copies of each resource are kept if
+	// Config.HistoryMaxVersions is not set
+	defaultHistoryMaxVersions = 10
+
+	// historyStampKey is the object metadata holding the history stamp of the write that made the object
+	historyStampKey = "history-stamp"
+)
+
+// historyMode returns how history is kept, checking whether the bucket is versioned on first use
+func (b *Backend) historyMode(ctx context.Context) (HistoryMode, error) {
+	b.historyMtx.Lock()
+	defer b.historyMtx.Unlock()
+
+	if b.history != HistoryAuto {
+		return b.history, nil
+	}
+	output, err := b.client.GetBucketVersioning(ctx, &s3.GetBucketVersioningInput{
+		Bucket: aws.String(b.bucket),
+	})
+	switch {
+	case err == nil && output.Status == types.BucketVersioningStatusEnabled:
+		b.history = HistoryVersioning
+	case err == nil:
+		// Versioning is suspended or was never enabled
+		b.history = HistoryCopy
+	case statusCode(err) != 0:
+		gitbackedrest.Logger(ctx, b.logger).InfoContext(ctx, "provider does not support bucket versioning", "error", err)
+		b.history = HistoryCopy
+	default:
+		return "", fmt.Errorf("checking bucket versioning: %w", err)
+	}
+	gitbackedrest.Logger(ctx, b.logger).DebugContext(ctx, "detected history mode", "mode", b.history)
+	return b.history, nil
+}
+
+// historyDir returns the key prefix of the history copies for path p
+func (b *Backend) historyDir(p string) string {
+	return path.Join(b.prefix, historyPrefix, p) + "/"
+}
+
+// historyKinds names each kind of write in the keys of history copies
+var historyKinds = map[writeKind]string{
+	writeCreate: "create",
+	writeUpdate: "update",
+	writeDelete: "delete",
+}
+
+// historyStamp returns the history stamp in an object's metadata, or zero if it has none
+func historyStamp(metadata map[string]string) int64 {
+	stamp, _ := strconv.ParseInt(metadata[historyStampKey], 10, 64)
+	return stamp
+}
+
+// historyStampMetadata returns the metadata recording a write's history stamp on the object it writes
+func historyStampMetadata(stamp int64) map[string]string {
+	return map[string]string{historyStampKey: strconv.FormatInt(stamp, 10)}
+}
+
+// nextHistoryStamp returns the stamp for a write replacing a version with the given stamp: the current time
+// in nanoseconds, or just after previous if the clock is behind it
+func nextHistoryStamp(previous int64) int64 {
+	return max(time.Now().UnixNano(), previous+1)
+}
+
+// recordHistory records a write to path p in its history once it has been made, then drops the oldest copies
+// beyond the limit. The write has already succeeded, so failures are logged rather than returned, and the copy
+// is made even if the request is cancelled.
+func (b *Backend) recordHistory(ctx context.Context, p string, kind writeKind, body []byte, stamp int64) {
+	ctx = context.WithoutCancel(ctx)
+	logger := gitbackedrest.Logger(ctx, b.logger)
+
+	// Names sort by stamp, and record the kind of write since listings do not include metadata
+	key := b.historyDir(p) + fmt.Sprintf("%020d-%s-%s", stamp, historyKinds[kind], rand.Text())
+	_, err := b.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(body),
+	})
+	if err != nil {
+		logger.WarnContext(ctx, "writing history copy, the version will be missing from history", "path", p, "error", err)
+		return
+	}
+	if b.historyMaxVersions < 0 {
+		return
+	}
+	if err := b.pruneHistory(ctx, p); err != nil {
+		logger.WarnContext(ctx, "pruning history copies", "path", p, "error", err)
+	}
+}
+
+// pruneHistory deletes the oldest history copies of path p beyond the limit. A resource whose only copy left is
+// its deletion has all its copies deleted, so deleted resources don't keep history forever.
+func (b *Backend) pruneHistory(ctx context.Context, p string) error {
+	versions, err := b.copyHistory(ctx, p)
+	if err != nil {
+		return err
+	}
+	keep := min(len(versions), b.historyMaxVersions)
+	if keep == 1 && versions[0].Deleted {
+		keep = 0
+	}
+	if keep == len(versions) {
+		return nil
+	}
+	keys := make([]string, 0, len(versions)-keep)
+	for _, version := range versions[keep:] {
+		keys = append(keys, b.historyDir(p)+version.ID)
+	}
+	_, failed := b.deleteKeys(ctx, keys)
+	return bulkError(failed)
+}
+
+// parseHistoryName parses the time and kind of write from the name of a history copy
+func parseHistoryName(name string) (time.Time, string, bool) {
+	fields := strings.Split(name, "-")
+	if len(fields) != 3 || strings.Contains(name, "/") {
+		return time.Time{}, "", false
+	}
+	nanos, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return time.Time{}, "", false
+	}
+	return time.Unix(0, nanos), fields[1], true
+}
+
+// History implements gitbackedrest.Historian.
+func (b *Backend) History(ctx context.Context, p string) ([]gitbackedrest.Version, error) {
+	ctx, phase := gitbackedrest.StartPhase(ctx, b.logger, "History")
+	defer phase.End()
+
+	if err := checkPath(p); err != nil {
+		return nil, err
+	}
+	mode, err := b.historyMode(ctx)
+	if err != nil {
+		return nil, historyError(err)
+	}
+	switch mode {
+	case HistoryVersioning:
+		return b.versionHistory(ctx, p)
+	case HistoryCopy:
+		return b.copyHistory(ctx, p)
+	default:
+		return nil, historyDisabledError()
+	}
+}
+
+// versionHistory lists the versions of path p from bucket versioning
+func (b *Backend) versionHistory(ctx context.Context, p string) ([]gitbackedrest.Version, error) {
+	key := b.buildKey(p)
+
+	var (
+		versions []gitbackedrest.Version
+		latest   string
+	)
+	paginator := s3.NewListObjectVersionsPaginator(b.client, &s3.ListObjectVersionsInput{
+		Bucket: aws.String(b.bucket),
+		Prefix: aws.String(key),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, historyError(fmt.Errorf("listing object versions: %w", err))
+		}
+		// The prefix also matches longer keys
+		for _, version := range page.Versions {
+			if aws.ToString(version.Key) == key {
+				if aws.ToBool(version.IsLatest) {
+					latest = aws.ToString(version.VersionId)
+				}
+				versions = append(versions, gitbackedrest.Version{
+					ID:   aws.ToString(version.VersionId),
+					Time: aws.ToTime(version.LastModified),
+				})
+			}
+		}
+		for _, marker := range page.DeleteMarkers {
+			if aws.ToString(marker.Key) == key {
+				if aws.ToBool(marker.IsLatest) {
+					latest = aws.ToString(marker.VersionId)
+				}
+				versions = append(versions, gitbackedrest.Version{
+					ID:      aws.ToString(marker.VersionId),
+					Time:    aws.ToTime(marker.LastModified),
+					Deleted: true,
+				})
+			}
+		}
+	}
+	// Versions and delete markers are listed separately, each newest first. Modification times have
+	// millisecond precision, so the latest version is put first if it shares its time with others.
+	slices.SortStableFunc(versions, func(a, b gitbackedrest.Version) int {
+		if c := b.Time.Compare(a.Time); c != 0 {
+			return c
+		}
+		switch latest {
+		case a.ID:
+			return -1
+		case b.ID:
+			return 1
+		}
+		return 0
+	})
+	return versions, nil
+}
+
+// copyHistory lists the versions of path p from its history copies, newest first
+func (b *Backend) copyHistory(ctx context.Context, p string) ([]gitbackedrest.Version, error) {
+	dir := b.historyDir(p)
+
+	var versions []gitbackedrest.Version
+	paginator := s3.NewListObjectsV2Paginator(b.client, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(b.bucket),
+		Prefix:    aws.String(dir),
+		Delimiter: aws.String("/"),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, historyError(fmt.Errorf("listing history copies: %w", err))
+		}
+		for _, obj := range page.Contents {
+			name := strings.TrimPrefix(aws.ToString(obj.Key), dir)
+			written, kind, ok := parseHistoryName(name)
+			if !ok {
+				continue
+			}
+			versions = append(versions, gitbackedrest.Version{
+				ID:      name,
+				Time:    written,
+				Message: kind + " " + p,
+				Deleted: kind == historyKinds[writeDelete],
+			})
+		}
+	}
+	slices.SortFunc(versions, func(a, b gitbackedrest.Version) int {
+		return cmp.Compare(b.ID, a.ID)
+	})
+	return versions, nil
+}
+
+// GETVersion implements gitbackedrest.Historian.
+func (b *Backend) GETVersion(ctx context.Context, p, version string) (*gitbackedrest.GetResult, error) {
+	ctx, phase := gitbackedrest.StartPhase(ctx, b.logger, "GETVersion")
+	defer phase.End()
+
+	if err := checkPath(p); err != nil {
+		return nil, err
+	}
+	mode, err := b.historyMode(ctx)
+	if err != nil {
+		return nil, historyError(err)
+	}
+
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(b.bucket),
+	}
+	switch mode {
+	case HistoryVersioning:
+		input.Key = aws.String(b.buildKey(p))
+		input.VersionId = aws.String(version)
+	case HistoryCopy:
+		_, kind, ok := parseHistoryName(version)
+		if !ok {
+			return nil, versionNotFoundError(version)
+		}
+		if kind == historyKinds[writeDelete] {
+			return nil, notFoundError()
+		}
+		input.Key = aws.String(b.historyDir(p) + version)
+	default:
+		return nil, historyDisabledError()
+	}
+
+	output, err := b.client.GetObject(ctx, input)
+	switch {
+	case statusCode(err) == http.StatusMethodNotAllowed:
+		// The version is a delete marker
+		return nil, notFoundError()
+	case isNotFound(err), statusCode(err) == http.StatusBadRequest:
+		// Malformed version IDs are rejected as invalid arguments
+		return nil, versionNotFoundError(version)
+	case err != nil:
+		return nil, historyError(fmt.Errorf("getting object version: %w", err))
+	}
+	defer output.Body.Close()
+
+	body, err := io.ReadAll(output.Body)
+	if err != nil {
+		return nil, historyError(fmt.Errorf("reading object body: %w", err))
+	}
+	return &gitbackedrest.GetResult{
+		Data:    body,
+		Retries: 0, // S3 GET doesn't retry
+	}, nil
+}
+
+func versionNotFoundError(version string) error {
+	return gitbackedrest.NewUserError(
+		"Version not found",
+		gitbackedrest.NewHTTPError(
+			http.StatusNotFound,
+			fmt.Errorf("version %q not found", version),
+		),
+	)
+}
+
+func historyDisabledError() error {
+	return gitbackedrest.NewUserError(
+		"History is disabled for this backend",
+		gitbackedrest.NewHTTPError(
+			http.StatusNotImplemented,
+			errors.New("history is off"),
+		),
+	)
+}
+
+func historyError(err error) error {
+	return gitbackedrest.NewUserError(
+		"Internal Server Error",
+		gitbackedrest.NewHTTPError(
+			http.StatusInternalServerError,
+			fmt.Errorf("reading history: %w", err),
+		),
+	)
+}
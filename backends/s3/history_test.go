@@ -0,0 +1,250 @@
+package s3
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/theothertomelliott/git-backed-rest/backends/s3/s3test"
+)
+
+// newHistoryBackend creates a backend keeping history in the given mode, using a fake S3 server started with opts
+func newHistoryBackend(t *testing.T, mode HistoryMode, opts ...s3test.Option) (*Backend, *s3test.Server) {
+	t.Helper()
+
+	backend, server := newFakeBackend(t, ConditionalWritesAuto, opts...)
+	backend.history = mode
+	return backend, server
+}
+
+func TestHistory(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		mode     HistoryMode
+		opts     []s3test.Option
+		expected HistoryMode
+	}{
+		{name: "auto with versioning", opts: []s3test.Option{s3test.WithVersioning()}, expected: HistoryVersioning},
+		{name: "auto without versioning", expected: HistoryCopy},
+		{name: "copy with versioning", mode: HistoryCopy, opts: []s3test.Option{s3test.WithVersioning()}, expected: HistoryCopy},
+		{name: "copy with lock objects", mode: HistoryCopy, opts: []s3test.Option{s3test.WithoutConditionalWrites()}, expected: HistoryCopy},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := t.Context()
+			backend, _ := newHistoryBackend(t, test.mode, test.opts...)
+
+			_, err := backend.POST(ctx, "/doc", []byte("v1"))
+			expectStatus(t, err, 0)
+			_, err = backend.PUT(ctx, "/doc", []byte("v2"))
+			expectStatus(t, err, 0)
+			_, err = backend.DELETE(ctx, "/doc")
+			expectStatus(t, err, 0)
+			_, err = backend.POST(ctx, "/doc", []byte("v3"))
+			expectStatus(t, err, 0)
+			_, err = backend.POST(ctx, "/doc/child", []byte("child"))
+			expectStatus(t, err, 0)
+
+			// Failed writes leave no version
+			_, err = backend.POST(ctx, "/doc", []byte("conflict"))
+			expectStatus(t, err, http.StatusConflict)
+			_, err = backend.PUT(ctx, "/missing", []byte("missing"))
+			expectStatus(t, err, http.StatusNotFound)
+
+			if backend.history != test.expected {
+				t.Errorf("expected history mode %q, got %q", test.expected, backend.history)
+			}
+
+			versions, err := backend.History(ctx, "/doc")
+			expectStatus(t, err, 0)
+			if len(versions) != 4 {
+				t.Fatalf("expected 4 versions, got %+v", versions)
+			}
+			for i, expected := range []string{"v3", "", "v2", "v1"} {
+				version := versions[i]
+				if version.Deleted != (expected == "") {
+					t.Errorf("version %d: expected deleted to be %v, got %+v", i, expected == "", version)
+				}
+				result, err := backend.GETVersion(ctx, "/doc", version.ID)
+				if expected == "" {
+					expectStatus(t, err, http.StatusNotFound)
+					continue
+				}
+				expectStatus(t, err, 0)
+				if string(result.Data) != expected {
+					t.Errorf("version %d: expected %q, got %q", i, expected, result.Data)
+				}
+			}
+
+			_, err = backend.GETVersion(ctx, "/doc", "unknown")
+			expectStatus(t, err, http.StatusNotFound)
+
+			versions, err = backend.History(ctx, "/missing")
+			expectStatus(t, err, 0)
+			if len(versions) != 0 {
+				t.Errorf("expected no versions of a resource never written, got %+v", versions)
+			}
+
+			paths, err := backend.List(ctx, "/")
+			expectStatus(t, err, 0)
+			if !slices.Equal(paths, []string{"/doc", "/doc/child"}) {
+				t.Errorf("expected listing to exclude history, got %v", paths)
+			}
+		})
+	}
+}
+
+func TestHistoryCopies(t *testing.T) {
+	ctx := t.Context()
+	backend, server := newHistoryBackend(t, HistoryCopy)
+
+	_, err := backend.POST(ctx, "/doc", []byte("v1"))
+	expectStatus(t, err, 0)
+
+	var copies []string
+	for _, key := range server.Keys(fakeBucket) {
+		if strings.HasPrefix(key, "store/.history/doc/") {
+			copies = append(copies, key)
+		}
+	}
+	if len(copies) != 1 || !strings.Contains(copies[0], "-create-") {
+		t.Errorf("expected one copy of the create, got %v", copies)
+	}
+
+	// No copy is written if the write fails, so history only lists versions that became current
+	server.InjectError(s3test.OpHeadObject, -1, s3test.ErrAccessDenied)
+	_, err = backend.PUT(ctx, "/doc", []byte("v2"))
+	expectStatus(t, err, http.StatusInternalServerError)
+	server.ClearErrors()
+
+	if keys := server.Keys(fakeBucket); len(keys) != 2 {
+		t.Errorf("expected only the resource and its copy, got %v", keys)
+	}
+}
+
+func TestHistoryStamps(t *testing.T) {
+	for name, opts := range map[string][]s3test.Option{
+		"conditional requests": nil,
+		"lock objects":         {s3test.WithoutConditionalWrites()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := t.Context()
+			backend, _ := newHistoryBackend(t, HistoryCopy, opts...)
+
+			// Another replica, with a clock an hour ahead, wrote the current version
+			ahead := time.Now().Add(time.Hour).UnixNano()
+			_, err := backend.client.PutObject(ctx, &s3.PutObjectInput{
+				Bucket:   aws.String(fakeBucket),
+				Key:      aws.String(backend.buildKey("/doc")),
+				Body:     strings.NewReader("v1"),
+				Metadata: historyStampMetadata(ahead),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			backend.recordHistory(ctx, "/doc", writeCreate, []byte("v1"), ahead)
+
+			_, err = backend.PUT(ctx, "/doc", []byte("v2"))
+			expectStatus(t, err, 0)
+			versions, err := backend.History(ctx, "/doc")
+			expectStatus(t, err, 0)
+			if len(versions) != 2 {
+				t.Fatalf("expected 2 versions, got %+v", versions)
+			}
+			result, err := backend.GETVersion(ctx, "/doc", versions[0].ID)
+			expectStatus(t, err, 0)
+			if string(result.Data) != "v2" {
+				t.Errorf("expected the update to be listed after the version it replaced, got %q first", result.Data)
+			}
+		})
+	}
+}
+
+func TestHistoryCopyAfterCancel(t *testing.T) {
+	backend, _ := newHistoryBackend(t, HistoryCopy)
+
+	// The write has been made, so its copy is recorded even if the request has gone away
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	backend.recordHistory(ctx, "/doc", writeCreate, []byte("v1"), nextHistoryStamp(0))
+
+	versions, err := backend.History(t.Context(), "/doc")
+	expectStatus(t, err, 0)
+	if len(versions) != 1 {
+		t.Errorf("expected the copy to be written, got %+v", versions)
+	}
+}
+
+func TestHistoryRetention(t *testing.T) {
+	ctx := t.Context()
+	backend, server := newHistoryBackend(t, HistoryCopy)
+	backend.historyMaxVersions = 2
+
+	_, err := backend.POST(ctx, "/doc", []byte("v1"))
+	expectStatus(t, err, 0)
+	for _, data := range []string{"v2", "v3", "v4"} {
+		_, err = backend.PUT(ctx, "/doc", []byte(data))
+		expectStatus(t, err, 0)
+	}
+	versions, err := backend.History(ctx, "/doc")
+	expectStatus(t, err, 0)
+	if len(versions) != 2 {
+		t.Fatalf("expected the 2 latest versions, got %+v", versions)
+	}
+	result, err := backend.GETVersion(ctx, "/doc", versions[1].ID)
+	expectStatus(t, err, 0)
+	if string(result.Data) != "v3" {
+		t.Errorf("expected the oldest version kept to be v3, got %q", result.Data)
+	}
+
+	// A deleted resource's copies are removed once its deletion is the only one left
+	backend.historyMaxVersions = 1
+	_, err = backend.DELETE(ctx, "/doc")
+	expectStatus(t, err, 0)
+	if keys := server.Keys(fakeBucket); len(keys) != 0 {
+		t.Errorf("expected no copies of the deleted resource, got %v", keys)
+	}
+}
+
+func TestHistoryOff(t *testing.T) {
+	ctx := t.Context()
+	backend, server := newHistoryBackend(t, HistoryOff)
+
+	_, err := backend.POST(ctx, "/doc", []byte("v1"))
+	expectStatus(t, err, 0)
+	if keys := server.Keys(fakeBucket); len(keys) != 1 {
+		t.Errorf("expected no history copies, got %v", keys)
+	}
+	if server.Requests(s3test.OpGetBucketVersioning) != 0 {
+		t.Error("expected versioning not to be checked")
+	}
+
+	_, err = backend.History(ctx, "/doc")
+	expectStatus(t, err, http.StatusNotImplemented)
+	_, err = backend.GETVersion(ctx, "/doc", "v1")
+	expectStatus(t, err, http.StatusNotImplemented)
+}
+
+func TestHistoryDetection(t *testing.T) {
+	ctx := t.Context()
+	backend, server := newHistoryBackend(t, HistoryAuto)
+
+	// Providers without versioning reject the request
+	server.InjectError(s3test.OpGetBucketVersioning, 1, s3test.Error{Status: http.StatusNotImplemented, Code: "NotImplemented"})
+	_, err := backend.POST(ctx, "/doc", []byte("v1"))
+	expectStatus(t, err, 0)
+	if backend.history != HistoryCopy {
+		t.Errorf("expected history mode %q, got %q", HistoryCopy, backend.history)
+	}
+
+	_, err = backend.PUT(ctx, "/doc", []byte("v2"))
+	expectStatus(t, err, 0)
+	if requests := server.Requests(s3test.OpGetBucketVersioning); requests != 1 {
+		t.Errorf("expected versioning to be checked once, got %d requests", requests)
+	}
+}
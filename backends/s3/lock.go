@@ -0,0 +1,159 @@
+package s3
+
+// Lock objects
+//
+// Where the provider does not support conditional writes, each write holds a lock on its resource
+// while it checks whether the object exists and then writes it. Without conditional writes S3 has no
+// atomic create, so the lock relies on S3's strongly consistent listing instead:
+//
+//  1. The writer puts an empty lock object with a unique name under .locks/<path>/ in the backend's
+//     prefix. The name starts with the time at which the lock expires.
+//  2. It lists .locks/<path>/. If its own lock object is the only unexpired one, it holds the lock.
+//     Expired lock objects, left by writers that crashed, are deleted.
+//  3. Otherwise it deletes its lock object and tries again after an exponential backoff.
+//  4. Once its write is complete, it deletes its lock object.
+//
+// Two writers cannot both hold the lock: each lists after its own lock object is written, so whichever
+// lists last sees the other's lock object. A writer that holds a lock for longer than its TTL may overlap
+// with the next writer. All writers to a prefix must use the same protocol, since conditional writes do
+// not respect lock objects.
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/cenkalti/backoff/v5"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+// lockPrefix is the directory within the backend's prefix that holds lock objects
+const lockPrefix = ".locks"
+
+// defaultLockTTL is how long a lock object is honored
+const defaultLockTTL = 30 * time.Second
+
+// errLockHeld indicates that another writer holds a lock
+var errLockHeld = errors.New("lock held by another writer")
+
+// lockDir returns the key prefix of the lock objects for path p
+func (b *Backend) lockDir(p string) string {
+	return path.Join(b.prefix, lockPrefix, p) + "/"
+}
+
+// lock acquires the lock for path p, returning a function to release it and the number of retries
+// taken. It fails with ErrUnavailable if the lock is not acquired within the lock TTL.
+func (b *Backend) lock(ctx context.Context, p string) (func(), int, error) {
+	dir := b.lockDir(p)
+
+	retries := -1
+	operation := func() (string, error) {
+		retries++
+		key := dir + lockName(time.Now().Add(b.lockTTL))
+		_, err := b.client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(b.bucket),
+			Key:    aws.String(key),
+			Body:   strings.NewReader(""),
+		})
+		if err != nil {
+			return "", backoff.Permanent(fmt.Errorf("putting lock object: %w", err))
+		}
+
+		held, err := b.onlyLock(ctx, dir, key)
+		if err != nil || !held {
+			b.unlock(ctx, key)
+		}
+		if err != nil {
+			return "", backoff.Permanent(err)
+		}
+		if !held {
+			return "", errLockHeld
+		}
+		return key, nil
+	}
+
+	policy := backoff.NewExponentialBackOff()
+	policy.InitialInterval = 50 * time.Millisecond
+	policy.MaxInterval = time.Second
+	key, err := backoff.Retry(ctx, operation, backoff.WithBackOff(policy), backoff.WithMaxElapsedTime(b.lockTTL))
+	if errors.Is(err, errLockHeld) {
+		return nil, retries, gitbackedrest.NewUserError(
+			"Service Unavailable",
+			gitbackedrest.NewHTTPError(
+				http.StatusServiceUnavailable,
+				fmt.Errorf("timed out waiting for lock on %s: %w", p, gitbackedrest.ErrUnavailable),
+			),
+		)
+	}
+	if err != nil {
+		return nil, retries, err
+	}
+	return func() { b.unlock(ctx, key) }, retries, nil
+}
+
+// onlyLock reports whether key is the only unexpired lock object under dir, deleting any expired ones
+func (b *Backend) onlyLock(ctx context.Context, dir, key string) (bool, error) {
+	now := time.Now()
+	only := true
+	// The delimiter excludes the locks of paths beneath p
+	paginator := s3.NewListObjectsV2Paginator(b.client, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(b.bucket),
+		Prefix:    aws.String(dir),
+		Delimiter: aws.String("/"),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return false, fmt.Errorf("listing lock objects: %w", err)
+		}
+		for _, obj := range page.Contents {
+			other := aws.ToString(obj.Key)
+			if other == key {
+				continue
+			}
+			if expires, ok := lockExpiry(strings.TrimPrefix(other, dir)); ok && now.After(expires) {
+				gitbackedrest.Logger(ctx, b.logger).WarnContext(ctx, "deleting expired lock object", "key", other)
+				b.unlock(ctx, other)
+				continue
+			}
+			only = false
+		}
+	}
+	return only, nil
+}
+
+// lockName returns a unique name for a lock object that expires at the given time.
+// Names sort by expiry.
+func lockName(expires time.Time) string {
+	return fmt.Sprintf("%020d-%s", expires.UnixNano(), rand.Text())
+}
+
+// lockExpiry parses the expiry time from the name of a lock object
+func lockExpiry(name string) (time.Time, bool) {
+	nanos, _, _ := strings.Cut(name, "-")
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, n), true
+}
+
+// unlock deletes the lock object at key, even if ctx has been cancelled
+func (b *Backend) unlock(ctx context.Context, key string) {
+	_, err := b.client.DeleteObject(context.WithoutCancel(ctx), &s3.DeleteObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		gitbackedrest.Logger(ctx, b.logger).WarnContext(ctx, "deleting lock object", "key", key, "error", err)
+	}
+}
@@ -0,0 +1,33 @@
+package s3
+
+import (
+	"context"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go/middleware"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+// metricsBackend is the value of the "backend" label for this backend's metrics
+const metricsBackend = "s3"
+
+// addPhaseMiddleware records each S3 API call as a phase of the operation that made it,
+// so calls such as HeadObject and PutObject appear as child spans of a PUT.
+// The latency of each call is recorded by operation name.
+func (b *Backend) addPhaseMiddleware(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("GitBackedRestPhase", func(
+		ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler,
+	) (middleware.InitializeOutput, middleware.Metadata, error) {
+		operation := awsmiddleware.GetOperationName(ctx)
+		ctx, phase := gitbackedrest.StartPhase(ctx, b.logger, operation)
+		defer phase.End()
+
+		start := time.Now()
+		out, metadata, err := next.HandleInitialize(ctx, in)
+		b.metrics.ObserveOperation(metricsBackend, operation, start, err)
+		phase.SetError(err)
+		return out, metadata, err
+	}), middleware.After)
+}
@@ -0,0 +1,106 @@
+package s3
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+// Scheme is the URL scheme for S3 backends, e.g. s3://bucket/prefix?endpoint=https://s3.example.com
+const Scheme = "s3"
+
+func init() {
+	gitbackedrest.Register(Scheme, open)
+}
+
+// open creates a backend from an s3://bucket/prefix URL. The endpoint, region, profile, path_style, ca_bundle,
+// request_timeout, max_attempts, max_backoff, conditional_writes, history and history_max_versions query parameters
+// configure the client, and encryption, kms_key_id and storage_class configure written objects.
+// Credentials are taken from the URL's user info if given, otherwise from S3_ACCESS_KEY_ID, S3_SECRET_ACCESS_KEY
+// and S3_SESSION_TOKEN, and otherwise from the AWS credential chain. The endpoint defaults to S3_ENDPOINT.
+// The SSE-C key is read from S3_SSE_CUSTOMER_KEY, base64 encoded, rather than the URL.
+func open(ctx context.Context, u *url.URL, cfg gitbackedrest.OpenConfig) (gitbackedrest.APIBackend, error) {
+	if u.Host == "" {
+		return nil, errors.New("bucket is required, e.g. s3://bucket/prefix")
+	}
+
+	query := u.Query()
+	config := Config{
+		Endpoint:          query.Get("endpoint"),
+		AccessKeyID:       os.Getenv("S3_ACCESS_KEY_ID"),
+		SecretAccessKey:   os.Getenv("S3_SECRET_ACCESS_KEY"),
+		SessionToken:      os.Getenv("S3_SESSION_TOKEN"),
+		Profile:           query.Get("profile"),
+		Bucket:            u.Host,
+		Prefix:            strings.Trim(u.Path, "/"),
+		Region:            query.Get("region"),
+		CABundle:          query.Get("ca_bundle"),
+		Logger:            cfg.Logger,
+		Metrics:           cfg.Metrics,
+		ConditionalWrites: ConditionalWriteMode(query.Get("conditional_writes")),
+		History:           HistoryMode(query.Get("history")),
+		ObjectSettings: ObjectSettings{
+			Encryption:   Encryption(query.Get("encryption")),
+			KMSKeyID:     query.Get("kms_key_id"),
+			StorageClass: types.StorageClass(query.Get("storage_class")),
+		},
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = os.Getenv("S3_ENDPOINT")
+	}
+	if u.User != nil {
+		config.AccessKeyID = u.User.Username()
+		config.SecretAccessKey, _ = u.User.Password()
+		config.SessionToken = ""
+	}
+
+	if value := query.Get("path_style"); value != "" {
+		pathStyle, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("parsing path_style: %w", err)
+		}
+		config.UsePathStyle = pathStyle
+	}
+	for key, target := range map[string]*time.Duration{
+		"request_timeout": &config.RequestTimeout,
+		"max_backoff":     &config.MaxBackoff,
+	} {
+		if value := query.Get(key); value != "" {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return nil, fmt.Errorf("parsing %s: %w", key, err)
+			}
+			*target = d
+		}
+	}
+	if value := os.Getenv("S3_SSE_CUSTOMER_KEY"); value != "" {
+		key, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("decoding S3_SSE_CUSTOMER_KEY: %w", err)
+		}
+		config.ObjectSettings.CustomerKey = key
+	}
+	for key, target := range map[string]*int{
+		"max_attempts":         &config.MaxAttempts,
+		"history_max_versions": &config.HistoryMaxVersions,
+	} {
+		if value := query.Get(key); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("parsing %s: %w", key, err)
+			}
+			*target = n
+		}
+	}
+	return NewBackend(config)
+}
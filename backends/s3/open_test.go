@@ -0,0 +1,78 @@
+package s3
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+func TestOpen(t *testing.T) {
+	opened, err := gitbackedrest.Open(t.Context(), "s3://key:secret@bucket/store/one/?endpoint=http://localhost:9000&region=us-east-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := opened.(*Backend)
+	if backend.bucket != "bucket" || backend.prefix != "store/one" {
+		t.Errorf("unexpected bucket %q and prefix %q", backend.bucket, backend.prefix)
+	}
+	if region := backend.client.Options().Region; region != "us-east-1" {
+		t.Errorf("expected region us-east-1, got %q", region)
+	}
+
+	if _, err := gitbackedrest.Open(t.Context(), "s3:///prefix"); err == nil {
+		t.Error("expected an error without a bucket")
+	}
+}
+
+func TestOpenClientSettings(t *testing.T) {
+	opened, err := gitbackedrest.Open(t.Context(), "s3://key:secret@bucket/?endpoint=http://localhost:9000&path_style=true&request_timeout=5s&max_attempts=7&max_backoff=2s&history_max_versions=3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := opened.(*Backend).client.Options()
+	if !options.UsePathStyle {
+		t.Error("expected path-style addressing")
+	}
+	if attempts := options.Retryer.MaxAttempts(); attempts != 7 {
+		t.Errorf("expected 7 attempts, got %d", attempts)
+	}
+	if limit := opened.(*Backend).historyMaxVersions; limit != 3 {
+		t.Errorf("expected 3 history copies to be kept, got %d", limit)
+	}
+
+	for _, query := range []string{"path_style=maybe", "request_timeout=soon", "max_attempts=many", "max_backoff=1", "history_max_versions=all"} {
+		if _, err := gitbackedrest.Open(t.Context(), "s3://key:secret@bucket/?endpoint=http://localhost:9000&"+query); err == nil {
+			t.Errorf("expected an error for %s", query)
+		}
+	}
+}
+
+func TestOpenObjectSettings(t *testing.T) {
+	t.Setenv("S3_SSE_CUSTOMER_KEY", "")
+	opened, err := gitbackedrest.Open(t.Context(), "s3://key:secret@bucket/?endpoint=http://localhost:9000&encryption=sse-kms&kms_key_id=alias/data&storage_class=STANDARD_IA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := opened.(*Backend).objects.forPath("/doc")
+	if options.encryption != types.ServerSideEncryptionAwsKms || aws.ToString(options.kmsKeyID) != "alias/data" || options.storageClass != types.StorageClassStandardIa {
+		t.Errorf("unexpected object options %+v", options)
+	}
+
+	t.Setenv("S3_SSE_CUSTOMER_KEY", base64.StdEncoding.EncodeToString(testCustomerKey))
+	opened, err = gitbackedrest.Open(t.Context(), "s3://key:secret@bucket/?endpoint=http://localhost:9000&encryption=sse-c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options := opened.(*Backend).objects.forPath("/doc"); options.customerKey == nil {
+		t.Error("expected the customer key from S3_SSE_CUSTOMER_KEY")
+	}
+
+	t.Setenv("S3_SSE_CUSTOMER_KEY", "not base64!")
+	if _, err := gitbackedrest.Open(t.Context(), "s3://key:secret@bucket/?endpoint=http://localhost:9000&encryption=sse-c"); err == nil {
+		t.Error("expected an error for an invalid customer key")
+	}
+}
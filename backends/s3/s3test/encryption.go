@@ -0,0 +1,122 @@
+package s3test
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"net/http"
+)
+
+// Server-side encryption and storage class headers
+const (
+	headerEncryption        = "X-Amz-Server-Side-Encryption"
+	headerKMSKeyID          = "X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id"
+	headerCustomerAlgorithm = "X-Amz-Server-Side-Encryption-Customer-Algorithm"
+	headerCustomerKey       = "X-Amz-Server-Side-Encryption-Customer-Key"
+	headerCustomerKeyMD5    = "X-Amz-Server-Side-Encryption-Customer-Key-Md5"
+	headerStorageClass      = "X-Amz-Storage-Class"
+)
+
+// encryption is how an object is stored. The fake does not encrypt anything, but enforces the SSE-C key
+// on reads as S3 does.
+type encryption struct {
+	// algorithm is AES256 or aws:kms, or empty for SSE-C or no encryption
+	algorithm string
+	kmsKeyID  string
+	// customerKeyMD5 is the base64 MD5 of the SSE-C key, or empty without SSE-C
+	customerKeyMD5 string
+	// storageClass is empty for STANDARD
+	storageClass string
+}
+
+// readCustomerKey returns the base64 MD5 of the SSE-C key in a request's headers, or an empty string
+// if there is none. The error is set if the headers are invalid.
+func readCustomerKey(r *http.Request) (string, *Error) {
+	algorithm, key, keyMD5 := r.Header.Get(headerCustomerAlgorithm), r.Header.Get(headerCustomerKey), r.Header.Get(headerCustomerKeyMD5)
+	if algorithm == "" && key == "" && keyMD5 == "" {
+		return "", nil
+	}
+	if algorithm != "AES256" {
+		return "", &Error{http.StatusBadRequest, "InvalidEncryptionAlgorithmError", "The encryption request you specified is not valid. The valid value is AES256."}
+	}
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(decoded) != 32 {
+		return "", &Error{http.StatusBadRequest, "InvalidArgument", "The secret key was invalid for the specified algorithm."}
+	}
+	sum := md5.Sum(decoded)
+	if base64.StdEncoding.EncodeToString(sum[:]) != keyMD5 {
+		return "", &Error{http.StatusBadRequest, "InvalidArgument", "The calculated MD5 hash of the key did not match the hash that was provided."}
+	}
+	return keyMD5, nil
+}
+
+// readEncryption returns the encryption and storage class requested by a PutObject
+func readEncryption(r *http.Request) (encryption, *Error) {
+	enc := encryption{
+		algorithm:    r.Header.Get(headerEncryption),
+		kmsKeyID:     r.Header.Get(headerKMSKeyID),
+		storageClass: r.Header.Get(headerStorageClass),
+	}
+	switch enc.algorithm {
+	case "", "AES256", "aws:kms":
+	default:
+		return encryption{}, &Error{http.StatusBadRequest, "InvalidArgument", "Server Side Encryption with the specified algorithm is not supported."}
+	}
+	if enc.kmsKeyID != "" && enc.algorithm != "aws:kms" {
+		return encryption{}, &Error{http.StatusBadRequest, "InvalidArgument", "Server Side Encryption with AWS KMS managed key requires HTTP header x-amz-server-side-encryption : aws:kms"}
+	}
+	if enc.storageClass == "STANDARD" {
+		enc.storageClass = ""
+	}
+
+	keyMD5, err := readCustomerKey(r)
+	if err != nil {
+		return encryption{}, err
+	}
+	if keyMD5 != "" && enc.algorithm != "" {
+		return encryption{}, &Error{http.StatusBadRequest, "InvalidArgument", "Server Side Encryption with Customer provided key is incompatible with the encryption method specified."}
+	}
+	enc.customerKeyMD5 = keyMD5
+	return enc, nil
+}
+
+// checkCustomerKey checks a GetObject or HeadObject presents the SSE-C key the object was written with,
+// and no key if it was not written with one
+func checkCustomerKey(r *http.Request, enc encryption) *Error {
+	keyMD5, err := readCustomerKey(r)
+	switch {
+	case err != nil:
+		return err
+	case enc.customerKeyMD5 == "" && keyMD5 != "":
+		return &Error{http.StatusBadRequest, "InvalidRequest", "The encryption parameters are not applicable to this object."}
+	case enc.customerKeyMD5 != "" && keyMD5 == "":
+		return &Error{http.StatusBadRequest, "InvalidRequest", "The object was stored using a form of Server Side Encryption. The correct parameters must be provided to retrieve the object."}
+	case enc.customerKeyMD5 != keyMD5:
+		return &Error{http.StatusForbidden, "AccessDenied", "Access Denied"}
+	}
+	return nil
+}
+
+// setHeaders sets the response headers describing enc
+func (enc encryption) setHeaders(header http.Header) {
+	if enc.algorithm != "" {
+		header.Set(headerEncryption, enc.algorithm)
+	}
+	if enc.kmsKeyID != "" {
+		header.Set(headerKMSKeyID, enc.kmsKeyID)
+	}
+	if enc.customerKeyMD5 != "" {
+		header.Set(headerCustomerAlgorithm, "AES256")
+		header.Set(headerCustomerKeyMD5, enc.customerKeyMD5)
+	}
+	if enc.storageClass != "" {
+		header.Set(headerStorageClass, enc.storageClass)
+	}
+}
+
+// listStorageClass returns the storage class of enc as reported in listings
+func (enc encryption) listStorageClass() string {
+	if enc.storageClass == "" {
+		return "STANDARD"
+	}
+	return enc.storageClass
+}
@@ -0,0 +1,76 @@
+package s3test
+
+import "net/http"
+
+// Error is an S3 error response
+type Error struct {
+	// Status is the HTTP status code
+	Status int
+	// Code is the S3 error code, such as "SlowDown"
+	Code string
+	// Message describes the error
+	Message string
+}
+
+// Common errors to inject
+var (
+	ErrInternal     = Error{http.StatusInternalServerError, "InternalError", "We encountered an internal error. Please try again."}
+	ErrSlowDown     = Error{http.StatusServiceUnavailable, "SlowDown", "Please reduce your request rate."}
+	ErrAccessDenied = Error{http.StatusForbidden, "AccessDenied", "Access Denied"}
+)
+
+// fault is an injected error
+type fault struct {
+	operation string
+	// remaining is the number of requests left to fail, or negative to fail indefinitely
+	remaining int
+	err       Error
+}
+
+// InjectError makes the next count requests for operation fail with err. An empty operation matches all
+// operations, and a negative count fails requests until ClearErrors is called. Faults are applied in the
+// order they were injected.
+func (s *Server) InjectError(operation string, count int, err Error) {
+	if count == 0 {
+		return
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.faults = append(s.faults, fault{operation: operation, remaining: count, err: err})
+}
+
+// InjectKeyError makes every request for key fail with err until ClearErrors is called. Unlike InjectError,
+// DeleteObjects requests still succeed, reporting err for key alone, to test handling of partial failures.
+func (s *Server) InjectKeyError(key string, err Error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.keyFaults[key] = err
+}
+
+// ClearErrors removes all injected errors
+func (s *Server) ClearErrors() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.faults = nil
+	clear(s.keyFaults)
+}
+
+// takeFault returns the first injected fault matching operation, consuming one of its requests.
+// s.mtx must be held.
+func (s *Server) takeFault(operation string) (fault, bool) {
+	for i := range s.faults {
+		f := &s.faults[i]
+		if f.operation != "" && f.operation != operation {
+			continue
+		}
+		taken := *f
+		if f.remaining > 0 {
+			f.remaining--
+			if f.remaining == 0 {
+				s.faults = append(s.faults[:i], s.faults[i+1:]...)
+			}
+		}
+		return taken, true
+	}
+	return fault{}, false
+}
@@ -0,0 +1,124 @@
+package s3test
+
+import (
+	"encoding/base64"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxKeys is the largest page of a listing, as in S3
+const maxKeys = 1000
+
+// listBucketResult is the body of a ListObjectsV2 response
+type listBucketResult struct {
+	XMLName               struct{}       `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name                  string         `xml:"Name"`
+	Prefix                string         `xml:"Prefix"`
+	KeyCount              int            `xml:"KeyCount"`
+	MaxKeys               int            `xml:"MaxKeys"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	ContinuationToken     string         `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+	StartAfter            string         `xml:"StartAfter,omitempty"`
+	Delimiter             string         `xml:"Delimiter,omitempty"`
+	Contents              []listContents `xml:"Contents"`
+	CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+}
+
+type commonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+type listContents struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int    `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+// listObjects serves ListObjectsV2, in pages of up to max-keys keys in lexical order.
+// Keys containing the delimiter after the prefix are grouped into common prefixes, which count
+// towards max-keys. Continuation tokens encode the last key or common prefix of the previous page.
+func (s *Server) listObjects(w http.ResponseWriter, r *http.Request, bucket string, objects map[string]*object) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	delimiter := query.Get("delimiter")
+
+	limit := maxKeys
+	if value := query.Get("max-keys"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			writeError(w, r, http.StatusBadRequest, "InvalidArgument", "max-keys must be a non-negative integer.")
+			return
+		}
+		limit = min(n, maxKeys)
+	}
+
+	after := query.Get("start-after")
+	if token := query.Get("continuation-token"); token != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(token)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "InvalidArgument", "The continuation token provided is incorrect.")
+			return
+		}
+		after = string(decoded)
+	}
+
+	// Entries are keys, or common prefixes ending in the delimiter
+	isCommonPrefix := func(entry string) bool {
+		return delimiter != "" && strings.Contains(entry[len(prefix):], delimiter)
+	}
+	seen := make(map[string]bool)
+	var entries []string
+	for key := range objects {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		entry := key
+		if isCommonPrefix(key) {
+			entry = key[:len(prefix)+strings.Index(key[len(prefix):], delimiter)+len(delimiter)]
+		}
+		if entry > after && !seen[entry] {
+			seen[entry] = true
+			entries = append(entries, entry)
+		}
+	}
+	slices.Sort(entries)
+
+	result := listBucketResult{
+		Name:              bucket,
+		Prefix:            prefix,
+		MaxKeys:           limit,
+		ContinuationToken: query.Get("continuation-token"),
+		StartAfter:        query.Get("start-after"),
+		Delimiter:         delimiter,
+	}
+	if len(entries) > limit {
+		entries = entries[:limit]
+		result.IsTruncated = true
+		if limit > 0 {
+			result.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(entries[len(entries)-1]))
+		}
+	}
+	for _, entry := range entries {
+		if isCommonPrefix(entry) {
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: entry})
+			continue
+		}
+		obj := objects[entry]
+		result.Contents = append(result.Contents, listContents{
+			Key:          entry,
+			LastModified: obj.modified.Format(time.RFC3339Nano),
+			ETag:         obj.etag,
+			Size:         len(obj.data),
+			StorageClass: obj.encryption.listStorageClass(),
+		})
+	}
+	result.KeyCount = len(result.Contents) + len(result.CommonPrefixes)
+
+	writeXML(w, http.StatusOK, result)
+}
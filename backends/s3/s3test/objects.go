@@ -0,0 +1,356 @@
+package s3test
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// object is a stored object
+type object struct {
+	data        []byte
+	etag        string
+	modified    time.Time
+	contentType string
+	// metadata holds the x-amz-meta-* headers, keyed by canonical header name
+	metadata   http.Header
+	encryption encryption
+	// versionID identifies the object among the versions of its key, if the bucket is versioned
+	versionID string
+	// deleteMarker is true for a version recording a delete
+	deleteMarker bool
+}
+
+// pendingWrite is a write that is not yet visible to reads
+type pendingWrite struct {
+	key string
+	// obj is the written object, or nil for a delete
+	obj     *object
+	visible time.Time
+}
+
+// bucket holds the objects of a bucket
+type bucket struct {
+	// objects is the latest state of the bucket
+	objects map[string]*object
+	// visible is the state seen by reads, which lags objects by the pending writes
+	visible map[string]*object
+	pending []pendingWrite
+
+	// versions holds every version of each key, oldest first, if the bucket is versioned
+	versions    map[string][]*object
+	nextVersion int
+}
+
+func newBucket() *bucket {
+	objects := make(map[string]*object)
+	return &bucket{
+		objects: objects,
+		visible: objects,
+	}
+}
+
+// write stores obj at key, or deletes it if obj is nil. The write is visible to reads after delay.
+// Without a delay, reads share the latest state. In a versioned bucket, the write is recorded as a new version,
+// and its version ID returned.
+func (b *bucket) write(key string, obj *object, now time.Time, delay time.Duration) string {
+	var versionID string
+	if b.versions != nil {
+		b.nextVersion++
+		versionID = fmt.Sprintf("v%08d", b.nextVersion)
+		version := obj
+		if obj == nil {
+			version = &object{deleteMarker: true, modified: now.UTC()}
+		}
+		version.versionID = versionID
+		b.versions[key] = append(b.versions[key], version)
+	}
+
+	if delay > 0 && len(b.pending) == 0 {
+		// Reads see a copy until the write is visible
+		b.visible = maps.Clone(b.objects)
+	}
+	if obj == nil {
+		delete(b.objects, key)
+	} else {
+		b.objects[key] = obj
+	}
+	if delay > 0 {
+		b.pending = append(b.pending, pendingWrite{key: key, obj: obj, visible: now.Add(delay)})
+	}
+	return versionID
+}
+
+// version returns the version of key with the given ID, or nil if there is none
+func (b *bucket) version(key, versionID string) *object {
+	for _, version := range b.versions[key] {
+		if version.versionID == versionID {
+			return version
+		}
+	}
+	return nil
+}
+
+// view returns the objects visible to reads at now
+func (b *bucket) view(now time.Time) map[string]*object {
+	n := 0
+	for n < len(b.pending) && !b.pending[n].visible.After(now) {
+		w := b.pending[n]
+		if w.obj == nil {
+			delete(b.visible, w.key)
+		} else {
+			b.visible[w.key] = w.obj
+		}
+		n++
+	}
+	b.pending = b.pending[n:]
+	return b.visible
+}
+
+func (s *Server) getObject(w http.ResponseWriter, r *http.Request, obj *object) {
+	if obj == nil {
+		writeError(w, r, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+
+	if err := checkCustomerKey(r, obj.encryption); err != nil {
+		writeError(w, r, err.Status, err.Code, err.Message)
+		return
+	}
+
+	header := w.Header()
+	for name, values := range obj.metadata {
+		header[name] = values
+	}
+	obj.encryption.setHeaders(header)
+	header.Set("ETag", obj.etag)
+	header.Set("Last-Modified", obj.modified.Format(http.TimeFormat))
+	header.Set("Content-Type", obj.contentType)
+	if obj.versionID != "" {
+		header.Set("X-Amz-Version-Id", obj.versionID)
+	}
+
+	if status, ok := checkReadConditions(r, obj); !ok {
+		if status == http.StatusNotModified {
+			w.WriteHeader(status)
+			return
+		}
+		writeError(w, r, status, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold.")
+		return
+	}
+
+	header.Set("Content-Length", fmt.Sprint(len(obj.data)))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		w.Write(obj.data)
+	}
+}
+
+// checkReadConditions checks the conditional headers of a GET or HEAD, returning the status to respond with
+// if they are not met
+func checkReadConditions(r *http.Request, obj *object) (int, bool) {
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && !matchETag(ifMatch, obj.etag) {
+		return http.StatusPreconditionFailed, false
+	}
+	if since, err := http.ParseTime(r.Header.Get("If-Unmodified-Since")); err == nil && r.Header.Get("If-Match") == "" && obj.modified.Truncate(time.Second).After(since) {
+		return http.StatusPreconditionFailed, false
+	}
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		if matchETag(ifNoneMatch, obj.etag) {
+			return http.StatusNotModified, false
+		}
+	} else if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !obj.modified.Truncate(time.Second).After(since) {
+		return http.StatusNotModified, false
+	}
+	return 0, true
+}
+
+// matchETag reports whether a conditional header's comma-separated list of ETags, or *, matches etag
+func matchETag(header, etag string) bool {
+	for candidate := range strings.SplitSeq(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkWriteConditions checks the If-Match and If-None-Match headers of a write against the current object,
+// writing an error response and returning false if they are not met.
+func (s *Server) checkWriteConditions(w http.ResponseWriter, r *http.Request, current *object) bool {
+	ifMatch, ifNoneMatch := r.Header.Get("If-Match"), r.Header.Get("If-None-Match")
+	if ifMatch == "" && ifNoneMatch == "" {
+		return true
+	}
+	if !s.conditionalWrites {
+		writeError(w, r, http.StatusNotImplemented, "NotImplemented", "A header you provided implies functionality that is not implemented.")
+		return false
+	}
+	if ifMatch != "" {
+		if current == nil {
+			writeError(w, r, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+			return false
+		}
+		if !matchETag(ifMatch, current.etag) {
+			writeError(w, r, http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold.")
+			return false
+		}
+	}
+	if ifNoneMatch == "*" && current != nil {
+		writeError(w, r, http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold.")
+		return false
+	}
+	return true
+}
+
+func (s *Server) putObject(w http.ResponseWriter, r *http.Request, b *bucket, key string, now time.Time) {
+	data, err := readBody(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+	enc, encErr := readEncryption(r)
+	if encErr != nil {
+		writeError(w, r, encErr.Status, encErr.Code, encErr.Message)
+		return
+	}
+	if !s.checkWriteConditions(w, r, b.objects[key]) {
+		return
+	}
+
+	sum := md5.Sum(data)
+	obj := &object{
+		data:        data,
+		etag:        `"` + hex.EncodeToString(sum[:]) + `"`,
+		modified:    now.UTC(),
+		contentType: r.Header.Get("Content-Type"),
+		metadata:    make(http.Header),
+		encryption:  enc,
+	}
+	if obj.contentType == "" {
+		obj.contentType = "binary/octet-stream"
+	}
+	for name, values := range r.Header {
+		if strings.HasPrefix(name, "X-Amz-Meta-") {
+			obj.metadata[name] = values
+		}
+	}
+	if versionID := b.write(key, obj, now, s.visibilityDelay); versionID != "" {
+		w.Header().Set("X-Amz-Version-Id", versionID)
+	}
+
+	enc.setHeaders(w.Header())
+	w.Header().Set("ETag", obj.etag)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) deleteObject(w http.ResponseWriter, r *http.Request, b *bucket, key string, now time.Time) {
+	if !s.checkWriteConditions(w, r, b.objects[key]) {
+		return
+	}
+	// Like S3, deleting a missing object succeeds
+	if versionID := b.write(key, nil, now, s.visibilityDelay); versionID != "" {
+		w.Header().Set("X-Amz-Version-Id", versionID)
+		w.Header().Set("X-Amz-Delete-Marker", "true")
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// readBody reads the body of a PutObject request, decoding the aws-chunked encoding used by the SDK
+// to send trailing checksums.
+func readBody(r *http.Request) ([]byte, error) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.Contains(r.Header.Get("Content-Encoding"), "aws-chunked") {
+		return data, nil
+	}
+
+	var decoded []byte
+	for {
+		header, rest, ok := strings.Cut(string(data), "\r\n")
+		if !ok {
+			return nil, fmt.Errorf("malformed chunk header")
+		}
+		sizeHex, _, _ := strings.Cut(header, ";")
+		var size int
+		if _, err := fmt.Sscanf(sizeHex, "%x", &size); err != nil {
+			return nil, fmt.Errorf("malformed chunk size %q", sizeHex)
+		}
+		if size == 0 {
+			// The remainder holds the trailing headers
+			return decoded, nil
+		}
+		if len(rest) < size+2 {
+			return nil, fmt.Errorf("truncated chunk")
+		}
+		decoded = append(decoded, rest[:size]...)
+		data = []byte(rest[size+2:])
+	}
+}
+
+// deleteRequest is the body of a DeleteObjects request
+type deleteRequest struct {
+	Objects []struct {
+		Key string `xml:"Key"`
+	} `xml:"Object"`
+	Quiet bool `xml:"Quiet"`
+}
+
+// deleteResult is the body of a DeleteObjects response
+type deleteResult struct {
+	XMLName struct{}        `xml:"http://s3.amazonaws.com/doc/2006-03-01/ DeleteResult"`
+	Deleted []deletedObject `xml:"Deleted"`
+	Errors  []deleteError   `xml:"Error"`
+}
+
+type deletedObject struct {
+	Key string `xml:"Key"`
+}
+
+type deleteError struct {
+	Key     string `xml:"Key"`
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// maxDeleteObjects is the most keys a DeleteObjects request may delete, as in S3
+const maxDeleteObjects = 1000
+
+func (s *Server) deleteObjects(w http.ResponseWriter, r *http.Request, b *bucket, now time.Time) {
+	data, err := readBody(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+	var request deleteRequest
+	if err := xml.Unmarshal(data, &request); err != nil {
+		writeError(w, r, http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed.")
+		return
+	}
+	if len(request.Objects) == 0 || len(request.Objects) > maxDeleteObjects {
+		writeError(w, r, http.StatusBadRequest, "MalformedXML", "The request must delete between 1 and 1000 objects.")
+		return
+	}
+
+	var result deleteResult
+	for _, obj := range request.Objects {
+		if err, ok := s.keyFaults[obj.Key]; ok {
+			result.Errors = append(result.Errors, deleteError{Key: obj.Key, Code: err.Code, Message: err.Message})
+			continue
+		}
+		b.write(obj.Key, nil, now, s.visibilityDelay)
+		if !request.Quiet {
+			result.Deleted = append(result.Deleted, deletedObject{Key: obj.Key})
+		}
+	}
+	writeXML(w, http.StatusOK, result)
+}
@@ -0,0 +1,286 @@
+// Package s3test provides an in-process S3-compatible server for testing the S3 backend without credentials.
+//
+// The server implements the subset of the S3 API used by the backend, addressed path-style:
+// GetObject, PutObject, HeadObject, DeleteObject, DeleteObjects, ListObjectsV2 and HeadBucket, including conditional
+// headers, object metadata, and server-side encryption and storage class headers, and optionally versioning with
+// GetBucketVersioning and ListObjectVersions. Objects are not really encrypted, but SSE-C keys are checked on reads.
+// Faults such as errors, latency and eventual consistency can be injected to test how clients handle them.
+package s3test
+
+import (
+	"crypto/x509"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Operation names, as used by the S3 API and the SDK's metrics
+const (
+	OpGetObject     = "GetObject"
+	OpPutObject     = "PutObject"
+	OpHeadObject    = "HeadObject"
+	OpDeleteObject  = "DeleteObject"
+	OpListObjectsV2 = "ListObjectsV2"
+	OpHeadBucket    = "HeadBucket"
+	OpDeleteObjects = "DeleteObjects"
+
+	OpGetBucketVersioning = "GetBucketVersioning"
+	OpListObjectVersions  = "ListObjectVersions"
+)
+
+// Server is a fake S3 server backed by memory
+type Server struct {
+	// URL is the endpoint of the server, for s3.Config.Endpoint
+	URL string
+
+	server *httptest.Server
+
+	mtx      sync.Mutex
+	buckets  map[string]*bucket
+	faults   []fault
+	requests map[string]int
+	// keyFaults are errors for every request for a key
+	keyFaults map[string]Error
+
+	conditionalWrites bool
+	hook              func(r *http.Request)
+	latency           time.Duration
+	visibilityDelay   time.Duration
+	versioning        bool
+	tls               bool
+}
+
+// Option configures a Server
+type Option func(*Server)
+
+// WithoutConditionalWrites makes the server reject PutObject and DeleteObject requests with If-Match or
+// If-None-Match headers as not implemented, like S3-compatible providers without conditional write support.
+func WithoutConditionalWrites() Option {
+	return func(s *Server) {
+		s.conditionalWrites = false
+	}
+}
+
+// WithRequestHook calls hook before each request is handled, such as to make a concurrent change.
+// The hook may make requests to the server.
+func WithRequestHook(hook func(r *http.Request)) Option {
+	return func(s *Server) {
+		s.hook = hook
+	}
+}
+
+// WithLatency delays every response by d
+func WithLatency(d time.Duration) Option {
+	return func(s *Server) {
+		s.latency = d
+	}
+}
+
+// WithEventualConsistency makes writes visible to GetObject, HeadObject and ListObjectsV2 only after d,
+// until which reads return the previous state of the object, like S3 before it became strongly consistent.
+// Conditional writes are always checked against the latest state.
+func WithEventualConsistency(d time.Duration) Option {
+	return func(s *Server) {
+		s.visibilityDelay = d
+	}
+}
+
+// WithVersioning enables versioning on every bucket, so that each write is kept as a version that can
+// be listed with ListObjectVersions and read by ID. Versions are always strongly consistent.
+func WithVersioning() Option {
+	return func(s *Server) {
+		s.versioning = true
+	}
+}
+
+// WithTLS serves HTTPS with a self-signed certificate, which clients must trust using Certificate
+func WithTLS() Option {
+	return func(s *Server) {
+		s.tls = true
+	}
+}
+
+// NewServer starts a server with the given buckets. Call Close when finished.
+func NewServer(buckets []string, opts ...Option) *Server {
+	s := &Server{
+		buckets:           make(map[string]*bucket),
+		requests:          make(map[string]int),
+		keyFaults:         make(map[string]Error),
+		conditionalWrites: true,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	for _, name := range buckets {
+		s.buckets[name] = newBucket()
+		if s.versioning {
+			s.buckets[name].versions = make(map[string][]*object)
+		}
+	}
+
+	// The handler is used directly rather than through a ServeMux, which would clean keys containing "//"
+	s.server = httptest.NewUnstartedServer(http.HandlerFunc(s.serveHTTP))
+	if s.tls {
+		s.server.StartTLS()
+	} else {
+		s.server.Start()
+	}
+	s.URL = s.server.URL
+	return s
+}
+
+// Close shuts down the server
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// Certificate returns the certificate of a server started WithTLS, or nil
+func (s *Server) Certificate() *x509.Certificate {
+	return s.server.Certificate()
+}
+
+// Keys returns the keys stored in a bucket, including writes that are not yet visible, in no particular order
+func (s *Server) Keys(bucket string) []string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	b, ok := s.buckets[bucket]
+	if !ok {
+		return nil
+	}
+	var keys []string
+	for key := range b.objects {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Requests returns the number of requests received for an operation, such as OpListObjectsV2
+func (s *Server) Requests(operation string) int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.requests[operation]
+}
+
+// operation returns the name of the S3 operation for a request
+func operation(r *http.Request, key string) string {
+	switch {
+	case key == "" && r.Method == http.MethodHead:
+		return OpHeadBucket
+	case key == "" && r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2":
+		return OpListObjectsV2
+	case key == "" && r.Method == http.MethodGet && r.URL.Query().Has("versioning"):
+		return OpGetBucketVersioning
+	case key == "" && r.Method == http.MethodGet && r.URL.Query().Has("versions"):
+		return OpListObjectVersions
+	case key == "" && r.Method == http.MethodPost && r.URL.Query().Has("delete"):
+		return OpDeleteObjects
+	case key == "":
+		return ""
+	case r.Method == http.MethodGet:
+		return OpGetObject
+	case r.Method == http.MethodHead:
+		return OpHeadObject
+	case r.Method == http.MethodPut:
+		return OpPutObject
+	case r.Method == http.MethodDelete:
+		return OpDeleteObject
+	default:
+		return ""
+	}
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.hook != nil {
+		s.hook(r)
+	}
+	if s.latency > 0 {
+		select {
+		case <-time.After(s.latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	bucketName, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	op := operation(r, key)
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.requests[op]++
+	if f, ok := s.takeFault(op); ok {
+		writeError(w, r, f.err.Status, f.err.Code, f.err.Message)
+		return
+	}
+	if err, ok := s.keyFaults[key]; ok && key != "" {
+		writeError(w, r, err.Status, err.Code, err.Message)
+		return
+	}
+
+	b, ok := s.buckets[bucketName]
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist.")
+		return
+	}
+
+	now := time.Now()
+	switch op {
+	case OpHeadBucket:
+		w.WriteHeader(http.StatusOK)
+	case OpListObjectsV2:
+		s.listObjects(w, r, bucketName, b.view(now))
+	case OpGetBucketVersioning:
+		s.getBucketVersioning(w, b)
+	case OpListObjectVersions:
+		s.listObjectVersions(w, r, bucketName, b)
+	case OpGetObject, OpHeadObject:
+		if versionID := r.URL.Query().Get("versionId"); versionID != "" {
+			s.getObjectVersion(w, r, b, key, versionID)
+			return
+		}
+		s.getObject(w, r, b.view(now)[key])
+	case OpPutObject:
+		s.putObject(w, r, b, key, now)
+	case OpDeleteObject:
+		s.deleteObject(w, r, b, key, now)
+	case OpDeleteObjects:
+		s.deleteObjects(w, r, b, now)
+	default:
+		writeError(w, r, http.StatusNotImplemented, "NotImplemented", "The operation is not implemented.")
+	}
+}
+
+// errorResponse is the body of an S3 error
+type errorResponse struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	Resource  string   `xml:"Resource"`
+	RequestID string   `xml:"RequestId"`
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	if r.Method == http.MethodHead {
+		// Responses to HEAD have no body, so clients identify errors by status code
+		w.WriteHeader(status)
+		return
+	}
+	writeXML(w, status, errorResponse{
+		Code:      code,
+		Message:   message,
+		Resource:  r.URL.Path,
+		RequestID: "s3test",
+	})
+}
+
+func writeXML(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(v)
+}
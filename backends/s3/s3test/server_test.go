@@ -0,0 +1,587 @@
+package s3test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const testBucket = "bucket"
+
+// newClient starts a server and returns an SDK client for it, which retries
+// with a short backoff to keep tests fast
+func newClient(t *testing.T, opts ...Option) (*s3.Client, *Server) {
+	t.Helper()
+
+	server := NewServer([]string{testBucket}, opts...)
+	t.Cleanup(server.Close)
+
+	client := s3.NewFromConfig(aws.Config{
+		Region:       "us-east-1",
+		Credentials:  credentials.NewStaticCredentialsProvider("key", "secret", ""),
+		BaseEndpoint: aws.String(server.URL),
+		Retryer: func() aws.Retryer {
+			return retry.NewStandard(func(o *retry.StandardOptions) {
+				o.MaxBackoff = time.Millisecond
+			})
+		},
+	}, func(o *s3.Options) {
+		o.UsePathStyle = true
+	})
+	return client, server
+}
+
+func put(t *testing.T, client *s3.Client, key, body string) {
+	t.Helper()
+	_, err := client.PutObject(t.Context(), &s3.PutObjectInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String(key),
+		Body:   strings.NewReader(body),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func get(t *testing.T, client *s3.Client, key string) (string, error) {
+	t.Helper()
+	output, err := client.GetObject(t.Context(), &s3.GetObjectInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return "", err
+	}
+	defer output.Body.Close()
+	data, err := io.ReadAll(output.Body)
+	return string(data), err
+}
+
+// statusCode returns the HTTP status code of an SDK error, or 0
+func statusCode(err error) int {
+	var responseErr interface{ HTTPStatusCode() int }
+	if errors.As(err, &responseErr) {
+		return responseErr.HTTPStatusCode()
+	}
+	return 0
+}
+
+func TestObjects(t *testing.T) {
+	ctx := t.Context()
+	client, _ := newClient(t)
+
+	if _, err := get(t, client, "missing"); statusCode(err) != http.StatusNotFound {
+		t.Errorf("expected 404 for a missing object, got %v", err)
+	}
+
+	_, err := client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(testBucket),
+		Key:         aws.String("/nested//doc"),
+		Body:        strings.NewReader("content"),
+		ContentType: aws.String("application/json"),
+		Metadata:    map[string]string{"owner": "alice"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data, err := get(t, client, "/nested//doc"); err != nil || data != "content" {
+		t.Errorf("expected content, got %q, %v", data, err)
+	}
+	head, err := client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String("/nested//doc"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aws.ToString(head.ContentType) != "application/json" || head.Metadata["owner"] != "alice" || aws.ToInt64(head.ContentLength) != 7 {
+		t.Errorf("unexpected head output: type %q, metadata %v, length %d", aws.ToString(head.ContentType), head.Metadata, aws.ToInt64(head.ContentLength))
+	}
+
+	if _, err := client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String("/nested//doc"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := get(t, client, "/nested//doc"); statusCode(err) != http.StatusNotFound {
+		t.Errorf("expected 404 after delete, got %v", err)
+	}
+
+	if _, err := client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String("other")}); statusCode(err) != http.StatusNotFound {
+		t.Errorf("expected 404 for a missing bucket, got %v", err)
+	}
+}
+
+func TestConditionalHeaders(t *testing.T) {
+	ctx := t.Context()
+	client, _ := newClient(t)
+
+	output, err := client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(testBucket),
+		Key:         aws.String("doc"),
+		Body:        strings.NewReader("v1"),
+		IfNoneMatch: aws.String("*"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	etag := aws.ToString(output.ETag)
+
+	for _, test := range []struct {
+		name     string
+		err      error
+		expected int
+	}{
+		{
+			name: "create existing",
+			err: func() error {
+				_, err := client.PutObject(ctx, &s3.PutObjectInput{Bucket: aws.String(testBucket), Key: aws.String("doc"), Body: strings.NewReader("v2"), IfNoneMatch: aws.String("*")})
+				return err
+			}(),
+			expected: http.StatusPreconditionFailed,
+		},
+		{
+			name: "update with stale etag",
+			err: func() error {
+				_, err := client.PutObject(ctx, &s3.PutObjectInput{Bucket: aws.String(testBucket), Key: aws.String("doc"), Body: strings.NewReader("v2"), IfMatch: aws.String(`"stale"`)})
+				return err
+			}(),
+			expected: http.StatusPreconditionFailed,
+		},
+		{
+			name: "update missing",
+			err: func() error {
+				_, err := client.PutObject(ctx, &s3.PutObjectInput{Bucket: aws.String(testBucket), Key: aws.String("missing"), Body: strings.NewReader("v2"), IfMatch: aws.String(etag)})
+				return err
+			}(),
+			expected: http.StatusNotFound,
+		},
+		{
+			name: "delete with stale etag",
+			err: func() error {
+				_, err := client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String(testBucket), Key: aws.String("doc"), IfMatch: aws.String(`"stale"`)})
+				return err
+			}(),
+			expected: http.StatusPreconditionFailed,
+		},
+		{
+			name: "get unchanged",
+			err: func() error {
+				_, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(testBucket), Key: aws.String("doc"), IfNoneMatch: aws.String(etag)})
+				return err
+			}(),
+			expected: http.StatusNotModified,
+		},
+		{
+			name: "get changed",
+			err: func() error {
+				_, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(testBucket), Key: aws.String("doc"), IfMatch: aws.String(`"stale"`)})
+				return err
+			}(),
+			expected: http.StatusPreconditionFailed,
+		},
+		{
+			name: "head not modified since",
+			err: func() error {
+				_, err := client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(testBucket), Key: aws.String("doc"), IfModifiedSince: aws.Time(time.Now().Add(time.Hour))})
+				return err
+			}(),
+			expected: http.StatusNotModified,
+		},
+		{
+			name: "update with current etag",
+			err: func() error {
+				_, err := client.PutObject(ctx, &s3.PutObjectInput{Bucket: aws.String(testBucket), Key: aws.String("doc"), Body: strings.NewReader("v2"), IfMatch: aws.String(etag)})
+				return err
+			}(),
+		},
+	} {
+		if status := statusCode(test.err); status != test.expected {
+			t.Errorf("%s: expected status %d, got %v", test.name, test.expected, test.err)
+		}
+	}
+}
+
+func TestWithoutConditionalWrites(t *testing.T) {
+	client, _ := newClient(t, WithoutConditionalWrites())
+
+	_, err := client.PutObject(t.Context(), &s3.PutObjectInput{
+		Bucket:      aws.String(testBucket),
+		Key:         aws.String("doc"),
+		Body:        strings.NewReader("v1"),
+		IfNoneMatch: aws.String("*"),
+	})
+	if statusCode(err) != http.StatusNotImplemented {
+		t.Errorf("expected 501, got %v", err)
+	}
+}
+
+func TestListPagination(t *testing.T) {
+	client, server := newClient(t)
+
+	var expected []string
+	for i := range 25 {
+		key := fmt.Sprintf("dir/%02d", i)
+		put(t, client, key, "x")
+		expected = append(expected, key)
+	}
+	put(t, client, "dir/sub/a", "x")
+	put(t, client, "other", "x")
+
+	var keys, prefixes []string
+	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(testBucket),
+		Prefix:    aws.String("dir/"),
+		Delimiter: aws.String("/"),
+		MaxKeys:   aws.Int32(10),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(t.Context())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, obj := range page.Contents {
+			keys = append(keys, aws.ToString(obj.Key))
+		}
+		for _, prefix := range page.CommonPrefixes {
+			prefixes = append(prefixes, aws.ToString(prefix.Prefix))
+		}
+	}
+
+	if !slices.Equal(keys, expected) {
+		t.Errorf("expected keys %v, got %v", expected, keys)
+	}
+	if !slices.Equal(prefixes, []string{"dir/sub/"}) {
+		t.Errorf("expected common prefix dir/sub/, got %v", prefixes)
+	}
+	if requests := server.Requests(OpListObjectsV2); requests != 3 {
+		t.Errorf("expected 3 pages, got %d", requests)
+	}
+}
+
+func TestInjectError(t *testing.T) {
+	client, server := newClient(t)
+	put(t, client, "doc", "content")
+
+	// The SDK retries a transient error
+	server.InjectError(OpGetObject, 1, ErrSlowDown)
+	if data, err := get(t, client, "doc"); err != nil || data != "content" {
+		t.Errorf("expected a retried read to succeed, got %q, %v", data, err)
+	}
+	if requests := server.Requests(OpGetObject); requests != 2 {
+		t.Errorf("expected 2 requests, got %d", requests)
+	}
+
+	// But not a permanent one
+	server.InjectError(OpGetObject, 1, ErrAccessDenied)
+	if _, err := get(t, client, "doc"); statusCode(err) != http.StatusForbidden {
+		t.Errorf("expected 403, got %v", err)
+	}
+
+	// Errors for other operations do not affect reads
+	server.InjectError(OpPutObject, -1, ErrInternal)
+	if _, err := get(t, client, "doc"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, err := client.PutObject(t.Context(), &s3.PutObjectInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String("doc"),
+		Body:   strings.NewReader("v2"),
+	})
+	if statusCode(err) != http.StatusInternalServerError {
+		t.Errorf("expected 500, got %v", err)
+	}
+
+	server.ClearErrors()
+	put(t, client, "doc", "v2")
+}
+
+func TestLatency(t *testing.T) {
+	const latency = 50 * time.Millisecond
+	client, _ := newClient(t, WithLatency(latency))
+
+	start := time.Now()
+	put(t, client, "doc", "content")
+	if elapsed := time.Since(start); elapsed < latency {
+		t.Errorf("expected a request to take at least %v, took %v", latency, elapsed)
+	}
+}
+
+func TestEventualConsistency(t *testing.T) {
+	const delay = 100 * time.Millisecond
+	client, server := newClient(t, WithEventualConsistency(delay))
+
+	put(t, client, "doc", "v1")
+	if _, err := get(t, client, "doc"); statusCode(err) != http.StatusNotFound {
+		t.Errorf("expected a new object not to be visible yet, got %v", err)
+	}
+	if keys := server.Keys(testBucket); len(keys) != 1 {
+		t.Errorf("expected the write to be stored, got %v", keys)
+	}
+
+	time.Sleep(delay)
+	if data, err := get(t, client, "doc"); err != nil || data != "v1" {
+		t.Errorf("expected v1 once visible, got %q, %v", data, err)
+	}
+
+	put(t, client, "doc", "v2")
+	if data, _ := get(t, client, "doc"); data != "v1" {
+		t.Errorf("expected a stale read of v1, got %q", data)
+	}
+	list, err := client.ListObjectsV2(t.Context(), &s3.ListObjectsV2Input{Bucket: aws.String(testBucket)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Contents) != 1 {
+		t.Errorf("expected 1 listed object, got %d", len(list.Contents))
+	}
+
+	time.Sleep(delay)
+	if data, _ := get(t, client, "doc"); data != "v2" {
+		t.Errorf("expected v2 once visible, got %q", data)
+	}
+}
+
+func TestVersioning(t *testing.T) {
+	ctx := t.Context()
+	client, server := newClient(t, WithVersioning())
+
+	versioning, err := client.GetBucketVersioning(ctx, &s3.GetBucketVersioningInput{Bucket: aws.String(testBucket)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if versioning.Status != "Enabled" {
+		t.Errorf("expected versioning to be enabled, got %q", versioning.Status)
+	}
+
+	put(t, client, "doc", "v1")
+	put(t, client, "doc", "v2")
+	if _, err := client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String(testBucket), Key: aws.String("doc")}); err != nil {
+		t.Fatal(err)
+	}
+	put(t, client, "doc", "v3")
+	put(t, client, "doc2", "other")
+
+	type entry struct {
+		key, id string
+		marker  bool
+	}
+	var entries []entry
+	paginator := s3.NewListObjectVersionsPaginator(client, &s3.ListObjectVersionsInput{
+		Bucket:  aws.String(testBucket),
+		Prefix:  aws.String("doc"),
+		MaxKeys: aws.Int32(2),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, version := range page.Versions {
+			entries = append(entries, entry{aws.ToString(version.Key), aws.ToString(version.VersionId), false})
+		}
+		for _, marker := range page.DeleteMarkers {
+			entries = append(entries, entry{aws.ToString(marker.Key), aws.ToString(marker.VersionId), true})
+		}
+	}
+	slices.SortFunc(entries, func(a, b entry) int { return strings.Compare(a.id, b.id) })
+	expected := []entry{
+		{"doc", "v00000001", false},
+		{"doc", "v00000002", false},
+		{"doc", "v00000003", true},
+		{"doc", "v00000004", false},
+		{"doc2", "v00000005", false},
+	}
+	if !slices.Equal(entries, expected) {
+		t.Errorf("expected versions %v, got %v", expected, entries)
+	}
+	if requests := server.Requests(OpListObjectVersions); requests != 3 {
+		t.Errorf("expected 3 pages, got %d", requests)
+	}
+
+	for _, test := range []struct {
+		id       string
+		expected string
+		status   int
+	}{
+		{id: "v00000001", expected: "v1"},
+		{id: "v00000004", expected: "v3"},
+		{id: "v00000003", status: http.StatusMethodNotAllowed},
+		{id: "v00000005", status: http.StatusNotFound},
+	} {
+		output, err := client.GetObject(ctx, &s3.GetObjectInput{
+			Bucket:    aws.String(testBucket),
+			Key:       aws.String("doc"),
+			VersionId: aws.String(test.id),
+		})
+		if status := statusCode(err); status != test.status {
+			t.Errorf("version %s: expected status %d, got %v", test.id, test.status, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		data, _ := io.ReadAll(output.Body)
+		output.Body.Close()
+		if string(data) != test.expected {
+			t.Errorf("version %s: expected %q, got %q", test.id, test.expected, data)
+		}
+	}
+}
+
+func TestWithoutVersioning(t *testing.T) {
+	client, _ := newClient(t)
+
+	versioning, err := client.GetBucketVersioning(t.Context(), &s3.GetBucketVersioningInput{Bucket: aws.String(testBucket)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if versioning.Status != "" {
+		t.Errorf("expected versioning never to have been enabled, got %q", versioning.Status)
+	}
+}
+
+func TestDeleteObjects(t *testing.T) {
+	client, server := newClient(t)
+	put(t, client, "a", "x")
+	put(t, client, "b", "x")
+	put(t, client, "c", "x")
+	server.InjectKeyError("b", ErrAccessDenied)
+
+	output, err := client.DeleteObjects(t.Context(), &s3.DeleteObjectsInput{
+		Bucket: aws.String(testBucket),
+		Delete: &types.Delete{
+			Objects: []types.ObjectIdentifier{{Key: aws.String("a")}, {Key: aws.String("b")}, {Key: aws.String("missing")}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var deleted []string
+	for _, obj := range output.Deleted {
+		deleted = append(deleted, aws.ToString(obj.Key))
+	}
+	if !slices.Equal(deleted, []string{"a", "missing"}) {
+		t.Errorf("expected a and missing to be deleted, got %v", deleted)
+	}
+	if len(output.Errors) != 1 || aws.ToString(output.Errors[0].Key) != "b" || aws.ToString(output.Errors[0].Code) != "AccessDenied" {
+		t.Errorf("expected b to fail, got %+v", output.Errors)
+	}
+
+	server.ClearErrors()
+	keys := server.Keys(testBucket)
+	slices.Sort(keys)
+	if !slices.Equal(keys, []string{"b", "c"}) {
+		t.Errorf("expected b and c to remain, got %v", keys)
+	}
+}
+
+func TestEncryption(t *testing.T) {
+	ctx := t.Context()
+	client, _ := newClient(t)
+
+	customerKey := func(b byte) (*string, *string) {
+		key := bytes.Repeat([]byte{b}, 32)
+		sum := md5.Sum(key)
+		return aws.String(base64.StdEncoding.EncodeToString(key)), aws.String(base64.StdEncoding.EncodeToString(sum[:]))
+	}
+	key, keyMD5 := customerKey(1)
+	otherKey, otherKeyMD5 := customerKey(2)
+
+	_, err := client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:               aws.String(testBucket),
+		Key:                  aws.String("kms"),
+		Body:                 strings.NewReader("content"),
+		ServerSideEncryption: types.ServerSideEncryptionAwsKms,
+		SSEKMSKeyId:          aws.String("alias/data"),
+		StorageClass:         types.StorageClassStandardIa,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(testBucket), Key: aws.String("kms")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.ServerSideEncryption != types.ServerSideEncryptionAwsKms || aws.ToString(head.SSEKMSKeyId) != "alias/data" || head.StorageClass != types.StorageClassStandardIa {
+		t.Errorf("unexpected encryption %q, key %q and storage class %q", head.ServerSideEncryption, aws.ToString(head.SSEKMSKeyId), head.StorageClass)
+	}
+
+	_, err = client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:               aws.String(testBucket),
+		Key:                  aws.String("sse-c"),
+		Body:                 strings.NewReader("secret"),
+		SSECustomerAlgorithm: aws.String("AES256"),
+		SSECustomerKey:       key,
+		SSECustomerKeyMD5:    keyMD5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name     string
+		key, md5 *string
+		status   int
+	}{
+		{name: "matching key", key: key, md5: keyMD5, status: http.StatusOK},
+		{name: "no key", status: http.StatusBadRequest},
+		{name: "other key", key: otherKey, md5: otherKeyMD5, status: http.StatusForbidden},
+		{name: "mismatched MD5", key: key, md5: otherKeyMD5, status: http.StatusBadRequest},
+	} {
+		input := &s3.GetObjectInput{Bucket: aws.String(testBucket), Key: aws.String("sse-c")}
+		if test.key != nil {
+			input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = aws.String("AES256"), test.key, test.md5
+		}
+		output, err := client.GetObject(ctx, input)
+		switch {
+		case test.status == http.StatusOK && err != nil:
+			t.Errorf("%s: %v", test.name, err)
+		case test.status == http.StatusOK && aws.ToString(output.SSECustomerKeyMD5) != aws.ToString(keyMD5):
+			t.Errorf("%s: expected the key MD5 in the response, got %q", test.name, aws.ToString(output.SSECustomerKeyMD5))
+		case test.status != http.StatusOK && statusCode(err) != test.status:
+			t.Errorf("%s: expected %d, got %v", test.name, test.status, err)
+		}
+	}
+
+	// A key is only accepted for objects written with one
+	_, err = client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:               aws.String(testBucket),
+		Key:                  aws.String("kms"),
+		SSECustomerAlgorithm: aws.String("AES256"),
+		SSECustomerKey:       key,
+		SSECustomerKeyMD5:    keyMD5,
+	})
+	if statusCode(err) != http.StatusBadRequest {
+		t.Errorf("expected 400 for a key with an object without SSE-C, got %v", err)
+	}
+
+	_, err = client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:               aws.String(testBucket),
+		Key:                  aws.String("both"),
+		Body:                 strings.NewReader("content"),
+		ServerSideEncryption: types.ServerSideEncryptionAes256,
+		SSECustomerAlgorithm: aws.String("AES256"),
+		SSECustomerKey:       key,
+		SSECustomerKeyMD5:    keyMD5,
+	})
+	if statusCode(err) != http.StatusBadRequest {
+		t.Errorf("expected 400 for SSE-S3 with SSE-C, got %v", err)
+	}
+}
@@ -0,0 +1,149 @@
+package s3test
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// versioningConfiguration is the body of a GetBucketVersioning response
+type versioningConfiguration struct {
+	XMLName struct{} `xml:"http://s3.amazonaws.com/doc/2006-03-01/ VersioningConfiguration"`
+	Status  string   `xml:"Status,omitempty"`
+}
+
+func (s *Server) getBucketVersioning(w http.ResponseWriter, b *bucket) {
+	var config versioningConfiguration
+	if b.versions != nil {
+		config.Status = "Enabled"
+	}
+	writeXML(w, http.StatusOK, config)
+}
+
+// getObjectVersion serves GetObject and HeadObject for a version ID
+func (s *Server) getObjectVersion(w http.ResponseWriter, r *http.Request, b *bucket, key, versionID string) {
+	version := b.version(key, versionID)
+	switch {
+	case version == nil:
+		writeError(w, r, http.StatusNotFound, "NoSuchVersion", "The specified version does not exist.")
+	case version.deleteMarker:
+		// As in S3, a delete marker cannot be read
+		w.Header().Set("X-Amz-Delete-Marker", "true")
+		w.Header().Set("X-Amz-Version-Id", versionID)
+		writeError(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed against this resource.")
+	default:
+		s.getObject(w, r, version)
+	}
+}
+
+// listVersionsResult is the body of a ListObjectVersions response
+type listVersionsResult struct {
+	XMLName             struct{}       `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListVersionsResult"`
+	Name                string         `xml:"Name"`
+	Prefix              string         `xml:"Prefix"`
+	KeyMarker           string         `xml:"KeyMarker"`
+	VersionIdMarker     string         `xml:"VersionIdMarker"`
+	NextKeyMarker       string         `xml:"NextKeyMarker,omitempty"`
+	NextVersionIdMarker string         `xml:"NextVersionIdMarker,omitempty"`
+	MaxKeys             int            `xml:"MaxKeys"`
+	IsTruncated         bool           `xml:"IsTruncated"`
+	Versions            []listVersion  `xml:"Version"`
+	DeleteMarkers       []deleteMarker `xml:"DeleteMarker"`
+}
+
+type listVersion struct {
+	Key          string `xml:"Key"`
+	VersionID    string `xml:"VersionId"`
+	IsLatest     bool   `xml:"IsLatest"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int    `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type deleteMarker struct {
+	Key          string `xml:"Key"`
+	VersionID    string `xml:"VersionId"`
+	IsLatest     bool   `xml:"IsLatest"`
+	LastModified string `xml:"LastModified"`
+}
+
+// listObjectVersions serves ListObjectVersions, listing keys in lexical order and the versions of each key
+// newest first. Pages hold up to max-keys versions, and continue from the key and version ID markers.
+func (s *Server) listObjectVersions(w http.ResponseWriter, r *http.Request, bucketName string, b *bucket) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	keyMarker, versionMarker := query.Get("key-marker"), query.Get("version-id-marker")
+
+	limit := maxKeys
+	if value := query.Get("max-keys"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			writeError(w, r, http.StatusBadRequest, "InvalidArgument", "max-keys must be a positive integer.")
+			return
+		}
+		limit = min(n, maxKeys)
+	}
+
+	var keys []string
+	for key := range b.versions {
+		if strings.HasPrefix(key, prefix) && key >= keyMarker {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	result := listVersionsResult{
+		Name:            bucketName,
+		Prefix:          prefix,
+		KeyMarker:       keyMarker,
+		VersionIdMarker: versionMarker,
+		MaxKeys:         limit,
+	}
+	count := 0
+	for _, key := range keys {
+		versions := b.versions[key]
+		for i := len(versions) - 1; i >= 0; i-- {
+			version := versions[i]
+			if key == keyMarker {
+				// Skip versions up to and including the marker, or the whole key without a version marker.
+				// Version IDs increase with each write.
+				if versionMarker == "" || version.versionID >= versionMarker {
+					continue
+				}
+			}
+			if count == limit {
+				result.IsTruncated = true
+				writeXML(w, http.StatusOK, result)
+				return
+			}
+			count++
+			result.NextKeyMarker, result.NextVersionIdMarker = key, version.versionID
+
+			modified := version.modified.Format(time.RFC3339Nano)
+			latest := i == len(versions)-1
+			if version.deleteMarker {
+				result.DeleteMarkers = append(result.DeleteMarkers, deleteMarker{
+					Key:          key,
+					VersionID:    version.versionID,
+					IsLatest:     latest,
+					LastModified: modified,
+				})
+				continue
+			}
+			result.Versions = append(result.Versions, listVersion{
+				Key:          key,
+				VersionID:    version.versionID,
+				IsLatest:     latest,
+				LastModified: modified,
+				ETag:         version.etag,
+				Size:         len(version.data),
+				StorageClass: version.encryption.listStorageClass(),
+			})
+		}
+	}
+	result.NextKeyMarker, result.NextVersionIdMarker = "", ""
+	writeXML(w, http.StatusOK, result)
+}
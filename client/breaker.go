@@ -0,0 +1,94 @@
+package client
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+// ErrCircuitOpen is returned without making a request while the circuit breaker is open.
+// It also matches gitbackedrest.ErrUnavailable.
+var ErrCircuitOpen = fmt.Errorf("circuit breaker is open: %w", gitbackedrest.ErrUnavailable)
+
+// WithCircuitBreaker stops sending requests after threshold consecutive failed attempts,
+// failing calls immediately with ErrCircuitOpen. After resetTimeout, a single trial request is allowed:
+// if it succeeds the breaker closes, otherwise it stays open for another resetTimeout.
+//
+// Network errors and retryable statuses (see RetryPolicy) count as failures.
+//
+// WithCircuitBreaker panics if threshold is less than 1 or resetTimeout is not positive.
+func WithCircuitBreaker(threshold int, resetTimeout time.Duration) Option {
+	if threshold < 1 {
+		panic(fmt.Sprintf("client: WithCircuitBreaker threshold must be at least 1, got %d", threshold))
+	}
+	if resetTimeout <= 0 {
+		panic(fmt.Sprintf("client: WithCircuitBreaker resetTimeout must be positive, got %v", resetTimeout))
+	}
+	return func(c *Client) {
+		c.breaker = &circuitBreaker{
+			threshold:    threshold,
+			resetTimeout: resetTimeout,
+		}
+	}
+}
+
+// circuitBreaker tracks consecutive failures across all of a client's requests
+type circuitBreaker struct {
+	threshold    int
+	resetTimeout time.Duration
+
+	mtx      sync.Mutex
+	failures int
+	openedAt time.Time
+	// trial is set while the single request allowed after resetTimeout is in progress
+	trial bool
+}
+
+// allow returns ErrCircuitOpen if a request should not be sent
+func (b *circuitBreaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if b.failures < b.threshold {
+		return nil
+	}
+	if b.trial || time.Since(b.openedAt) < b.resetTimeout {
+		return ErrCircuitOpen
+	}
+	b.trial = true
+	return nil
+}
+
+// record updates the breaker with the outcome of a request
+func (b *circuitBreaker) record(failed bool) {
+	if b == nil {
+		return
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.trial = false
+	if !failed {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openedAt = time.Now()
+	}
+}
+
+// abandon releases a trial request that ended without an outcome, such as when its context was cancelled
+func (b *circuitBreaker) abandon() {
+	if b == nil {
+		return
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.trial = false
+}
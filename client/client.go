@@ -3,122 +3,294 @@ package client
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"mime"
 	"net/http"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
 )
 
+// RequestIDHeader is the header used to propagate request IDs to the server
+const RequestIDHeader = "X-Request-ID"
+
+// propagator injects W3C trace context and baggage into outgoing requests
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
 type Client struct {
 	baseURL    string
 	httpClient *http.Client
+	logger     *slog.Logger
+	retry      RetryPolicy
+	breaker    *circuitBreaker
+	token      string
+}
+
+// Option configures optional behavior of a Client
+type Option func(*Client)
+
+// WithLogger sets the logger used for request logs.
+// If not set, the default slog logger is used.
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *Client) {
+		c.logger = logger
+	}
 }
 
-func New(baseURL string) *Client {
-	return &Client{
-		baseURL:    baseURL,
+// WithHTTPClient sets the HTTP client used to make requests.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithToken sends token as a bearer token with every request, for servers behind an authenticating proxy.
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// New creates a client for the server at baseURL.
+// If the server mounts resources under a prefix, it should be included in baseURL (e.g. http://localhost:8080/v1/resources).
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
 		httpClient: &http.Client{},
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
 }
 
 func NewWithHTTPClient(baseURL string, httpClient *http.Client) *Client {
-	return &Client{
-		baseURL:    baseURL,
-		httpClient: httpClient,
-	}
+	return New(baseURL, WithHTTPClient(httpClient))
 }
 
-func (c *Client) GET(ctx context.Context, path string) ([]byte, error) {
+// log returns the client's logger annotated with the request ID and trace ID from ctx
+func (c *Client) log(ctx context.Context) *slog.Logger {
+	return gitbackedrest.Logger(ctx, c.logger)
+}
+
+// do sends a single request, propagating the request ID from ctx or generating a new one.
+// Each request is recorded as a client span, with its trace context sent in the traceparent header.
+// Any headers in header are added to the request.
+// The caller is responsible for closing the response body.
+func (c *Client) do(ctx context.Context, method, path string, body []byte, header http.Header) (*http.Response, error) {
 	url := c.baseURL + path
 
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	requestID := gitbackedrest.RequestID(ctx)
+	if requestID == "" {
+		requestID = gitbackedrest.NewRequestID()
+		ctx = gitbackedrest.WithRequestID(ctx, requestID)
+	}
+
+	ctx, span := otel.Tracer(gitbackedrest.TracerName).Start(ctx, method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", method),
+			attribute.String("url.full", url),
+			attribute.String("request_id", requestID),
+		),
+	)
+	defer span.End()
+	logger := c.log(ctx)
+
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, bodyReader)
 	if err != nil {
-		return nil, fmt.Errorf("creating GET request: %w", err)
+		return nil, fmt.Errorf("creating %s request: %w", method, err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
 	}
+	req.Header.Set(RequestIDHeader, requestID)
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if key := IdempotencyKey(ctx); key != "" && method == http.MethodPost {
+		req.Header.Set(IdempotencyKeyHeader, key)
+	}
+	propagator.Inject(ctx, propagation.HeaderCarrier(req.Header))
 
+	start := time.Now()
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("executing GET request: %w", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		logger.WarnContext(ctx, "request failed", "method", method, "url", url, "duration", time.Since(start), "error", err)
+		return nil, fmt.Errorf("executing %s request: %w", method, err)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("GET request failed with status %d: %s", resp.StatusCode, string(body))
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(resp.StatusCode))
 	}
 
-	return io.ReadAll(resp.Body)
+	logger.DebugContext(ctx, "request completed", "method", method, "url", url, "status", resp.StatusCode, "duration", time.Since(start))
+	return resp, nil
 }
 
-func (c *Client) POST(ctx context.Context, path string, body []byte) error {
-	url := c.baseURL + path
+// responseError decodes the problem details in a failed response.
+// The returned error matches the sentinel errors in gitbackedrest for its status, such as gitbackedrest.ErrNotFound,
+// and can be inspected with errors.As for a *gitbackedrest.Problem.
+func responseError(resp *http.Response) error {
+	body, _ := io.ReadAll(resp.Body)
 
-	log.Printf("Client: POST %s", url)
+	var problem gitbackedrest.Problem
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != gitbackedrest.ProblemContentType || json.Unmarshal(body, &problem) != nil || problem.Status == 0 {
+		// Not a problem response, e.g. from a proxy in front of the server
+		problem = *gitbackedrest.NewProblem(resp.StatusCode, strings.TrimSpace(string(body)))
+		problem.RequestID = resp.Header.Get(RequestIDHeader)
+	}
+	return gitbackedrest.NewUserError(problem.Detail, &problem)
+}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
-	if err != nil {
-		return fmt.Errorf("creating POST request: %w", err)
+// response is the result of a successful call
+type response struct {
+	data    []byte
+	header  http.Header
+	retries int
+}
+
+// call sends a request, retrying according to the client's retry policy, and returns the response body,
+// headers and the number of retries made. A response with a status other than expectedStatus is an error.
+func (c *Client) call(ctx context.Context, method, path string, body []byte, header http.Header, expectedStatus int) (*response, error) {
+	// Retries share a request ID, so they can be correlated in server logs
+	if gitbackedrest.RequestID(ctx) == "" {
+		ctx = gitbackedrest.WithRequestID(ctx, gitbackedrest.NewRequestID())
 	}
 
-	log.Printf("Client: Sending POST request to %s", url)
-	resp, err := c.httpClient.Do(req)
+	result := &response{}
+	retries, err := c.withRetries(ctx, method, c.baseURL+path, func() *attemptError {
+		if err := c.breaker.allow(); err != nil {
+			return &attemptError{err: fmt.Errorf("%s request not sent: %w", method, err)}
+		}
+
+		resp, err := c.do(ctx, method, path, body, header)
+		if err != nil {
+			if ctx.Err() != nil {
+				c.breaker.abandon()
+				return &attemptError{err: err}
+			}
+			c.breaker.record(true)
+			return &attemptError{err: err, retryable: true}
+		}
+		defer resp.Body.Close()
+
+		retryable := retryableStatus(resp.StatusCode)
+		c.breaker.record(retryable)
+		if resp.StatusCode != expectedStatus {
+			return &attemptError{
+				err:        fmt.Errorf("%s request failed: %w", method, responseError(resp)),
+				retryable:  retryable,
+				retryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+			}
+		}
+
+		result.header = resp.Header
+		result.data, err = io.ReadAll(resp.Body)
+		if err != nil {
+			return &attemptError{err: fmt.Errorf("reading %s response: %w", method, err), retryable: true}
+		}
+		return nil
+	})
 	if err != nil {
-		log.Printf("Client: POST request failed: %v", err)
-		return fmt.Errorf("executing POST request: %w", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
+	result.retries = retries
+	return result, nil
+}
 
-	log.Printf("Client: POST response status: %d", resp.StatusCode)
-	if resp.StatusCode != http.StatusCreated {
-		body, _ := io.ReadAll(resp.Body)
-		log.Printf("Client: POST response body: %s", string(body))
-		return fmt.Errorf("POST request failed with status %d: %s", resp.StatusCode, string(body))
+func (c *Client) GET(ctx context.Context, path string) ([]byte, error) {
+	result, err := c.GetResult(ctx, path)
+	if err != nil {
+		return nil, err
 	}
-
-	log.Printf("Client: POST successful")
-	return nil
+	return result.Data, nil
 }
 
-func (c *Client) PUT(ctx context.Context, path string, body []byte) error {
-	url := c.baseURL + path
-
-	req, err := http.NewRequestWithContext(ctx, "PUT", url, bytes.NewReader(body))
+// GetResult is like GET, also returning the number of retries made.
+func (c *Client) GetResult(ctx context.Context, path string) (*gitbackedrest.GetResult, error) {
+	resp, err := c.call(ctx, http.MethodGet, path, nil, nil, http.StatusOK)
 	if err != nil {
-		return fmt.Errorf("creating PUT request: %w", err)
+		return nil, err
 	}
+	return &gitbackedrest.GetResult{Data: resp.data, Retries: resp.retries}, nil
+}
 
-	resp, err := c.httpClient.Do(req)
+func (c *Client) POST(ctx context.Context, path string, body []byte) error {
+	_, err := c.PostResult(ctx, path, body)
+	return err
+}
+
+// PostResult is like POST, also returning the number of retries made.
+// POST is only retried if ctx carries an idempotency key.
+func (c *Client) PostResult(ctx context.Context, path string, body []byte) (*gitbackedrest.Result, error) {
+	resp, err := c.call(ctx, http.MethodPost, path, body, nil, http.StatusCreated)
 	if err != nil {
-		return fmt.Errorf("executing PUT request: %w", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
+	return &gitbackedrest.Result{Retries: resp.retries}, nil
+}
 
-	if resp.StatusCode != http.StatusNoContent {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("PUT request failed with status %d: %s", resp.StatusCode, string(body))
-	}
+func (c *Client) PUT(ctx context.Context, path string, body []byte) error {
+	_, err := c.PutResult(ctx, path, body)
+	return err
+}
 
-	return nil
+// PutResult is like PUT, also returning the number of retries made.
+func (c *Client) PutResult(ctx context.Context, path string, body []byte) (*gitbackedrest.Result, error) {
+	resp, err := c.call(ctx, http.MethodPut, path, body, nil, http.StatusNoContent)
+	if err != nil {
+		return nil, err
+	}
+	return &gitbackedrest.Result{Retries: resp.retries}, nil
 }
 
 func (c *Client) DELETE(ctx context.Context, path string) error {
-	url := c.baseURL + path
+	_, err := c.DeleteResult(ctx, path)
+	return err
+}
 
-	req, err := http.NewRequestWithContext(ctx, "DELETE", url, nil)
+// DeleteResult is like DELETE, also returning the number of retries made.
+func (c *Client) DeleteResult(ctx context.Context, path string) (*gitbackedrest.Result, error) {
+	resp, err := c.call(ctx, http.MethodDelete, path, nil, nil, http.StatusNoContent)
 	if err != nil {
-		return fmt.Errorf("creating DELETE request: %w", err)
+		return nil, err
 	}
+	return &gitbackedrest.Result{Retries: resp.retries}, nil
+}
 
-	resp, err := c.httpClient.Do(req)
+// List returns the paths of the resources under a collection path, such as "/users/".
+// A trailing slash is added to path if it has none. Paths are listed at any depth.
+func (c *Client) List(ctx context.Context, path string) ([]string, error) {
+	if !strings.HasSuffix(path, "/") {
+		path += "/"
+	}
+	resp, err := c.call(ctx, http.MethodGet, path, nil, nil, http.StatusOK)
 	if err != nil {
-		return fmt.Errorf("executing DELETE request: %w", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusNoContent {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("DELETE request failed with status %d: %s", resp.StatusCode, string(body))
+	var listing gitbackedrest.Listing
+	if err := json.Unmarshal(resp.data, &listing); err != nil {
+		return nil, fmt.Errorf("decoding listing: %w", err)
 	}
-
-	return nil
+	return listing.Paths, nil
 }
@@ -3,12 +3,14 @@ package client
 import (
 	"bytes"
 	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
 	"time"
 
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
 	"github.com/theothertomelliott/git-backed-rest/backends/memory"
 	"github.com/theothertomelliott/git-backed-rest/server"
 )
@@ -85,14 +87,14 @@ func TestClientErrorHandling(t *testing.T) {
 
 	// Test GET non-existent resource
 	_, err := client.GET(ctx, "/nonexistent")
-	if err == nil {
-		t.Error("GET non-existent resource should have failed")
+	if !errors.Is(err, gitbackedrest.ErrNotFound) {
+		t.Errorf("GET non-existent resource should have failed with ErrNotFound, got %v", err)
 	}
 
 	// Test PUT non-existent resource
 	err = client.PUT(ctx, "/nonexistent", []byte("data"))
-	if err == nil {
-		t.Error("PUT non-existent resource should have failed")
+	if !errors.Is(err, gitbackedrest.ErrNotFound) {
+		t.Errorf("PUT non-existent resource should have failed with ErrNotFound, got %v", err)
 	}
 
 	// Test POST to existing resource
@@ -104,14 +106,45 @@ func TestClientErrorHandling(t *testing.T) {
 
 	// Try POST again to same resource (should conflict)
 	err = client.POST(ctx, "/conflict", testData)
-	if err == nil {
-		t.Error("POST to existing resource should have failed")
+	if !errors.Is(err, gitbackedrest.ErrConflict) {
+		t.Errorf("POST to existing resource should have failed with ErrConflict, got %v", err)
 	}
 
 	// Test DELETE non-existent resource
 	err = client.DELETE(ctx, "/nonexistent")
-	if err == nil {
-		t.Error("DELETE non-existent resource should have failed")
+	if !errors.Is(err, gitbackedrest.ErrNotFound) {
+		t.Errorf("DELETE non-existent resource should have failed with ErrNotFound, got %v", err)
+	}
+}
+
+func TestClientProblem(t *testing.T) {
+	srv := server.New(memory.NewBackend())
+	httpServer := httptest.NewServer(srv)
+	defer httpServer.Close()
+
+	ctx := gitbackedrest.WithRequestID(context.Background(), "problem-request")
+	_, err := New(httpServer.URL).GET(ctx, "/missing")
+
+	var problem *gitbackedrest.Problem
+	if !errors.As(err, &problem) {
+		t.Fatalf("expected a problem, got %v", err)
+	}
+	if problem.Status != http.StatusNotFound || problem.RequestID != "problem-request" || problem.Instance != "/missing" {
+		t.Errorf("unexpected problem: %+v", problem)
+	}
+	if message := gitbackedrest.GetUserMessage(err); message != problem.Detail {
+		t.Errorf("expected user message %q, got %q", problem.Detail, message)
+	}
+
+	// Errors not from the server, such as from a proxy, are mapped by status
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "upstream unavailable", http.StatusServiceUnavailable)
+	}))
+	defer proxy.Close()
+
+	_, err = New(proxy.URL).GET(ctx, "/missing")
+	if !errors.Is(err, gitbackedrest.ErrUnavailable) || !strings.Contains(err.Error(), "upstream unavailable") {
+		t.Errorf("expected ErrUnavailable with the response body, got %v", err)
 	}
 }
 
@@ -142,3 +175,47 @@ func TestClientWithContext(t *testing.T) {
 		t.Errorf("Error should mention context: %v", err)
 	}
 }
+
+func TestClientRequestID(t *testing.T) {
+	var received string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get(RequestIDHeader)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := New(server.URL)
+
+	ctx := gitbackedrest.WithRequestID(context.Background(), "request-1")
+	if _, err := client.GET(ctx, "/doc1"); err != nil {
+		t.Fatal(err)
+	}
+	if received != "request-1" {
+		t.Errorf("expected request ID from context, got %q", received)
+	}
+
+	if _, err := client.GET(context.Background(), "/doc1"); err != nil {
+		t.Fatal(err)
+	}
+	if received == "" || received == "request-1" {
+		t.Errorf("expected a generated request ID, got %q", received)
+	}
+}
+
+func TestClientResourcePrefix(t *testing.T) {
+	backend := memory.NewBackend()
+	srv := server.New(backend, server.WithResourcePrefix("/v1/resources/"))
+
+	httpServer := httptest.NewServer(srv)
+	defer httpServer.Close()
+
+	client := New(httpServer.URL + "/v1/resources/")
+	ctx := context.Background()
+
+	if err := client.POST(ctx, "/test/resource", []byte("data")); err != nil {
+		t.Fatalf("POST failed: %v", err)
+	}
+	if _, err := backend.GET(ctx, "/test/resource"); err != nil {
+		t.Errorf("expected resource to be stored relative to the prefix: %v", err)
+	}
+}
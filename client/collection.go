@@ -0,0 +1,326 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+// idPlaceholder marks where a document's ID appears in a collection's path template
+const idPlaceholder = "{id}"
+
+// DefaultMaxAttempts is the number of times UpdateFunc and UpsertFunc try to write a document
+// before giving up on concurrent modifications, if not set with WithMaxAttempts.
+const DefaultMaxAttempts = 10
+
+// Document is a value stored in a Collection, with the ETag identifying the version that was read or written
+type Document[T any] struct {
+	ID    string
+	Value T
+	// ETag is the entity tag of the stored content. Updating a document with an ETag
+	// fails with gitbackedrest.ErrPreconditionFailed if the document has changed since.
+	ETag string
+}
+
+// Collection stores values of type T as documents at paths generated from a template,
+// such as "/users/{id}.json", encoding them with a Codec.
+type Collection[T any] struct {
+	client *Client
+	// prefix and suffix surround the ID in document paths
+	prefix, suffix string
+	codec          Codec
+	maxAttempts    int
+}
+
+// collectionConfig holds the settings applied by CollectionOptions
+type collectionConfig struct {
+	codec       Codec
+	maxAttempts int
+}
+
+// CollectionOption configures optional behavior of a Collection
+type CollectionOption func(*collectionConfig)
+
+// WithCodec sets the codec used to encode documents. If not set, JSONCodec is used.
+func WithCodec(codec Codec) CollectionOption {
+	return func(cfg *collectionConfig) {
+		cfg.codec = codec
+	}
+}
+
+// WithMaxAttempts sets how many times UpdateFunc and UpsertFunc try to write a document
+// that is being modified concurrently. If not set, DefaultMaxAttempts is used.
+func WithMaxAttempts(attempts int) CollectionOption {
+	return func(cfg *collectionConfig) {
+		cfg.maxAttempts = attempts
+	}
+}
+
+// NewCollection creates a collection of documents stored through c at paths generated from template.
+// The template must begin with "/" and contain "{id}" exactly once, e.g. "/users/{id}" or "/tenants/acme/users/{id}/profile.yaml".
+func NewCollection[T any](c *Client, template string, opts ...CollectionOption) (*Collection[T], error) {
+	if !strings.HasPrefix(template, "/") {
+		return nil, fmt.Errorf("path template %q must begin with /", template)
+	}
+	if strings.Count(template, idPlaceholder) != 1 {
+		return nil, fmt.Errorf("path template %q must contain %s exactly once", template, idPlaceholder)
+	}
+	prefix, suffix, _ := strings.Cut(template, idPlaceholder)
+
+	cfg := collectionConfig{
+		codec:       JSONCodec,
+		maxAttempts: DefaultMaxAttempts,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.maxAttempts < 1 {
+		return nil, fmt.Errorf("max attempts must be at least 1, got %d", cfg.maxAttempts)
+	}
+
+	return &Collection[T]{
+		client:      c,
+		prefix:      prefix,
+		suffix:      suffix,
+		codec:       cfg.codec,
+		maxAttempts: cfg.maxAttempts,
+	}, nil
+}
+
+// Path returns the resource path of the document with the given ID
+func (c *Collection[T]) Path(id string) string {
+	return c.prefix + id + c.suffix
+}
+
+// requestPath returns the escaped path used to request the document with the given ID
+func (c *Collection[T]) requestPath(id string) (string, error) {
+	if id == "" || strings.Contains(id, "/") {
+		return "", fmt.Errorf("invalid document ID %q: must be non-empty and not contain /", id)
+	}
+	return c.prefix + url.PathEscape(id) + c.suffix, nil
+}
+
+// idFromPath returns the document ID for a resource path, or false if the path is not a document in the collection
+func (c *Collection[T]) idFromPath(path string) (string, bool) {
+	if len(path) <= len(c.prefix)+len(c.suffix) || !strings.HasPrefix(path, c.prefix) || !strings.HasSuffix(path, c.suffix) {
+		return "", false
+	}
+	id := path[len(c.prefix) : len(path)-len(c.suffix)]
+	return id, !strings.Contains(id, "/")
+}
+
+// Get reads the document with the given ID.
+// A document that does not exist returns an error matching gitbackedrest.ErrNotFound.
+func (c *Collection[T]) Get(ctx context.Context, id string) (*Document[T], error) {
+	path, err := c.requestPath(id)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.call(ctx, http.MethodGet, path, nil, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &Document[T]{ID: id, ETag: etag(resp)}
+	if err := c.codec.Unmarshal(resp.data, &doc.Value); err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", c.Path(id), err)
+	}
+	return doc, nil
+}
+
+// Create stores a new document.
+// If a document with the ID already exists, it returns an error matching gitbackedrest.ErrConflict.
+func (c *Collection[T]) Create(ctx context.Context, id string, value T) (*Document[T], error) {
+	path, err := c.requestPath(id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := c.codec.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("encoding %s: %w", c.Path(id), err)
+	}
+
+	resp, err := c.client.call(ctx, http.MethodPost, path, data, nil, http.StatusCreated)
+	if err != nil {
+		return nil, err
+	}
+	return &Document[T]{ID: id, Value: value, ETag: etagOr(resp, data)}, nil
+}
+
+// Update replaces an existing document with doc.Value, returning the document as written.
+// If doc.ETag is set, the write is conditional: it fails with an error matching
+// gitbackedrest.ErrPreconditionFailed if the document has changed since doc was read.
+func (c *Collection[T]) Update(ctx context.Context, doc *Document[T]) (*Document[T], error) {
+	path, err := c.requestPath(doc.ID)
+	if err != nil {
+		return nil, err
+	}
+	data, err := c.codec.Marshal(doc.Value)
+	if err != nil {
+		return nil, fmt.Errorf("encoding %s: %w", c.Path(doc.ID), err)
+	}
+
+	var header http.Header
+	if doc.ETag != "" {
+		header = http.Header{"If-Match": {doc.ETag}}
+	}
+	resp, err := c.client.call(ctx, http.MethodPut, path, data, header, http.StatusNoContent)
+	if err != nil {
+		return nil, err
+	}
+	return &Document[T]{ID: doc.ID, Value: doc.Value, ETag: etagOr(resp, data)}, nil
+}
+
+// Delete removes the document with the given ID.
+// A document that does not exist returns an error matching gitbackedrest.ErrNotFound.
+func (c *Collection[T]) Delete(ctx context.Context, id string) error {
+	path, err := c.requestPath(id)
+	if err != nil {
+		return err
+	}
+	_, err = c.client.call(ctx, http.MethodDelete, path, nil, nil, http.StatusNoContent)
+	return err
+}
+
+// IDs returns the sorted IDs of the documents in the collection.
+// Resources under the collection's path that do not match its template are ignored.
+func (c *Collection[T]) IDs(ctx context.Context) ([]string, error) {
+	// The listing covers the deepest directory containing every document
+	dir := c.prefix[:strings.LastIndex(c.prefix, "/")+1]
+	paths, err := c.client.List(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, path := range paths {
+		if id, ok := c.idFromPath(path); ok {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+// List reads every document in the collection, ordered by ID.
+// Each document is read with a separate request, so the result is not a consistent snapshot.
+// Documents deleted while listing are omitted.
+func (c *Collection[T]) List(ctx context.Context) ([]*Document[T], error) {
+	ids, err := c.IDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	docs := make([]*Document[T], 0, len(ids))
+	for _, id := range ids {
+		doc, err := c.Get(ctx, id)
+		if errors.Is(err, gitbackedrest.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+// UpdateFunc applies fn to the current value of an existing document and writes the result,
+// conditional on the document not having changed since it was read. If it has, the document is
+// read again and fn reapplied, up to the collection's maximum number of attempts, so fn may be
+// called more than once and should not have side effects.
+//
+// An error from fn is returned without writing the document. If the document is deleted before it is
+// written, ErrNotFound is returned rather than recreating it.
+func (c *Collection[T]) UpdateFunc(ctx context.Context, id string, fn func(*T) error) (*Document[T], error) {
+	doc, err := c.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return c.modify(ctx, doc, fn, false)
+}
+
+// UpsertFunc is like UpdateFunc, but if the document does not exist, fn is applied to
+// the zero value of T and the result is created.
+func (c *Collection[T]) UpsertFunc(ctx context.Context, id string, fn func(*T) error) (*Document[T], error) {
+	doc, err := c.Get(ctx, id)
+	if errors.Is(err, gitbackedrest.ErrNotFound) {
+		// Missing documents are created, and have no tag to match
+		doc, err = &Document[T]{ID: id}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c.modify(ctx, doc, fn, true)
+}
+
+// modify applies fn to doc and writes the result, retrying with the latest version of the
+// document if it was modified concurrently. A doc without an ETag is created. A document deleted
+// concurrently is only recreated if upsert is set, otherwise ErrNotFound is returned.
+func (c *Collection[T]) modify(ctx context.Context, doc *Document[T], fn func(*T) error, upsert bool) (*Document[T], error) {
+	for attempt := 1; ; attempt++ {
+		if err := fn(&doc.Value); err != nil {
+			return nil, err
+		}
+
+		var written *Document[T]
+		var err error
+		if doc.ETag == "" {
+			written, err = c.Create(ctx, doc.ID, doc.Value)
+		} else {
+			written, err = c.Update(ctx, doc)
+		}
+		if err == nil {
+			return written, nil
+		}
+		if !errors.Is(err, gitbackedrest.ErrPreconditionFailed) && !errors.Is(err, gitbackedrest.ErrConflict) {
+			return nil, err
+		}
+
+		// The document changed, or a retried request failed because an earlier attempt had already
+		// made the write, which is assumed if the stored document is exactly what was written
+		var retryErr *RetryError
+		retried := errors.As(err, &retryErr)
+		current, getErr := c.Get(ctx, doc.ID)
+		switch {
+		case errors.Is(getErr, gitbackedrest.ErrNotFound) && !upsert:
+			return nil, getErr
+		case errors.Is(getErr, gitbackedrest.ErrNotFound):
+			current = &Document[T]{ID: doc.ID}
+		case getErr != nil:
+			return nil, getErr
+		case retried && c.wrote(current, doc.Value):
+			return current, nil
+		}
+		if attempt >= c.maxAttempts {
+			return nil, fmt.Errorf("writing %s: giving up after %d attempts: %w", c.Path(doc.ID), attempt, err)
+		}
+		c.client.log(ctx).DebugContext(ctx, "document modified concurrently, retrying", "path", c.Path(doc.ID), "attempt", attempt)
+		doc = current
+	}
+}
+
+// wrote reports whether the stored document is exactly the encoding of value
+func (c *Collection[T]) wrote(current *Document[T], value T) bool {
+	data, err := c.codec.Marshal(value)
+	return err == nil && current.ETag == gitbackedrest.ETag(data)
+}
+
+// etag returns the ETag of a response
+func etag(resp *response) string {
+	return etagOr(resp, resp.data)
+}
+
+// etagOr returns the ETag of a response, or the tag of data for servers that do not report one
+func etagOr(resp *response, data []byte) string {
+	if tag := resp.header.Get("ETag"); tag != "" {
+		return tag
+	}
+	return gitbackedrest.ETag(data)
+}
@@ -0,0 +1,322 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync/atomic"
+	"testing"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+	"github.com/theothertomelliott/git-backed-rest/backends/memory"
+	"github.com/theothertomelliott/git-backed-rest/server"
+)
+
+type user struct {
+	Name  string `json:"name" yaml:"name"`
+	Count int    `json:"count" yaml:"count"`
+}
+
+// newTestCollection creates a collection backed by a server with a memory backend
+func newTestCollection(t *testing.T, template string, opts ...CollectionOption) (*Collection[user], *memory.Backend) {
+	t.Helper()
+
+	backend := memory.NewBackend()
+	httpServer := httptest.NewServer(server.New(backend))
+	t.Cleanup(httpServer.Close)
+
+	users, err := NewCollection[user](New(httpServer.URL), template, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return users, backend
+}
+
+func TestCollection(t *testing.T) {
+	ctx := context.Background()
+	users, backend := newTestCollection(t, "/users/{id}.json")
+
+	created, err := users.Create(ctx, "alice", user{Name: "Alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.Create(ctx, "alice", user{Name: "Alice"}); !errors.Is(err, gitbackedrest.ErrConflict) {
+		t.Errorf("expected conflict creating an existing document, got %v", err)
+	}
+	if _, err := users.Create(ctx, "bob smith", user{Name: "Bob"}); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := backend.GET(ctx, "/users/bob smith.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(stored.Data) != `{"name":"Bob","count":0}` {
+		t.Errorf("unexpected stored content: %s", stored.Data)
+	}
+
+	doc, err := users.Get(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Value.Name != "Alice" || doc.ETag != created.ETag {
+		t.Errorf("expected %+v, got %+v", created, doc)
+	}
+
+	doc.Value.Count = 1
+	updated, err := users.Update(ctx, doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.ETag == doc.ETag {
+		t.Error("expected the ETag to change after an update")
+	}
+	// doc still carries the tag from before the update
+	if _, err := users.Update(ctx, doc); !errors.Is(err, gitbackedrest.ErrPreconditionFailed) {
+		t.Errorf("expected precondition failure updating a stale document, got %v", err)
+	}
+
+	// Resources that don't match the template are not documents
+	if _, err := backend.POST(ctx, "/users/readme.txt", []byte("not a user")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.POST(ctx, "/users/archive/carol.json", []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	ids, err := users.IDs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"alice", "bob smith"}; !slices.Equal(ids, expected) {
+		t.Errorf("expected IDs %v, got %v", expected, ids)
+	}
+
+	docs, err := users.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 2 || docs[0].Value != (user{Name: "Alice", Count: 1}) || docs[1].Value != (user{Name: "Bob"}) {
+		t.Errorf("unexpected documents: %+v", docs)
+	}
+
+	if err := users.Delete(ctx, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.Get(ctx, "alice"); !errors.Is(err, gitbackedrest.ErrNotFound) {
+		t.Errorf("expected not found after delete, got %v", err)
+	}
+	if _, err := users.Get(ctx, "a/b"); err == nil {
+		t.Error("expected an error for an ID containing /")
+	}
+}
+
+func TestCollectionUpdateFunc(t *testing.T) {
+	ctx := context.Background()
+	users, _ := newTestCollection(t, "/users/{id}")
+
+	if _, err := users.Create(ctx, "alice", user{Name: "Alice"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another writer increments the count between the first read and write
+	calls := 0
+	doc, err := users.UpdateFunc(ctx, "alice", func(u *user) error {
+		calls++
+		if calls == 1 {
+			if _, err := users.UpdateFunc(ctx, "alice", func(u *user) error {
+				u.Count++
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		u.Count++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Errorf("expected fn to be retried once, got %d calls", calls)
+	}
+	if doc.Value.Count != 2 {
+		t.Errorf("expected both increments to be applied, got %d", doc.Value.Count)
+	}
+
+	errAbort := errors.New("abort")
+	if _, err := users.UpdateFunc(ctx, "alice", func(u *user) error { return errAbort }); !errors.Is(err, errAbort) {
+		t.Errorf("expected the error from fn, got %v", err)
+	}
+	if _, err := users.UpdateFunc(ctx, "bob", func(u *user) error { return nil }); !errors.Is(err, gitbackedrest.ErrNotFound) {
+		t.Errorf("expected not found updating a missing document, got %v", err)
+	}
+}
+
+func TestCollectionUpdateFuncGivesUp(t *testing.T) {
+	ctx := context.Background()
+	users, _ := newTestCollection(t, "/users/{id}", WithMaxAttempts(3))
+
+	if _, err := users.Create(ctx, "alice", user{Name: "Alice"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every attempt is preceded by a conflicting write
+	calls := 0
+	_, err := users.UpdateFunc(ctx, "alice", func(u *user) error {
+		calls++
+		current, err := users.Get(ctx, "alice")
+		if err != nil {
+			return err
+		}
+		current.Value.Count += 10
+		if _, err := users.Update(ctx, current); err != nil {
+			return err
+		}
+		u.Count++
+		return nil
+	})
+	if !errors.Is(err, gitbackedrest.ErrPreconditionFailed) {
+		t.Errorf("expected precondition failure after exhausting attempts, got %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("expected 3 attempts, got %d", calls)
+	}
+}
+
+func TestCollectionUpdateFuncDeleted(t *testing.T) {
+	ctx := context.Background()
+	users, _ := newTestCollection(t, "/users/{id}")
+
+	for _, upsert := range []bool{false, true} {
+		if _, err := users.Create(ctx, "alice", user{Name: "Alice"}); err != nil {
+			t.Fatal(err)
+		}
+
+		// Another writer deletes the document between the first read and write
+		calls := 0
+		fn := func(u *user) error {
+			calls++
+			if calls == 1 {
+				if err := users.Delete(ctx, "alice"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			u.Count++
+			return nil
+		}
+
+		if !upsert {
+			if _, err := users.UpdateFunc(ctx, "alice", fn); !errors.Is(err, gitbackedrest.ErrNotFound) {
+				t.Errorf("expected not found updating a deleted document, got %v", err)
+			}
+			if _, err := users.Get(ctx, "alice"); !errors.Is(err, gitbackedrest.ErrNotFound) {
+				t.Errorf("expected the deleted document not to be recreated, got %v", err)
+			}
+			continue
+		}
+
+		doc, err := users.UpsertFunc(ctx, "alice", fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if doc.Value.Count != 1 || doc.Value.Name != "" {
+			t.Errorf("expected the document to be recreated from the zero value, got %+v", doc.Value)
+		}
+	}
+}
+
+func TestCollectionUpsertFunc(t *testing.T) {
+	ctx := context.Background()
+	users, _ := newTestCollection(t, "/users/{id}")
+
+	increment := func(u *user) error {
+		u.Count++
+		return nil
+	}
+	for expected := 1; expected <= 2; expected++ {
+		doc, err := users.UpsertFunc(ctx, "alice", increment)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if doc.Value.Count != expected {
+			t.Errorf("expected count %d, got %d", expected, doc.Value.Count)
+		}
+	}
+}
+
+// TestCollectionUpdateFuncRetriedWrite checks that a write retried after its first attempt
+// succeeded is recognized, rather than failing or applying fn twice.
+func TestCollectionUpdateFuncRetriedWrite(t *testing.T) {
+	ctx := context.Background()
+	srv := server.New(memory.NewBackend())
+
+	// The first PUT is applied, but its response is lost
+	var puts atomic.Int32
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut && puts.Add(1) == 1 {
+			srv.ServeHTTP(httptest.NewRecorder(), r)
+			http.Error(w, "gateway timeout", http.StatusGatewayTimeout)
+			return
+		}
+		srv.ServeHTTP(w, r)
+	}))
+	defer httpServer.Close()
+
+	users, err := NewCollection[user](New(httpServer.URL, WithRetryPolicy(fastRetries)), "/users/{id}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.Create(ctx, "alice", user{Name: "Alice"}); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := 0
+	doc, err := users.UpdateFunc(ctx, "alice", func(u *user) error {
+		calls++
+		u.Count++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 || doc.Value.Count != 1 {
+		t.Errorf("expected a single increment, got %d calls and count %d", calls, doc.Value.Count)
+	}
+}
+
+func TestCollectionYAML(t *testing.T) {
+	ctx := context.Background()
+	users, backend := newTestCollection(t, "/users/{id}.yaml", WithCodec(YAMLCodec))
+
+	if _, err := users.Create(ctx, "alice", user{Name: "Alice", Count: 3}); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := backend.GET(ctx, "/users/alice.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(stored.Data) != "name: Alice\ncount: 3\n" {
+		t.Errorf("unexpected stored content: %q", stored.Data)
+	}
+
+	doc, err := users.Get(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Value != (user{Name: "Alice", Count: 3}) {
+		t.Errorf("unexpected value: %+v", doc.Value)
+	}
+}
+
+func TestNewCollectionErrors(t *testing.T) {
+	c := New("http://localhost")
+	for _, template := range []string{"users/{id}", "/users/", "/users/{id}/{id}"} {
+		if _, err := NewCollection[user](c, template); err == nil {
+			t.Errorf("expected an error for template %q", template)
+		}
+	}
+	if _, err := NewCollection[user](c, "/users/{id}", WithMaxAttempts(0)); err == nil {
+		t.Error("expected an error for zero max attempts")
+	}
+}
@@ -0,0 +1,91 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+// History returns the versions of the resource at path, newest first.
+// Servers whose backend keeps no history return an error with status 501 Not Implemented.
+func (c *Client) History(ctx context.Context, path string) ([]gitbackedrest.Version, error) {
+	resp, err := c.call(ctx, http.MethodGet, path+"?history", nil, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+
+	var history struct {
+		Versions []gitbackedrest.Version `json:"versions"`
+	}
+	if err := json.Unmarshal(resp.data, &history); err != nil {
+		return nil, fmt.Errorf("decoding history: %w", err)
+	}
+	return history.Versions, nil
+}
+
+// GetVersion returns the content of the resource at path as of a version returned by History.
+func (c *Client) GetVersion(ctx context.Context, path, version string) ([]byte, error) {
+	resp, err := c.call(ctx, http.MethodGet, path+"?version="+url.QueryEscape(version), nil, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	return resp.data, nil
+}
+
+// Restore writes the content of the resource at path as of a version returned by History as its current content,
+// recreating it if it has been deleted since.
+func (c *Client) Restore(ctx context.Context, path, version string) error {
+	_, err := c.call(ctx, http.MethodPut, path+"?restore="+url.QueryEscape(version), nil, nil, http.StatusNoContent)
+	return err
+}
+
+// systemPath is where the server's system endpoints are served, as server.SystemPrefix
+const systemPath = "/_system"
+
+// WatchResult is a batch of changes returned by Watch
+type WatchResult struct {
+	Events []gitbackedrest.Event `json:"events"`
+	// Version is the resume token to pass to the next call to Watch
+	Version string `json:"version"`
+}
+
+// Watch waits up to timeout for changes to resources under prefix after the version since,
+// returning them with a new resume token. If since is empty, it waits for the next change.
+// A result with no events means the timeout expired, and the watch can be resumed from its version.
+//
+// Watches are served from the server's system endpoints, which are assumed to be at the root of the base URL's host.
+func (c *Client) Watch(ctx context.Context, prefix, since string, timeout time.Duration) (*WatchResult, error) {
+	query := url.Values{"prefix": {prefix}}
+	if since != "" {
+		query.Set("since", since)
+	}
+	if timeout > 0 {
+		query.Set("timeout", timeout.String())
+	}
+
+	resp, err := c.system().call(ctx, http.MethodGet, "/watch?"+query.Encode(), nil, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+
+	var result WatchResult
+	if err := json.Unmarshal(resp.data, &result); err != nil {
+		return nil, fmt.Errorf("decoding watch response: %w", err)
+	}
+	return &result, nil
+}
+
+// system returns a copy of the client for the server's system endpoints, at the root of the base URL's host
+func (c *Client) system() *Client {
+	system := *c
+	system.baseURL = systemPath
+	if u, err := url.Parse(c.baseURL); err == nil {
+		system.baseURL = u.Scheme + "://" + u.Host + systemPath
+	}
+	return &system
+}
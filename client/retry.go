@@ -0,0 +1,168 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cenkalti/backoff/v5"
+)
+
+// IdempotencyKeyHeader is the header used to send idempotency keys with POST requests
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// RetryPolicy controls how failed requests are retried.
+//
+// Network errors and 408, 429, 500, 502, 503 and 504 responses are retried with exponential backoff
+// and jitter, waiting at least as long as any Retry-After header. GET, PUT and DELETE are always
+// safe to retry; POST is only retried when the context carries an idempotency key (see WithIdempotencyKey).
+type RetryPolicy struct {
+	// MaxTries is the maximum number of attempts, including the first. Zero or one disables retries.
+	MaxTries uint
+	// InitialInterval is the delay before the first retry
+	InitialInterval time.Duration
+	// MaxInterval caps the delay between retries, except when the server asks for a longer delay with Retry-After
+	MaxInterval time.Duration
+	// MaxElapsedTime bounds the total time spent on a call, including delays. Zero means no limit.
+	MaxElapsedTime time.Duration
+}
+
+// DefaultRetryPolicy is a retry policy suitable for most clients
+var DefaultRetryPolicy = RetryPolicy{
+	MaxTries:        4,
+	InitialInterval: 100 * time.Millisecond,
+	MaxInterval:     5 * time.Second,
+	MaxElapsedTime:  30 * time.Second,
+}
+
+// WithRetryPolicy retries failed requests according to policy.
+// If not set, each call makes a single attempt.
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(c *Client) {
+		c.retry = policy
+	}
+}
+
+// RetryError is returned when a call fails after one or more retries.
+type RetryError struct {
+	// Retries is the number of attempts made after the first
+	Retries int
+	// Err is the error from the last attempt
+	Err error
+}
+
+// Error implements the error interface.
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("failed after %d retries: %v", e.Retries, e.Err)
+}
+
+// Unwrap returns the error from the last attempt.
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+type idempotencyKeyContextKey struct{}
+
+// WithIdempotencyKey returns a context that sends key as the Idempotency-Key of POST requests,
+// allowing them to be retried without creating a resource twice.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyContextKey{}, key)
+}
+
+// IdempotencyKey returns the idempotency key carried by ctx, or an empty string if there is none.
+func IdempotencyKey(ctx context.Context) string {
+	key, _ := ctx.Value(idempotencyKeyContextKey{}).(string)
+	return key
+}
+
+// attemptError is the outcome of a failed attempt, with whether it can be retried
+type attemptError struct {
+	err        error
+	retryable  bool
+	retryAfter time.Duration
+}
+
+// retryableStatus reports whether a response status indicates a transient failure
+func retryableStatus(status int) bool {
+	switch status {
+	case http.StatusRequestTimeout,
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// parseRetryAfter returns the delay requested by a Retry-After header, in seconds or as an HTTP date.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0)
+	}
+	return 0
+}
+
+// idempotent reports whether a request can be safely repeated
+func idempotent(ctx context.Context, method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	case http.MethodPost:
+		return IdempotencyKey(ctx) != ""
+	}
+	return false
+}
+
+// withRetries calls attempt until it succeeds, fails permanently, or the retry policy is exhausted.
+// It returns the number of retries made.
+func (c *Client) withRetries(ctx context.Context, method, url string, attempt func() *attemptError) (int, error) {
+	policy := backoff.NewExponentialBackOff()
+	if c.retry.InitialInterval > 0 {
+		policy.InitialInterval = c.retry.InitialInterval
+	}
+	if c.retry.MaxInterval > 0 {
+		policy.MaxInterval = c.retry.MaxInterval
+	}
+
+	canRetry := idempotent(ctx, method)
+	start := time.Now()
+	for retries := 0; ; retries++ {
+		failure := attempt()
+		if failure == nil {
+			return retries, nil
+		}
+
+		err := failure.err
+		if retries > 0 {
+			err = &RetryError{Retries: retries, Err: failure.err}
+		}
+		if !canRetry || !failure.retryable || uint(retries+1) >= c.retry.MaxTries || ctx.Err() != nil {
+			return retries, err
+		}
+
+		delay := max(policy.NextBackOff(), failure.retryAfter)
+		if c.retry.MaxElapsedTime > 0 && time.Since(start)+delay > c.retry.MaxElapsedTime {
+			return retries, err
+		}
+
+		c.log(ctx).WarnContext(ctx, "retrying request", "method", method, "url", url, "retry", retries+1, "delay", delay, "error", failure.err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return retries, errors.Join(err, ctx.Err())
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+// fastRetries is a retry policy with short delays for tests
+var fastRetries = RetryPolicy{
+	MaxTries:        4,
+	InitialInterval: time.Millisecond,
+	MaxInterval:     5 * time.Millisecond,
+}
+
+// flakyServer fails the first failures requests with status, then succeeds.
+// It returns the server and a function returning the requests received.
+func flakyServer(t *testing.T, failures int, status int, header http.Header) (*httptest.Server, func() []*http.Request) {
+	t.Helper()
+
+	var mtx sync.Mutex
+	var requests []*http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		requests = append(requests, r)
+		count := len(requests)
+		mtx.Unlock()
+
+		if count <= failures {
+			for key, values := range header {
+				w.Header()[key] = values
+			}
+			http.Error(w, "transient failure", status)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			w.Write([]byte("ok"))
+		case http.MethodPost:
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func() []*http.Request {
+		mtx.Lock()
+		defer mtx.Unlock()
+		return requests
+	}
+}
+
+func TestRetry(t *testing.T) {
+	server, requests := flakyServer(t, 2, http.StatusServiceUnavailable, nil)
+	client := New(server.URL, WithRetryPolicy(fastRetries))
+
+	result, err := client.GetResult(context.Background(), "/resource")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Retries != 2 || string(result.Data) != "ok" {
+		t.Errorf("expected ok after 2 retries, got %q after %d", result.Data, result.Retries)
+	}
+
+	received := requests()
+	requestID := received[0].Header.Get(RequestIDHeader)
+	for _, r := range received {
+		if r.Header.Get(RequestIDHeader) != requestID {
+			t.Errorf("expected retries to share request ID %q, got %q", requestID, r.Header.Get(RequestIDHeader))
+		}
+	}
+}
+
+func TestRetryExhausted(t *testing.T) {
+	server, requests := flakyServer(t, 10, http.StatusBadGateway, nil)
+	client := New(server.URL, WithRetryPolicy(fastRetries))
+
+	err := client.PUT(context.Background(), "/resource", []byte("data"))
+	var retryErr *RetryError
+	if !errors.As(err, &retryErr) || retryErr.Retries != 3 {
+		t.Fatalf("expected a RetryError after 3 retries, got %v", err)
+	}
+	if len(requests()) != 4 {
+		t.Errorf("expected 4 attempts, got %d", len(requests()))
+	}
+	if gitbackedrest.GetHTTPStatusCode(err, 0) != http.StatusBadGateway {
+		t.Errorf("expected the last status to be reported, got %v", err)
+	}
+}
+
+func TestRetryNotRetryable(t *testing.T) {
+	server, requests := flakyServer(t, 1, http.StatusNotFound, nil)
+	client := New(server.URL, WithRetryPolicy(fastRetries))
+
+	if _, err := client.GET(context.Background(), "/resource"); !errors.Is(err, gitbackedrest.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if len(requests()) != 1 {
+		t.Errorf("expected a single attempt, got %d", len(requests()))
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	server, _ := flakyServer(t, 1, http.StatusTooManyRequests, http.Header{"Retry-After": {"1"}})
+	client := New(server.URL, WithRetryPolicy(fastRetries))
+
+	start := time.Now()
+	result, err := client.DeleteResult(context.Background(), "/resource")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("expected to wait for Retry-After, retried after %v", elapsed)
+	}
+	if result.Retries != 1 {
+		t.Errorf("expected 1 retry, got %d", result.Retries)
+	}
+
+	// A Retry-After beyond the policy's time limit is not waited for
+	server, requests := flakyServer(t, 1, http.StatusServiceUnavailable, http.Header{"Retry-After": {"60"}})
+	policy := fastRetries
+	policy.MaxElapsedTime = time.Second
+	client = New(server.URL, WithRetryPolicy(policy))
+	if err := client.DELETE(context.Background(), "/resource"); !errors.Is(err, gitbackedrest.ErrUnavailable) {
+		t.Errorf("expected ErrUnavailable, got %v", err)
+	}
+	if len(requests()) != 1 {
+		t.Errorf("expected a single attempt, got %d", len(requests()))
+	}
+}
+
+func TestRetryPOST(t *testing.T) {
+	server, requests := flakyServer(t, 1, http.StatusServiceUnavailable, nil)
+	client := New(server.URL, WithRetryPolicy(fastRetries))
+
+	if err := client.POST(context.Background(), "/resource", []byte("data")); err == nil {
+		t.Fatal("expected POST without an idempotency key not to be retried")
+	}
+
+	ctx := WithIdempotencyKey(context.Background(), "create-resource")
+	result, err := client.PostResult(ctx, "/resource", []byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Retries != 0 {
+		t.Errorf("expected no retries against the recovered server, got %d", result.Retries)
+	}
+	if key := requests()[1].Header.Get(IdempotencyKeyHeader); key != "create-resource" {
+		t.Errorf("expected idempotency key to be sent, got %q", key)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	var failing atomic.Bool
+	failing.Store(true)
+	var count atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count.Add(1)
+		if failing.Load() {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	client := New(server.URL, WithCircuitBreaker(2, 50*time.Millisecond))
+	ctx := context.Background()
+
+	for range 2 {
+		if _, err := client.GET(ctx, "/resource"); errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("expected breaker to be closed, got %v", err)
+		}
+	}
+	_, err := client.GET(ctx, "/resource")
+	if !errors.Is(err, ErrCircuitOpen) || !errors.Is(err, gitbackedrest.ErrUnavailable) {
+		t.Fatalf("expected breaker to be open, got %v", err)
+	}
+	if count.Load() != 2 {
+		t.Errorf("expected no request while open, got %d requests", count.Load())
+	}
+
+	// After the reset timeout, a successful trial closes the breaker
+	failing.Store(false)
+	time.Sleep(60 * time.Millisecond)
+	for range 2 {
+		if _, err := client.GET(ctx, "/resource"); err != nil {
+			t.Fatalf("expected breaker to close, got %v", err)
+		}
+	}
+}
+
+func TestCircuitBreakerInvalid(t *testing.T) {
+	for _, test := range []struct {
+		threshold    int
+		resetTimeout time.Duration
+	}{
+		{0, time.Second},
+		{-1, time.Second},
+		{1, 0},
+		{1, -time.Second},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected a panic for threshold %d and reset timeout %v", test.threshold, test.resetTimeout)
+				}
+			}()
+			WithCircuitBreaker(test.threshold, test.resetTimeout)
+		}()
+	}
+}
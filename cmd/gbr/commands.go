@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+// watchPollTimeout is how long each watch request waits for changes
+const watchPollTimeout = 20 * time.Second
+
+// parseFlags parses a command's flags, requiring between minArgs and maxArgs positional arguments
+func parseFlags(e *env, flags *flag.FlagSet, args []string, minArgs, maxArgs int) ([]string, error) {
+	flags.SetOutput(e.stderr)
+	if err := flags.Parse(args); err != nil {
+		return nil, errUsage
+	}
+	if flags.NArg() < minArgs || flags.NArg() > maxArgs {
+		return nil, errUsage
+	}
+	return flags.Args(), nil
+}
+
+// readBody reads a request body from the named file, or stdin if the name is empty or "-"
+func readBody(e *env, file string) ([]byte, error) {
+	if file == "" || file == "-" {
+		return io.ReadAll(e.stdin)
+	}
+	return os.ReadFile(file)
+}
+
+func runGet(ctx context.Context, e *env, args []string) error {
+	flags := flag.NewFlagSet("get", flag.ContinueOnError)
+	version := flags.String("version", "", "version ID from history to read instead of the current content")
+	args, err := parseFlags(e, flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	if *version != "" {
+		data, err = e.client.GetVersion(ctx, args[0], *version)
+	} else {
+		data, err = e.client.GET(ctx, args[0])
+	}
+	if err != nil {
+		return err
+	}
+	return writeResource(e, data)
+}
+
+func runCreate(ctx context.Context, e *env, args []string) error {
+	flags := flag.NewFlagSet("create", flag.ContinueOnError)
+	file := flags.String("f", "-", "file to read the resource from, or - for stdin")
+	args, err := parseFlags(e, flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	body, err := readBody(e, *file)
+	if err != nil {
+		return fmt.Errorf("reading body: %w", err)
+	}
+	return e.client.POST(ctx, args[0], body)
+}
+
+func runUpdate(ctx context.Context, e *env, args []string) error {
+	flags := flag.NewFlagSet("update", flag.ContinueOnError)
+	file := flags.String("f", "-", "file to read the resource from, or - for stdin")
+	args, err := parseFlags(e, flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	body, err := readBody(e, *file)
+	if err != nil {
+		return fmt.Errorf("reading body: %w", err)
+	}
+	return e.client.PUT(ctx, args[0], body)
+}
+
+func runDelete(ctx context.Context, e *env, args []string) error {
+	args, err := parseFlags(e, flag.NewFlagSet("delete", flag.ContinueOnError), args, 1, 1)
+	if err != nil {
+		return err
+	}
+	return e.client.DELETE(ctx, args[0])
+}
+
+func runList(ctx context.Context, e *env, args []string) error {
+	args, err := parseFlags(e, flag.NewFlagSet("list", flag.ContinueOnError), args, 0, 1)
+	if err != nil {
+		return err
+	}
+	prefix := "/"
+	if len(args) > 0 {
+		prefix = args[0]
+	}
+
+	paths, err := e.client.List(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	return writeList(e, paths)
+}
+
+func runHistory(ctx context.Context, e *env, args []string) error {
+	args, err := parseFlags(e, flag.NewFlagSet("history", flag.ContinueOnError), args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	versions, err := e.client.History(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	return writeHistory(e, versions)
+}
+
+func runRestore(ctx context.Context, e *env, args []string) error {
+	args, err := parseFlags(e, flag.NewFlagSet("restore", flag.ContinueOnError), args, 2, 2)
+	if err != nil {
+		return err
+	}
+	return e.client.Restore(ctx, args[0], args[1])
+}
+
+// currentVersion names the current content of a resource in diff
+const currentVersion = "current"
+
+func runDiff(ctx context.Context, e *env, args []string) error {
+	args, err := parseFlags(e, flag.NewFlagSet("diff", flag.ContinueOnError), args, 1, 3)
+	if err != nil {
+		return err
+	}
+	path := args[0]
+
+	// By default, compare the version before the latest change with the current content
+	from, to := "", currentVersion
+	if len(args) > 1 {
+		from = args[1]
+	}
+	if len(args) > 2 {
+		to = args[2]
+	}
+	if from == "" {
+		versions, err := e.client.History(ctx, path)
+		if err != nil {
+			return err
+		}
+		if len(versions) < 2 {
+			return fmt.Errorf("%s has no previous version to compare", path)
+		}
+		from = versions[1].ID
+	}
+
+	fromData, err := readVersion(ctx, e, path, from)
+	if err != nil {
+		return err
+	}
+	toData, err := readVersion(ctx, e, path, to)
+	if err != nil {
+		return err
+	}
+	writeDiff(e.stdout, path+"@"+from, path+"@"+to, string(fromData), string(toData))
+	return nil
+}
+
+// readVersion reads a version of a resource for diff, treating a version in which it did not exist as empty
+func readVersion(ctx context.Context, e *env, path, version string) ([]byte, error) {
+	var data []byte
+	var err error
+	if version == currentVersion {
+		data, err = e.client.GET(ctx, path)
+	} else {
+		data, err = e.client.GetVersion(ctx, path, version)
+	}
+	if errors.Is(err, gitbackedrest.ErrNotFound) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func runWatch(ctx context.Context, e *env, args []string) error {
+	flags := flag.NewFlagSet("watch", flag.ContinueOnError)
+	since := flags.String("since", "", "resume token from a previous watch, to include the changes made since")
+	args, err := parseFlags(e, flags, args, 0, 1)
+	if err != nil {
+		return err
+	}
+	prefix := "/"
+	if len(args) > 0 {
+		prefix = args[0]
+	}
+
+	// Each poll must complete within the request timeout
+	pollTimeout := watchPollTimeout
+	if e.timeout > 0 && e.timeout <= pollTimeout {
+		pollTimeout = e.timeout / 2
+	}
+
+	version := *since
+	for {
+		result, err := e.client.Watch(ctx, prefix, version, pollTimeout)
+		if ctx.Err() != nil {
+			// Interrupted
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := writeEvents(e, result.Events); err != nil {
+			return err
+		}
+		version = result.Version
+	}
+}
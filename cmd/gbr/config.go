@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// config is the profiles file, e.g.
+//
+//	default_profile: local
+//	profiles:
+//	  local:
+//	    url: http://localhost:8080
+//	  prod:
+//	    url: https://config.example.com/v1/resources
+//	    token: s3cr3t
+type config struct {
+	DefaultProfile string             `yaml:"default_profile"`
+	Profiles       map[string]profile `yaml:"profiles"`
+}
+
+// profile holds the settings for one server
+type profile struct {
+	// URL is the base URL of the server, including any resource prefix
+	URL string `yaml:"url"`
+	// Token is sent as a bearer token with every request
+	Token string `yaml:"token"`
+}
+
+// defaultConfigPath returns $GBR_CONFIG, or gbr/config.yaml in the user config directory
+func defaultConfigPath() string {
+	if path := os.Getenv("GBR_CONFIG"); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "gbr", "config.yaml")
+}
+
+// loadConfig reads the profiles file at path. A missing file is an empty config.
+func loadConfig(path string) (*config, error) {
+	var cfg config
+	if path == "" {
+		return &cfg, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading config: %w", err)
+	}
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing config %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// resolveProfile selects a profile from the config file at configPath, and applies the URL and token overrides.
+// If no profile is named, the config's default profile is used, if any.
+func resolveProfile(configPath, name, url, token string) (profile, error) {
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		return profile{}, err
+	}
+
+	if name == "" {
+		name = cfg.DefaultProfile
+	}
+	var selected profile
+	if name != "" {
+		var ok bool
+		if selected, ok = cfg.Profiles[name]; !ok {
+			if len(cfg.Profiles) == 0 {
+				return profile{}, fmt.Errorf("unknown profile %q, no profiles are configured in %s", name, configPath)
+			}
+			var names []string
+			for name := range cfg.Profiles {
+				names = append(names, name)
+			}
+			slices.Sort(names)
+			return profile{}, fmt.Errorf("unknown profile %q, available profiles: %s", name, strings.Join(names, ", "))
+		}
+	}
+
+	if url != "" {
+		selected.URL = url
+	}
+	if token != "" {
+		selected.Token = token
+	}
+	if selected.URL == "" {
+		return profile{}, errors.New("no server URL: set -url, $GBR_URL or a profile")
+	}
+	return selected, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around each change
+const diffContext = 3
+
+// diffOp is a line of a diff: kept (' '), removed ('-') or added ('+').
+// from and to are the line's position in each text, or where it would be.
+type diffOp struct {
+	kind     byte
+	text     string
+	from, to int
+}
+
+// splitLines splits text into lines without their line endings
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// diffLines returns the edit script turning from into to, using their longest common subsequence
+func diffLines(from, to []string) []diffOp {
+	// common[i][j] is the length of the longest common subsequence of from[i:] and to[j:]
+	common := make([][]int, len(from)+1)
+	for i := range common {
+		common[i] = make([]int, len(to)+1)
+	}
+	for i := len(from) - 1; i >= 0; i-- {
+		for j := len(to) - 1; j >= 0; j-- {
+			if from[i] == to[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else {
+				common[i][j] = max(common[i+1][j], common[i][j+1])
+			}
+		}
+	}
+
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(from) || j < len(to) {
+		switch {
+		case i < len(from) && j < len(to) && from[i] == to[j]:
+			ops = append(ops, diffOp{' ', from[i], i, j})
+			i++
+			j++
+		case j == len(to) || (i < len(from) && common[i+1][j] >= common[i][j+1]):
+			ops = append(ops, diffOp{'-', from[i], i, j})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', to[j], i, j})
+			j++
+		}
+	}
+	return ops
+}
+
+// writeDiff writes a unified diff of two texts. Nothing is written if they are the same.
+func writeDiff(w io.Writer, fromName, toName, from, to string) {
+	ops := diffLines(splitLines(from), splitLines(to))
+
+	var changes []int
+	for i, op := range ops {
+		if op.kind != ' ' {
+			changes = append(changes, i)
+		}
+	}
+	if len(changes) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "--- %s\n+++ %s\n", fromName, toName)
+	for len(changes) > 0 {
+		// Extend the hunk while the next change is within the context of the last
+		start := max(changes[0]-diffContext, 0)
+		last := 0
+		for last+1 < len(changes) && changes[last+1]-changes[last] <= 2*diffContext {
+			last++
+		}
+		end := min(changes[last]+diffContext+1, len(ops))
+		changes = changes[last+1:]
+
+		hunk := ops[start:end]
+		var fromCount, toCount int
+		for _, op := range hunk {
+			if op.kind != '+' {
+				fromCount++
+			}
+			if op.kind != '-' {
+				toCount++
+			}
+		}
+		fmt.Fprintf(w, "@@ -%s +%s @@\n", hunkRange(hunk[0].from, fromCount), hunkRange(hunk[0].to, toCount))
+		for _, op := range hunk {
+			fmt.Fprintf(w, "%c%s\n", op.kind, op.text)
+		}
+	}
+}
+
+// hunkRange formats the range of lines in a hunk header. An empty range is given as the line before it.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteDiff(t *testing.T) {
+	var lines []string
+	for i := range 20 {
+		lines = append(lines, string(rune('a'+i)))
+	}
+	original := strings.Join(lines, "\n") + "\n"
+
+	for _, test := range []struct {
+		name     string
+		from, to string
+		expected string
+	}{
+		{
+			name:     "identical",
+			from:     original,
+			to:       original,
+			expected: "",
+		},
+		{
+			name: "created",
+			from: "",
+			to:   "a\nb\n",
+			expected: "--- from\n+++ to\n" +
+				"@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name: "separate hunks",
+			from: original,
+			to:   strings.Replace(strings.Replace(original, "b\n", "B\n", 1), "s\n", "", 1),
+			expected: "--- from\n+++ to\n" +
+				"@@ -1,5 +1,5 @@\n a\n-b\n+B\n c\n d\n e\n" +
+				"@@ -16,5 +16,4 @@\n p\n q\n r\n-s\n t\n",
+		},
+		{
+			name: "merged hunks",
+			from: original,
+			to:   strings.Replace(strings.Replace(original, "b\n", "B\n", 1), "f\n", "F\n", 1),
+			expected: "--- from\n+++ to\n" +
+				"@@ -1,9 +1,9 @@\n a\n-b\n+B\n c\n d\n e\n-f\n+F\n g\n h\n i\n",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var out strings.Builder
+			writeDiff(&out, "from", "to", test.from, test.to)
+			if out.String() != test.expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", test.expected, out.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+// Command gbr reads and writes resources on a git-backed-rest server.
+//
+// Usage:
+//
+//	gbr [flags] <command> [arguments]
+//
+// Run gbr -h for the list of commands and flags.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+	"github.com/theothertomelliott/git-backed-rest/client"
+)
+
+// Exit codes, so scripts can distinguish common failures
+const (
+	exitOK       = 0
+	exitError    = 1
+	exitUsage    = 2
+	exitNotFound = 3
+	exitConflict = 4
+	exitAuth     = 5
+)
+
+// errUsage reports invalid arguments to a command, so its usage is printed
+var errUsage = errors.New("invalid usage")
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	code := run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+	stop()
+	os.Exit(code)
+}
+
+// env holds the state shared by commands
+type env struct {
+	client *client.Client
+	format string
+	// timeout limits each request, or is zero for none
+	timeout time.Duration
+	stdin   io.Reader
+	stdout  io.Writer
+	stderr  io.Writer
+}
+
+// command is a gbr subcommand
+type command struct {
+	usage string
+	help  string
+	run   func(ctx context.Context, e *env, args []string) error
+}
+
+var commands = map[string]command{
+	"get":     {"get [-version ID] PATH", "print a resource, or a past version of it", runGet},
+	"create":  {"create [-f FILE] PATH", "create a resource from a file or stdin", runCreate},
+	"update":  {"update [-f FILE] PATH", "replace a resource from a file or stdin", runUpdate},
+	"delete":  {"delete PATH", "delete a resource", runDelete},
+	"list":    {"list [PREFIX]", "list the resources under a collection path", runList},
+	"history": {"history PATH", "list the versions of a resource", runHistory},
+	"restore": {"restore PATH VERSION", "make a past version of a resource its current content", runRestore},
+	"diff":    {"diff PATH [FROM [TO]]", "compare two versions of a resource, by default the previous and current", runDiff},
+	"watch":   {"watch [-since VERSION] [PREFIX]", "print changes under a prefix as they happen", runWatch},
+}
+
+// commandOrder lists commands in the order they are documented
+var commandOrder = []string{"get", "create", "update", "delete", "list", "history", "restore", "diff", "watch"}
+
+// run executes the command line args, returning the process exit code
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("gbr", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configPath := flags.String("config", defaultConfigPath(), "path to the profiles config file (default $GBR_CONFIG or the user config directory)")
+	profileName := flags.String("profile", os.Getenv("GBR_PROFILE"), "profile to use from the config file (default $GBR_PROFILE or the config's default_profile)")
+	baseURL := flags.String("url", os.Getenv("GBR_URL"), "base URL of the server, overriding the profile (default $GBR_URL)")
+	token := flags.String("token", os.Getenv("GBR_TOKEN"), "bearer token, overriding the profile (default $GBR_TOKEN)")
+	format := flags.String("o", "", "output format: raw, json or table (default raw for resources, table for listings)")
+	timeout := flags.Duration("timeout", 30*time.Second, "timeout for each request, or 0 for none")
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: gbr [flags] <command> [arguments]\n\nCommands:\n")
+		for _, name := range commandOrder {
+			fmt.Fprintf(stderr, "  %-38s %s\n", commands[name].usage, commands[name].help)
+		}
+		fmt.Fprintf(stderr, "\nFlags:\n")
+		flags.PrintDefaults()
+		fmt.Fprintf(stderr, "\nExit codes: 1 error, 2 usage, 3 not found, 4 conflict or precondition failed, 5 unauthorized or forbidden\n")
+	}
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	cmd, ok := commands[flags.Arg(0)]
+	if !ok {
+		fmt.Fprintf(stderr, "gbr: unknown command %q\n", flags.Arg(0))
+		flags.Usage()
+		return exitUsage
+	}
+	switch *format {
+	case "", formatRaw, formatJSON, formatTable:
+	default:
+		fmt.Fprintf(stderr, "gbr: unknown output format %q\n", *format)
+		return exitUsage
+	}
+
+	profile, err := resolveProfile(*configPath, *profileName, *baseURL, *token)
+	if err != nil {
+		fmt.Fprintf(stderr, "gbr: %v\n", err)
+		return exitUsage
+	}
+
+	e := &env{
+		client: client.New(profile.URL,
+			client.WithToken(profile.Token),
+			client.WithHTTPClient(&http.Client{Timeout: *timeout}),
+		),
+		format:  *format,
+		timeout: *timeout,
+		stdin:   stdin,
+		stdout:  stdout,
+		stderr:  stderr,
+	}
+	if err := cmd.run(ctx, e, flags.Args()[1:]); err != nil {
+		if errors.Is(err, errUsage) {
+			fmt.Fprintf(stderr, "Usage: gbr %s\n", cmd.usage)
+			return exitUsage
+		}
+		fmt.Fprintf(stderr, "gbr: %v\n", err)
+		return exitCode(err)
+	}
+	return exitOK
+}
+
+// exitCode maps an error from the client to the process exit code
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, gitbackedrest.ErrNotFound):
+		return exitNotFound
+	case errors.Is(err, gitbackedrest.ErrConflict), errors.Is(err, gitbackedrest.ErrPreconditionFailed):
+		return exitConflict
+	case gitbackedrest.HasHTTPStatusCode(err, http.StatusUnauthorized, http.StatusForbidden):
+		return exitAuth
+	default:
+		return exitError
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+	"github.com/theothertomelliott/git-backed-rest/backends/memory"
+	"github.com/theothertomelliott/git-backed-rest/server"
+)
+
+// historyBackend adds fixed versions of /doc to a memory backend
+type historyBackend struct {
+	*memory.Backend
+}
+
+var testVersions = map[string]string{
+	"v1": "line1\nline2\nline3\n",
+	"v2": "line1\nchanged\nline3\n",
+}
+
+func (h historyBackend) History(ctx context.Context, path string) ([]gitbackedrest.Version, error) {
+	return []gitbackedrest.Version{
+		{ID: "v2", Time: time.Unix(2, 0), Author: "bob", Message: "change line 2"},
+		{ID: "v1", Time: time.Unix(1, 0), Author: "alice", Message: "create doc"},
+	}, nil
+}
+
+func (h historyBackend) GETVersion(ctx context.Context, path, version string) (*gitbackedrest.GetResult, error) {
+	data, ok := testVersions[version]
+	if !ok {
+		return nil, gitbackedrest.NewUserError("Not Found", gitbackedrest.NewHTTPError(http.StatusNotFound, errors.New("version not found")))
+	}
+	return &gitbackedrest.GetResult{Data: []byte(data)}, nil
+}
+
+// newTestServer starts a server with a memory backend, returning its URL
+func newTestServer(t *testing.T) string {
+	t.Helper()
+
+	httpServer := httptest.NewServer(server.New(historyBackend{memory.NewBackend()}))
+	t.Cleanup(httpServer.Close)
+	return httpServer.URL
+}
+
+// gbr runs the command line against the server at url, returning the exit code and output
+func gbr(t *testing.T, url, stdin string, args ...string) (int, string, string) {
+	t.Helper()
+
+	var stdout, stderr bytes.Buffer
+	args = append([]string{"-config", "", "-url", url}, args...)
+	code := run(t.Context(), args, strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestCommands(t *testing.T) {
+	url := newTestServer(t)
+
+	for _, test := range []struct {
+		name     string
+		stdin    string
+		args     []string
+		code     int
+		expected string
+	}{
+		{"get missing", "", []string{"get", "/users/alice"}, exitNotFound, ""},
+		{"create", `{"name":"Alice"}`, []string{"create", "/users/alice"}, exitOK, ""},
+		{"create existing", `{}`, []string{"create", "/users/alice"}, exitConflict, ""},
+		{"get raw", "", []string{"get", "/users/alice"}, exitOK, `{"name":"Alice"}`},
+		{"get json", "", []string{"-o", "json", "get", "/users/alice"}, exitOK, "{\n  \"name\": \"Alice\"\n}\n"},
+		{"update", `{"name":"Alice Smith"}`, []string{"update", "/users/alice"}, exitOK, ""},
+		{"get updated", "", []string{"get", "/users/alice"}, exitOK, `{"name":"Alice Smith"}`},
+		{"create bob", `{"name":"Bob"}`, []string{"create", "/users/bob"}, exitOK, ""},
+		{"list table", "", []string{"list", "/users/"}, exitOK, "PATH\n/users/alice\n/users/bob\n"},
+		{"list raw", "", []string{"-o", "raw", "list", "/users"}, exitOK, "/users/alice\n/users/bob\n"},
+		{"list json", "", []string{"-o", "json", "list", "/missing/"}, exitOK, "[]\n"},
+		{"delete", "", []string{"delete", "/users/bob"}, exitOK, ""},
+		{"delete missing", "", []string{"delete", "/users/bob"}, exitNotFound, ""},
+		{"get version", "", []string{"get", "-version", "v1", "/doc"}, exitOK, testVersions["v1"]},
+		{"history raw", "", []string{"-o", "raw", "history", "/doc"}, exitOK, "v2\nv1\n"},
+		{"diff versions", "", []string{"diff", "/doc", "v1", "v2"}, exitOK, "--- /doc@v1\n+++ /doc@v2\n@@ -1,3 +1,3 @@\n line1\n-line2\n+changed\n line3\n"},
+		{"diff same", "", []string{"diff", "/doc", "v1", "v1"}, exitOK, ""},
+		{"restore", "", []string{"restore", "/doc", "v2"}, exitOK, ""},
+		{"get restored", "", []string{"get", "/doc"}, exitOK, testVersions["v2"]},
+		{"restore missing version", "", []string{"restore", "/doc", "v3"}, exitNotFound, ""},
+		{"unknown command", "", []string{"frobnicate"}, exitUsage, ""},
+		{"missing argument", "", []string{"get"}, exitUsage, ""},
+		{"table resource", "", []string{"-o", "table", "get", "/users/alice"}, exitError, ""},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			code, stdout, stderr := gbr(t, url, test.stdin, test.args...)
+			if code != test.code {
+				t.Fatalf("expected exit code %d, got %d: %s", test.code, code, stderr)
+			}
+			if stdout != test.expected {
+				t.Errorf("expected output %q, got %q", test.expected, stdout)
+			}
+		})
+	}
+}
+
+func TestCreateFromFile(t *testing.T) {
+	url := newTestServer(t)
+
+	file := filepath.Join(t.TempDir(), "profile.json")
+	if err := os.WriteFile(file, []byte(`{"name":"Carol"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if code, _, stderr := gbr(t, url, "", "create", "-f", file, "/users/carol"); code != exitOK {
+		t.Fatalf("create failed with exit code %d: %s", code, stderr)
+	}
+	if _, stdout, _ := gbr(t, url, "", "get", "/users/carol"); stdout != `{"name":"Carol"}` {
+		t.Errorf("unexpected content: %q", stdout)
+	}
+}
+
+func TestHistoryTable(t *testing.T) {
+	url := newTestServer(t)
+
+	code, stdout, stderr := gbr(t, url, "", "history", "/doc")
+	if code != exitOK {
+		t.Fatalf("history failed with exit code %d: %s", code, stderr)
+	}
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "VERSION") || !strings.Contains(lines[1], "change line 2") {
+		t.Errorf("unexpected history table:\n%s", stdout)
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	url := newTestServer(t)
+	if code, _, stderr := gbr(t, url, `{"name":"Alice"}`, "create", "/users/alice"); code != exitOK {
+		t.Fatalf("create failed with exit code %d: %s", code, stderr)
+	}
+
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	config := "default_profile: test\nprofiles:\n  test:\n    url: " + url + "\n  broken:\n    url: http://127.0.0.1:1\n"
+	if err := os.WriteFile(configPath, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		args []string
+		code int
+	}{
+		{[]string{"get", "/users/alice"}, exitOK},
+		{[]string{"-profile", "broken", "get", "/users/alice"}, exitError},
+		{[]string{"-profile", "broken", "-url", url, "get", "/users/alice"}, exitOK},
+		{[]string{"-profile", "missing", "get", "/users/alice"}, exitUsage},
+	} {
+		var stdout, stderr bytes.Buffer
+		args := append([]string{"-config", configPath, "-timeout", "5s"}, test.args...)
+		if code := run(t.Context(), args, strings.NewReader(""), &stdout, &stderr); code != test.code {
+			t.Errorf("%v: expected exit code %d, got %d: %s", test.args, test.code, code, stderr.String())
+		}
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	for _, test := range []struct {
+		status int
+		code   int
+	}{
+		{http.StatusNotFound, exitNotFound},
+		{http.StatusConflict, exitConflict},
+		{http.StatusPreconditionFailed, exitConflict},
+		{http.StatusUnauthorized, exitAuth},
+		{http.StatusForbidden, exitAuth},
+		{http.StatusInternalServerError, exitError},
+	} {
+		if code := exitCode(gitbackedrest.NewProblem(test.status, "")); code != test.code {
+			t.Errorf("status %d: expected exit code %d, got %d", test.status, test.code, code)
+		}
+	}
+}
+
+// syncBuffer is a bytes.Buffer that can be written and read concurrently
+type syncBuffer struct {
+	mtx sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.buf.String()
+}
+
+func TestWatch(t *testing.T) {
+	url := newTestServer(t)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	var stdout syncBuffer
+	done := make(chan int)
+	go func() {
+		done <- run(ctx, []string{"-config", "", "-url", url, "-o", "raw", "watch", "/users/"}, strings.NewReader(""), &stdout, &bytes.Buffer{})
+	}()
+
+	// Create resources until the watch has started and reported one
+	deadline := time.Now().Add(5 * time.Second)
+	for i := 0; !strings.Contains(stdout.String(), "/users/"); i++ {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for watch output")
+		}
+		gbr(t, url, "{}", "create", "/users/"+string(rune('a'+i)))
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	cancel()
+	if code := <-done; code != exitOK {
+		t.Errorf("expected exit code %d after interrupting the watch, got %d", exitOK, code)
+	}
+}
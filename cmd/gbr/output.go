@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+// Output formats
+const (
+	// formatRaw writes resources as stored, and listings as one item per line
+	formatRaw = "raw"
+	// formatJSON pretty-prints resources and listings as JSON
+	formatJSON = "json"
+	// formatTable writes listings as aligned columns
+	formatTable = "table"
+)
+
+// writeResource writes the content of a resource
+func writeResource(e *env, data []byte) error {
+	switch e.format {
+	case formatJSON:
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, data, "", "  "); err != nil {
+			return fmt.Errorf("resource is not valid JSON: %w", err)
+		}
+		indented.WriteByte('\n')
+		_, err := e.stdout.Write(indented.Bytes())
+		return err
+	case formatTable:
+		return fmt.Errorf("the %s format is only available for listings", formatTable)
+	default:
+		_, err := e.stdout.Write(data)
+		return err
+	}
+}
+
+// writeJSON pretty-prints v
+func writeJSON(e *env, v any) error {
+	encoder := json.NewEncoder(e.stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// writeList writes the paths of a listing
+func writeList(e *env, paths []string) error {
+	switch e.format {
+	case formatJSON:
+		if paths == nil {
+			paths = []string{}
+		}
+		return writeJSON(e, paths)
+	case formatRaw:
+		for _, path := range paths {
+			fmt.Fprintln(e.stdout, path)
+		}
+		return nil
+	default:
+		w := tabwriter.NewWriter(e.stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "PATH")
+		for _, path := range paths {
+			fmt.Fprintln(w, path)
+		}
+		return w.Flush()
+	}
+}
+
+// writeHistory writes the versions of a resource
+func writeHistory(e *env, versions []gitbackedrest.Version) error {
+	switch e.format {
+	case formatJSON:
+		if versions == nil {
+			versions = []gitbackedrest.Version{}
+		}
+		return writeJSON(e, versions)
+	case formatRaw:
+		for _, version := range versions {
+			fmt.Fprintln(e.stdout, version.ID)
+		}
+		return nil
+	default:
+		w := tabwriter.NewWriter(e.stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tTIME\tAUTHOR\tMESSAGE")
+		for _, version := range versions {
+			message := version.Message
+			if version.Deleted {
+				message = "[deleted] " + message
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", version.ID, version.Time.Local().Format(time.DateTime), version.Author, message)
+		}
+		return w.Flush()
+	}
+}
+
+// writeEvents writes a batch of watched events. JSON events are written one per line, so they can be streamed.
+func writeEvents(e *env, events []gitbackedrest.Event) error {
+	for _, event := range events {
+		switch e.format {
+		case formatJSON:
+			if err := json.NewEncoder(e.stdout).Encode(event); err != nil {
+				return err
+			}
+		case formatRaw:
+			fmt.Fprintln(e.stdout, event.Path)
+		default:
+			fmt.Fprintf(e.stdout, "%-6s  %s  %s\n", event.Type, event.Path, event.Version)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/go-git/go-git/v6/plumbing/transport"
+	githttp "github.com/go-git/go-git/v6/plumbing/transport/http"
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+	_ "github.com/theothertomelliott/git-backed-rest/backends/all"
+	"github.com/theothertomelliott/git-backed-rest/backends/filesystem"
+	"github.com/theothertomelliott/git-backed-rest/backends/gitlocal"
+	"github.com/theothertomelliott/git-backed-rest/backends/gitporcelain"
+	"github.com/theothertomelliott/git-backed-rest/backends/gitprotocol"
+	"github.com/theothertomelliott/git-backed-rest/backends/memory"
+	"github.com/theothertomelliott/git-backed-rest/backends/mount"
+	"github.com/theothertomelliott/git-backed-rest/backends/s3"
+)
+
+// createBackend creates the backend described by cfg, which must have been validated.
+// The returned cleanup function closes the backend, and is nil if it has nothing to close.
+func createBackend(cfg backendConfig, logger *slog.Logger, metrics *gitbackedrest.BackendMetrics) (gitbackedrest.APIBackend, func(), error) {
+	if cfg.URL != "" {
+		backend, err := gitbackedrest.Open(context.Background(), cfg.URL,
+			gitbackedrest.OpenWithLogger(logger),
+			gitbackedrest.OpenWithMetrics(metrics),
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+		return backend, closeBackend(backend, logger), nil
+	}
+
+	switch cfg.Type {
+	case "memory":
+		return createMemoryBackend(cfg.Memory, logger)
+	case "filesystem":
+		return createFilesystemBackend(cfg.Filesystem, logger)
+	case "git":
+		return createGitBackend(cfg.Git, logger, metrics)
+	case "gitlocal":
+		return createGitLocalBackend(cfg.GitLocal, logger, metrics)
+	case "gitporcelain":
+		return createGitPorcelainBackend(cfg.GitPorcelain, logger, metrics)
+	case "s3":
+		return createS3Backend(cfg.S3, logger, metrics)
+	case "mount":
+		return createMountBackend(cfg.Mounts, logger, metrics)
+	default:
+		return nil, nil, fmt.Errorf("unknown backend type: %s. Supported: memory, filesystem, git, gitlocal, gitporcelain, s3, mount", cfg.Type)
+	}
+}
+
+// closeBackend returns a cleanup function that closes backend, if it has a Close method
+func closeBackend(backend gitbackedrest.APIBackend, logger *slog.Logger) func() {
+	closer, ok := backend.(io.Closer)
+	if !ok {
+		return nil
+	}
+	return func() {
+		if err := closer.Close(); err != nil {
+			logger.Error("closing backend", "error", err)
+		}
+	}
+}
+
+// createMemoryBackend creates a memory backend, persisted to a directory if a path is configured
+func createMemoryBackend(cfg *memoryConfig, logger *slog.Logger) (gitbackedrest.APIBackend, func(), error) {
+	if cfg == nil || cfg.Path == "" {
+		var opts []memory.Option
+		if cfg != nil {
+			opts = append(opts, memory.WithMaxVersions(cfg.MaxVersions))
+		}
+		return memory.NewBackend(opts...), nil, nil
+	}
+	backend, err := memory.NewPersistentBackend(cfg.Path,
+		memory.WithLogger(logger),
+		memory.WithSync(memory.SyncMode(cfg.Sync)),
+		memory.WithSyncInterval(cfg.SyncInterval),
+		memory.WithCompactAfter(cfg.CompactAfter),
+		memory.WithMaxVersions(cfg.MaxVersions),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	return backend, closeBackend(backend, logger), nil
+}
+
+func createFilesystemBackend(cfg *filesystemConfig, logger *slog.Logger) (gitbackedrest.APIBackend, func(), error) {
+	opts := []filesystem.Option{filesystem.WithLogger(logger)}
+	if cfg.Fsync != nil {
+		opts = append(opts, filesystem.WithFsync(*cfg.Fsync))
+	}
+	backend, err := filesystem.NewBackend(cfg.Path, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return backend, closeBackend(backend, logger), nil
+}
+
+func createGitBackend(cfg *gitConfig, logger *slog.Logger, metrics *gitbackedrest.BackendMetrics) (gitbackedrest.APIBackend, func(), error) {
+	var auth transport.AuthMethod
+	if cfg.Auth.Token != "" {
+		auth = &githttp.BasicAuth{
+			Username: cfg.Auth.Username,
+			Password: cfg.Auth.Token,
+		}
+	} else {
+		var err error
+		if auth, err = gitprotocol.GetAuthForEndpoint(cfg.URL); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	opts := []gitprotocol.Option{
+		gitprotocol.WithLogger(logger),
+		gitprotocol.WithMetrics(metrics),
+		gitprotocol.WithRetryPolicy(gitprotocol.RetryPolicy{
+			InitialInterval: cfg.Retry.InitialInterval,
+			MaxInterval:     cfg.Retry.MaxInterval,
+			MaxElapsedTime:  cfg.Retry.MaxElapsedTime,
+			MaxTries:        cfg.Retry.MaxTries,
+		}),
+	}
+	if cfg.Branch != "" {
+		opts = append(opts, gitprotocol.WithBranch(cfg.Branch))
+	}
+	if cfg.Cache.Interval > 0 {
+		opts = append(opts, gitprotocol.WithCacheInterval(cfg.Cache.Interval))
+	}
+
+	backend, err := gitprotocol.NewBackendWithAuth(cfg.URL, auth, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return backend, closeBackend(backend, logger), nil
+}
+
+// createGitLocalBackend creates a backend on the bare repository at the configured path, pushing to a mirror
+// if one is configured
+func createGitLocalBackend(cfg *gitLocalConfig, logger *slog.Logger, metrics *gitbackedrest.BackendMetrics) (gitbackedrest.APIBackend, func(), error) {
+	opts := []gitlocal.Option{
+		gitlocal.WithLogger(logger),
+		gitlocal.WithMetrics(metrics),
+	}
+	if cfg.Branch != "" {
+		opts = append(opts, gitlocal.WithBranch(cfg.Branch))
+	}
+	if m := cfg.Mirror; m != nil {
+		var auth transport.AuthMethod
+		if m.Auth.Token != "" {
+			auth = &githttp.BasicAuth{
+				Username: m.Auth.Username,
+				Password: m.Auth.Token,
+			}
+		}
+		opts = append(opts, gitlocal.WithMirror(m.URL, auth))
+		if m.RetryInterval > 0 {
+			opts = append(opts, gitlocal.WithMirrorRetryInterval(m.RetryInterval))
+		}
+	}
+
+	backend, err := gitlocal.NewBackend(cfg.Path, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return backend, closeBackend(backend, logger), nil
+}
+
+// createGitPorcelainBackend clones the remote into a working copy at the configured path
+func createGitPorcelainBackend(cfg *gitPorcelainConfig, logger *slog.Logger, metrics *gitbackedrest.BackendMetrics) (gitbackedrest.APIBackend, func(), error) {
+	backend, err := gitporcelain.NewBackend(cfg.Remote, cfg.Path,
+		gitporcelain.WithLogger(logger),
+		gitporcelain.WithMetrics(metrics),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	return backend, closeBackend(backend, logger), nil
+}
+
+// createS3Backend creates an S3 backend, with an optional key prefix for namespace isolation
+func createS3Backend(cfg *s3Config, logger *slog.Logger, metrics *gitbackedrest.BackendMetrics) (gitbackedrest.APIBackend, func(), error) {
+	config := s3.Config{
+		Endpoint:           cfg.Endpoint,
+		AccessKeyID:        cfg.AccessKeyID,
+		SecretAccessKey:    cfg.SecretAccessKey,
+		SessionToken:       cfg.SessionToken,
+		Profile:            cfg.Profile,
+		Bucket:             cfg.Bucket,
+		Prefix:             cfg.Prefix,
+		Region:             cfg.Region,
+		UsePathStyle:       cfg.PathStyle,
+		CABundle:           cfg.CABundle,
+		RequestTimeout:     cfg.RequestTimeout,
+		MaxAttempts:        cfg.MaxAttempts,
+		MaxBackoff:         cfg.MaxBackoff,
+		Logger:             logger,
+		Metrics:            metrics,
+		ConditionalWrites:  s3.ConditionalWriteMode(cfg.ConditionalWrites),
+		History:            s3.HistoryMode(cfg.History),
+		HistoryMaxVersions: cfg.HistoryMaxVersions,
+		ObjectSettings:     cfg.s3ObjectConfig.settings(),
+	}
+	if len(cfg.Prefixes) > 0 {
+		config.PrefixObjectSettings = make(map[string]s3.ObjectSettings)
+		for _, prefix := range cfg.Prefixes {
+			config.PrefixObjectSettings[prefix.Prefix] = prefix.s3ObjectConfig.settings()
+		}
+	}
+	backend, err := s3.NewBackend(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	return backend, closeBackend(backend, logger), nil
+}
+
+// settings converts o to the s3 backend's settings. The customer key has been validated as base64.
+func (o s3ObjectConfig) settings() s3.ObjectSettings {
+	customerKey, _ := base64.StdEncoding.DecodeString(o.CustomerKey)
+	return s3.ObjectSettings{
+		Encryption:   s3.Encryption(o.Encryption),
+		KMSKeyID:     o.KMSKeyID,
+		CustomerKey:  customerKey,
+		StorageClass: types.StorageClass(o.StorageClass),
+	}
+}
+
+func createMountBackend(cfgs []mountConfig, logger *slog.Logger, metrics *gitbackedrest.BackendMetrics) (gitbackedrest.APIBackend, func(), error) {
+	var mounts []mount.Mount
+	// cleanups close the backends created so far if a later one fails
+	var cleanups []func()
+	cleanup := func() {
+		for _, c := range cleanups {
+			c()
+		}
+	}
+	for _, cfg := range cfgs {
+		backend, c, err := createBackend(cfg.Backend, logger, metrics)
+		if err != nil {
+			cleanup()
+			return nil, nil, fmt.Errorf("creating backend for %s: %w", cfg.Prefix, err)
+		}
+		if c != nil {
+			cleanups = append(cleanups, c)
+		}
+		mounts = append(mounts, mount.Mount{
+			Prefix:      cfg.Prefix,
+			Backend:     backend,
+			StripPrefix: cfg.StripPrefix,
+		})
+		logger.Info("mounted backend", "prefix", cfg.Prefix, "backend", cfg.Backend.describe(), "strip_prefix", cfg.StripPrefix)
+	}
+
+	backend, err := mount.NewBackend(mounts...)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	// Closing the mount closes each mounted backend
+	return backend, closeBackend(backend, logger), nil
+}
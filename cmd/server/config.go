@@ -0,0 +1,650 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+	"github.com/theothertomelliott/git-backed-rest/server"
+	"github.com/theothertomelliott/git-backed-rest/tracing"
+	"go.yaml.in/yaml/v3"
+)
+
+// configVersion is the only supported value of the version field
+const configVersion = 1
+
+// config is the format of the file given by -config or CONFIG_FILE. JSON files are also accepted.
+//
+// Only logging.level and server.admin_token can be changed by reloading the file with SIGHUP;
+// other changes require a restart.
+type config struct {
+	Version   int             `yaml:"version"`
+	Server    serverConfig    `yaml:"server"`
+	Logging   loggingConfig   `yaml:"logging"`
+	Tracing   tracingConfig   `yaml:"tracing"`
+	Profiling profilingConfig `yaml:"profiling"`
+	Webhooks  *webhooksConfig `yaml:"webhooks"`
+	Backend   backendConfig   `yaml:"backend"`
+}
+
+type serverConfig struct {
+	Port           string `yaml:"port"`
+	ResourcePrefix string `yaml:"resource_prefix"`
+	AdminToken     string `yaml:"admin_token"`
+	// ShutdownTimeout is how long to wait for in-flight requests when stopping
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// Idempotency enables Idempotency-Key support for writes
+	Idempotency *idempotencyConfig `yaml:"idempotency"`
+}
+
+// idempotencyConfig configures where the outcomes of keyed writes are remembered
+type idempotencyConfig struct {
+	// Store is memory, or backend to share records between replicas
+	Store string        `yaml:"store"`
+	TTL   time.Duration `yaml:"ttl"`
+	// Path is the prefix records are stored under with the backend store
+	Path string `yaml:"path"`
+}
+
+type loggingConfig struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+}
+
+type tracingConfig struct {
+	Exporter string `yaml:"exporter"`
+	Endpoint string `yaml:"endpoint"`
+	File     string `yaml:"file"`
+}
+
+type profilingConfig struct {
+	PyroscopeAddress string `yaml:"pyroscope_address"`
+}
+
+// webhooksConfig is also the format of the file referenced by WEBHOOK_CONFIG
+type webhooksConfig struct {
+	Subscriptions   []server.WebhookSubscription `yaml:"subscriptions"`
+	QueuePath       string                       `yaml:"queue_path"`
+	MaxAttempts     int                          `yaml:"max_attempts"`
+	InitialInterval time.Duration                `yaml:"initial_interval"`
+	MaxInterval     time.Duration                `yaml:"max_interval"`
+}
+
+// backendConfig configures a backend of the given type, using the section matching that type.
+// Alternatively, URL selects a backend registered with gitbackedrest.Register, with no type.
+type backendConfig struct {
+	URL          string              `yaml:"url"`
+	Type         string              `yaml:"type"`
+	Memory       *memoryConfig       `yaml:"memory"`
+	Filesystem   *filesystemConfig   `yaml:"filesystem"`
+	Git          *gitConfig          `yaml:"git"`
+	GitPorcelain *gitPorcelainConfig `yaml:"gitporcelain"`
+	GitLocal     *gitLocalConfig     `yaml:"gitlocal"`
+	S3           *s3Config           `yaml:"s3"`
+	Mounts       []mountConfig       `yaml:"mounts"`
+}
+
+// memoryConfig optionally persists a memory backend to a directory
+type memoryConfig struct {
+	// Path is empty to keep resources only in memory
+	Path string `yaml:"path"`
+	// Sync is "always", "periodic" or "never", defaulting to always
+	Sync         string        `yaml:"sync"`
+	SyncInterval time.Duration `yaml:"sync_interval"`
+	CompactAfter int           `yaml:"compact_after"`
+	// MaxVersions limits the history kept of each resource, defaulting to 10, or is negative to keep every version
+	MaxVersions int `yaml:"max_versions"`
+}
+
+// filesystemConfig stores each resource as a file in a directory
+type filesystemConfig struct {
+	Path string `yaml:"path"`
+	// Fsync flushes each write to disk before it returns, defaulting to true
+	Fsync *bool `yaml:"fsync"`
+}
+
+type gitConfig struct {
+	URL    string        `yaml:"url"`
+	Branch string        `yaml:"branch"`
+	Auth   gitAuthConfig `yaml:"auth"`
+	Retry  retryConfig   `yaml:"retry"`
+	Cache  cacheConfig   `yaml:"cache"`
+}
+
+// gitAuthConfig configures HTTP basic auth. If no token is set, credentials are chosen
+// from the URL's scheme as for GIT_REPO_URL.
+type gitAuthConfig struct {
+	Username string `yaml:"username"`
+	Token    string `yaml:"token"`
+}
+
+// gitLocalConfig commits to a bare repository on local disk, optionally pushing to a mirror
+type gitLocalConfig struct {
+	Path   string                `yaml:"path"`
+	Branch string                `yaml:"branch"`
+	Mirror *gitLocalMirrorConfig `yaml:"mirror"`
+}
+
+// gitLocalMirrorConfig is a remote the branch is pushed to after each write. Without a token, SSH remotes
+// use the SSH agent.
+type gitLocalMirrorConfig struct {
+	URL           string        `yaml:"url"`
+	Auth          gitAuthConfig `yaml:"auth"`
+	RetryInterval time.Duration `yaml:"retry_interval"`
+}
+
+type retryConfig struct {
+	InitialInterval time.Duration `yaml:"initial_interval"`
+	MaxInterval     time.Duration `yaml:"max_interval"`
+	MaxElapsedTime  time.Duration `yaml:"max_elapsed_time"`
+	MaxTries        uint          `yaml:"max_tries"`
+}
+
+type cacheConfig struct {
+	Interval time.Duration `yaml:"interval"`
+}
+
+type gitPorcelainConfig struct {
+	Remote string `yaml:"remote"`
+	Path   string `yaml:"path"`
+}
+
+type s3Config struct {
+	// Endpoint is empty for AWS S3
+	Endpoint string `yaml:"endpoint"`
+	// AccessKeyID, SecretAccessKey and SessionToken are empty to use the AWS credential chain
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+	SessionToken    string `yaml:"session_token"`
+	Profile         string `yaml:"profile"`
+	Bucket          string `yaml:"bucket"`
+	Prefix          string `yaml:"prefix"`
+	Region          string `yaml:"region"`
+	PathStyle       bool   `yaml:"path_style"`
+	CABundle        string `yaml:"ca_bundle"`
+
+	RequestTimeout time.Duration `yaml:"request_timeout"`
+	MaxAttempts    int           `yaml:"max_attempts"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+
+	// ConditionalWrites is "native" or "lock", or empty to detect provider support
+	ConditionalWrites string `yaml:"conditional_writes"`
+	// History is "versioning", "copy" or "off", or empty to use versioning if the bucket has it enabled
+	History string `yaml:"history"`
+	// HistoryMaxVersions limits the history copies kept of each resource, defaulting to 10, or is negative to
+	// keep every copy
+	HistoryMaxVersions int `yaml:"history_max_versions"`
+
+	s3ObjectConfig `yaml:",inline"`
+	// Prefixes override the object settings for resources under path prefixes, longest prefix first
+	Prefixes []s3PrefixConfig `yaml:"prefixes"`
+}
+
+// s3ObjectConfig sets the encryption and storage class of the objects an s3 backend writes
+type s3ObjectConfig struct {
+	// Encryption is "sse-s3", "sse-kms" or "sse-c", or empty for the bucket's default
+	Encryption string `yaml:"encryption"`
+	KMSKeyID   string `yaml:"kms_key_id"`
+	// CustomerKey is the base64 encoded 256-bit key for "sse-c"
+	CustomerKey  string `yaml:"customer_key"`
+	StorageClass string `yaml:"storage_class"`
+}
+
+type s3PrefixConfig struct {
+	Prefix         string `yaml:"prefix"`
+	s3ObjectConfig `yaml:",inline"`
+}
+
+type mountConfig struct {
+	Prefix      string        `yaml:"prefix"`
+	StripPrefix bool          `yaml:"strip_prefix"`
+	Backend     backendConfig `yaml:"backend"`
+}
+
+// defaultConfig returns the configuration used when no file is given
+func defaultConfig() *config {
+	return &config{
+		Version: configVersion,
+		Server:  serverConfig{Port: "8080", ShutdownTimeout: 30 * time.Second},
+		Logging: loggingConfig{Level: "info", Format: "text"},
+		Profiling: profilingConfig{
+			PyroscopeAddress: "http://localhost:4040",
+		},
+		Backend: backendConfig{Type: "memory"},
+	}
+}
+
+// loadConfig reads the config file at path, if any, applies environment variable overrides and validates the result.
+func loadConfig(path string) (*config, error) {
+	cfg := defaultConfig()
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading config: %w", err)
+		}
+		cfg.Version = 0
+		if err := decodeConfig(data, cfg); err != nil {
+			return nil, fmt.Errorf("parsing config %s: %w", path, err)
+		}
+	}
+
+	if err := applyEnv(cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config:\n%w", err)
+	}
+	return cfg, nil
+}
+
+// decodeConfig decodes YAML or JSON into out, rejecting unknown fields and interpolating
+// environment variables into string values.
+func decodeConfig(data []byte, out any) error {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return err
+	}
+	if err := interpolateNode(&root); err != nil {
+		return err
+	}
+
+	// Node.Decode does not check for unknown fields, so decode the interpolated document again
+	interpolated, err := yaml.Marshal(&root)
+	if err != nil {
+		return err
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(interpolated))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(out); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// envReference matches ${VAR}, ${VAR:-default} and the escape $$
+var envReference = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// interpolateNode replaces environment variable references in every scalar under node.
+// Referencing an unset variable without a default is an error.
+func interpolateNode(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode && strings.Contains(node.Value, "$") {
+		var errs []error
+		value := envReference.ReplaceAllStringFunc(node.Value, func(ref string) string {
+			if ref == "$$" {
+				return "$"
+			}
+			match := envReference.FindStringSubmatch(ref)
+			if value, ok := os.LookupEnv(match[1]); ok {
+				return value
+			}
+			if match[2] != "" {
+				return match[3]
+			}
+			errs = append(errs, fmt.Errorf("line %d: environment variable %s is not set", node.Line, match[1]))
+			return ""
+		})
+		if len(errs) > 0 {
+			return errors.Join(errs...)
+		}
+		if value != node.Value {
+			// Resolve the type of the interpolated value, so numbers can be given by variables
+			node.Value = value
+			node.Tag = ""
+			node.Style = 0
+		}
+	}
+
+	var errs []error
+	for _, child := range node.Content {
+		if err := interpolateNode(child); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// applyEnv applies the environment variables that configured the server before config files existed.
+// They override values from the file.
+func applyEnv(cfg *config) error {
+	envString := func(key string, target *string) {
+		if value := os.Getenv(key); value != "" {
+			*target = value
+		}
+	}
+
+	envString("PORT", &cfg.Server.Port)
+	envString("RESOURCE_PREFIX", &cfg.Server.ResourcePrefix)
+	envString("ADMIN_TOKEN", &cfg.Server.AdminToken)
+	envString("LOG_LEVEL", &cfg.Logging.Level)
+	envString("LOG_FORMAT", &cfg.Logging.Format)
+	envString("TRACE_EXPORTER", &cfg.Tracing.Exporter)
+	envString("TRACE_ENDPOINT", &cfg.Tracing.Endpoint)
+	envString("TRACE_FILE", &cfg.Tracing.File)
+	envString("PYROSCOPE_ADDRESS", &cfg.Profiling.PyroscopeAddress)
+	envString("BACKEND_TYPE", &cfg.Backend.Type)
+	if backendURL := os.Getenv("BACKEND_URL"); backendURL != "" {
+		// Takes precedence over BACKEND_TYPE and the file's backend
+		cfg.Backend = backendConfig{URL: backendURL}
+	}
+
+	if os.Getenv("GIT_REPO_URL") != "" {
+		if cfg.Backend.Git == nil {
+			cfg.Backend.Git = &gitConfig{}
+		}
+		envString("GIT_REPO_URL", &cfg.Backend.Git.URL)
+	}
+	for _, key := range []string{"S3_ENDPOINT", "S3_ACCESS_KEY_ID", "S3_SECRET_ACCESS_KEY", "S3_SESSION_TOKEN", "S3_BUCKET", "S3_PREFIX", "S3_SSE_CUSTOMER_KEY"} {
+		if os.Getenv(key) != "" && cfg.Backend.S3 == nil {
+			cfg.Backend.S3 = &s3Config{}
+		}
+	}
+	if cfg.Backend.S3 != nil {
+		envString("S3_ENDPOINT", &cfg.Backend.S3.Endpoint)
+		envString("S3_ACCESS_KEY_ID", &cfg.Backend.S3.AccessKeyID)
+		envString("S3_SECRET_ACCESS_KEY", &cfg.Backend.S3.SecretAccessKey)
+		envString("S3_SESSION_TOKEN", &cfg.Backend.S3.SessionToken)
+		envString("S3_BUCKET", &cfg.Backend.S3.Bucket)
+		envString("S3_PREFIX", &cfg.Backend.S3.Prefix)
+		envString("S3_SSE_CUSTOMER_KEY", &cfg.Backend.S3.CustomerKey)
+	}
+
+	if path := os.Getenv("WEBHOOK_CONFIG"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("reading webhook config: %w", err)
+		}
+		cfg.Webhooks = &webhooksConfig{}
+		if err := decodeConfig(data, cfg.Webhooks); err != nil {
+			return fmt.Errorf("parsing webhook config %s: %w", path, err)
+		}
+	}
+
+	if path := os.Getenv("MOUNT_CONFIG"); path != "" {
+		mounts, err := loadMountFile(path, cfg.Backend)
+		if err != nil {
+			return err
+		}
+		cfg.Backend.Mounts = mounts
+	}
+	return nil
+}
+
+// mountFile is the format of the file referenced by MOUNT_CONFIG
+type mountFile struct {
+	Mounts []mountFileEntry `yaml:"mounts"`
+}
+
+// mountFileEntry configures a single mount.
+// Backend settings not given here are taken from the environment, as for a single backend.
+type mountFileEntry struct {
+	Prefix      string `yaml:"prefix"`
+	Backend     string `yaml:"backend"`
+	StripPrefix bool   `yaml:"strip_prefix"`
+
+	// GitRepoURL overrides GIT_REPO_URL for git backends
+	GitRepoURL string `yaml:"git_repo_url"`
+	// S3Prefix overrides S3_PREFIX for s3 backends
+	S3Prefix string `yaml:"s3_prefix"`
+}
+
+// loadMountFile reads mounts from a MOUNT_CONFIG file, filling in settings from the environment's backend
+func loadMountFile(path string, env backendConfig) ([]mountConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading mount config: %w", err)
+	}
+	var file mountFile
+	if err := decodeConfig(data, &file); err != nil {
+		return nil, fmt.Errorf("parsing mount config %s: %w", path, err)
+	}
+
+	var mounts []mountConfig
+	for _, entry := range file.Mounts {
+		backend := backendConfig{Type: entry.Backend}
+		switch entry.Backend {
+		case "git":
+			backend.Git = &gitConfig{}
+			if env.Git != nil {
+				*backend.Git = *env.Git
+			}
+			if entry.GitRepoURL != "" {
+				backend.Git.URL = entry.GitRepoURL
+			}
+		case "s3":
+			backend.S3 = &s3Config{}
+			if env.S3 != nil {
+				*backend.S3 = *env.S3
+			}
+			if entry.S3Prefix != "" {
+				backend.S3.Prefix = entry.S3Prefix
+			}
+		}
+		mounts = append(mounts, mountConfig{
+			Prefix:      entry.Prefix,
+			StripPrefix: entry.StripPrefix,
+			Backend:     backend,
+		})
+	}
+	return mounts, nil
+}
+
+// validate checks the configuration, returning an error describing every problem found
+func (c *config) validate() error {
+	var errs []error
+	fail := func(field, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+
+	if c.Version != configVersion {
+		fail("version", "unsupported version %d, supported: %d", c.Version, configVersion)
+	}
+
+	if port, err := strconv.Atoi(c.Server.Port); err != nil || port < 1 || port > 65535 {
+		fail("server.port", "must be a port number, got %q", c.Server.Port)
+	}
+
+	if c.Server.ShutdownTimeout <= 0 {
+		fail("server.shutdown_timeout", "must be positive, got %v", c.Server.ShutdownTimeout)
+	}
+
+	if idem := c.Server.Idempotency; idem != nil {
+		if idem.Store != "memory" && idem.Store != "backend" {
+			fail("server.idempotency.store", "must be memory or backend, got %q", idem.Store)
+		}
+		if idem.TTL < 0 {
+			fail("server.idempotency.ttl", "must not be negative, got %v", idem.TTL)
+		}
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Logging.Level)); err != nil {
+		fail("logging.level", "must be one of debug, info, warn or error, got %q", c.Logging.Level)
+	}
+	if c.Logging.Format != "text" && c.Logging.Format != "json" {
+		fail("logging.format", "must be text or json, got %q", c.Logging.Format)
+	}
+
+	switch c.Tracing.Exporter {
+	case tracing.ExporterNone, tracing.ExporterOTLP, tracing.ExporterStdout:
+	case tracing.ExporterFile:
+		if c.Tracing.File == "" {
+			fail("tracing.file", "required for the file exporter")
+		}
+	default:
+		fail("tracing.exporter", "must be one of otlp, file or stdout, got %q", c.Tracing.Exporter)
+	}
+
+	if c.Webhooks != nil {
+		for i, sub := range c.Webhooks.Subscriptions {
+			if sub.URL == "" {
+				fail(fmt.Sprintf("webhooks.subscriptions[%d].url", i), "required")
+			}
+			if sub.Secret == "" {
+				fail(fmt.Sprintf("webhooks.subscriptions[%d].secret", i), "required")
+			}
+		}
+	}
+
+	errs = append(errs, c.Backend.validate("backend")...)
+	return errors.Join(errs...)
+}
+
+// describe returns the backend's type, or its URL with any credentials redacted
+func (b *backendConfig) describe() string {
+	if b.URL == "" {
+		return b.Type
+	}
+	if u, err := url.Parse(b.URL); err == nil {
+		return gitbackedrest.Redact(u)
+	}
+	return b.Type
+}
+
+// validate checks object settings, with field names relative to field. The s3 backend checks the rest.
+func (o s3ObjectConfig) validate(field string, fail func(name, format string, args ...any)) {
+	switch o.Encryption {
+	case "", "sse-s3", "sse-kms", "sse-c":
+	default:
+		fail(field+".encryption", "must be sse-s3, sse-kms or sse-c, got %q", o.Encryption)
+	}
+	if _, err := base64.StdEncoding.DecodeString(o.CustomerKey); err != nil {
+		fail(field+".customer_key", "must be base64 encoded: %v", err)
+	}
+}
+
+// validate checks a backend's configuration, with field names relative to field
+func (b *backendConfig) validate(field string) []error {
+	var errs []error
+	fail := func(name, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s.%s: %s", field, name, fmt.Sprintf(format, args...)))
+	}
+
+	if b.URL != "" {
+		if b.Type != "" {
+			fail("url", "cannot be combined with type %q", b.Type)
+		}
+		if u, err := url.Parse(b.URL); err != nil {
+			fail("url", "%v", err)
+		} else if !slices.Contains(gitbackedrest.Schemes(), u.Scheme) {
+			fail("url", "unknown scheme %q, supported: %s", u.Scheme, strings.Join(gitbackedrest.Schemes(), ", "))
+		}
+		return errs
+	}
+
+	switch b.Type {
+	case "memory":
+		if m := b.Memory; m != nil {
+			switch m.Sync {
+			case "", "always", "periodic", "never":
+			default:
+				fail("memory.sync", "must be always, periodic or never, got %q", m.Sync)
+			}
+			if m.SyncInterval < 0 {
+				fail("memory.sync_interval", "must not be negative")
+			}
+			if m.CompactAfter < 0 {
+				fail("memory.compact_after", "must not be negative")
+			}
+		}
+	case "filesystem":
+		if b.Filesystem == nil || b.Filesystem.Path == "" {
+			fail("filesystem.path", "required for filesystem backends")
+		}
+	case "git":
+		if b.Git == nil || b.Git.URL == "" {
+			fail("git.url", "required for git backends (or set GIT_REPO_URL)")
+		}
+	case "gitlocal":
+		if b.GitLocal == nil || b.GitLocal.Path == "" {
+			fail("gitlocal.path", "required for gitlocal backends")
+		}
+		if m := b.GitLocal; m != nil && m.Mirror != nil {
+			if m.Mirror.URL == "" {
+				fail("gitlocal.mirror.url", "required with a mirror")
+			}
+			if m.Mirror.RetryInterval < 0 {
+				fail("gitlocal.mirror.retry_interval", "must not be negative")
+			}
+		}
+	case "gitporcelain":
+		if b.GitPorcelain == nil || b.GitPorcelain.Remote == "" {
+			fail("gitporcelain.remote", "required for gitporcelain backends")
+		}
+		if b.GitPorcelain == nil || b.GitPorcelain.Path == "" {
+			fail("gitporcelain.path", "required for gitporcelain backends")
+		}
+	case "s3":
+		s3 := b.S3
+		if s3 == nil {
+			s3 = &s3Config{}
+		}
+		if s3.Bucket == "" {
+			fail("s3.bucket", "required for s3 backends")
+		}
+		switch {
+		case s3.AccessKeyID != "" && s3.SecretAccessKey == "":
+			fail("s3.secret_access_key", "required with access_key_id")
+		case s3.AccessKeyID == "" && s3.SecretAccessKey != "":
+			fail("s3.access_key_id", "required with secret_access_key")
+		case s3.AccessKeyID == "" && s3.SessionToken != "":
+			fail("s3.session_token", "requires access_key_id and secret_access_key")
+		}
+		if s3.RequestTimeout < 0 {
+			fail("s3.request_timeout", "must not be negative")
+		}
+		if s3.MaxAttempts < 0 {
+			fail("s3.max_attempts", "must not be negative")
+		}
+		if s3.MaxBackoff < 0 {
+			fail("s3.max_backoff", "must not be negative")
+		}
+		switch s3.ConditionalWrites {
+		case "", "native", "lock":
+		default:
+			fail("s3.conditional_writes", "must be native or lock, got %q", s3.ConditionalWrites)
+		}
+		switch s3.History {
+		case "", "versioning", "copy", "off":
+		default:
+			fail("s3.history", "must be versioning, copy or off, got %q", s3.History)
+		}
+		s3.s3ObjectConfig.validate("s3", fail)
+		for i, prefix := range s3.Prefixes {
+			name := fmt.Sprintf("s3.prefixes[%d]", i)
+			if !strings.HasPrefix(prefix.Prefix, "/") {
+				fail(name+".prefix", "must start with /, got %q", prefix.Prefix)
+			}
+			prefix.s3ObjectConfig.validate(name, fail)
+		}
+	case "mount":
+		if len(b.Mounts) == 0 {
+			fail("mounts", "at least one mount is required for mount backends")
+		}
+		prefixes := make(map[string]bool)
+		for i, m := range b.Mounts {
+			mountField := fmt.Sprintf("%s.mounts[%d]", field, i)
+			prefix := strings.Trim(m.Prefix, "/")
+			if prefixes[prefix] {
+				errs = append(errs, fmt.Errorf("%s.prefix: %q is already mounted", mountField, m.Prefix))
+			}
+			prefixes[prefix] = true
+			errs = append(errs, m.Backend.validate(mountField+".backend")...)
+		}
+	case "":
+		fail("type", "required, or set url")
+	default:
+		fail("type", "must be one of memory, filesystem, git, gitlocal, gitporcelain, s3 or mount, got %q", b.Type)
+	}
+	return errs
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfig writes a config file to a temporary directory and returns its path
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Setenv("TEST_GIT_TOKEN", "secret: token #1")
+	t.Setenv("TEST_MAX_TRIES", "5")
+
+	path := writeConfig(t, "config.yaml", `
+version: 1
+server:
+  port: "9090"
+  resource_prefix: /api
+logging:
+  level: debug
+  format: json
+backend:
+  type: mount
+  mounts:
+    - prefix: /
+      backend:
+        type: memory
+    - prefix: /repo
+      strip_prefix: true
+      backend:
+        type: git
+        git:
+          url: https://example.com/repo.git
+          branch: ${TEST_GIT_BRANCH:-develop}
+          auth:
+            username: bot
+            token: ${TEST_GIT_TOKEN}
+          retry:
+            initial_interval: 100ms
+            max_tries: ${TEST_MAX_TRIES}
+          cache:
+            interval: 1m
+`)
+
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.Port != "9090" || cfg.Server.ResourcePrefix != "/api" {
+		t.Errorf("unexpected server config: %+v", cfg.Server)
+	}
+	if cfg.Logging.Level != "debug" || cfg.Logging.Format != "json" {
+		t.Errorf("unexpected logging config: %+v", cfg.Logging)
+	}
+	if cfg.Profiling.PyroscopeAddress != "http://localhost:4040" {
+		t.Errorf("expected default pyroscope address, got %q", cfg.Profiling.PyroscopeAddress)
+	}
+
+	if len(cfg.Backend.Mounts) != 2 {
+		t.Fatalf("expected 2 mounts, got %d", len(cfg.Backend.Mounts))
+	}
+	git := cfg.Backend.Mounts[1].Backend.Git
+	if git.Branch != "develop" {
+		t.Errorf("expected default branch from interpolation, got %q", git.Branch)
+	}
+	if git.Auth.Token != "secret: token #1" {
+		t.Errorf("expected token from environment, got %q", git.Auth.Token)
+	}
+	if git.Retry.InitialInterval != 100*time.Millisecond || git.Retry.MaxTries != 5 {
+		t.Errorf("unexpected retry config: %+v", git.Retry)
+	}
+	if git.Cache.Interval != time.Minute {
+		t.Errorf("expected cache interval of 1m, got %v", git.Cache.Interval)
+	}
+}
+
+func TestLoadConfigJSON(t *testing.T) {
+	path := writeConfig(t, "config.json", `{
+		"version": 1,
+		"backend": {"type": "s3", "s3": {"endpoint": "http://localhost:9000", "access_key_id": "key", "secret_access_key": "$${literal}", "bucket": "data"}}
+	}`)
+
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Backend.S3.SecretAccessKey != "${literal}" {
+		t.Errorf("expected escaped dollar sign, got %q", cfg.Backend.S3.SecretAccessKey)
+	}
+	if cfg.Server.Port != "8080" {
+		t.Errorf("expected default port, got %q", cfg.Server.Port)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		content  string
+		expected []string
+	}{
+		{
+			name:     "missing version",
+			content:  "backend: {type: memory}",
+			expected: []string{"version: unsupported version 0"},
+		},
+		{
+			name:     "unknown field",
+			content:  "version: 1\nserver: {prot: 80}",
+			expected: []string{"field prot not found"},
+		},
+		{
+			name:     "unset variable",
+			content:  "version: 1\nserver:\n  admin_token: ${TEST_UNSET_VARIABLE}",
+			expected: []string{"line 3: environment variable TEST_UNSET_VARIABLE is not set"},
+		},
+		{
+			name: "invalid values",
+			content: `
+version: 1
+server: {port: "http", idempotency: {store: redis}}
+logging: {level: loud}
+backend:
+  type: mount
+  mounts:
+    - prefix: /a
+      backend: {type: git}
+    - prefix: /a/
+      backend: {type: ftp}
+`,
+			expected: []string{
+				"server.port: must be a port number",
+				"server.idempotency.store: must be memory or backend",
+				"logging.level: must be one of",
+				"backend.mounts[0].backend.git.url: required",
+				`backend.mounts[1].prefix: "/a/" is already mounted`,
+				"backend.mounts[1].backend.type: must be one of",
+			},
+		},
+		{
+			name:     "invalid s3 conditional writes",
+			content:  "version: 1\nbackend:\n  type: s3\n  s3: {endpoint: http://localhost:9000, access_key_id: key, secret_access_key: secret, bucket: data, conditional_writes: sometimes}",
+			expected: []string{`backend.s3.conditional_writes: must be native or lock, got "sometimes"`},
+		},
+		{
+			name:    "incomplete s3 credentials",
+			content: "version: 1\nbackend:\n  type: s3\n  s3: {access_key_id: key, max_attempts: -1, request_timeout: -1s}",
+			expected: []string{
+				"backend.s3.bucket: required for s3 backends",
+				"backend.s3.secret_access_key: required with access_key_id",
+				"backend.s3.request_timeout: must not be negative",
+				"backend.s3.max_attempts: must not be negative",
+			},
+		},
+		{
+			name:     "invalid s3 history",
+			content:  "version: 1\nbackend:\n  type: s3\n  s3: {endpoint: http://localhost:9000, access_key_id: key, secret_access_key: secret, bucket: data, history: forever}",
+			expected: []string{`backend.s3.history: must be versioning, copy or off, got "forever"`},
+		},
+		{
+			name:    "invalid memory persistence",
+			content: "version: 1\nbackend:\n  type: memory\n  memory: {path: data, sync: sometimes, compact_after: -1}",
+			expected: []string{
+				`backend.memory.sync: must be always, periodic or never, got "sometimes"`,
+				"backend.memory.compact_after: must not be negative",
+			},
+		},
+		{
+			name:     "missing filesystem path",
+			content:  "version: 1\nbackend:\n  type: filesystem",
+			expected: []string{"backend.filesystem.path: required for filesystem backends"},
+		},
+		{
+			name:    "invalid gitlocal mirror",
+			content: "version: 1\nbackend:\n  type: gitlocal\n  gitlocal: {mirror: {retry_interval: -1s}}",
+			expected: []string{
+				"backend.gitlocal.path: required for gitlocal backends",
+				"backend.gitlocal.mirror.url: required with a mirror",
+				"backend.gitlocal.mirror.retry_interval: must not be negative",
+			},
+		},
+		{
+			name:    "invalid s3 object settings",
+			content: "version: 1\nbackend:\n  type: s3\n  s3:\n    bucket: data\n    encryption: rot13\n    prefixes:\n      - {prefix: archive/, customer_key: \"not base64!\"}",
+			expected: []string{
+				`backend.s3.encryption: must be sse-s3, sse-kms or sse-c, got "rot13"`,
+				`backend.s3.prefixes[0].prefix: must start with /, got "archive/"`,
+				"backend.s3.prefixes[0].customer_key: must be base64 encoded",
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := loadConfig(writeConfig(t, "config.yaml", test.content))
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			for _, expected := range test.expected {
+				if !strings.Contains(err.Error(), expected) {
+					t.Errorf("expected error to contain %q, got:\n%v", expected, err)
+				}
+			}
+		})
+	}
+}
+
+func TestEnvOverrides(t *testing.T) {
+	t.Setenv("PORT", "7070")
+	t.Setenv("LOG_LEVEL", "warn")
+	t.Setenv("BACKEND_TYPE", "git")
+	t.Setenv("GIT_REPO_URL", "file:///tmp/repo.git")
+
+	path := writeConfig(t, "config.yaml", `
+version: 1
+server: {port: "9090"}
+backend:
+  type: memory
+  git: {branch: trunk}
+`)
+
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.Port != "7070" || cfg.Logging.Level != "warn" {
+		t.Errorf("expected environment to override file, got %+v %+v", cfg.Server, cfg.Logging)
+	}
+	if cfg.Backend.Type != "git" || cfg.Backend.Git.URL != "file:///tmp/repo.git" || cfg.Backend.Git.Branch != "trunk" {
+		t.Errorf("unexpected backend config: %+v %+v", cfg.Backend, cfg.Backend.Git)
+	}
+}
+
+func TestMountConfigEnv(t *testing.T) {
+	t.Setenv("S3_ENDPOINT", "http://localhost:9000")
+	t.Setenv("S3_ACCESS_KEY_ID", "key")
+	t.Setenv("S3_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("S3_BUCKET", "data")
+	t.Setenv("BACKEND_TYPE", "mount")
+	t.Setenv("MOUNT_CONFIG", writeConfig(t, "mounts.json", `{"mounts": [
+		{"prefix": "/", "backend": "memory"},
+		{"prefix": "/files", "backend": "s3", "s3_prefix": "files/", "strip_prefix": true}
+	]}`))
+
+	cfg, err := loadConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Backend.Mounts) != 2 {
+		t.Fatalf("expected 2 mounts, got %d", len(cfg.Backend.Mounts))
+	}
+	s3 := cfg.Backend.Mounts[1].Backend.S3
+	if s3.Bucket != "data" || s3.Prefix != "files/" {
+		t.Errorf("unexpected s3 config: %+v", s3)
+	}
+}
+
+func TestBackendURL(t *testing.T) {
+	path := writeConfig(t, "config.yaml", `
+version: 1
+backend:
+  type: mount
+  mounts:
+    - prefix: /
+      backend: {url: "mem://"}
+`)
+	if _, err := loadConfig(path); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("BACKEND_URL", "ftp://example.com")
+	_, err := loadConfig(path)
+	if err == nil || !strings.Contains(err.Error(), `backend.url: unknown scheme "ftp"`) {
+		t.Errorf("expected unknown scheme error, got %v", err)
+	}
+}
+
+func TestPersistentMemoryBackend(t *testing.T) {
+	dir := t.TempDir()
+	path := writeConfig(t, "config.yaml", "version: 1\nbackend:\n  type: memory\n  memory: {path: "+dir+", sync: never}\n")
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backend, cleanup, err := createBackend(cfg.Backend, slog.Default(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.POST(t.Context(), "/doc", []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	cleanup()
+
+	backend, cleanup, err = createBackend(cfg.Backend, slog.Default(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	if _, err := backend.GET(t.Context(), "/doc"); err != nil {
+		t.Errorf("expected the resource to persist, got %v", err)
+	}
+}
@@ -1,25 +1,58 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
 
 	"github.com/grafana/pyroscope-go"
+	"github.com/prometheus/client_golang/prometheus"
 	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
-	"github.com/theothertomelliott/git-backed-rest/backends/gitprotocol"
-	"github.com/theothertomelliott/git-backed-rest/backends/memory"
-	"github.com/theothertomelliott/git-backed-rest/backends/s3"
 	"github.com/theothertomelliott/git-backed-rest/server"
+	"github.com/theothertomelliott/git-backed-rest/tracing"
 )
 
+// idempotencySweepInterval is how often expired records are removed from a backend idempotency store
+const idempotencySweepInterval = time.Hour
+
 func main() {
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML or JSON config file (default $CONFIG_FILE)")
+	flag.Parse()
+
+	if err := run(*configPath); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run serves until SIGINT or SIGTERM, then shuts down gracefully: in-flight requests are given
+// until the shutdown timeout to complete, then webhooks, the backend, tracing and profiling are closed.
+func run(configPath string) error {
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		return fmt.Errorf("loading configuration: %w", err)
+	}
+
+	// The log level can be changed by reloading the config
+	logLevel := new(slog.LevelVar)
+	logger, err := newLogger(logLevel, cfg.Logging)
+	if err != nil {
+		return fmt.Errorf("configuring logging: %w", err)
+	}
+	slog.SetDefault(logger)
+
 	// Start Pyroscope profiling
-	pyroscopeAddress := getEnv("PYROSCOPE_ADDRESS", "http://localhost:4040")
-	if pyroscopeAddress != "" {
-		log.Printf("Starting Pyroscope profiling to %s", pyroscopeAddress)
+	if pyroscopeAddress := cfg.Profiling.PyroscopeAddress; pyroscopeAddress != "" {
+		logger.Info("starting Pyroscope profiling", "address", pyroscopeAddress)
 
-		_, err := pyroscope.Start(pyroscope.Config{
+		profiler, err := pyroscope.Start(pyroscope.Config{
 			ApplicationName: "git-backed-rest",
 			ServerAddress:   pyroscopeAddress,
 			// You can provide profiling tags, but we'll skip for now
@@ -32,113 +65,223 @@ func main() {
 			// },
 		})
 		if err != nil {
-			log.Printf("Failed to start Pyroscope: %v", err)
+			logger.Warn("failed to start Pyroscope", "error", err)
+		} else {
+			defer closeLogged(logger, "profiling", profiler.Stop)
 		}
 	}
 
-	// Get configuration from environment variables
-	port := getEnv("PORT", "8080")
-	backendType := getEnv("BACKEND_TYPE", "memory")
+	// Export traces if configured
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		Exporter: cfg.Tracing.Exporter,
+		Endpoint: cfg.Tracing.Endpoint,
+		Path:     cfg.Tracing.File,
+	})
+	if err != nil {
+		return fmt.Errorf("configuring tracing: %w", err)
+	}
+	defer closeLogged(logger, "tracing", func() error {
+		// Flush any buffered spans, including those from requests drained during shutdown
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return shutdownTracing(ctx)
+	})
+
+	logger.Info("starting server", "port", cfg.Server.Port, "backend", cfg.Backend.describe(), "config", configPath)
 
-	log.Printf("Starting server on port %s with %s backend", port, backendType)
+	// Server, webhook and backend metrics share a registry, served from /metrics
+	registry := server.NewRegistry()
+	backendMetrics := gitbackedrest.NewBackendMetrics(registry)
 
 	// Create backend based on type
-	backend, cleanup, err := createBackend(backendType)
+	backend, cleanup, err := createBackend(cfg.Backend, logger, backendMetrics)
 	if err != nil {
-		log.Fatalf("Failed to create backend: %v", err)
+		return fmt.Errorf("creating backend: %w", err)
 	}
 	if cleanup != nil {
+		// Runs after shutdown, once requests have finished. Backends wait for operations in progress,
+		// such as pushes, before closing.
 		defer cleanup()
 	}
 
+	opts := []server.Option{
+		server.WithLogger(logger),
+		server.WithRegistry(registry),
+		server.WithResourcePrefix(cfg.Server.ResourcePrefix),
+		server.WithAdminToken(cfg.Server.AdminToken),
+	}
+	var backendIdempotency *server.BackendIdempotencyStore
+	if idem := cfg.Server.Idempotency; idem != nil {
+		var store server.IdempotencyStore = server.NewMemoryIdempotencyStore()
+		if idem.Store == "backend" {
+			if _, ok := backend.(gitbackedrest.ConditionalWriter); !ok {
+				return fmt.Errorf("idempotency store %q requires a backend that supports conditional writes", idem.Store)
+			}
+			path := idem.Path
+			if path == "" {
+				path = "/.idempotency/"
+			}
+			backendIdempotency = server.NewBackendIdempotencyStore(backend, path)
+			store = backendIdempotency
+		}
+		opts = append(opts, server.WithIdempotency(store, idem.TTL))
+	}
+	if cfg.Webhooks != nil {
+		webhooks, err := createWebhookDispatcher(cfg.Webhooks, logger, registry)
+		if err != nil {
+			return fmt.Errorf("creating webhook dispatcher: %w", err)
+		}
+		// Persists undelivered webhooks for the next run
+		defer closeLogged(logger, "webhooks", webhooks.Close)
+		opts = append(opts, server.WithWebhooks(webhooks))
+	}
+
 	// Create server
-	srv := server.New(backend)
-	http.HandleFunc("/", srv.HandleRequest)
+	srv := server.New(backend, opts...)
+
+	go reloadOnHangup(configPath, cfg, logger, logLevel, srv)
 
-	// Create http.Server for proper shutdown
 	httpServer := &http.Server{
-		Addr:    ":" + port,
-		Handler: nil, // Use default ServeMux
+		Addr:    ":" + cfg.Server.Port,
+		Handler: srv,
 	}
+	// End watches and fail readiness checks as soon as shutdown starts
+	httpServer.RegisterOnShutdown(srv.Drain)
 
-	log.Printf("Server ready on http://localhost:%s", port)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	// Start server
-	if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		log.Fatalf("Server error: %v", err)
+	if backendIdempotency != nil {
+		go deleteExpiredIdempotencyRecords(ctx, backendIdempotency, logger)
 	}
-}
-
-func createBackend(backendType string) (gitbackedrest.APIBackend, func(), error) {
-	switch backendType {
-	case "memory":
-		return memory.NewBackend(), nil, nil
 
-	case "git":
-		return createGitBackend()
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- httpServer.ListenAndServe()
+	}()
+	logger.Info("server ready", "url", "http://localhost:"+cfg.Server.Port)
 
-	case "s3":
-		return createS3Backend()
+	select {
+	case err := <-serveErr:
+		return fmt.Errorf("server error: %w", err)
+	case <-ctx.Done():
+	}
+	// A second signal stops the process immediately
+	stop()
 
-	default:
-		log.Fatalf("Unknown backend type: %s. Supported: memory, git, s3", backendType)
-		return nil, nil, nil // This line won't be reached due to log.Fatalf
+	logger.Info("shutting down", "timeout", cfg.Server.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		// Closing connections cancels the remaining requests' contexts, stopping any retries
+		logger.Warn("requests still in progress at shutdown timeout, closing connections", "error", err)
+		httpServer.Close()
 	}
+
+	logger.Info("server stopped")
+	return nil
 }
 
-func createGitBackend() (gitbackedrest.APIBackend, func(), error) {
-	// Require explicit repository URL
-	testRepoURL := getEnv("GIT_REPO_URL", "")
-	if testRepoURL == "" {
-		log.Fatalf("GIT_REPO_URL environment variable must be set for git backend")
+// closeLogged calls close, logging any error
+func closeLogged(logger *slog.Logger, name string, close func() error) {
+	if err := close(); err != nil {
+		logger.Warn("error closing "+name, "error", err)
 	}
+}
 
-	// Use existing repository
-	auth, err := gitprotocol.GetAuthForEndpoint(testRepoURL)
-	if err != nil {
-		return nil, nil, err
-	}
+// deleteExpiredIdempotencyRecords removes expired idempotency records from the backend every
+// idempotencySweepInterval until ctx is done, so records for keys that are never reused don't accumulate.
+func deleteExpiredIdempotencyRecords(ctx context.Context, store *server.BackendIdempotencyStore, logger *slog.Logger) {
+	ticker := time.NewTicker(idempotencySweepInterval)
+	defer ticker.Stop()
 
-	backend, err := gitprotocol.NewBackendWithAuth(testRepoURL, auth)
-	if err != nil {
-		return nil, nil, err
-	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
 
-	return backend, nil, nil
+		deleted, err := store.DeleteExpired(ctx)
+		if err != nil && ctx.Err() == nil {
+			logger.Error("failed to delete expired idempotency records", "error", err)
+		}
+		if deleted > 0 {
+			logger.Info("deleted expired idempotency records", "count", deleted)
+		}
+	}
 }
 
-func createS3Backend() (gitbackedrest.APIBackend, func(), error) {
-	// Required S3 environment variables
-	endpoint := getEnv("S3_ENDPOINT", "")
-	accessKeyID := getEnv("S3_ACCESS_KEY_ID", "")
-	secretAccessKey := getEnv("S3_SECRET_ACCESS_KEY", "")
-	bucket := getEnv("S3_BUCKET", "")
+// reloadOnHangup reloads the config file whenever the process receives SIGHUP.
+// The log level and admin token are applied immediately, other changes are logged as requiring a restart.
+// An invalid file is reported and the running configuration is kept.
+func reloadOnHangup(path string, current *config, logger *slog.Logger, logLevel *slog.LevelVar, srv *server.Server) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
 
-	if endpoint == "" || accessKeyID == "" || secretAccessKey == "" || bucket == "" {
-		log.Fatalf("S3 backend requires: S3_ENDPOINT, S3_ACCESS_KEY_ID, S3_SECRET_ACCESS_KEY, S3_BUCKET")
-		return nil, nil, nil // This line won't be reached due to log.Fatalf
-	}
+	for range signals {
+		if path == "" {
+			logger.Warn("received SIGHUP, but no config file is set")
+			continue
+		}
 
-	// Optional prefix for namespace isolation
-	prefix := getEnv("S3_PREFIX", "")
+		next, err := loadConfig(path)
+		if err != nil {
+			logger.Error("failed to reload configuration, keeping the current configuration", "error", err)
+			continue
+		}
 
-	backend, err := s3.NewBackend(s3.Config{
-		Endpoint:        endpoint,
-		AccessKeyID:     accessKeyID,
-		SecretAccessKey: secretAccessKey,
-		Bucket:          bucket,
-		Prefix:          prefix,
-	})
-	if err != nil {
-		return nil, nil, err
+		if next.Logging.Level != current.Logging.Level {
+			// Validated by loadConfig
+			_ = logLevel.UnmarshalText([]byte(next.Logging.Level))
+			logger.Info("changed log level", "level", next.Logging.Level)
+		}
+		if next.Server.AdminToken != current.Server.AdminToken {
+			srv.SetAdminToken(next.Server.AdminToken)
+			logger.Info("changed admin token")
+		}
+
+		// Compare the remaining settings, which are only read at startup
+		restartNeeded := *next
+		restartNeeded.Logging.Level = current.Logging.Level
+		restartNeeded.Server.AdminToken = current.Server.AdminToken
+		if !reflect.DeepEqual(&restartNeeded, current) {
+			logger.Warn("configuration changes other than logging.level and server.admin_token require a restart")
+		}
+
+		current.Logging.Level = next.Logging.Level
+		current.Server.AdminToken = next.Server.AdminToken
+		logger.Info("reloaded configuration", "config", path)
 	}
+}
 
-	return backend, nil, nil
+func createWebhookDispatcher(cfg *webhooksConfig, logger *slog.Logger, registry *prometheus.Registry) (*server.WebhookDispatcher, error) {
+	logger.Info("delivering webhooks", "subscriptions", len(cfg.Subscriptions))
+	return server.NewWebhookDispatcher(server.WebhookConfig{
+		Subscriptions:   cfg.Subscriptions,
+		QueuePath:       cfg.QueuePath,
+		MaxAttempts:     cfg.MaxAttempts,
+		InitialInterval: cfg.InitialInterval,
+		MaxInterval:     cfg.MaxInterval,
+		Logger:          logger,
+		Registerer:      registry,
+	})
 }
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// newLogger creates a structured logger with the configured level (debug, info, warn or error)
+// and format (text or json). The level is stored in level, so it can be changed while running.
+func newLogger(level *slog.LevelVar, cfg loggingConfig) (*slog.Logger, error) {
+	if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+		return nil, fmt.Errorf("parsing log level: %w", err)
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	switch cfg.Format {
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q, supported: text, json", cfg.Format)
 	}
-	return defaultValue
 }
@@ -2,8 +2,37 @@ package gitbackedrest
 
 import (
 	"errors"
+	"net/http"
 )
 
+// Sentinel errors for common failures, for use with errors.Is.
+// An HTTPError with the corresponding status code matches the sentinel, and a wrapped sentinel
+// is given the corresponding status code by GetHTTPStatusCode.
+var (
+	// ErrNotFound indicates that a resource does not exist (404)
+	ErrNotFound = errors.New("not found")
+	// ErrConflict indicates that a resource already exists or was concurrently modified (409)
+	ErrConflict = errors.New("conflict")
+	// ErrPreconditionFailed indicates that a conditional request's precondition was not met (412)
+	ErrPreconditionFailed = errors.New("precondition failed")
+	// ErrTooLarge indicates that a request body exceeds the allowed size (413)
+	ErrTooLarge = errors.New("too large")
+	// ErrUnavailable indicates that the backend is temporarily unable to serve requests (503)
+	ErrUnavailable = errors.New("unavailable")
+)
+
+// sentinelCodes maps sentinel errors to their HTTP status codes
+var sentinelCodes = []struct {
+	err  error
+	code int
+}{
+	{ErrNotFound, http.StatusNotFound},
+	{ErrConflict, http.StatusConflict},
+	{ErrPreconditionFailed, http.StatusPreconditionFailed},
+	{ErrTooLarge, http.StatusRequestEntityTooLarge},
+	{ErrUnavailable, http.StatusServiceUnavailable},
+}
+
 // HTTPError wraps an error with an HTTP status code.
 type HTTPError struct {
 	Err  error
@@ -20,6 +49,17 @@ func (e *HTTPError) Unwrap() error {
 	return e.Err
 }
 
+// Is reports whether target is the sentinel error for the status code, so that
+// errors.Is(err, ErrNotFound) is true for a 404 HTTPError.
+func (e *HTTPError) Is(target error) bool {
+	for _, sentinel := range sentinelCodes {
+		if target == sentinel.err {
+			return e.Code == sentinel.code
+		}
+	}
+	return false
+}
+
 // NewHTTPError creates a new HTTPError with the given status code and underlying error.
 func NewHTTPError(code int, err error) error {
 	return &HTTPError{
@@ -53,13 +93,26 @@ func NewUserError(userMessage string, err error) error {
 }
 
 // GetHTTPStatusCode extracts the HTTP status code from an error if it's an HTTPError.
-// Returns the provided default code if no HTTPError is found.
+// Returns the provided default code if no HTTPError or sentinel error is found.
 func GetHTTPStatusCode(err error, defaultCode int) int {
+	if code, ok := httpStatusCode(err); ok {
+		return code
+	}
+	return defaultCode
+}
+
+// httpStatusCode returns the status code of an HTTPError in err, or of a wrapped sentinel error
+func httpStatusCode(err error) (int, bool) {
 	var httpErr *HTTPError
 	if errors.As(err, &httpErr) {
-		return httpErr.Code
+		return httpErr.Code, true
 	}
-	return defaultCode
+	for _, sentinel := range sentinelCodes {
+		if errors.Is(err, sentinel.err) {
+			return sentinel.code, true
+		}
+	}
+	return 0, false
 }
 
 // GetUserMessage extracts the user-friendly message from an error if it's a UserError.
@@ -73,12 +126,11 @@ func GetUserMessage(err error) string {
 }
 
 // HasHTTPStatusCode checks if an error contains any of the provided HTTP status codes.
-// Returns true if the error has an HTTPError with a status code that matches any in the provided set.
+// Returns true if the error has an HTTPError or sentinel error with a status code that matches any in the provided set.
 func HasHTTPStatusCode(err error, codes ...int) bool {
-	var httpErr *HTTPError
-	if errors.As(err, &httpErr) {
+	if statusCode, ok := httpStatusCode(err); ok {
 		for _, code := range codes {
-			if httpErr.Code == code {
+			if statusCode == code {
 				return true
 			}
 		}
@@ -0,0 +1,46 @@
+package gitbackedrest
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestSentinelErrors(t *testing.T) {
+	for _, test := range []struct {
+		sentinel error
+		code     int
+	}{
+		{ErrNotFound, http.StatusNotFound},
+		{ErrConflict, http.StatusConflict},
+		{ErrPreconditionFailed, http.StatusPreconditionFailed},
+		{ErrTooLarge, http.StatusRequestEntityTooLarge},
+		{ErrUnavailable, http.StatusServiceUnavailable},
+	} {
+		httpErr := NewUserError("message", NewHTTPError(test.code, errors.New("cause")))
+		if !errors.Is(httpErr, test.sentinel) {
+			t.Errorf("expected %d HTTPError to match %v", test.code, test.sentinel)
+		}
+		if errors.Is(NewHTTPError(http.StatusTeapot, errors.New("cause")), test.sentinel) {
+			t.Errorf("expected %d HTTPError not to match %v", http.StatusTeapot, test.sentinel)
+		}
+
+		wrapped := fmt.Errorf("doing something: %w", test.sentinel)
+		if code := GetHTTPStatusCode(wrapped, http.StatusInternalServerError); code != test.code {
+			t.Errorf("expected wrapped %v to have status %d, got %d", test.sentinel, test.code, code)
+		}
+		if !HasHTTPStatusCode(wrapped, test.code) {
+			t.Errorf("expected wrapped %v to have status %d", test.sentinel, test.code)
+		}
+
+		problem := NewProblem(test.code, "detail")
+		if !errors.Is(problem, test.sentinel) || GetHTTPStatusCode(problem, 0) != test.code {
+			t.Errorf("expected %d problem to match %v", test.code, test.sentinel)
+		}
+	}
+
+	if code := GetHTTPStatusCode(errors.New("other"), http.StatusInternalServerError); code != http.StatusInternalServerError {
+		t.Errorf("expected default status, got %d", code)
+	}
+}
@@ -0,0 +1,53 @@
+package gitbackedrest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// ETag returns the entity tag the server reports for a resource with the given content.
+// Tags are derived from the content alone, so identical content always has the same tag
+// and a client can compute the tag of a body it has written.
+func ETag(data []byte) string {
+	hash := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(hash[:16]) + `"`
+}
+
+// MatchETag reports whether the value of an If-Match or If-None-Match header matches etag.
+// The header may list several tags, or be "*" to match any existing resource.
+// Weak tags are compared by their opaque value.
+func MatchETag(header, etag string) bool {
+	for candidate := range strings.SplitSeq(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckIfMatch evaluates an If-Match header against the current content of a resource, for backends
+// implementing ConditionalWriter. It returns nil if the header matches, otherwise an error matching
+// ErrPreconditionFailed. A resource that does not exist matches no tag.
+func CheckIfMatch(ifMatch string, current []byte, exists bool) error {
+	if !exists {
+		return preconditionFailed("resource does not exist")
+	}
+	if !MatchETag(ifMatch, ETag(current)) {
+		return preconditionFailed("resource has been modified")
+	}
+	return nil
+}
+
+func preconditionFailed(reason string) error {
+	return NewUserError(
+		"Precondition Failed: "+reason,
+		NewHTTPError(
+			http.StatusPreconditionFailed,
+			errors.New(reason),
+		),
+	)
+}
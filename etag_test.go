@@ -0,0 +1,38 @@
+package gitbackedrest
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMatchETag(t *testing.T) {
+	etag := ETag([]byte("content"))
+	for _, test := range []struct {
+		header   string
+		expected bool
+	}{
+		{etag, true},
+		{"*", true},
+		{"W/" + etag, true},
+		{`"other", ` + etag, true},
+		{`"other"`, false},
+		{ETag([]byte("content2")), false},
+	} {
+		if got := MatchETag(test.header, etag); got != test.expected {
+			t.Errorf("MatchETag(%q): expected %v, got %v", test.header, test.expected, got)
+		}
+	}
+}
+
+func TestCheckIfMatch(t *testing.T) {
+	etag := ETag([]byte("content"))
+	if err := CheckIfMatch(etag, []byte("content"), true); err != nil {
+		t.Errorf("expected match, got %v", err)
+	}
+	if err := CheckIfMatch(etag, []byte("content2"), true); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("expected precondition failed for modified content, got %v", err)
+	}
+	if err := CheckIfMatch("*", nil, false); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("expected precondition failed for missing resource, got %v", err)
+	}
+}
@@ -0,0 +1,29 @@
+package gitbackedrest
+
+import "context"
+
+// EventType identifies the kind of change made to a resource.
+type EventType string
+
+const (
+	EventCreate EventType = "create"
+	EventUpdate EventType = "update"
+	EventDelete EventType = "delete"
+)
+
+// Event describes a single change to a resource.
+type Event struct {
+	Type EventType `json:"type"`
+	Path string    `json:"path"`
+	// Version identifies the state of the store after this event.
+	// It can be passed back as a resume token to receive only later events.
+	Version string `json:"version"`
+}
+
+// ChangeFeed is implemented by backends that can report changes to their resources,
+// including changes that were not made through this process.
+type ChangeFeed interface {
+	// Changes returns the events that occurred after the version since, along with
+	// the current version. If since is empty, no events are returned.
+	Changes(ctx context.Context, since string) ([]Event, string, error)
+}
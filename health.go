@@ -0,0 +1,15 @@
+package gitbackedrest
+
+import "context"
+
+// HealthChecker is implemented by backends that can check their underlying storage is reachable.
+type HealthChecker interface {
+	// CheckHealth returns an error if the backend cannot currently serve requests.
+	CheckHealth(ctx context.Context) error
+}
+
+// Diagnoser is implemented by backends that can report their internal state for debugging.
+type Diagnoser interface {
+	// Diagnostics returns a JSON-encodable snapshot of the backend's state.
+	Diagnostics(ctx context.Context) map[string]any
+}
@@ -0,0 +1,30 @@
+package gitbackedrest
+
+import (
+	"context"
+	"time"
+)
+
+// Version describes a change to a resource recorded by a backend's history.
+type Version struct {
+	// ID identifies the version, such as a commit hash, and can be passed to GETVersion
+	ID string `json:"id"`
+	// Time is when the version was written
+	Time time.Time `json:"time"`
+	// Author is who wrote the version, if known
+	Author string `json:"author,omitempty"`
+	// Message describes the change, such as a commit message
+	Message string `json:"message,omitempty"`
+	// Deleted is true if this version removed the resource
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// Historian is implemented by backends that retain previous versions of resources.
+type Historian interface {
+	// History returns the versions of the resource at path, newest first.
+	// A resource that has never existed has no versions.
+	History(ctx context.Context, path string) ([]Version, error)
+	// GETVersion returns the content of the resource at path as of the given version.
+	// A version in which the resource did not exist returns an error matching ErrNotFound.
+	GETVersion(ctx context.Context, path, version string) (*GetResult, error)
+}
@@ -0,0 +1,100 @@
+package gitbackedrest
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Label values shared by backend metrics.
+// Label names and values are part of the metrics' stable interface, so dashboards and alerts can depend on them.
+const (
+	// ResultSuccess and ResultError are the values of the "result" label for operations
+	ResultSuccess = "success"
+	ResultError   = "error"
+
+	// CacheHit and CacheMiss are the values of the "result" label for cache lookups
+	CacheHit  = "hit"
+	CacheMiss = "miss"
+
+	// OperationFetch and OperationPush are the "operation" labels for Git fetches and pushes
+	OperationFetch = "fetch"
+	OperationPush  = "push"
+)
+
+// BackendMetrics records metrics for the operations backends perform against their storage.
+// Every metric has a "backend" label identifying the backend implementation (e.g. gitprotocol or s3).
+//
+// A nil *BackendMetrics is valid and records nothing.
+type BackendMetrics struct {
+	operationDuration *prometheus.HistogramVec
+	packfileSize      *prometheus.HistogramVec
+	pushRejections    *prometheus.CounterVec
+	cacheLookups      *prometheus.CounterVec
+}
+
+// NewBackendMetrics creates backend metrics and registers them with reg.
+func NewBackendMetrics(reg prometheus.Registerer) *BackendMetrics {
+	m := &BackendMetrics{
+		operationDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "backend_operation_duration_seconds",
+			Help:    "Duration of calls made by backends to their storage, such as Git fetches and pushes or S3 API calls",
+			Buckets: prometheus.ExponentialBuckets(0.005, 2, 14),
+		}, []string{"backend", "operation", "result"}),
+		packfileSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "backend_packfile_size_bytes",
+			Help:    "Size of packfiles sent in Git pushes",
+			Buckets: prometheus.ExponentialBuckets(256, 4, 10),
+		}, []string{"backend"}),
+		pushRejections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "backend_push_rejections_total",
+			Help: "Total number of Git pushes rejected by the remote, by reason",
+		}, []string{"backend", "reason"}),
+		cacheLookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "backend_cache_lookups_total",
+			Help: "Total number of backend cache lookups, by result (hit or miss)",
+		}, []string{"backend", "cache", "result"}),
+	}
+	reg.MustRegister(m.operationDuration, m.packfileSize, m.pushRejections, m.cacheLookups)
+	return m
+}
+
+// ObserveOperation records the duration of an operation that started at start and finished with err.
+func (m *BackendMetrics) ObserveOperation(backend, operation string, start time.Time, err error) {
+	if m == nil {
+		return
+	}
+	result := ResultSuccess
+	if err != nil {
+		result = ResultError
+	}
+	m.operationDuration.WithLabelValues(backend, operation, result).Observe(time.Since(start).Seconds())
+}
+
+// ObservePackfileSize records the size in bytes of a pushed packfile.
+func (m *BackendMetrics) ObservePackfileSize(backend string, size int64) {
+	if m == nil {
+		return
+	}
+	m.packfileSize.WithLabelValues(backend).Observe(float64(size))
+}
+
+// PushRejected records a push rejected for the given reason.
+func (m *BackendMetrics) PushRejected(backend, reason string) {
+	if m == nil {
+		return
+	}
+	m.pushRejections.WithLabelValues(backend, reason).Inc()
+}
+
+// CacheLookup records a lookup in the named cache.
+func (m *BackendMetrics) CacheLookup(backend, cache string, hit bool) {
+	if m == nil {
+		return
+	}
+	result := CacheMiss
+	if hit {
+		result = CacheHit
+	}
+	m.cacheLookups.WithLabelValues(backend, cache, result).Inc()
+}
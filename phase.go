@@ -0,0 +1,63 @@
+package gitbackedrest
+
+import (
+	"context"
+	"log/slog"
+	"runtime/trace"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// TracerName is the instrumentation name used for spans created by this module
+const TracerName = "github.com/theothertomelliott/git-backed-rest"
+
+// Phase is a named part of a backend operation.
+// Phases are recorded as spans using the global OpenTelemetry tracer provider,
+// as regions for go tool trace, and their durations are logged at debug level.
+type Phase struct {
+	ctx    context.Context
+	name   string
+	logger *slog.Logger
+	start  time.Time
+	region *trace.Region
+	span   oteltrace.Span
+}
+
+// StartPhase starts a phase as a child of any span in ctx.
+// The returned context should be passed to nested operations so their phases become children of this one.
+func StartPhase(ctx context.Context, logger *slog.Logger, name string, attrs ...attribute.KeyValue) (context.Context, *Phase) {
+	ctx, span := otel.Tracer(TracerName).Start(ctx, name, oteltrace.WithAttributes(attrs...))
+	return ctx, &Phase{
+		ctx:    ctx,
+		name:   name,
+		logger: logger,
+		start:  time.Now(),
+		region: trace.StartRegion(ctx, name),
+		span:   span,
+	}
+}
+
+// SetAttributes adds attributes to the phase's span.
+func (p *Phase) SetAttributes(attrs ...attribute.KeyValue) {
+	p.span.SetAttributes(attrs...)
+}
+
+// SetError marks the phase as failed. A nil error is ignored.
+func (p *Phase) SetError(err error) {
+	if err == nil {
+		return
+	}
+	p.span.RecordError(err)
+	p.span.SetStatus(codes.Error, err.Error())
+}
+
+// End completes the phase.
+func (p *Phase) End() {
+	p.region.End()
+	p.span.End()
+	Logger(p.ctx, p.logger).DebugContext(p.ctx, "phase completed", "phase", p.name, "duration", time.Since(p.start))
+}
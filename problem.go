@@ -0,0 +1,55 @@
+package gitbackedrest
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ProblemContentType is the media type of error responses, as defined by RFC 9457
+const ProblemContentType = "application/problem+json"
+
+// Problem is an RFC 9457 problem details object, returned by the server for failed requests.
+// The type is omitted, and so is "about:blank": the status code identifies the problem.
+type Problem struct {
+	// Title is the text for the status code, e.g. "Not Found"
+	Title string `json:"title"`
+	// Status is the HTTP status code
+	Status int `json:"status"`
+	// Detail is a message describing this occurrence of the problem
+	Detail string `json:"detail,omitempty"`
+	// Instance is the path of the request
+	Instance string `json:"instance,omitempty"`
+	// RequestID identifies the request in server logs
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// NewProblem creates a problem for the status code, with a title from http.StatusText
+func NewProblem(status int, detail string) *Problem {
+	return &Problem{
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+}
+
+// Error implements the error interface.
+func (p *Problem) Error() string {
+	message := fmt.Sprintf("%d %s", p.Status, p.Title)
+	if p.Detail != "" {
+		message += ": " + p.Detail
+	}
+	if p.RequestID != "" {
+		message += fmt.Sprintf(" (request ID %s)", p.RequestID)
+	}
+	return message
+}
+
+// Unwrap returns the problem as an HTTPError, so it matches the sentinel error for its status
+// and GetHTTPStatusCode returns its status.
+func (p *Problem) Unwrap() error {
+	return &HTTPError{
+		Code: p.Status,
+		Err:  errors.New(p.Detail),
+	}
+}
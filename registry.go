@@ -0,0 +1,128 @@
+package gitbackedrest
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Opener creates a backend from a URL with a registered scheme.
+// The returned backend must be ready to serve requests.
+type Opener func(ctx context.Context, u *url.URL, cfg OpenConfig) (APIBackend, error)
+
+// OpenConfig holds settings shared by all backends, set with OpenOptions
+type OpenConfig struct {
+	// Logger is used by the backend. If nil, the default slog logger is used.
+	Logger *slog.Logger
+	// Metrics records the backend's operations. If nil, no metrics are recorded.
+	Metrics *BackendMetrics
+}
+
+// OpenOption configures a backend created by Open
+type OpenOption func(*OpenConfig)
+
+// OpenWithLogger sets the logger used by the backend.
+func OpenWithLogger(logger *slog.Logger) OpenOption {
+	return func(cfg *OpenConfig) {
+		cfg.Logger = logger
+	}
+}
+
+// OpenWithMetrics records the backend's operations in metrics.
+func OpenWithMetrics(metrics *BackendMetrics) OpenOption {
+	return func(cfg *OpenConfig) {
+		cfg.Metrics = metrics
+	}
+}
+
+var (
+	openersMtx sync.RWMutex
+	openers    = make(map[string]Opener)
+)
+
+// Register makes a backend available to Open for URLs with the given scheme.
+// Backend packages register their schemes in init, so they must be imported for their schemes
+// to be available, as with database/sql drivers. The backends/all package imports every backend.
+//
+// Register panics if the scheme is already registered or opener is nil.
+func Register(scheme string, opener Opener) {
+	openersMtx.Lock()
+	defer openersMtx.Unlock()
+
+	scheme = strings.ToLower(scheme)
+	if opener == nil {
+		panic("gitbackedrest: Register opener is nil for scheme " + scheme)
+	}
+	if _, exists := openers[scheme]; exists {
+		panic("gitbackedrest: Register called twice for scheme " + scheme)
+	}
+	openers[scheme] = opener
+}
+
+// Schemes returns the sorted list of registered URL schemes.
+func Schemes() []string {
+	openersMtx.RLock()
+	defer openersMtx.RUnlock()
+
+	var schemes []string
+	for scheme := range openers {
+		schemes = append(schemes, scheme)
+	}
+	slices.Sort(schemes)
+	return schemes
+}
+
+// Open creates a backend from a URL, using the backend registered for the URL's scheme.
+// For example:
+//
+//	mem://
+//	git+https://github.com/acme/config?branch=main
+//	git+ssh://git@github.com/acme/config
+//	gitcli+file:///srv/repos/config.git
+//	s3://bucket/prefix?endpoint=https://s3.example.com
+func Open(ctx context.Context, rawURL string, opts ...OpenOption) (APIBackend, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing backend URL: %w", err)
+	}
+	if u.Scheme == "" {
+		return nil, fmt.Errorf("backend URL %q has no scheme, registered schemes: %s", Redact(u), strings.Join(Schemes(), ", "))
+	}
+
+	openersMtx.RLock()
+	opener, ok := openers[u.Scheme]
+	openersMtx.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown backend scheme %q, registered schemes: %s", u.Scheme, strings.Join(Schemes(), ", "))
+	}
+
+	var cfg OpenConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	backend, err := opener(ctx, u, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", Redact(u), err)
+	}
+	return backend, nil
+}
+
+// Redact returns the URL as a string with any password or secret query parameters masked,
+// for use in logs and errors.
+func Redact(u *url.URL) string {
+	redacted := *u
+	query := redacted.Query()
+	for key := range query {
+		lower := strings.ToLower(key)
+		if strings.Contains(lower, "secret") || strings.Contains(lower, "token") || strings.Contains(lower, "password") {
+			query.Set(key, "xxxxx")
+		}
+	}
+	redacted.RawQuery = query.Encode()
+	return redacted.Redacted()
+}
@@ -0,0 +1,62 @@
+package gitbackedrest
+
+import (
+	"context"
+	"log/slog"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+type testBackend struct {
+	APIBackend
+	url *url.URL
+	cfg OpenConfig
+}
+
+func TestOpen(t *testing.T) {
+	Register("test+open", func(ctx context.Context, u *url.URL, cfg OpenConfig) (APIBackend, error) {
+		return &testBackend{url: u, cfg: cfg}, nil
+	})
+
+	logger := slog.Default()
+	backend, err := Open(t.Context(), "test+open://host/path?option=value", OpenWithLogger(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opened := backend.(*testBackend)
+	if opened.url.Host != "host" || opened.url.Path != "/path" || opened.url.Query().Get("option") != "value" {
+		t.Errorf("unexpected URL: %v", opened.url)
+	}
+	if opened.cfg.Logger != logger {
+		t.Error("expected logger to be passed to opener")
+	}
+
+	_, err = Open(t.Context(), "unknown://host")
+	if err == nil || !strings.Contains(err.Error(), "test+open") {
+		t.Errorf("expected error listing registered schemes, got %v", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected duplicate registration to panic")
+		}
+	}()
+	Register("test+open", func(ctx context.Context, u *url.URL, cfg OpenConfig) (APIBackend, error) {
+		return nil, nil
+	})
+}
+
+func TestRedact(t *testing.T) {
+	u, err := url.Parse("s3://key:secret@bucket/prefix?endpoint=https://s3.example.com&secret_access_key=abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	redacted := Redact(u)
+	if strings.Contains(redacted, "secret@") || strings.Contains(redacted, "abc") {
+		t.Errorf("expected credentials to be redacted, got %s", redacted)
+	}
+	if !strings.Contains(redacted, "bucket/prefix") {
+		t.Errorf("expected bucket and prefix to be kept, got %s", redacted)
+	}
+}
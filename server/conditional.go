@@ -0,0 +1,114 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"sync"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+// keyedMutex serializes work sharing a key, such as writes to the same path.
+// The zero value is ready to use.
+type keyedMutex struct {
+	mtx   sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock blocks until no other holder of key remains, returning a function to unlock it
+func (m *keyedMutex) lock(key string) func() {
+	m.mtx.Lock()
+	if m.locks == nil {
+		m.locks = make(map[string]*keyLock)
+	}
+	l, ok := m.locks[key]
+	if !ok {
+		l = &keyLock{}
+		m.locks[key] = l
+	}
+	l.refs++
+	m.mtx.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+
+		m.mtx.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(m.locks, key)
+		}
+		m.mtx.Unlock()
+	}
+}
+
+// put replaces the request's resource with body. If the request has an If-Match header, the backend checks it
+// atomically with the write, so it holds against writes made through other replicas or directly to the backend.
+func (s *Server) put(r *http.Request, body []byte) (*gitbackedrest.Result, error) {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		return s.backend.PUT(r.Context(), r.URL.Path, body)
+	}
+	writer, err := s.conditionalWriter()
+	if err != nil {
+		return nil, err
+	}
+	return writer.PUTIfMatch(r.Context(), r.URL.Path, body, ifMatch)
+}
+
+// delete deletes the request's resource, checking any If-Match header as put does
+func (s *Server) delete(r *http.Request) (*gitbackedrest.Result, error) {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		return s.backend.DELETE(r.Context(), r.URL.Path)
+	}
+	writer, err := s.conditionalWriter()
+	if err != nil {
+		return nil, err
+	}
+	return writer.DELETEIfMatch(r.Context(), r.URL.Path, ifMatch)
+}
+
+// conditionalWriter returns the backend as a ConditionalWriter. Backends that can't check a precondition
+// atomically with a write reject conditional writes, rather than check them in a way that could let another
+// write through.
+func (s *Server) conditionalWriter() (gitbackedrest.ConditionalWriter, error) {
+	writer, ok := s.backend.(gitbackedrest.ConditionalWriter)
+	if !ok {
+		return nil, gitbackedrest.NewUserError(
+			"Conditional writes are not supported by this backend",
+			gitbackedrest.NewHTTPError(
+				http.StatusNotImplemented,
+				errors.New("backend cannot check If-Match atomically with writes"),
+			),
+		)
+	}
+	return writer, nil
+}
+
+// handleList serves a GET of a collection path, listing the resources beneath it
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) (string, int) {
+	lister, ok := s.backend.(gitbackedrest.Lister)
+	if !ok {
+		writeProblem(w, r, http.StatusNotImplemented, "Listing is not supported by this backend")
+		return "error", 0
+	}
+
+	paths, err := lister.List(r.Context(), r.URL.Path)
+	if err != nil {
+		return s.handleError(w, r, err)
+	}
+	paths = slices.DeleteFunc(paths, s.reserved)
+	if paths == nil {
+		paths = []string{}
+	}
+
+	writeJSON(w, http.StatusOK, gitbackedrest.Listing{Paths: paths})
+	return "success", 0
+}
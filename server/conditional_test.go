@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+	"github.com/theothertomelliott/git-backed-rest/backends/memory"
+)
+
+func TestConditionalRequests(t *testing.T) {
+	server := &Server{
+		backend: memory.NewBackend(),
+	}
+	if _, err := server.backend.POST(context.Background(), "/doc1", []byte("content1")); err != nil {
+		t.Fatal(err)
+	}
+	current := gitbackedrest.ETag([]byte("content1"))
+
+	for _, test := range []struct {
+		name     string
+		method   string
+		header   string
+		value    string
+		body     string
+		expected int
+	}{
+		{"GET reports tag", http.MethodGet, "", "", "", http.StatusOK},
+		{"GET not modified", http.MethodGet, "If-None-Match", current, "", http.StatusNotModified},
+		{"GET modified", http.MethodGet, "If-None-Match", `"other"`, "", http.StatusOK},
+		{"PUT stale tag", http.MethodPut, "If-Match", `"other"`, "content2", http.StatusPreconditionFailed},
+		{"DELETE stale tag", http.MethodDelete, "If-Match", `"other"`, "", http.StatusPreconditionFailed},
+		{"PUT current tag", http.MethodPut, "If-Match", current, "content2", http.StatusNoContent},
+		{"PUT replaced tag", http.MethodPut, "If-Match", current, "content3", http.StatusPreconditionFailed},
+		{"DELETE any tag", http.MethodDelete, "If-Match", "*", "", http.StatusNoContent},
+		{"PUT missing resource", http.MethodPut, "If-Match", "*", "content4", http.StatusPreconditionFailed},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, "/doc1", strings.NewReader(test.body))
+			if test.header != "" {
+				req.Header.Set(test.header, test.value)
+			}
+			resp := httptest.NewRecorder()
+			server.HandleRequest(resp, req)
+
+			if resp.Code != test.expected {
+				t.Fatalf("expected status code %d, got %d: %v", test.expected, resp.Code, resp.Body)
+			}
+			if test.method == http.MethodGet && resp.Header().Get("ETag") != current {
+				t.Errorf("expected ETag %s, got %q", current, resp.Header().Get("ETag"))
+			}
+			if test.method == http.MethodPut && resp.Code == http.StatusNoContent {
+				current = gitbackedrest.ETag([]byte(test.body))
+				if resp.Header().Get("ETag") != current {
+					t.Errorf("expected ETag %s, got %q", current, resp.Header().Get("ETag"))
+				}
+			}
+		})
+	}
+}
+
+func TestList(t *testing.T) {
+	server := &Server{
+		backend: memory.NewBackend(),
+	}
+	for _, path := range []string{"/users/alice", "/users/bob", "/groups/admins"} {
+		if _, err := server.backend.POST(context.Background(), path, []byte(path)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, test := range []struct {
+		path     string
+		expected []string
+	}{
+		{"/", []string{"/groups/admins", "/users/alice", "/users/bob"}},
+		{"/users/", []string{"/users/alice", "/users/bob"}},
+		{"/missing/", []string{}},
+	} {
+		resp := httptest.NewRecorder()
+		server.HandleRequest(resp, httptest.NewRequest(http.MethodGet, test.path, nil))
+		if resp.Code != http.StatusOK {
+			t.Fatalf("GET %s: expected status code %d, got %d: %v", test.path, http.StatusOK, resp.Code, resp.Body)
+		}
+
+		var listing gitbackedrest.Listing
+		if err := json.NewDecoder(resp.Body).Decode(&listing); err != nil {
+			t.Fatal(err)
+		}
+		if listing.Paths == nil || !slices.Equal(listing.Paths, test.expected) {
+			t.Errorf("GET %s: expected %v, got %v", test.path, test.expected, listing.Paths)
+		}
+	}
+}
+
+// unlistableBackend hides the Lister implementation of the memory backend
+type unlistableBackend struct {
+	gitbackedrest.APIBackend
+}
+
+func TestListUnsupported(t *testing.T) {
+	server := &Server{
+		backend: unlistableBackend{memory.NewBackend()},
+	}
+
+	resp := httptest.NewRecorder()
+	server.HandleRequest(resp, httptest.NewRequest(http.MethodGet, "/users/", nil))
+	if resp.Code != http.StatusNotImplemented {
+		t.Errorf("expected status code %d, got %d: %v", http.StatusNotImplemented, resp.Code, resp.Body)
+	}
+}
+
+func TestConditionalWriteUnsupported(t *testing.T) {
+	backend := memory.NewBackend()
+	if _, err := backend.POST(context.Background(), "/doc1", []byte("content1")); err != nil {
+		t.Fatal(err)
+	}
+	// Embedding the interface hides the memory backend's conditional writes
+	server := &Server{
+		backend: unlistableBackend{backend},
+	}
+
+	for _, method := range []string{http.MethodPut, http.MethodDelete} {
+		req := httptest.NewRequest(method, "/doc1", strings.NewReader("content2"))
+		req.Header.Set("If-Match", gitbackedrest.ETag([]byte("content1")))
+		resp := httptest.NewRecorder()
+		server.HandleRequest(resp, req)
+		if resp.Code != http.StatusNotImplemented {
+			t.Errorf("%s: expected status code %d, got %d: %v", method, http.StatusNotImplemented, resp.Code, resp.Body)
+		}
+	}
+
+	result, err := backend.GET(context.Background(), "/doc1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result.Data) != "content1" {
+		t.Errorf("expected resource to be unchanged, got %q", result.Data)
+	}
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+// readinessTimeout bounds how long a readiness check waits for the backend
+const readinessTimeout = 5 * time.Second
+
+// healthResponse is the body of health and readiness responses. Probes are unauthenticated, so it never
+// includes error details, which could reveal endpoints or credentials.
+type healthResponse struct {
+	Status string `json:"status"`
+}
+
+// diagnosticsResponse is the body of the diagnostics endpoint
+type diagnosticsResponse struct {
+	StartTime time.Time      `json:"start_time"`
+	Uptime    string         `json:"uptime"`
+	Backend   map[string]any `json:"backend,omitempty"`
+}
+
+// handleHealthz reports that the process is alive, without checking the backend
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, healthResponse{Status: "ok"})
+}
+
+// handleReadyz reports whether the backend is reachable, if it implements gitbackedrest.HealthChecker.
+// A draining server is never ready. Why the backend isn't reachable is logged, and not returned to the caller.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if s.draining() {
+		writeJSON(w, http.StatusServiceUnavailable, healthResponse{Status: "draining"})
+		return
+	}
+
+	checker, ok := s.backend.(gitbackedrest.HealthChecker)
+	if !ok {
+		writeJSON(w, http.StatusOK, healthResponse{Status: "ok"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	if err := checker.CheckHealth(ctx); err != nil {
+		s.log(ctx).WarnContext(ctx, "readiness check failed", "error", err)
+		writeJSON(w, http.StatusServiceUnavailable, healthResponse{Status: "not ready"})
+		return
+	}
+	writeJSON(w, http.StatusOK, healthResponse{Status: "ok"})
+}
+
+// handleDiagnostics reports the server's and backend's internal state.
+// It is only available when an admin token is configured, and requires that token as a bearer token.
+func (s *Server) handleDiagnostics(w http.ResponseWriter, r *http.Request) {
+	adminToken := s.adminToken.Load()
+	if adminToken == nil || *adminToken == "" {
+		notFound(w, r)
+		return
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(*adminToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeProblem(w, r, http.StatusUnauthorized, "A valid admin token is required")
+		return
+	}
+
+	startTime := s.metrics.GetStartTime()
+	response := diagnosticsResponse{
+		StartTime: startTime,
+		Uptime:    time.Since(startTime).Round(time.Second).String(),
+	}
+	if diagnoser, ok := s.backend.(gitbackedrest.Diagnoser); ok {
+		response.Backend = diagnoser.Diagnostics(r.Context())
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
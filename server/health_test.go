@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/theothertomelliott/git-backed-rest/backends/memory"
+)
+
+// checkedBackend is a backend with a configurable health check and diagnostics
+type checkedBackend struct {
+	*memory.Backend
+	err error
+}
+
+func (b checkedBackend) CheckHealth(ctx context.Context) error {
+	return b.err
+}
+
+func (b checkedBackend) Diagnostics(ctx context.Context) map[string]any {
+	return map[string]any{"ref": "abc123"}
+}
+
+func TestHealthz(t *testing.T) {
+	server := New(checkedBackend{memory.NewBackend(), errors.New("unreachable")})
+
+	resp := httptest.NewRecorder()
+	server.HandleRequest(resp, httptest.NewRequest("GET", "/healthz", nil))
+	if resp.Code != http.StatusOK {
+		t.Errorf("expected status code %d, got %d", http.StatusOK, resp.Code)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		server   *Server
+		expected int
+	}{
+		{"no checker", New(memory.NewBackend()), http.StatusOK},
+		{"healthy", New(checkedBackend{memory.NewBackend(), nil}), http.StatusOK},
+		{"unhealthy", New(checkedBackend{memory.NewBackend(), errors.New("unreachable")}), http.StatusServiceUnavailable},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			test.server.HandleRequest(resp, httptest.NewRequest("GET", "/readyz", nil))
+			if resp.Code != test.expected {
+				t.Errorf("expected status code %d, got %d: %s", test.expected, resp.Code, resp.Body)
+			}
+			if strings.Contains(resp.Body.String(), "unreachable") {
+				t.Errorf("expected the backend's error not to be returned, got %s", resp.Body)
+			}
+		})
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	backend := checkedBackend{memory.NewBackend(), nil}
+
+	resp := httptest.NewRecorder()
+	New(backend).HandleRequest(resp, httptest.NewRequest("GET", "/_diagnostics", nil))
+	if resp.Code != http.StatusNotFound {
+		t.Errorf("expected diagnostics to be disabled without an admin token, got %d", resp.Code)
+	}
+
+	server := New(backend, WithAdminToken("secret"))
+
+	resp = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/_diagnostics", nil)
+	req.Header.Set("Authorization", "Bearer wrong")
+	server.HandleRequest(resp, req)
+	if resp.Code != http.StatusUnauthorized {
+		t.Errorf("expected status code %d, got %d", http.StatusUnauthorized, resp.Code)
+	}
+
+	resp = httptest.NewRecorder()
+	req.Header.Set("Authorization", "Bearer secret")
+	server.HandleRequest(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d", http.StatusOK, resp.Code)
+	}
+
+	var diagnostics diagnosticsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&diagnostics); err != nil {
+		t.Fatal(err)
+	}
+	if diagnostics.Backend["ref"] != "abc123" {
+		t.Errorf("expected backend diagnostics, got %v", diagnostics.Backend)
+	}
+}
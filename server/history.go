@@ -0,0 +1,92 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+// historyResponse is the body returned for the history of a resource
+type historyResponse struct {
+	Versions []gitbackedrest.Version `json:"versions"`
+}
+
+// historian returns the backend's history, writing a problem response if it has none
+func (s *Server) historian(w http.ResponseWriter, r *http.Request) (gitbackedrest.Historian, bool) {
+	historian, ok := s.backend.(gitbackedrest.Historian)
+	if !ok {
+		writeProblem(w, r, http.StatusNotImplemented, "History is not supported by this backend")
+	}
+	return historian, ok
+}
+
+// handleHistory serves a GET of a resource with the history query parameter, listing its versions
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) (string, int) {
+	historian, ok := s.historian(w, r)
+	if !ok {
+		return "error", 0
+	}
+
+	versions, err := historian.History(r.Context(), r.URL.Path)
+	if err != nil {
+		return s.handleError(w, r, err)
+	}
+	if versions == nil {
+		versions = []gitbackedrest.Version{}
+	}
+	writeJSON(w, http.StatusOK, historyResponse{Versions: versions})
+	return "success", 0
+}
+
+// handleGETVersion serves a GET of a resource with the version query parameter, returning its content as of that version
+func (s *Server) handleGETVersion(w http.ResponseWriter, r *http.Request, version string) (string, int) {
+	historian, ok := s.historian(w, r)
+	if !ok {
+		return "error", 0
+	}
+
+	result, err := historian.GETVersion(r.Context(), r.URL.Path, version)
+	if err != nil {
+		return s.handleError(w, r, err)
+	}
+
+	w.Header().Set("ETag", gitbackedrest.ETag(result.Data))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(result.Data)
+	return "success", result.Retries
+}
+
+// handleRestore serves a PUT of a resource with the restore query parameter, writing its content as of that version
+// as a new version. A resource deleted since is recreated.
+func (s *Server) handleRestore(w http.ResponseWriter, r *http.Request, version string) (string, int) {
+	historian, ok := s.historian(w, r)
+	if !ok {
+		return "error", 0
+	}
+
+	unlock := s.writeLocks.lock(r.URL.Path)
+	defer unlock()
+
+	previous, err := historian.GETVersion(r.Context(), r.URL.Path, version)
+	if err != nil {
+		return s.handleError(w, r, err)
+	}
+
+	// With If-Match, a resource deleted since fails the precondition rather than being recreated
+	eventType := gitbackedrest.EventUpdate
+	result, err := s.put(r, previous.Data)
+	if errors.Is(err, gitbackedrest.ErrNotFound) {
+		eventType = gitbackedrest.EventCreate
+		result, err = s.backend.POST(r.Context(), r.URL.Path, previous.Data)
+	}
+	if err != nil {
+		return s.handleError(w, r, err)
+	}
+
+	s.recordChange(r, eventType)
+	w.Header().Set("ETag", gitbackedrest.ETag(previous.Data))
+	w.WriteHeader(http.StatusNoContent)
+	return "success", previous.Retries + result.Retries
+}
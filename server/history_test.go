@@ -0,0 +1,169 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+	"github.com/theothertomelliott/git-backed-rest/backends/memory"
+)
+
+// historyBackend serves fixed versions of /doc1
+type historyBackend struct {
+	*memory.Backend
+	versions map[string]string
+}
+
+func (h *historyBackend) History(ctx context.Context, path string) ([]gitbackedrest.Version, error) {
+	if path != "/doc1" {
+		return nil, nil
+	}
+	return []gitbackedrest.Version{
+		{ID: "v2", Time: time.Unix(2, 0).UTC(), Message: "update"},
+		{ID: "v1", Time: time.Unix(1, 0).UTC(), Message: "create"},
+	}, nil
+}
+
+func (h *historyBackend) GETVersion(ctx context.Context, path, version string) (*gitbackedrest.GetResult, error) {
+	data, ok := h.versions[version]
+	if path != "/doc1" || !ok {
+		return nil, gitbackedrest.NewUserError("Not Found", gitbackedrest.NewHTTPError(http.StatusNotFound, errors.New("version not found")))
+	}
+	return &gitbackedrest.GetResult{Data: []byte(data)}, nil
+}
+
+func TestHistory(t *testing.T) {
+	server := &Server{
+		backend: &historyBackend{
+			Backend:  memory.NewBackend(),
+			versions: map[string]string{"v1": "content1", "v2": "content2"},
+		},
+	}
+
+	resp := httptest.NewRecorder()
+	server.HandleRequest(resp, httptest.NewRequest(http.MethodGet, "/doc1?history", nil))
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d: %v", http.StatusOK, resp.Code, resp.Body)
+	}
+	var history historyResponse
+	if err := json.NewDecoder(resp.Body).Decode(&history); err != nil {
+		t.Fatal(err)
+	}
+	if len(history.Versions) != 2 || history.Versions[0].ID != "v2" || history.Versions[1].Message != "create" {
+		t.Errorf("unexpected versions: %+v", history.Versions)
+	}
+
+	for _, test := range []struct {
+		path     string
+		expected int
+		body     string
+	}{
+		{"/doc1?version=v1", http.StatusOK, "content1"},
+		{"/doc1?version=v2", http.StatusOK, "content2"},
+		{"/doc1?version=v3", http.StatusNotFound, ""},
+		{"/doc2?history", http.StatusOK, `{"versions":[]}` + "\n"},
+	} {
+		resp := httptest.NewRecorder()
+		server.HandleRequest(resp, httptest.NewRequest(http.MethodGet, test.path, nil))
+		if resp.Code != test.expected {
+			t.Errorf("GET %s: expected status code %d, got %d: %v", test.path, test.expected, resp.Code, resp.Body)
+			continue
+		}
+		if test.body != "" && resp.Body.String() != test.body {
+			t.Errorf("GET %s: expected %q, got %q", test.path, test.body, resp.Body)
+		}
+	}
+}
+
+// historylessBackend hides the Historian implementation of the memory backend
+type historylessBackend struct {
+	gitbackedrest.APIBackend
+}
+
+func TestHistoryUnsupported(t *testing.T) {
+	server := &Server{
+		backend: historylessBackend{memory.NewBackend()},
+	}
+
+	for _, test := range []struct {
+		method string
+		path   string
+	}{
+		{http.MethodGet, "/doc1?history"},
+		{http.MethodGet, "/doc1?version=v1"},
+		{http.MethodPut, "/doc1?restore=v1"},
+	} {
+		resp := httptest.NewRecorder()
+		server.HandleRequest(resp, httptest.NewRequest(test.method, test.path, nil))
+		if resp.Code != http.StatusNotImplemented {
+			t.Errorf("%s %s: expected status code %d, got %d: %v", test.method, test.path, http.StatusNotImplemented, resp.Code, resp.Body)
+		}
+	}
+}
+
+func TestRestore(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		current  string
+		version  string
+		ifMatch  string
+		expected int
+	}{
+		{name: "restore over current content", current: "content2", version: "v1", expected: http.StatusNoContent},
+		{name: "restore deleted resource", version: "v1", expected: http.StatusNoContent},
+		{name: "unknown version", current: "content2", version: "v3", expected: http.StatusNotFound},
+		{name: "matching precondition", current: "content2", version: "v1", ifMatch: gitbackedrest.ETag([]byte("content2")), expected: http.StatusNoContent},
+		{name: "failed precondition", current: "content2", version: "v1", ifMatch: gitbackedrest.ETag([]byte("content1")), expected: http.StatusPreconditionFailed},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			backend := memory.NewBackend()
+			if test.current != "" {
+				if _, err := backend.POST(t.Context(), "/doc1", []byte(test.current)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			server := &Server{
+				backend: &historyBackend{
+					Backend:  backend,
+					versions: map[string]string{"v1": "content1", "v2": "content2"},
+				},
+			}
+
+			req := httptest.NewRequest(http.MethodPut, "/doc1?restore="+test.version, nil)
+			if test.ifMatch != "" {
+				req.Header.Set("If-Match", test.ifMatch)
+			}
+			resp := httptest.NewRecorder()
+			server.HandleRequest(resp, req)
+			if resp.Code != test.expected {
+				t.Fatalf("expected status code %d, got %d: %v", test.expected, resp.Code, resp.Body)
+			}
+
+			expected := test.current
+			if test.expected == http.StatusNoContent {
+				expected = "content1"
+				if etag := resp.Header().Get("ETag"); etag != gitbackedrest.ETag([]byte(expected)) {
+					t.Errorf("expected ETag of restored content, got %q", etag)
+				}
+			}
+			result, err := backend.GET(t.Context(), "/doc1")
+			if expected == "" {
+				if !errors.Is(err, gitbackedrest.ErrNotFound) {
+					t.Errorf("expected resource to remain deleted, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(result.Data) != expected {
+				t.Errorf("expected %q, got %q", expected, result.Data)
+			}
+		})
+	}
+}
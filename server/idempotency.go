@@ -0,0 +1,440 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+const (
+	// IdempotencyKeyHeader is the header clients use to make writes safe to retry
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader is set to "true" on responses replayed for a duplicate key
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	// maxIdempotencyKeyLength limits the size of idempotency keys
+	maxIdempotencyKeyLength = 255
+
+	// DefaultIdempotencyTTL is how long outcomes are remembered if no TTL is given
+	DefaultIdempotencyTTL = 24 * time.Hour
+
+	// idempotencyClaimTTL is how long a claim on a key is held for a request in progress, after which
+	// the key can be claimed again, e.g. if the replica handling the request stopped
+	idempotencyClaimTTL = 5 * time.Minute
+)
+
+// IdempotencyRecord is the remembered outcome of a write made with an idempotency key
+type IdempotencyRecord struct {
+	// Fingerprint identifies the request's method, path and body
+	Fingerprint string    `json:"fingerprint"`
+	Status      int       `json:"status"`
+	ContentType string    `json:"content_type,omitempty"`
+	Body        []byte    `json:"body,omitempty"`
+	Expires     time.Time `json:"expires"`
+	// InProgress is set on a claim, recorded while the write is being made
+	InProgress bool `json:"in_progress,omitempty"`
+}
+
+// IdempotencyStore stores the outcomes of keyed writes.
+//
+// A request claims its key before making the write, and completes the claim with its outcome afterwards.
+// Claims are atomic, so only one request with a key makes the write, even across replicas sharing a store.
+type IdempotencyStore interface {
+	// Claim stores claim for key if there is no live record for key, and returns nil.
+	// Otherwise it returns the live record, which may be another request's claim.
+	Claim(ctx context.Context, key string, claim *IdempotencyRecord) (*IdempotencyRecord, error)
+	// Complete replaces claim with the outcome of the write. It fails if the claim has been replaced.
+	Complete(ctx context.Context, key string, claim, record *IdempotencyRecord) error
+	// Release removes claim, so that the write can be retried. It does nothing if the claim has been replaced.
+	Release(ctx context.Context, key string, claim *IdempotencyRecord) error
+}
+
+// WithIdempotency remembers the outcome of writes made with an Idempotency-Key header for ttl,
+// so that retries with the same key and body receive the original response instead of repeating the write.
+// Reusing a key with a different request is rejected with 422 Unprocessable Entity.
+//
+// A request whose key is in use by a request still in progress is rejected with 409 Conflict.
+// Server errors (5xx) are not remembered, so the write can be retried.
+// If ttl is zero, DefaultIdempotencyTTL is used.
+//
+// The backend store's prefix is reserved: requests for paths under it are rejected with 400 Bad Request,
+// and its records are left out of listings and watches.
+func WithIdempotency(store IdempotencyStore, ttl time.Duration) Option {
+	return func(s *Server) {
+		if ttl <= 0 {
+			ttl = DefaultIdempotencyTTL
+		}
+		s.idempotency = &idempotency{
+			store: store,
+			ttl:   ttl,
+		}
+		if b, ok := store.(*BackendIdempotencyStore); ok {
+			s.idempotency.reserved = b.prefix
+		}
+	}
+}
+
+// idempotency holds the server's idempotency settings
+type idempotency struct {
+	store IdempotencyStore
+	ttl   time.Duration
+	// reserved is the path prefix of records stored in the backend, if any
+	reserved string
+}
+
+// reserved reports whether path is reserved for idempotency records
+func (s *Server) reserved(path string) bool {
+	return s.idempotency != nil && s.idempotency.reserved != "" &&
+		(strings.HasPrefix(path, s.idempotency.reserved) || path+"/" == s.idempotency.reserved)
+}
+
+// requestFingerprint identifies a write by its method, path and body
+func requestFingerprint(method, path string, body []byte) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\n%s\n", method, path)
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// withIdempotency serves a write through handler, replaying the remembered outcome if the request
+// carries an idempotency key that has already been used for the same request.
+func (s *Server) withIdempotency(w http.ResponseWriter, r *http.Request, handler func(http.ResponseWriter, *http.Request) (string, int)) (string, int) {
+	key := r.Header.Get(IdempotencyKeyHeader)
+	if s.idempotency == nil || key == "" {
+		return handler(w, r)
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Idempotency-Key must be at most %d characters", maxIdempotencyKeyLength))
+		return "error", 0
+	}
+
+	var body []byte
+	if r.Body != nil {
+		var err error
+		if body, err = io.ReadAll(r.Body); err != nil {
+			return s.handleError(w, r, gitbackedrest.NewUserError(
+				"Error reading request body",
+				gitbackedrest.NewHTTPError(
+					http.StatusInternalServerError,
+					fmt.Errorf("reading request body: %w", err),
+				),
+			))
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	fingerprint := requestFingerprint(r.Method, r.URL.Path, body)
+
+	claim := &IdempotencyRecord{
+		Fingerprint: fingerprint,
+		Expires:     time.Now().Add(idempotencyClaimTTL),
+		InProgress:  true,
+	}
+	record, err := s.idempotency.store.Claim(r.Context(), key, claim)
+	if err != nil {
+		return s.handleError(w, r, fmt.Errorf("claiming idempotency key: %w", err))
+	}
+	if record != nil {
+		if record.Fingerprint != fingerprint {
+			writeProblem(w, r, http.StatusUnprocessableEntity, "Idempotency-Key has already been used for a different request")
+			return "error", 0
+		}
+		if record.InProgress {
+			writeProblem(w, r, http.StatusConflict, "A request with this Idempotency-Key is still in progress")
+			return "error", 0
+		}
+
+		s.log(r.Context()).DebugContext(r.Context(), "replaying idempotent response", "status", record.Status)
+		if record.ContentType != "" {
+			w.Header().Set("Content-Type", record.ContentType)
+		}
+		w.Header().Set(IdempotentReplayedHeader, "true")
+		w.WriteHeader(record.Status)
+		w.Write(record.Body)
+		if record.Status >= http.StatusBadRequest {
+			return "error", 0
+		}
+		return "success", 0
+	}
+
+	capture := &responseCapture{ResponseWriter: w, status: http.StatusOK}
+	status, retries := handler(capture, r)
+
+	// The claim must be resolved even if the client has gone away, or the key is held until it expires
+	ctx := context.WithoutCancel(r.Context())
+	if capture.status >= http.StatusInternalServerError {
+		if err := s.idempotency.store.Release(ctx, key, claim); err != nil {
+			s.log(r.Context()).ErrorContext(r.Context(), "failed to release idempotency key", "error", err)
+		}
+		return status, retries
+	}
+	err = s.idempotency.store.Complete(ctx, key, claim, &IdempotencyRecord{
+		Fingerprint: fingerprint,
+		Status:      capture.status,
+		ContentType: capture.Header().Get("Content-Type"),
+		Body:        capture.body.Bytes(),
+		Expires:     time.Now().Add(s.idempotency.ttl),
+	})
+	if err != nil {
+		// The write has been made, so a retry will see its effects rather than a replay
+		s.log(r.Context()).ErrorContext(r.Context(), "failed to store idempotent response", "error", err)
+	}
+	return status, retries
+}
+
+// responseCapture records the status and body written to a response
+type responseCapture struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (c *responseCapture) WriteHeader(code int) {
+	c.status = code
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *responseCapture) Write(b []byte) (int, error) {
+	c.body.Write(b)
+	return c.ResponseWriter.Write(b)
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer
+func (c *responseCapture) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// MemoryIdempotencyStore stores idempotency records in memory.
+// Records are not shared between replicas or kept across restarts.
+type MemoryIdempotencyStore struct {
+	mtx     sync.Mutex
+	records map[string]*IdempotencyRecord
+}
+
+var _ IdempotencyStore = (*MemoryIdempotencyStore)(nil)
+
+// NewMemoryIdempotencyStore creates an empty in-memory store
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{
+		records: make(map[string]*IdempotencyRecord),
+	}
+}
+
+// Claim implements IdempotencyStore. Expired records are removed as new ones are added.
+func (m *MemoryIdempotencyStore) Claim(ctx context.Context, key string, claim *IdempotencyRecord) (*IdempotencyRecord, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	now := time.Now()
+	for k, r := range m.records {
+		if now.After(r.Expires) {
+			delete(m.records, k)
+		}
+	}
+	if record, ok := m.records[key]; ok {
+		return record, nil
+	}
+	m.records[key] = claim
+	return nil, nil
+}
+
+// Complete implements IdempotencyStore.
+func (m *MemoryIdempotencyStore) Complete(ctx context.Context, key string, claim, record *IdempotencyRecord) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if m.records[key] != claim {
+		return errors.New("idempotency key has been claimed by another request")
+	}
+	m.records[key] = record
+	return nil
+}
+
+// Release implements IdempotencyStore.
+func (m *MemoryIdempotencyStore) Release(ctx context.Context, key string, claim *IdempotencyRecord) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if m.records[key] == claim {
+		delete(m.records, key)
+	}
+	return nil
+}
+
+// BackendIdempotencyStore stores idempotency records as resources in a backend, so they are shared by
+// every replica serving that backend. Claims are made with POST and completed with conditional writes,
+// so the backend must implement gitbackedrest.ConditionalWriter. Each keyed write makes two extra writes
+// to the backend.
+//
+// Records are replaced when their key is reused after they expire. Records for keys that are not reused
+// remain until removed by DeleteExpired, which should be called periodically.
+type BackendIdempotencyStore struct {
+	backend gitbackedrest.APIBackend
+	prefix  string
+}
+
+var _ IdempotencyStore = (*BackendIdempotencyStore)(nil)
+
+// NewBackendIdempotencyStore stores records in backend under the path prefix, e.g. "/.idempotency/".
+// A server using the store reserves the prefix, so it can't be used for other resources.
+func NewBackendIdempotencyStore(backend gitbackedrest.APIBackend, prefix string) *BackendIdempotencyStore {
+	return &BackendIdempotencyStore{
+		backend: backend,
+		prefix:  "/" + strings.Trim(prefix, "/") + "/",
+	}
+}
+
+// path returns the resource path for a key, hashed so any key is a valid path
+func (b *BackendIdempotencyStore) path(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return b.prefix + hex.EncodeToString(hash[:])
+}
+
+// conditionalWriter returns the backend's conditional writes, which claims are resolved with
+func (b *BackendIdempotencyStore) conditionalWriter() (gitbackedrest.ConditionalWriter, error) {
+	writer, ok := b.backend.(gitbackedrest.ConditionalWriter)
+	if !ok {
+		return nil, errors.New("idempotency store backend does not support conditional writes")
+	}
+	return writer, nil
+}
+
+// get returns the record at path and its data, or nil if there is none
+func (b *BackendIdempotencyStore) get(ctx context.Context, path string) (*IdempotencyRecord, []byte, error) {
+	result, err := b.backend.GET(ctx, path)
+	if errors.Is(err, gitbackedrest.ErrNotFound) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var record IdempotencyRecord
+	if err := json.Unmarshal(result.Data, &record); err != nil {
+		return nil, nil, fmt.Errorf("decoding idempotency record: %w", err)
+	}
+	return &record, result.Data, nil
+}
+
+// Claim implements IdempotencyStore.
+func (b *BackendIdempotencyStore) Claim(ctx context.Context, key string, claim *IdempotencyRecord) (*IdempotencyRecord, error) {
+	writer, err := b.conditionalWriter()
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(claim)
+	if err != nil {
+		return nil, fmt.Errorf("encoding idempotency record: %w", err)
+	}
+
+	path := b.path(key)
+	for {
+		_, err = b.backend.POST(ctx, path, data)
+		if !errors.Is(err, gitbackedrest.ErrConflict) {
+			return nil, err
+		}
+
+		record, current, err := b.get(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+		if record == nil {
+			// Released since the POST, so try again
+			continue
+		}
+		if !time.Now().After(record.Expires) {
+			return record, nil
+		}
+
+		// Replace the expired record, unless another request has done so first
+		_, err = writer.PUTIfMatch(ctx, path, data, gitbackedrest.ETag(current))
+		if !gitbackedrest.HasHTTPStatusCode(err, http.StatusPreconditionFailed, http.StatusNotFound) {
+			return nil, err
+		}
+	}
+}
+
+// Complete implements IdempotencyStore.
+func (b *BackendIdempotencyStore) Complete(ctx context.Context, key string, claim, record *IdempotencyRecord) error {
+	writer, err := b.conditionalWriter()
+	if err != nil {
+		return err
+	}
+	claimed, err := json.Marshal(claim)
+	if err != nil {
+		return fmt.Errorf("encoding idempotency record: %w", err)
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("encoding idempotency record: %w", err)
+	}
+
+	_, err = writer.PUTIfMatch(ctx, b.path(key), data, gitbackedrest.ETag(claimed))
+	return err
+}
+
+// Release implements IdempotencyStore.
+func (b *BackendIdempotencyStore) Release(ctx context.Context, key string, claim *IdempotencyRecord) error {
+	writer, err := b.conditionalWriter()
+	if err != nil {
+		return err
+	}
+	claimed, err := json.Marshal(claim)
+	if err != nil {
+		return fmt.Errorf("encoding idempotency record: %w", err)
+	}
+
+	_, err = writer.DELETEIfMatch(ctx, b.path(key), gitbackedrest.ETag(claimed))
+	if gitbackedrest.HasHTTPStatusCode(err, http.StatusPreconditionFailed, http.StatusNotFound) {
+		return nil
+	}
+	return err
+}
+
+// DeleteExpired removes expired records, returning how many were removed.
+// The backend must implement gitbackedrest.Lister.
+func (b *BackendIdempotencyStore) DeleteExpired(ctx context.Context) (int, error) {
+	writer, err := b.conditionalWriter()
+	if err != nil {
+		return 0, err
+	}
+	lister, ok := b.backend.(gitbackedrest.Lister)
+	if !ok {
+		return 0, errors.New("idempotency store backend does not support listing")
+	}
+	paths, err := lister.List(ctx, b.prefix)
+	if err != nil {
+		return 0, fmt.Errorf("listing idempotency records: %w", err)
+	}
+
+	var deleted int
+	for _, path := range paths {
+		record, current, err := b.get(ctx, path)
+		if err != nil {
+			return deleted, err
+		}
+		if record == nil || !time.Now().After(record.Expires) {
+			continue
+		}
+		// A record replaced since it was read is no longer expired
+		_, err = writer.DELETEIfMatch(ctx, path, gitbackedrest.ETag(current))
+		if gitbackedrest.HasHTTPStatusCode(err, http.StatusPreconditionFailed, http.StatusNotFound) {
+			continue
+		}
+		if err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
@@ -0,0 +1,216 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+	"github.com/theothertomelliott/git-backed-rest/backends/memory"
+)
+
+// keyedRequest makes a request with an idempotency key, returning the response
+func keyedRequest(t *testing.T, server *Server, method, path, key, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	if key != "" {
+		req.Header.Set(IdempotencyKeyHeader, key)
+	}
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+	return resp
+}
+
+func TestIdempotency(t *testing.T) {
+	for name, newStore := range map[string]func() IdempotencyStore{
+		"memory":  func() IdempotencyStore { return NewMemoryIdempotencyStore() },
+		"backend": func() IdempotencyStore { return NewBackendIdempotencyStore(memory.NewBackend(), "/.idempotency/") },
+	} {
+		t.Run(name, func(t *testing.T) {
+			server := New(memory.NewBackend(), WithIdempotency(newStore(), time.Hour))
+
+			resp := keyedRequest(t, server, "POST", "/resource", "key-1", "content")
+			if resp.Code != http.StatusCreated || resp.Header().Get(IdempotentReplayedHeader) != "" {
+				t.Fatalf("expected a new resource, got %d", resp.Code)
+			}
+
+			// A retry gets the original response, rather than a conflict
+			resp = keyedRequest(t, server, "POST", "/resource", "key-1", "content")
+			if resp.Code != http.StatusCreated || resp.Header().Get(IdempotentReplayedHeader) != "true" {
+				t.Errorf("expected a replayed 201, got %d (replayed %q)", resp.Code, resp.Header().Get(IdempotentReplayedHeader))
+			}
+
+			// A different write with the same key is rejected
+			resp = keyedRequest(t, server, "POST", "/resource", "key-1", "other content")
+			if resp.Code != http.StatusUnprocessableEntity {
+				t.Errorf("expected 422 for a reused key, got %d", resp.Code)
+			}
+
+			// Another client's write conflicts as before
+			resp = keyedRequest(t, server, "POST", "/resource", "key-2", "content")
+			if resp.Code != http.StatusConflict {
+				t.Errorf("expected 409 for a different key, got %d", resp.Code)
+			}
+			// The conflict is remembered too
+			resp = keyedRequest(t, server, "POST", "/resource", "key-2", "content")
+			if resp.Code != http.StatusConflict || resp.Header().Get(IdempotentReplayedHeader) != "true" {
+				t.Errorf("expected a replayed 409, got %d", resp.Code)
+			}
+		})
+	}
+}
+
+func TestIdempotencyExpiry(t *testing.T) {
+	server := New(memory.NewBackend(), WithIdempotency(NewMemoryIdempotencyStore(), time.Millisecond))
+
+	if resp := keyedRequest(t, server, "POST", "/resource", "key", "content"); resp.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", resp.Code)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if resp := keyedRequest(t, server, "POST", "/resource", "key", "content"); resp.Code != http.StatusConflict {
+		t.Errorf("expected the expired key to be forgotten, got %d", resp.Code)
+	}
+}
+
+func TestIdempotencyDisabled(t *testing.T) {
+	server := New(memory.NewBackend())
+
+	keyedRequest(t, server, "POST", "/resource", "key", "content")
+	if resp := keyedRequest(t, server, "POST", "/resource", "key", "content"); resp.Code != http.StatusConflict {
+		t.Errorf("expected keys to be ignored without WithIdempotency, got %d", resp.Code)
+	}
+}
+
+func TestIdempotencyStoreClaims(t *testing.T) {
+	for name, newStore := range map[string]func() IdempotencyStore{
+		"memory":  func() IdempotencyStore { return NewMemoryIdempotencyStore() },
+		"backend": func() IdempotencyStore { return NewBackendIdempotencyStore(memory.NewBackend(), "/.idempotency/") },
+	} {
+		t.Run(name, func(t *testing.T) {
+			store := newStore()
+			ctx := context.Background()
+			claim := &IdempotencyRecord{Fingerprint: "a", Expires: time.Now().Add(time.Hour), InProgress: true}
+
+			if existing, err := store.Claim(ctx, "key", claim); err != nil || existing != nil {
+				t.Fatalf("expected the key to be claimed, got %v, %v", existing, err)
+			}
+			// Another replica's claim sees the first in progress
+			other := &IdempotencyRecord{Fingerprint: "a", Expires: time.Now().Add(time.Hour), InProgress: true}
+			existing, err := store.Claim(ctx, "key", other)
+			if err != nil || existing == nil || !existing.InProgress {
+				t.Fatalf("expected the claim in progress, got %v, %v", existing, err)
+			}
+			// Only the claim's holder can complete it
+			record := &IdempotencyRecord{Fingerprint: "a", Status: http.StatusCreated, Expires: time.Now().Add(time.Hour)}
+			if err := store.Complete(ctx, "key", other, record); err == nil {
+				t.Error("expected completing another request's claim to fail")
+			}
+			if err := store.Complete(ctx, "key", claim, record); err != nil {
+				t.Fatalf("completing claim: %v", err)
+			}
+			existing, err = store.Claim(ctx, "key", other)
+			if err != nil || existing == nil || existing.InProgress || existing.Status != http.StatusCreated {
+				t.Fatalf("expected the completed record, got %v, %v", existing, err)
+			}
+			// A completed record is never released
+			if err := store.Release(ctx, "key", claim); err != nil {
+				t.Fatalf("releasing replaced claim: %v", err)
+			}
+			if existing, _ := store.Claim(ctx, "key", other); existing == nil || existing.Status != http.StatusCreated {
+				t.Errorf("expected the completed record to remain, got %v", existing)
+			}
+
+			// A released claim can be claimed again
+			if existing, err := store.Claim(ctx, "other-key", claim); err != nil || existing != nil {
+				t.Fatalf("expected the key to be claimed, got %v, %v", existing, err)
+			}
+			if err := store.Release(ctx, "other-key", claim); err != nil {
+				t.Fatalf("releasing claim: %v", err)
+			}
+			if existing, err := store.Claim(ctx, "other-key", other); err != nil || existing != nil {
+				t.Errorf("expected the released key to be claimed, got %v, %v", existing, err)
+			}
+
+			// An expired record is replaced
+			expired := &IdempotencyRecord{Fingerprint: "a", Expires: time.Now().Add(-time.Second), InProgress: true}
+			store.Claim(ctx, "expired-key", expired)
+			if existing, err := store.Claim(ctx, "expired-key", claim); err != nil || existing != nil {
+				t.Errorf("expected the expired record to be replaced, got %v, %v", existing, err)
+			}
+		})
+	}
+}
+
+func TestIdempotencyInProgress(t *testing.T) {
+	store := NewBackendIdempotencyStore(memory.NewBackend(), "/.idempotency/")
+	server := New(memory.NewBackend(), WithIdempotency(store, time.Hour))
+
+	// Another replica is making the same write
+	_, err := store.Claim(context.Background(), "key", &IdempotencyRecord{
+		Fingerprint: requestFingerprint("POST", "/resource", []byte("content")),
+		Expires:     time.Now().Add(time.Hour),
+		InProgress:  true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp := keyedRequest(t, server, "POST", "/resource", "key", "content"); resp.Code != http.StatusConflict {
+		t.Errorf("expected 409 while the key is in use, got %d", resp.Code)
+	}
+	if resp := keyedRequest(t, server, "GET", "/resource", "", ""); resp.Code != http.StatusNotFound {
+		t.Errorf("expected the write not to be made, got %d", resp.Code)
+	}
+}
+
+func TestIdempotencyReservedPrefix(t *testing.T) {
+	backend := memory.NewBackend()
+	store := NewBackendIdempotencyStore(backend, "/.idempotency/")
+	server := New(backend, WithIdempotency(store, time.Hour))
+
+	if resp := keyedRequest(t, server, "POST", "/resource", "key", "content"); resp.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", resp.Code)
+	}
+
+	for _, method := range []string{"GET", "POST", "PUT", "DELETE"} {
+		if resp := keyedRequest(t, server, method, store.path("key"), "", "{}"); resp.Code != http.StatusBadRequest {
+			t.Errorf("expected 400 for %s of a record, got %d", method, resp.Code)
+		}
+	}
+	if resp := keyedRequest(t, server, "GET", "/.idempotency/", "", ""); resp.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 listing records, got %d", resp.Code)
+	}
+
+	resp := keyedRequest(t, server, "GET", "/", "", "")
+	var listing gitbackedrest.Listing
+	if err := json.NewDecoder(resp.Body).Decode(&listing); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(listing.Paths, []string{"/resource"}) {
+		t.Errorf("expected records to be left out of listings, got %v", listing.Paths)
+	}
+}
+
+func TestBackendIdempotencyStoreDeleteExpired(t *testing.T) {
+	backend := memory.NewBackend()
+	store := NewBackendIdempotencyStore(backend, "/.idempotency/")
+	ctx := context.Background()
+
+	store.Claim(ctx, "expired", &IdempotencyRecord{Expires: time.Now().Add(-time.Second)})
+	store.Claim(ctx, "live", &IdempotencyRecord{Expires: time.Now().Add(time.Hour)})
+
+	deleted, err := store.DeleteExpired(ctx)
+	if err != nil || deleted != 1 {
+		t.Fatalf("expected 1 record deleted, got %d, %v", deleted, err)
+	}
+	paths, _ := backend.List(ctx, "/.idempotency/")
+	if !slices.Equal(paths, []string{store.path("live")}) {
+		t.Errorf("expected only the live record to remain, got %v", paths)
+	}
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/theothertomelliott/git-backed-rest/backends/memory"
+)
+
+func TestRequestID(t *testing.T) {
+	var logs bytes.Buffer
+	server := New(memory.NewBackend(), WithLogger(slog.New(slog.NewTextHandler(&logs, nil))))
+
+	req, err := http.NewRequest("GET", "/doc1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(RequestIDHeader, "caller-id-1")
+
+	resp := httptest.NewRecorder()
+	server.HandleRequest(resp, req)
+
+	if got := resp.Header().Get(RequestIDHeader); got != "caller-id-1" {
+		t.Errorf("expected caller's request ID to be echoed, got %q", got)
+	}
+	if !strings.Contains(logs.String(), "request_id=caller-id-1") {
+		t.Errorf("expected request ID in logs, got: %s", logs.String())
+	}
+	if !strings.Contains(logs.String(), "status=404") {
+		t.Errorf("expected status in logs, got: %s", logs.String())
+	}
+
+	req.Header.Set(RequestIDHeader, "invalid id\n")
+	resp = httptest.NewRecorder()
+	server.HandleRequest(resp, req)
+
+	if got := resp.Header().Get(RequestIDHeader); got == "" || got == "invalid id\n" {
+		t.Errorf("expected a generated request ID, got %q", got)
+	}
+}
@@ -1,70 +1,203 @@
 package server
 
 import (
+	"io"
+	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-var (
-	// ServerUptime tracks how long the server has been running
-	// Includes start_time parameter to track different server runs
-	ServerUptime = promauto.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "server_uptime",
-		Help: "Current uptime of the server in seconds",
-	}, []string{"start_time"})
-
-	// RequestCount tracks the number of requests by method and status
-	RequestCount = promauto.NewCounterVec(prometheus.CounterOpts{
-		Name: "request_count",
-		Help: "Total number of requests",
-	}, []string{"method", "status", "retry"})
-
-	// RequestDuration tracks how long requests take
-	RequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
-		Name: "request_duration",
-		Help: "Duration of requests in seconds",
-	}, []string{"method", "status", "retry"})
-
-	// RetryCount tracks the number of retry attempts
-	RetryCount = promauto.NewCounterVec(prometheus.CounterOpts{
-		Name: "retry_count",
-		Help: "Total number of retry attempts",
-	}, []string{"method", "status"})
-)
+// bodySizeBuckets are the histogram buckets for request and response body sizes, from 64B to 16MiB
+var bodySizeBuckets = prometheus.ExponentialBuckets(64, 4, 10)
+
+// NewRegistry creates a Prometheus registry with the standard Go runtime and process collectors.
+// A registry can be shared between a server, its webhook dispatcher and its backend.
+func NewRegistry() *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return reg
+}
 
-// MetricsUpdater handles updating metrics over time
-type MetricsUpdater struct {
+// Metrics holds the request metrics for a server, registered with the server's registry
+type Metrics struct {
 	startTime time.Time
+	handler   http.Handler
+
+	// uptime tracks how long the server has been running
+	// Includes start_time parameter to track different server runs
+	uptime *prometheus.GaugeVec
+
+	// requestCount tracks the number of requests by method and status
+	requestCount *prometheus.CounterVec
+
+	// requestDuration tracks how long requests take
+	requestDuration *prometheus.HistogramVec
+
+	// retryCount tracks the number of retry attempts
+	retryCount *prometheus.CounterVec
+
+	// requestBodySize and responseBodySize track the size of request and response bodies in bytes
+	requestBodySize  *prometheus.HistogramVec
+	responseBodySize *prometheus.HistogramVec
 }
 
-// NewMetricsUpdater creates a new metrics updater
-func NewMetricsUpdater() *MetricsUpdater {
-	startTime := time.Now()
+// NewMetrics creates server metrics and registers them with reg
+func NewMetrics(reg *prometheus.Registry) *Metrics {
+	m := &Metrics{
+		startTime: time.Now(),
+		handler:   promhttp.HandlerFor(reg, promhttp.HandlerOpts{Registry: reg}),
+		uptime: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "server_uptime",
+			Help: "Current uptime of the server in seconds",
+		}, []string{"start_time"}),
+		requestCount: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "request_count",
+			Help: "Total number of requests",
+		}, []string{"method", "status", "retry"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "request_duration",
+			Help: "Duration of requests in seconds",
+		}, []string{"method", "status", "retry"}),
+		retryCount: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "retry_count",
+			Help: "Total number of retry attempts",
+		}, []string{"method", "status"}),
+		requestBodySize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "request_body_size_bytes",
+			Help:    "Size of request bodies in bytes",
+			Buckets: bodySizeBuckets,
+		}, []string{"method"}),
+		responseBodySize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "response_body_size_bytes",
+			Help:    "Size of response bodies in bytes",
+			Buckets: bodySizeBuckets,
+		}, []string{"method", "code"}),
+	}
+	reg.MustRegister(
+		m.uptime,
+		m.requestCount,
+		m.requestDuration,
+		m.retryCount,
+		m.requestBodySize,
+		m.responseBodySize,
+	)
 
 	// Set initial uptime with start time parameter
-	ServerUptime.WithLabelValues(startTime.Format(time.RFC3339)).Set(0)
+	m.uptime.WithLabelValues(m.startTime.Format(time.RFC3339)).Set(0)
 
-	return &MetricsUpdater{
-		startTime: startTime,
-	}
+	return m
 }
 
 // UpdateUptime updates the uptime metric
-func (m *MetricsUpdater) UpdateUptime() {
+func (m *Metrics) UpdateUptime() {
 	if m == nil {
 		return
 	}
 
 	uptime := time.Since(m.startTime).Seconds()
-	ServerUptime.WithLabelValues(m.startTime.Format(time.RFC3339)).Set(uptime)
+	m.uptime.WithLabelValues(m.startTime.Format(time.RFC3339)).Set(uptime)
 }
 
 // GetStartTime returns the server start time
-func (m *MetricsUpdater) GetStartTime() time.Time {
+func (m *Metrics) GetStartTime() time.Time {
 	if m == nil {
 		return time.Time{}
 	}
 	return m.startTime
 }
+
+// ServeHTTP serves the metrics in the server's registry
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if m == nil {
+		http.NotFound(w, r)
+		return
+	}
+	m.handler.ServeHTTP(w, r)
+}
+
+// requestStats describes a completed request
+type requestStats struct {
+	method        string
+	status        string
+	code          int
+	retries       int
+	duration      time.Duration
+	requestBytes  int64
+	responseBytes int64
+}
+
+// observeRequest records the metrics for a completed request
+func (m *Metrics) observeRequest(stats requestStats) {
+	if m == nil {
+		return
+	}
+
+	retryLabel := "false"
+	if stats.retries > 0 {
+		retryLabel = "true"
+	}
+
+	m.requestDuration.WithLabelValues(stats.method, stats.status, retryLabel).Observe(stats.duration.Seconds())
+	m.requestCount.WithLabelValues(stats.method, stats.status, retryLabel).Inc()
+
+	// Track retry attempts if any
+	if stats.retries > 0 {
+		m.retryCount.WithLabelValues(stats.method, stats.status).Add(float64(stats.retries))
+	}
+
+	m.requestBodySize.WithLabelValues(stats.method).Observe(float64(stats.requestBytes))
+	m.responseBodySize.WithLabelValues(stats.method, strconv.Itoa(stats.code)).Observe(float64(stats.responseBytes))
+}
+
+// webhookMetrics holds the delivery metrics for a webhook dispatcher
+type webhookMetrics struct {
+	// deliveryCount tracks webhook delivery attempts by result (success, retry or dead_letter)
+	deliveryCount *prometheus.CounterVec
+
+	// deliveryDuration tracks how long webhook delivery attempts take
+	deliveryDuration *prometheus.HistogramVec
+
+	// queueSize tracks the number of webhook deliveries waiting to be sent
+	queueSize prometheus.Gauge
+}
+
+// newWebhookMetrics creates webhook metrics, registering them with reg if it is not nil
+func newWebhookMetrics(reg prometheus.Registerer) *webhookMetrics {
+	m := &webhookMetrics{
+		deliveryCount: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "webhook_delivery_count",
+			Help: "Total number of webhook delivery attempts",
+		}, []string{"result"}),
+		deliveryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "webhook_delivery_duration",
+			Help: "Duration of webhook delivery attempts in seconds",
+		}, []string{"status"}),
+		queueSize: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "webhook_queue_size",
+			Help: "Number of pending webhook deliveries",
+		}),
+	}
+	if reg != nil {
+		reg.MustRegister(m.deliveryCount, m.deliveryDuration, m.queueSize)
+	}
+	return m
+}
+
+// countingReader counts the bytes read from a request body
+type countingReader struct {
+	io.ReadCloser
+	bytes int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.bytes += int64(n)
+	return n, err
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/theothertomelliott/git-backed-rest/backends/memory"
+)
+
+func TestMetricsPerServer(t *testing.T) {
+	first := New(memory.NewBackend())
+	second := New(memory.NewBackend())
+
+	req := httptest.NewRequest("POST", "/doc1", bytes.NewBufferString("content1"))
+	first.HandleRequest(httptest.NewRecorder(), req)
+
+	if count := testutil.ToFloat64(first.metrics.requestCount.WithLabelValues("POST", "success", "false")); count != 1 {
+		t.Errorf("expected 1 request on the first server, got %v", count)
+	}
+	if count := testutil.ToFloat64(second.metrics.requestCount.WithLabelValues("POST", "success", "false")); count != 0 {
+		t.Errorf("expected no requests on the second server, got %v", count)
+	}
+
+	resp := httptest.NewRecorder()
+	first.HandleRequest(resp, httptest.NewRequest("GET", "/metrics", nil))
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d", http.StatusOK, resp.Code)
+	}
+	for _, expected := range []string{
+		`request_body_size_bytes_sum{method="POST"} 8`,
+		`response_body_size_bytes_count{code="201",method="POST"} 1`,
+		"go_goroutines",
+	} {
+		if !strings.Contains(resp.Body.String(), expected) {
+			t.Errorf("expected %q in metrics, got:\n%s", expected, resp.Body.String())
+		}
+	}
+}
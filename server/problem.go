@@ -0,0 +1,31 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+// writeProblem writes an RFC 9457 problem details response for a failed request
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	problem := gitbackedrest.NewProblem(status, detail)
+	problem.RequestID = gitbackedrest.RequestID(r.Context())
+
+	// Report the path as requested, before any resource prefix was stripped
+	problem.Instance = r.URL.Path
+	if u, err := url.ParseRequestURI(r.RequestURI); err == nil {
+		problem.Instance = u.Path
+	}
+
+	w.Header().Set("Content-Type", gitbackedrest.ProblemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(problem)
+}
+
+// notFound serves a problem response for paths with no route
+func notFound(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, http.StatusNotFound, "No such endpoint")
+}
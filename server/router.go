@@ -0,0 +1,93 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+// SystemPrefix is the reserved namespace for endpoints that are not resources,
+// such as metrics, health checks and watches. Paths under it are never passed to the backend.
+const SystemPrefix = "/_system/"
+
+// WithResourcePrefix mounts resources under prefix (e.g. "/v1/resources/"), so that a resource
+// at /users/alice is served from /v1/resources/users/alice. Requests outside the prefix and
+// SystemPrefix receive a 404.
+//
+// An empty prefix or "/" keeps the legacy root mount, where resources are served from the root
+// and system endpoints are also available at their original paths (/metrics, /healthz, /readyz,
+// /_watch and /_diagnostics), shadowing any resources with those paths.
+func WithResourcePrefix(prefix string) Option {
+	return func(s *Server) {
+		prefix = strings.Trim(prefix, "/")
+		if prefix == "" {
+			s.resourcePrefix = ""
+			return
+		}
+		s.resourcePrefix = "/" + prefix + "/"
+	}
+}
+
+// ServeHTTP implements http.Handler, routing requests to resources or system endpoints.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.routerOnce.Do(func() {
+		s.router = s.routes()
+	})
+	s.router.ServeHTTP(w, r)
+}
+
+// routes builds the router for the server's resource mount
+func (s *Server) routes() http.Handler {
+	// Metrics and probes are served without request IDs, and don't update uptime
+	system := map[string]http.Handler{
+		"metrics":     s.metrics,
+		"healthz":     http.HandlerFunc(s.handleHealthz),
+		"readyz":      http.HandlerFunc(s.handleReadyz),
+		"watch":       s.withRequestID(s.watch),
+		"diagnostics": s.withRequestID(s.handleDiagnostics),
+	}
+
+	mux := http.NewServeMux()
+	for name, handler := range system {
+		mux.Handle(SystemPrefix+name, handler)
+	}
+	mux.HandleFunc(SystemPrefix, notFound)
+
+	resources := s.withRequestID(s.handleResource)
+	if s.resourcePrefix == "" {
+		for path, name := range map[string]string{
+			"/metrics":      "metrics",
+			"/healthz":      "healthz",
+			"/readyz":       "readyz",
+			"/_watch":       "watch",
+			"/_diagnostics": "diagnostics",
+		} {
+			mux.Handle(path, system[name])
+		}
+		mux.Handle("/", resources)
+		return mux
+	}
+
+	mux.Handle(s.resourcePrefix, http.StripPrefix(strings.TrimSuffix(s.resourcePrefix, "/"), resources))
+	mux.HandleFunc("/", notFound)
+	return mux
+}
+
+// withRequestID accepts the caller's request ID, or generates one, so logs can be correlated across services
+func (s *Server) withRequestID(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = gitbackedrest.NewRequestID()
+		}
+		w.Header().Set(RequestIDHeader, requestID)
+		next(w, r.WithContext(gitbackedrest.WithRequestID(r.Context(), requestID)))
+	})
+}
+
+// watch serves a watch. Watches are long-lived, so are excluded from request metrics.
+func (s *Server) watch(w http.ResponseWriter, r *http.Request) {
+	s.log(r.Context()).DebugContext(r.Context(), "watch started", "path", r.URL.Path, "query", r.URL.RawQuery)
+	s.handleWatch(w, r)
+}
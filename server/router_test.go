@@ -0,0 +1,66 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/theothertomelliott/git-backed-rest/backends/memory"
+)
+
+func TestResourcePrefix(t *testing.T) {
+	backend := memory.NewBackend()
+	server := New(backend, WithResourcePrefix("/v1/resources/"))
+
+	for _, test := range []struct {
+		method   string
+		path     string
+		body     string
+		expected int
+	}{
+		{"POST", "/v1/resources/doc1", "content1", http.StatusCreated},
+		{"GET", "/v1/resources/doc1", "", http.StatusOK},
+		{"GET", "/doc1", "", http.StatusNotFound},
+		// Paths that are reserved in the legacy root mount are ordinary resources under a prefix
+		{"POST", "/v1/resources/metrics", "content2", http.StatusCreated},
+		{"GET", "/metrics", "", http.StatusNotFound},
+		{"GET", "/_system/metrics", "", http.StatusOK},
+		{"GET", "/_system/healthz", "", http.StatusOK},
+		{"GET", "/_system/unknown", "", http.StatusNotFound},
+	} {
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, httptest.NewRequest(test.method, test.path, bytes.NewBufferString(test.body)))
+		if resp.Code != test.expected {
+			t.Errorf("%s %s: expected status code %d, got %d", test.method, test.path, test.expected, resp.Code)
+		}
+	}
+
+	if _, err := backend.GET(t.Context(), "/metrics"); err != nil {
+		t.Errorf("expected resource to be stored relative to the prefix: %v", err)
+	}
+}
+
+func TestLegacyRootMount(t *testing.T) {
+	server := New(memory.NewBackend())
+
+	for _, test := range []struct {
+		method   string
+		path     string
+		body     string
+		expected int
+	}{
+		{"POST", "/doc1", "content1", http.StatusCreated},
+		{"GET", "/doc1", "", http.StatusOK},
+		{"GET", "/metrics", "", http.StatusOK},
+		{"GET", "/healthz", "", http.StatusOK},
+		{"GET", "/_system/healthz", "", http.StatusOK},
+		{"POST", "/_system/doc2", "content2", http.StatusNotFound},
+	} {
+		resp := httptest.NewRecorder()
+		server.HandleRequest(resp, httptest.NewRequest(test.method, test.path, bytes.NewBufferString(test.body)))
+		if resp.Code != test.expected {
+			t.Errorf("%s %s: expected status code %d, got %d", test.method, test.path, test.expected, resp.Code)
+		}
+	}
+}
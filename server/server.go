@@ -1,89 +1,216 @@
 package server
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus"
 	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
 )
 
 type Server struct {
-	backend gitbackedrest.APIBackend
-	metrics *MetricsUpdater
+	backend  gitbackedrest.APIBackend
+	metrics  *Metrics
+	events   *eventLog
+	webhooks *WebhookDispatcher
+	logger   *slog.Logger
+
+	// idempotency remembers the outcomes of writes with an Idempotency-Key, if enabled
+	idempotency *idempotency
+
+	// adminToken enables the diagnostics endpoint for requests bearing this token
+	adminToken atomic.Pointer[string]
+
+	// resourcePrefix is the path resources are mounted under, or empty for the legacy root mount
+	resourcePrefix string
+	router         http.Handler
+	routerOnce     sync.Once
+
+	watchPollInterval time.Duration
+
+	// writeLocks serializes writes to each path made through this server, so their events are recorded in order
+	writeLocks keyedMutex
+
+	// drainCtx is cancelled by Drain, ending open watches
+	drainCtx    context.Context
+	drainCancel context.CancelFunc
+	drainOnce   sync.Once
+}
+
+// Option configures optional behavior of a Server
+type Option func(*Server)
+
+// WithWebhooks notifies the given dispatcher of every change made through the server.
+func WithWebhooks(webhooks *WebhookDispatcher) Option {
+	return func(s *Server) {
+		s.webhooks = webhooks
+	}
 }
 
-func New(backend gitbackedrest.APIBackend) *Server {
-	return &Server{
+// WithRegistry registers the server's metrics with reg, and serves reg from /metrics.
+// If not set, each server has its own registry created by NewRegistry.
+func WithRegistry(reg *prometheus.Registry) Option {
+	return func(s *Server) {
+		s.metrics = NewMetrics(reg)
+	}
+}
+
+// WithAdminToken enables the /_diagnostics endpoint, which requires the token as a bearer token.
+func WithAdminToken(token string) Option {
+	return func(s *Server) {
+		s.SetAdminToken(token)
+	}
+}
+
+// SetAdminToken replaces the token required by the /_diagnostics endpoint.
+// An empty token disables the endpoint.
+func (s *Server) SetAdminToken(token string) {
+	s.adminToken.Store(&token)
+}
+
+// WithLogger sets the logger used for request and error logs.
+// If not set, the default slog logger is used.
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *Server) {
+		s.logger = logger
+	}
+}
+
+func New(backend gitbackedrest.APIBackend, opts ...Option) *Server {
+	s := &Server{
 		backend: backend,
-		metrics: NewMetricsUpdater(),
+		events:  newEventLog(),
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.metrics == nil {
+		s.metrics = NewMetrics(NewRegistry())
+	}
+	return s
 }
 
+// HandleRequest serves a request. It is equivalent to ServeHTTP, for use with http.HandleFunc.
 func (s *Server) HandleRequest(w http.ResponseWriter, r *http.Request) {
-	// Handle metrics endpoint first - don't update uptime for metrics scraping
-	if r.URL.Path == "/metrics" {
-		promhttp.Handler().ServeHTTP(w, r)
-		return
-	}
+	s.ServeHTTP(w, r)
+}
 
+// handleResource serves a request for a resource, with a path relative to the resource mount
+func (s *Server) handleResource(w http.ResponseWriter, r *http.Request) {
 	// Update uptime metric for API requests only
 	s.metrics.UpdateUptime()
 
+	r, span := startRequestSpan(r)
+
 	start := time.Now()
-	log.Printf("Server: Received %s %s", r.Method, r.URL.Path)
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	w = recorder
+	body := &countingReader{ReadCloser: http.NoBody}
+	if r.Body != nil {
+		body.ReadCloser = r.Body
+		r.Body = body
+	}
 
 	var status string
 	var retries int
 	defer func() {
-		duration := time.Since(start).Seconds()
-		retryLabel := "false"
-		if retries > 0 {
-			retryLabel = "true"
-		}
-
-		RequestDuration.WithLabelValues(r.Method, status, retryLabel).Observe(duration)
-		RequestCount.WithLabelValues(r.Method, status, retryLabel).Inc()
-
-		// Track retry attempts if any
-		if retries > 0 {
-			RetryCount.WithLabelValues(r.Method, status).Add(float64(retries))
-		}
+		elapsed := time.Since(start)
+
+		endRequestSpan(span, recorder.status, retries)
+
+		s.log(r.Context()).InfoContext(r.Context(), "request completed",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", recorder.status,
+			"duration", elapsed,
+			"retries", retries,
+		)
+
+		s.metrics.observeRequest(requestStats{
+			method:        r.Method,
+			status:        status,
+			code:          recorder.status,
+			retries:       retries,
+			duration:      elapsed,
+			requestBytes:  body.bytes,
+			responseBytes: recorder.bytes,
+		})
 	}()
 
+	if s.reserved(r.URL.Path) {
+		writeProblem(w, r, http.StatusBadRequest, "Path is reserved for idempotency records")
+		status = "error"
+		return
+	}
+
 	switch r.Method {
 	case http.MethodGet:
 		status, retries = s.handleGET(w, r)
 	case http.MethodPost:
-		status, retries = s.handlePOST(w, r)
+		status, retries = s.withIdempotency(w, r, s.handlePOST)
 	case http.MethodPut:
-		status, retries = s.handlePUT(w, r)
+		status, retries = s.withIdempotency(w, r, s.handlePUT)
 	case http.MethodDelete:
-		status, retries = s.handleDELETE(w, r)
+		status, retries = s.withIdempotency(w, r, s.handleDELETE)
 	default:
-		log.Printf("Server: Method not allowed: %s", r.Method)
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 		status = "error"
 		retries = 0
 	}
 }
 
-// handleError handles API errors by extracting status code and user message, then writing HTTP error response
-func (s *Server) handleError(w http.ResponseWriter, err error) (string, int) {
+// handleError handles API errors by extracting status code and user message, then writing a problem details response
+func (s *Server) handleError(w http.ResponseWriter, r *http.Request, err error) (string, int) {
 	statusCode := gitbackedrest.GetHTTPStatusCode(err, http.StatusInternalServerError)
 	userMessage := gitbackedrest.GetUserMessage(err)
-	http.Error(w, userMessage, statusCode)
+
+	level := slog.LevelDebug
+	if statusCode >= http.StatusInternalServerError {
+		level = slog.LevelError
+	}
+	s.log(r.Context()).Log(r.Context(), level, "request failed", "method", r.Method, "path", r.URL.Path, "status", statusCode, "error", err)
+
+	writeProblem(w, r, statusCode, userMessage)
 	return "error", 0
 }
 
+// recordChange publishes a successful write to watchers and webhooks.
+// The author is taken from the X-Author header, if provided.
+func (s *Server) recordChange(r *http.Request, eventType gitbackedrest.EventType) {
+	event := s.events.publish(eventType, r.URL.Path)
+	s.webhooks.Notify(event, r.Header.Get("X-Author"))
+}
+
 func (s *Server) handleGET(w http.ResponseWriter, r *http.Request) (string, int) {
+	if strings.HasSuffix(r.URL.Path, "/") {
+		return s.handleList(w, r)
+	}
+	query := r.URL.Query()
+	if query.Has("history") {
+		return s.handleHistory(w, r)
+	}
+	if version := query.Get("version"); version != "" {
+		return s.handleGETVersion(w, r, version)
+	}
+
 	result, err := s.backend.GET(r.Context(), r.URL.Path)
 	if err != nil {
-		return s.handleError(w, err)
+		return s.handleError(w, r, err)
+	}
+
+	etag := gitbackedrest.ETag(result.Data)
+	w.Header().Set("ETag", etag)
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" && gitbackedrest.MatchETag(ifNoneMatch, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return "success", result.Retries
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -93,10 +220,8 @@ func (s *Server) handleGET(w http.ResponseWriter, r *http.Request) (string, int)
 }
 
 func (s *Server) handlePOST(w http.ResponseWriter, r *http.Request) (string, int) {
-	log.Printf("Server: handlePOST started for %s", r.URL.Path)
 
 	if r.Body == nil {
-		log.Printf("Server: Request body is nil")
 		err := gitbackedrest.NewUserError(
 			"Request body is required",
 			gitbackedrest.NewHTTPError(
@@ -104,13 +229,11 @@ func (s *Server) handlePOST(w http.ResponseWriter, r *http.Request) (string, int
 				errors.New("request body is required"),
 			),
 		)
-		return s.handleError(w, err)
+		return s.handleError(w, r, err)
 	}
 
-	log.Printf("Server: Reading request body...")
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		log.Printf("Server: Error reading request body: %v", err)
 		err = gitbackedrest.NewUserError(
 			"Error reading request body",
 			gitbackedrest.NewHTTPError(
@@ -118,22 +241,28 @@ func (s *Server) handlePOST(w http.ResponseWriter, r *http.Request) (string, int
 				fmt.Errorf("reading request body: %w", err),
 			),
 		)
-		return s.handleError(w, err)
+		return s.handleError(w, r, err)
 	}
 
-	log.Printf("Server: Calling backend.POST...")
+	unlock := s.writeLocks.lock(r.URL.Path)
+	defer unlock()
+
 	result, apiErr := s.backend.POST(r.Context(), r.URL.Path, body)
 	if apiErr != nil {
-		log.Printf("Server: backend.POST failed: %v", apiErr)
-		return s.handleError(w, apiErr)
+		return s.handleError(w, r, apiErr)
 	}
 
-	log.Printf("Server: backend.POST succeeded with %d retries", result.Retries)
+	s.recordChange(r, gitbackedrest.EventCreate)
+	w.Header().Set("ETag", gitbackedrest.ETag(body))
 	w.WriteHeader(http.StatusCreated)
 	return "success", result.Retries
 }
 
 func (s *Server) handlePUT(w http.ResponseWriter, r *http.Request) (string, int) {
+	if version := r.URL.Query().Get("restore"); version != "" {
+		return s.handleRestore(w, r, version)
+	}
+
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		err = gitbackedrest.NewUserError(
@@ -143,24 +272,34 @@ func (s *Server) handlePUT(w http.ResponseWriter, r *http.Request) (string, int)
 				fmt.Errorf("reading request body: %w", err),
 			),
 		)
-		return s.handleError(w, err)
+		return s.handleError(w, r, err)
 	}
-	result, apiErr := s.backend.PUT(r.Context(), r.URL.Path, body)
+
+	unlock := s.writeLocks.lock(r.URL.Path)
+	defer unlock()
+
+	result, apiErr := s.put(r, body)
 	if apiErr != nil {
-		return s.handleError(w, apiErr)
+		return s.handleError(w, r, apiErr)
 	}
 
+	s.recordChange(r, gitbackedrest.EventUpdate)
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", gitbackedrest.ETag(body))
 	w.WriteHeader(http.StatusNoContent)
 	return "success", result.Retries
 }
 
 func (s *Server) handleDELETE(w http.ResponseWriter, r *http.Request) (string, int) {
-	result, apiErr := s.backend.DELETE(r.Context(), r.URL.Path)
+	unlock := s.writeLocks.lock(r.URL.Path)
+	defer unlock()
+
+	result, apiErr := s.delete(r)
 	if apiErr != nil {
-		return s.handleError(w, apiErr)
+		return s.handleError(w, r, apiErr)
 	}
 
+	s.recordChange(r, gitbackedrest.EventDelete)
 	w.WriteHeader(http.StatusNoContent)
 	return "success", result.Retries
 }
@@ -2,11 +2,13 @@ package server
 
 import (
 	"bytes"
+	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
 	"github.com/theothertomelliott/git-backed-rest/backends/memory"
 )
 
@@ -180,3 +182,34 @@ func TestServerDELETE(t *testing.T) {
 		t.Errorf("expected status code %d, got %d: %v", http.StatusNoContent, resp.Code, resp.Body)
 	}
 }
+
+func TestServerProblem(t *testing.T) {
+	server := New(memory.NewBackend(), WithResourcePrefix("/v1/"))
+
+	req := httptest.NewRequest("GET", "/v1/missing", nil)
+	req.Header.Set(RequestIDHeader, "problem-request")
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusNotFound {
+		t.Fatalf("expected status %d, got %d", http.StatusNotFound, resp.Code)
+	}
+	if contentType := resp.Header().Get("Content-Type"); contentType != gitbackedrest.ProblemContentType {
+		t.Errorf("expected problem content type, got %q", contentType)
+	}
+
+	var problem gitbackedrest.Problem
+	if err := json.NewDecoder(resp.Body).Decode(&problem); err != nil {
+		t.Fatal(err)
+	}
+	expected := gitbackedrest.Problem{
+		Title:     "Not Found",
+		Status:    http.StatusNotFound,
+		Detail:    "Not Found",
+		Instance:  "/v1/missing",
+		RequestID: "problem-request",
+	}
+	if problem != expected {
+		t.Errorf("expected %+v, got %+v", expected, problem)
+	}
+}
@@ -0,0 +1,28 @@
+package server
+
+import (
+	"context"
+)
+
+// Drain prepares the server for shutdown. Readiness checks start failing, so load balancers
+// stop sending new requests, and open watches are ended so they don't hold
+// http.Server.Shutdown open. Other requests continue to be served until the http.Server closes.
+//
+// Drain can be registered with http.Server.RegisterOnShutdown.
+func (s *Server) Drain() {
+	s.drainContext()
+	s.drainCancel()
+}
+
+// drainContext returns a context that is cancelled when the server starts draining
+func (s *Server) drainContext() context.Context {
+	s.drainOnce.Do(func() {
+		s.drainCtx, s.drainCancel = context.WithCancel(context.Background())
+	})
+	return s.drainCtx
+}
+
+// draining reports whether Drain has been called
+func (s *Server) draining() bool {
+	return s.drainContext().Err() != nil
+}
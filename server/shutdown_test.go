@@ -0,0 +1,71 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/theothertomelliott/git-backed-rest/backends/memory"
+)
+
+func TestDrain(t *testing.T) {
+	server := New(memory.NewBackend())
+	httpServer := httptest.NewUnstartedServer(server)
+	httpServer.Config.RegisterOnShutdown(server.Drain)
+	httpServer.Start()
+	defer httpServer.Close()
+
+	req, err := http.NewRequest("GET", httpServer.URL+"/_system/watch", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	if !scanner.Scan() || !strings.HasPrefix(scanner.Text(), "id: ") {
+		t.Fatalf("expected initial id, got %q", scanner.Text())
+	}
+
+	// A long poll in progress should return early with no events
+	polled := make(chan int, 1)
+	go func() {
+		resp, err := http.Get(httpServer.URL + "/_system/watch?timeout=1m")
+		if err != nil {
+			polled <- 0
+			return
+		}
+		resp.Body.Close()
+		polled <- resp.StatusCode
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := httpServer.Config.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown was held open: %v", err)
+	}
+
+	// The stream should end once drained
+	if _, err := io.ReadAll(resp.Body); err != nil {
+		t.Errorf("reading stream: %v", err)
+	}
+	if status := <-polled; status != http.StatusOK {
+		t.Errorf("expected long poll to complete with 200, got %d", status)
+	}
+
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest("GET", "/readyz", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected draining server to be unready, got %d", recorder.Code)
+	}
+}
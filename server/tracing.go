@@ -0,0 +1,43 @@
+package server
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+// propagator extracts W3C trace context and baggage from incoming requests.
+// It is used regardless of the global propagator so that traces from client.Client are always joined.
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// startRequestSpan starts a server span for r as a child of any trace context in its headers.
+func startRequestSpan(r *http.Request) (*http.Request, trace.Span) {
+	ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := otel.Tracer(gitbackedrest.TracerName).Start(ctx, r.Method,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.request.method", r.Method),
+			attribute.String("url.path", r.URL.Path),
+			attribute.String("request_id", gitbackedrest.RequestID(r.Context())),
+		),
+	)
+	return r.WithContext(ctx), span
+}
+
+// endRequestSpan records the response status on span and ends it.
+func endRequestSpan(span trace.Span, status, retries int) {
+	span.SetAttributes(
+		attribute.Int("http.response.status_code", status),
+		attribute.Int("retries", retries),
+	)
+	if status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+	span.End()
+}
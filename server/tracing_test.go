@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+	"github.com/theothertomelliott/git-backed-rest/backends/memory"
+	"github.com/theothertomelliott/git-backed-rest/client"
+)
+
+// phasedBackend records a phase for each POST, as the remote backends do
+type phasedBackend struct {
+	*memory.Backend
+}
+
+func (b phasedBackend) POST(ctx context.Context, path string, body []byte) (*gitbackedrest.Result, error) {
+	ctx, phase := gitbackedrest.StartPhase(ctx, nil, "store")
+	defer phase.End()
+	return b.Backend.POST(ctx, path, body)
+}
+
+func TestTracePropagation(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	ts := httptest.NewServer(http.HandlerFunc(New(phasedBackend{memory.NewBackend()}).HandleRequest))
+	defer ts.Close()
+
+	if err := client.New(ts.URL).POST(context.Background(), "/doc1", []byte("content1")); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.SpanKind().String()+"/"+span.Name()] = span
+	}
+	clientSpan, serverSpan, phaseSpan := spans["client/POST"], spans["server/POST"], spans["internal/store"]
+	if clientSpan == nil || serverSpan == nil || phaseSpan == nil {
+		t.Fatalf("expected client, server and phase spans, got %v", spans)
+	}
+
+	if serverSpan.Parent().SpanID() != clientSpan.SpanContext().SpanID() {
+		t.Errorf("expected server span to be a child of the client span")
+	}
+	if phaseSpan.Parent().SpanID() != serverSpan.SpanContext().SpanID() {
+		t.Errorf("expected phase span to be a child of the server span")
+	}
+	if serverSpan.SpanContext().TraceID() != clientSpan.SpanContext().TraceID() {
+		t.Errorf("expected client and server spans to share a trace ID")
+	}
+}
@@ -0,0 +1,356 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+const (
+	// eventLogSize is the number of recent events retained for resuming watches
+	eventLogSize = 1024
+
+	defaultWatchPollInterval = 2 * time.Second
+	defaultWatchTimeout      = 30 * time.Second
+	maxWatchTimeout          = 5 * time.Minute
+	watchKeepAliveInterval   = 15 * time.Second
+)
+
+// watchResponse is the body returned by a long-poll watch request
+type watchResponse struct {
+	Events  []gitbackedrest.Event `json:"events"`
+	Version string                `json:"version"`
+}
+
+// changeSource provides the events served by the watch endpoint
+type changeSource interface {
+	// poll returns any events after since without waiting.
+	// If since is empty, it returns the current version.
+	poll(ctx context.Context, since string) ([]gitbackedrest.Event, string, error)
+	// wait blocks until there are events after since, or ctx is done.
+	wait(ctx context.Context, since string) ([]gitbackedrest.Event, string, error)
+}
+
+// eventLog records changes made through the server so they can be streamed to watchers.
+// Versions are of the form <epoch>-<sequence>, so tokens from a previous run are rejected.
+type eventLog struct {
+	mu     sync.Mutex
+	epoch  string
+	seq    uint64
+	events []gitbackedrest.Event
+	notify chan struct{}
+}
+
+func newEventLog() *eventLog {
+	return &eventLog{
+		epoch:  strconv.FormatInt(time.Now().UnixNano(), 36),
+		notify: make(chan struct{}),
+	}
+}
+
+func (l *eventLog) version(seq uint64) string {
+	return fmt.Sprintf("%s-%d", l.epoch, seq)
+}
+
+// publish records a change and wakes any waiting watchers
+func (l *eventLog) publish(eventType gitbackedrest.EventType, path string) gitbackedrest.Event {
+	event := gitbackedrest.Event{
+		Type: eventType,
+		Path: path,
+	}
+	if l == nil {
+		return event
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.seq++
+	event.Version = l.version(l.seq)
+	l.events = append(l.events, event)
+	if len(l.events) > eventLogSize {
+		l.events = l.events[len(l.events)-eventLogSize:]
+	}
+
+	close(l.notify)
+	l.notify = make(chan struct{})
+	return event
+}
+
+// since returns the events after the given version, the current version and a channel
+// that is closed when the next event is published.
+func (l *eventLog) since(since string) ([]gitbackedrest.Event, string, <-chan struct{}, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if since == "" {
+		return nil, l.version(l.seq), l.notify, nil
+	}
+
+	epoch, seqStr, found := strings.Cut(since, "-")
+	seq, err := strconv.ParseUint(seqStr, 10, 64)
+	if !found || err != nil {
+		return nil, "", nil, gitbackedrest.NewUserError(
+			"Invalid resume token",
+			gitbackedrest.NewHTTPError(
+				http.StatusBadRequest,
+				fmt.Errorf("malformed resume token: %q", since),
+			),
+		)
+	}
+
+	oldest := l.seq - uint64(len(l.events))
+	if epoch != l.epoch || seq > l.seq || seq < oldest {
+		return nil, "", nil, gitbackedrest.NewUserError(
+			"Resume token is no longer valid",
+			gitbackedrest.NewHTTPError(
+				http.StatusGone,
+				fmt.Errorf("resume token %q is outside the retained event log", since),
+			),
+		)
+	}
+
+	events := make([]gitbackedrest.Event, len(l.events)-int(seq-oldest))
+	copy(events, l.events[seq-oldest:])
+	return events, l.version(l.seq), l.notify, nil
+}
+
+func (l *eventLog) poll(ctx context.Context, since string) ([]gitbackedrest.Event, string, error) {
+	events, version, _, err := l.since(since)
+	return events, version, err
+}
+
+func (l *eventLog) wait(ctx context.Context, since string) ([]gitbackedrest.Event, string, error) {
+	for {
+		events, version, notify, err := l.since(since)
+		if err != nil {
+			return nil, "", err
+		}
+		if since == "" || len(events) > 0 {
+			return events, version, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, since, ctx.Err()
+		case <-notify:
+		}
+	}
+}
+
+// feedSource polls a backend's ChangeFeed for events
+type feedSource struct {
+	feed         gitbackedrest.ChangeFeed
+	pollInterval time.Duration
+}
+
+func (f *feedSource) poll(ctx context.Context, since string) ([]gitbackedrest.Event, string, error) {
+	return f.feed.Changes(ctx, since)
+}
+
+func (f *feedSource) wait(ctx context.Context, since string) ([]gitbackedrest.Event, string, error) {
+	for {
+		events, version, err := f.feed.Changes(ctx, since)
+		if err != nil {
+			return nil, "", err
+		}
+		if since == "" || len(events) > 0 || version != since {
+			return events, version, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, since, ctx.Err()
+		case <-time.After(f.pollInterval):
+		}
+	}
+}
+
+// changeSource returns the backend's own change feed where available, so that changes made
+// outside this server are included. Otherwise, only writes made through the server are seen.
+func (s *Server) changeSource() changeSource {
+	if feed, ok := s.backend.(gitbackedrest.ChangeFeed); ok {
+		pollInterval := s.watchPollInterval
+		if pollInterval == 0 {
+			pollInterval = defaultWatchPollInterval
+		}
+		return &feedSource{
+			feed:         feed,
+			pollInterval: pollInterval,
+		}
+	}
+	if s.events == nil {
+		return nil
+	}
+	return s.events
+}
+
+// handleWatch serves changes under a path prefix, either as a Server-Sent Events stream
+// or as a long-poll returning the next batch of events.
+func (s *Server) handleWatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	source := s.changeSource()
+	if source == nil {
+		writeProblem(w, r, http.StatusNotImplemented, "Watch is not supported")
+		return
+	}
+
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	if prefix == "" {
+		prefix = "/"
+	}
+	since := query.Get("since")
+	if since == "" {
+		since = r.Header.Get("Last-Event-ID")
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		s.streamEvents(w, r, source, prefix, since)
+		return
+	}
+	s.pollEvents(w, r, source, prefix, since)
+}
+
+func (s *Server) pollEvents(w http.ResponseWriter, r *http.Request, source changeSource, prefix, since string) {
+	timeout := defaultWatchTimeout
+	if t := r.URL.Query().Get("timeout"); t != "" {
+		parsed, err := time.ParseDuration(t)
+		if err != nil || parsed <= 0 {
+			writeProblem(w, r, http.StatusBadRequest, "Invalid timeout")
+			return
+		}
+		timeout = min(parsed, maxWatchTimeout)
+	}
+
+	// Return early with whatever has been collected if the server starts draining
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	stop := context.AfterFunc(s.drainContext(), cancel)
+	defer stop()
+
+	response := watchResponse{
+		Events:  []gitbackedrest.Event{},
+		Version: since,
+	}
+	if since == "" {
+		// Without a resume token, wait for changes after the current version
+		_, version, err := source.poll(ctx, "")
+		if err != nil {
+			s.handleError(w, r, err)
+			return
+		}
+		response.Version = version
+	}
+	for {
+		events, version, err := source.wait(ctx, response.Version)
+		if err != nil && ctx.Err() != nil && r.Context().Err() == nil {
+			break
+		}
+		if err != nil {
+			s.handleError(w, r, err)
+			return
+		}
+
+		response.Version = version
+		response.Events = append(response.Events, s.filterEvents(events, prefix)...)
+		if len(response.Events) > 0 {
+			break
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		s.log(r.Context()).WarnContext(r.Context(), "error writing watch response", "error", err)
+	}
+}
+
+func (s *Server) streamEvents(w http.ResponseWriter, r *http.Request, source changeSource, prefix, since string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeProblem(w, r, http.StatusInternalServerError, "Streaming is not supported")
+		return
+	}
+
+	// Resolve the starting version before committing to a stream, so invalid
+	// tokens are reported with an appropriate status code.
+	events, version, err := source.poll(r.Context(), since)
+	if err != nil {
+		s.handleError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	// An id-only message gives the client a resume token without dispatching an event
+	fmt.Fprintf(w, "id: %s\n\n", version)
+	flusher.Flush()
+
+	for {
+		matching := s.filterEvents(events, prefix)
+		for _, event := range matching {
+			data, err := json.Marshal(event)
+			if err != nil {
+				s.log(r.Context()).ErrorContext(r.Context(), "error encoding event", "error", err)
+				return
+			}
+			fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.Version, event.Type, data)
+		}
+		if version != since && (len(matching) == 0 || matching[len(matching)-1].Version != version) {
+			// Advance the client's resume token past changes outside the prefix
+			fmt.Fprintf(w, "id: %s\n\n", version)
+		}
+		flusher.Flush()
+		since = version
+
+		for {
+			ctx, cancel := context.WithTimeout(r.Context(), watchKeepAliveInterval)
+			stop := context.AfterFunc(s.drainContext(), cancel)
+			events, version, err = source.wait(ctx, since)
+			stop()
+			cancel()
+
+			// Clients reconnect with their last event ID, so a draining server can end the stream
+			if r.Context().Err() != nil || s.draining() {
+				return
+			}
+			if !errors.Is(err, context.DeadlineExceeded) {
+				break
+			}
+			fmt.Fprint(w, ": keepalive\n\n")
+			flusher.Flush()
+		}
+		if err != nil {
+			data, _ := json.Marshal(map[string]string{"error": gitbackedrest.GetUserMessage(err)})
+			fmt.Fprintf(w, "event: error\ndata: %s\n\n", data)
+			flusher.Flush()
+			return
+		}
+	}
+}
+
+// filterEvents returns the events under prefix, leaving out changes to reserved paths
+func (s *Server) filterEvents(events []gitbackedrest.Event, prefix string) []gitbackedrest.Event {
+	var filtered []gitbackedrest.Event
+	for _, event := range events {
+		if strings.HasPrefix(event.Path, prefix) && !s.reserved(event.Path) {
+			filtered = append(filtered, event)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+	"github.com/theothertomelliott/git-backed-rest/backends/memory"
+)
+
+func TestWatchLongPoll(t *testing.T) {
+	server := New(memory.NewBackend())
+
+	initial := pollWatch(t, server, "/_watch?timeout=10ms", http.StatusOK)
+	if len(initial.Events) != 0 {
+		t.Fatalf("expected no events, got %v", initial.Events)
+	}
+
+	doRequest(t, server, "POST", "/config/a", "content1", http.StatusCreated)
+	doRequest(t, server, "POST", "/other/b", "content1", http.StatusCreated)
+	doRequest(t, server, "PUT", "/config/a", "content2", http.StatusNoContent)
+
+	changes := pollWatch(t, server, "/_watch?prefix=/config/&since="+initial.Version, http.StatusOK)
+	expected := []gitbackedrest.EventType{gitbackedrest.EventCreate, gitbackedrest.EventUpdate}
+	if len(changes.Events) != len(expected) {
+		t.Fatalf("expected %d events, got %v", len(expected), changes.Events)
+	}
+	for i, event := range changes.Events {
+		if event.Type != expected[i] || event.Path != "/config/a" {
+			t.Errorf("unexpected event %d: %+v", i, event)
+		}
+	}
+
+	doRequest(t, server, "DELETE", "/config/a", "", http.StatusNoContent)
+
+	changes = pollWatch(t, server, "/_watch?prefix=/config/&since="+changes.Version, http.StatusOK)
+	if len(changes.Events) != 1 || changes.Events[0].Type != gitbackedrest.EventDelete {
+		t.Fatalf("expected a single delete event, got %v", changes.Events)
+	}
+}
+
+func TestWatchInvalidToken(t *testing.T) {
+	server := New(memory.NewBackend())
+
+	pollWatch(t, server, "/_watch?since=invalid", http.StatusBadRequest)
+	pollWatch(t, server, "/_watch?since=previousrun-1", http.StatusGone)
+}
+
+func TestWatchStream(t *testing.T) {
+	server := New(memory.NewBackend())
+	httpServer := httptest.NewServer(http.HandlerFunc(server.HandleRequest))
+	defer httpServer.Close()
+
+	req, err := http.NewRequestWithContext(t.Context(), "GET", httpServer.URL+"/_watch?prefix=/config/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("unexpected content type %q", resp.Header.Get("Content-Type"))
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	if !scanner.Scan() || !strings.HasPrefix(scanner.Text(), "id: ") {
+		t.Fatalf("expected initial id, got %q", scanner.Text())
+	}
+
+	doRequest(t, server, "POST", "/other/b", "content1", http.StatusCreated)
+	doRequest(t, server, "POST", "/config/a", "content1", http.StatusCreated)
+
+	var eventType string
+	var event gitbackedrest.Event
+	for scanner.Scan() {
+		line := scanner.Text()
+		if value, ok := strings.CutPrefix(line, "event: "); ok {
+			eventType = value
+		}
+		if value, ok := strings.CutPrefix(line, "data: "); ok {
+			if err := json.Unmarshal([]byte(value), &event); err != nil {
+				t.Fatal(err)
+			}
+			break
+		}
+	}
+
+	if eventType != "create" || event.Path != "/config/a" {
+		t.Errorf("unexpected event %q: %+v", eventType, event)
+	}
+}
+
+// TestWatchStreamResumeToken checks that the resume token advances past changes outside the prefix
+// that follow the last matching event in a batch.
+func TestWatchStreamResumeToken(t *testing.T) {
+	server := New(memory.NewBackend())
+	httpServer := httptest.NewServer(http.HandlerFunc(server.HandleRequest))
+	defer httpServer.Close()
+
+	initial := pollWatch(t, server, "/_watch?timeout=10ms", http.StatusOK)
+	doRequest(t, server, "POST", "/config/a", "content1", http.StatusCreated)
+	doRequest(t, server, "POST", "/other/b", "content1", http.StatusCreated)
+	latest := pollWatch(t, server, "/_watch?since="+initial.Version, http.StatusOK).Version
+
+	// Without the final id, the stream waits for changes until the request times out
+	ctx, cancel := context.WithTimeout(t.Context(), time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", httpServer.URL+"/_watch?prefix=/config/&since="+initial.Version, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	// The last id before the stream waits for more changes is the batch's version
+	var ids []string
+	var events int
+	scanner := bufio.NewScanner(resp.Body)
+	for events == 0 || len(ids) < 3 {
+		if !scanner.Scan() {
+			t.Fatalf("stream ended after ids %v", ids)
+		}
+		if value, ok := strings.CutPrefix(scanner.Text(), "id: "); ok {
+			ids = append(ids, value)
+		}
+		if strings.HasPrefix(scanner.Text(), "data: ") {
+			events++
+		}
+	}
+	if events != 1 || ids[len(ids)-1] != latest {
+		t.Errorf("expected 1 event followed by id %s, got %d events and ids %v", latest, events, ids)
+	}
+}
+
+func pollWatch(t *testing.T, server *Server, url string, expectedStatus int) watchResponse {
+	t.Helper()
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := httptest.NewRecorder()
+	server.HandleRequest(resp, req)
+
+	if resp.Code != expectedStatus {
+		t.Fatalf("expected status code %d, got %d: %v", expectedStatus, resp.Code, resp.Body)
+	}
+
+	var out watchResponse
+	if expectedStatus == http.StatusOK {
+		if err := json.Unmarshal(resp.Body.Bytes(), &out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return out
+}
+
+func doRequest(t *testing.T, server *Server, method, path, body string, expectedStatus int) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, path, bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := httptest.NewRecorder()
+	server.HandleRequest(resp, req)
+
+	if resp.Code != expectedStatus {
+		t.Fatalf("%s %s: expected status code %d, got %d: %v", method, path, expectedStatus, resp.Code, resp.Body)
+	}
+}
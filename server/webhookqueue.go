@@ -0,0 +1,209 @@
+package server
+
+// Webhook queue persistence
+//
+// The queue file is a journal with one JSON record per line, each holding a delivery as of a change to it: queued or
+// retried ("pending"), delivered ("done") or dead-lettered ("dead"). Records are appended and flushed by a
+// background writer, so queueing a delivery doesn't wait for the disk. A crash before the writer catches up loses
+// the changes since its last flush.
+//
+// On start, the journal is replayed, keeping the last record of each delivery, and replaced by rename with one
+// holding only the current queue. It is replaced the same way once it holds enough records. A crash while
+// appending can leave a partial last line, which is discarded on start since its write never succeeded.
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// compactWebhookQueueAfter is how many records the queue file holds before it is replaced with the current queue
+const compactWebhookQueueAfter = 1000
+
+// webhookState is the state of a delivery recorded in the queue file
+type webhookState string
+
+const (
+	webhookPending webhookState = "pending"
+	webhookDone    webhookState = "done"
+	webhookDead    webhookState = "dead"
+)
+
+// webhookRecord is a line of the queue file
+type webhookRecord struct {
+	State    webhookState    `json:"state"`
+	Delivery WebhookDelivery `json:"delivery"`
+}
+
+// readWebhookQueue replays the queue file at path. A missing file is an empty queue.
+func readWebhookQueue(path string) (webhookQueue, error) {
+	var queue webhookQueue
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return queue, nil
+	}
+	if err != nil {
+		return queue, fmt.Errorf("reading webhook queue: %w", err)
+	}
+	defer f.Close()
+
+	pending := make(map[string]*WebhookDelivery)
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// A line without a newline is a partial append, so it's discarded
+			break
+		}
+		if err != nil {
+			return queue, fmt.Errorf("reading webhook queue: %w", err)
+		}
+
+		var record webhookRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return queue, fmt.Errorf("decoding webhook queue: %w", err)
+		}
+		delivery := &record.Delivery
+		switch record.State {
+		case webhookPending:
+			if p, ok := pending[delivery.Payload.ID]; ok {
+				*p = *delivery
+			} else {
+				pending[delivery.Payload.ID] = delivery
+				queue.Pending = append(queue.Pending, delivery)
+			}
+		case webhookDone, webhookDead:
+			if p, ok := pending[delivery.Payload.ID]; ok {
+				delete(pending, delivery.Payload.ID)
+				queue.Pending = slices.DeleteFunc(queue.Pending, func(d *WebhookDelivery) bool { return d == p })
+			}
+			if record.State == webhookDead {
+				queue.Dead = append(queue.Dead, delivery)
+			}
+		default:
+			return queue, fmt.Errorf("decoding webhook queue: unknown state %q", record.State)
+		}
+	}
+
+	if len(queue.Dead) > maxDeadLetters {
+		queue.Dead = queue.Dead[len(queue.Dead)-maxDeadLetters:]
+	}
+	return queue, nil
+}
+
+// webhookJournal appends records to the queue file. It is only used by the dispatcher's writer.
+type webhookJournal struct {
+	path    string
+	file    *os.File
+	records int
+	// damaged is set when an append fails, which may leave a partial line, so that the file is replaced next
+	damaged bool
+	// err is the result of the last write, returned on close
+	err error
+}
+
+// openWebhookJournal replaces the queue file at path with one holding the given deliveries
+func openWebhookJournal(path string, pending, dead []WebhookDelivery) (*webhookJournal, error) {
+	j := &webhookJournal{path: path}
+	if err := j.replace(pending, dead); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// append writes records to the end of the file and flushes it
+func (j *webhookJournal) append(records []webhookRecord) error {
+	data, err := encodeWebhookRecords(records)
+	if err == nil {
+		_, err = j.file.Write(data)
+	}
+	if err == nil {
+		err = j.file.Sync()
+	}
+	if err != nil {
+		j.damaged = true
+		j.err = fmt.Errorf("appending to webhook queue: %w", err)
+		return j.err
+	}
+	j.records += len(records)
+	j.err = nil
+	return nil
+}
+
+// replace atomically replaces the file with one holding the given deliveries, and appends to it from then on
+func (j *webhookJournal) replace(pending, dead []WebhookDelivery) error {
+	j.err = j.writeFile(pending, dead)
+	return j.err
+}
+
+func (j *webhookJournal) writeFile(pending, dead []WebhookDelivery) error {
+	records := make([]webhookRecord, 0, len(pending)+len(dead))
+	for _, delivery := range pending {
+		records = append(records, webhookRecord{State: webhookPending, Delivery: delivery})
+	}
+	for _, delivery := range dead {
+		records = append(records, webhookRecord{State: webhookDead, Delivery: delivery})
+	}
+	data, err := encodeWebhookRecords(records)
+	if err != nil {
+		return fmt.Errorf("encoding webhook queue: %w", err)
+	}
+
+	dir := filepath.Dir(j.path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(j.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("creating webhook queue: %w", err)
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), j.path)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("replacing webhook queue: %w", err)
+	}
+	if j.file != nil {
+		j.file.Close()
+	}
+	j.file = tmp
+	j.records = len(records)
+	j.damaged = false
+	return syncDir(dir)
+}
+
+// close closes the file, returning any error from the last write
+func (j *webhookJournal) close() error {
+	return errors.Join(j.err, j.file.Close())
+}
+
+func encodeWebhookRecords(records []webhookRecord) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, record := range records {
+		if err := enc.Encode(record); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// syncDir flushes a directory, so that renames within it are durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadWebhookQueue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.json")
+	data := `{"state":"pending","delivery":{"subscription":"a","payload":{"id":"1","path":"/doc1"},"attempts":0}}
+{"state":"pending","delivery":{"subscription":"a","payload":{"id":"2","path":"/doc2"},"attempts":0}}
+{"state":"pending","delivery":{"subscription":"a","payload":{"id":"3","path":"/doc3"},"attempts":0}}
+{"state":"pending","delivery":{"subscription":"a","payload":{"id":"1","path":"/doc1"},"attempts":1}}
+{"state":"done","delivery":{"subscription":"a","payload":{"id":"2","path":"/doc2"},"attempts":1}}
+{"state":"dead","delivery":{"subscription":"a","payload":{"id":"3","path":"/doc3"},"attempts":3}}
+{"state":"done","delivery":{"subscription":"a","payl`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	queue, err := readWebhookQueue(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queue.Pending) != 1 || queue.Pending[0].Payload.ID != "1" || queue.Pending[0].Attempts != 1 {
+		t.Errorf("expected delivery 1 pending after 1 attempt, got %+v", copyDeliveries(queue.Pending))
+	}
+	if len(queue.Dead) != 1 || queue.Dead[0].Payload.ID != "3" {
+		t.Errorf("expected delivery 3 dead-lettered, got %+v", copyDeliveries(queue.Dead))
+	}
+}
+
+func TestWebhookJournalReplace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.json")
+	journal, err := openWebhookJournal(path, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	delivery := WebhookDelivery{Subscription: "a", Payload: WebhookPayload{ID: "1", Path: "/doc1"}}
+	if err := journal.append([]webhookRecord{{State: webhookPending, Delivery: delivery}}); err != nil {
+		t.Fatal(err)
+	}
+	delivery.Attempts = 1
+	if err := journal.append([]webhookRecord{{State: webhookPending, Delivery: delivery}}); err != nil {
+		t.Fatal(err)
+	}
+	if journal.records != 2 {
+		t.Errorf("expected 2 records, got %d", journal.records)
+	}
+
+	if err := journal.replace([]WebhookDelivery{delivery}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := journal.append([]webhookRecord{{State: webhookDone, Delivery: delivery}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := journal.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	queue, err := readWebhookQueue(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queue.Pending) != 0 || len(queue.Dead) != 0 {
+		t.Errorf("expected an empty queue, got %+v", queue)
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected only the queue file, got %v", entries)
+	}
+}
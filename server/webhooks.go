@@ -0,0 +1,562 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	mathrand "math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+)
+
+const (
+	defaultWebhookMaxAttempts     = 10
+	defaultWebhookInitialInterval = time.Second
+	defaultWebhookMaxInterval     = 10 * time.Minute
+	defaultWebhookTimeout         = 10 * time.Second
+
+	// maxDeadLetters is the number of failed deliveries retained for inspection
+	maxDeadLetters = 1000
+)
+
+// WebhookSubscription configures delivery of change notifications to a URL.
+type WebhookSubscription struct {
+	// ID identifies the subscription in the queue file, which holds neither its URL nor its secret, so that
+	// deliveries queued by a previous run are sent with the current ones. Defaults to a hash of the URL. Set it
+	// to keep queued deliveries when the URL changes, or to tell apart subscriptions to the same URL.
+	ID string `json:"id,omitempty" yaml:"id"`
+	// Prefix restricts notifications to resources under this path prefix
+	Prefix string `json:"prefix" yaml:"prefix"`
+	// Events restricts notifications to these operations. All operations are delivered if empty.
+	Events []gitbackedrest.EventType `json:"events,omitempty" yaml:"events"`
+	// URL receives a POST for each matching change
+	URL string `json:"url" yaml:"url"`
+	// Secret is the key used to sign payloads
+	Secret string `json:"secret" yaml:"secret"`
+}
+
+// id returns the subscription's ID, or a hash of its URL if none is set
+func (s WebhookSubscription) id() string {
+	if s.ID != "" {
+		return s.ID
+	}
+	sum := sha256.Sum256([]byte(s.URL))
+	return "url-" + hex.EncodeToString(sum[:8])
+}
+
+func (s WebhookSubscription) matches(event gitbackedrest.Event) bool {
+	if !strings.HasPrefix(event.Path, s.Prefix) {
+		return false
+	}
+	return len(s.Events) == 0 || slices.Contains(s.Events, event.Type)
+}
+
+// WebhookPayload is the JSON body delivered to webhook subscribers
+type WebhookPayload struct {
+	ID        string                  `json:"id"`
+	Path      string                  `json:"path"`
+	Operation gitbackedrest.EventType `json:"operation"`
+	Version   string                  `json:"version"`
+	Author    string                  `json:"author,omitempty"`
+	Timestamp time.Time               `json:"timestamp"`
+}
+
+// WebhookDelivery is a payload queued for delivery to a single subscription
+type WebhookDelivery struct {
+	// Subscription is the ID of the subscription the payload is delivered to
+	Subscription string         `json:"subscription"`
+	Payload      WebhookPayload `json:"payload"`
+	Attempts     int            `json:"attempts"`
+	NextAttempt  time.Time      `json:"next_attempt"`
+	LastError    string         `json:"last_error,omitempty"`
+}
+
+// WebhookConfig configures a WebhookDispatcher
+type WebhookConfig struct {
+	Subscriptions []WebhookSubscription
+
+	// QueuePath is a file used to persist pending and dead-lettered deliveries across restarts.
+	// Changes are appended and flushed in the background. If empty, the queue is only held in memory.
+	QueuePath string
+
+	// MaxAttempts is the number of delivery attempts before a delivery is dead-lettered
+	MaxAttempts int
+	// InitialInterval is the delay before the first retry, doubling for each subsequent retry
+	InitialInterval time.Duration
+	// MaxInterval caps the delay between retries
+	MaxInterval time.Duration
+
+	// HTTPClient is used to make deliveries. If nil, a client with a 10s timeout is used.
+	HTTPClient *http.Client
+
+	// Logger is used to log delivery failures. If nil, the default slog logger is used.
+	Logger *slog.Logger
+
+	// Registerer is used to register delivery metrics. If nil, metrics are not exported.
+	Registerer prometheus.Registerer
+}
+
+// webhookQueue is the persisted state of a dispatcher
+type webhookQueue struct {
+	Pending []*WebhookDelivery `json:"pending"`
+	Dead    []*WebhookDelivery `json:"dead"`
+}
+
+// WebhookDispatcher delivers HMAC-signed notifications of resource changes to subscribed URLs.
+// Each subscription is delivered to independently, so a slow subscriber doesn't delay the others.
+// Failed deliveries are retried with exponential backoff, and dead-lettered after
+// the configured number of attempts.
+//
+// Each payload is signed with HMAC-SHA256 over "<timestamp>.<body>", using the subscription's
+// secret. The timestamp and signature are sent in the X-Webhook-Timestamp and
+// X-Webhook-Signature headers, the latter in the form "sha256=<hex>".
+type WebhookDispatcher struct {
+	cfg     WebhookConfig
+	metrics *webhookMetrics
+	// subscriptions holds the configured subscriptions by ID
+	subscriptions map[string]WebhookSubscription
+
+	mtx   sync.Mutex
+	queue webhookQueue
+	// unwritten holds changes to the queue not yet appended to the queue file
+	unwritten []webhookRecord
+
+	// wake signals each subscription's worker that deliveries were queued
+	wake    map[string]chan struct{}
+	workers sync.WaitGroup
+	done    chan struct{}
+
+	// journal is nil if no queue path is configured
+	journal    *webhookJournal
+	flush      chan struct{}
+	stopWriter chan struct{}
+	writerDone chan struct{}
+	close      sync.Once
+	closeErr   error
+}
+
+// NewWebhookDispatcher creates a dispatcher and starts delivering any deliveries
+// persisted by a previous run.
+func NewWebhookDispatcher(cfg WebhookConfig) (*WebhookDispatcher, error) {
+	subscriptions := make(map[string]WebhookSubscription, len(cfg.Subscriptions))
+	for i, sub := range cfg.Subscriptions {
+		if sub.URL == "" {
+			return nil, fmt.Errorf("subscription %d: url is required", i)
+		}
+		if sub.Secret == "" {
+			return nil, fmt.Errorf("subscription %d: secret is required", i)
+		}
+		if _, ok := subscriptions[sub.id()]; ok {
+			return nil, fmt.Errorf("subscription %d: duplicate id %q, set a unique id for each subscription to the same url", i, sub.id())
+		}
+		subscriptions[sub.id()] = sub
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = defaultWebhookMaxAttempts
+	}
+	if cfg.InitialInterval <= 0 {
+		cfg.InitialInterval = defaultWebhookInitialInterval
+	}
+	if cfg.MaxInterval <= 0 {
+		cfg.MaxInterval = defaultWebhookMaxInterval
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: defaultWebhookTimeout}
+	}
+
+	d := &WebhookDispatcher{
+		cfg:           cfg,
+		metrics:       newWebhookMetrics(cfg.Registerer),
+		subscriptions: subscriptions,
+		wake:          make(map[string]chan struct{}, len(subscriptions)),
+		done:          make(chan struct{}),
+	}
+
+	if cfg.QueuePath != "" {
+		queue, err := readWebhookQueue(cfg.QueuePath)
+		if err != nil {
+			return nil, err
+		}
+		d.queue = queue
+		d.dropUnsubscribedLocked()
+
+		d.journal, err = openWebhookJournal(cfg.QueuePath, copyDeliveries(d.queue.Pending), copyDeliveries(d.queue.Dead))
+		if err != nil {
+			return nil, err
+		}
+		d.flush = make(chan struct{}, 1)
+		d.stopWriter = make(chan struct{})
+		d.writerDone = make(chan struct{})
+		go d.writeLoop()
+	}
+
+	for id := range subscriptions {
+		wake := make(chan struct{}, 1)
+		d.wake[id] = wake
+		d.workers.Go(func() {
+			d.run(id, wake)
+		})
+	}
+	return d, nil
+}
+
+// Notify queues deliveries of an event to all matching subscriptions
+func (d *WebhookDispatcher) Notify(event gitbackedrest.Event, author string) {
+	if d == nil {
+		return
+	}
+
+	now := time.Now()
+	var matched []string
+	d.mtx.Lock()
+	for _, sub := range d.cfg.Subscriptions {
+		if !sub.matches(event) {
+			continue
+		}
+		delivery := &WebhookDelivery{
+			Subscription: sub.id(),
+			Payload: WebhookPayload{
+				ID:        newDeliveryID(),
+				Path:      event.Path,
+				Operation: event.Type,
+				Version:   event.Version,
+				Author:    author,
+				Timestamp: now,
+			},
+			NextAttempt: now,
+		}
+		d.queue.Pending = append(d.queue.Pending, delivery)
+		d.recordLocked(webhookPending, delivery)
+		matched = append(matched, sub.id())
+	}
+	d.mtx.Unlock()
+
+	for _, id := range matched {
+		select {
+		case d.wake[id] <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Pending returns the deliveries waiting to be sent
+func (d *WebhookDispatcher) Pending() []WebhookDelivery {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	return copyDeliveries(d.queue.Pending)
+}
+
+// DeadLetters returns the most recent deliveries that exhausted their attempts
+func (d *WebhookDispatcher) DeadLetters() []WebhookDelivery {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	return copyDeliveries(d.queue.Dead)
+}
+
+// Close stops delivery and waits for remaining changes to the queue to be persisted
+func (d *WebhookDispatcher) Close() error {
+	d.close.Do(func() {
+		close(d.done)
+		d.workers.Wait()
+		if d.journal != nil {
+			close(d.stopWriter)
+			<-d.writerDone
+			d.closeErr = d.journal.close()
+		}
+	})
+	return d.closeErr
+}
+
+// run delivers to a single subscription until the dispatcher is closed
+func (d *WebhookDispatcher) run(id string, wake <-chan struct{}) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-wake:
+		case <-timer.C:
+		}
+
+		d.deliverDue(id)
+
+		timer.Stop()
+		if next, ok := d.nextAttempt(id); ok {
+			timer.Reset(time.Until(next))
+		}
+	}
+}
+
+// deliverDue attempts every delivery to a subscription whose retry time has passed
+func (d *WebhookDispatcher) deliverDue(id string) {
+	now := time.Now()
+
+	d.mtx.Lock()
+	var due []*WebhookDelivery
+	for _, delivery := range d.queue.Pending {
+		if delivery.Subscription == id && !delivery.NextAttempt.After(now) {
+			due = append(due, delivery)
+		}
+	}
+	d.mtx.Unlock()
+
+	for _, delivery := range due {
+		select {
+		case <-d.done:
+			return
+		default:
+		}
+
+		start := time.Now()
+		err := d.send(delivery)
+		d.metrics.deliveryDuration.WithLabelValues(deliveryStatus(err)).Observe(time.Since(start).Seconds())
+
+		d.mtx.Lock()
+		delivery.Attempts++
+		switch {
+		case err == nil:
+			d.removeLocked(delivery)
+			d.recordLocked(webhookDone, delivery)
+			d.metrics.deliveryCount.WithLabelValues("success").Inc()
+		case delivery.Attempts >= d.cfg.MaxAttempts:
+			d.log().Warn("dead-lettering webhook delivery",
+				"delivery_id", delivery.Payload.ID,
+				"subscription", delivery.Subscription,
+				"path", delivery.Payload.Path,
+				"attempts", delivery.Attempts,
+				"error", err,
+			)
+			d.deadLetterLocked(delivery, err)
+		default:
+			delivery.LastError = err.Error()
+			delivery.NextAttempt = time.Now().Add(d.retryInterval(delivery.Attempts))
+			d.log().Debug("webhook delivery failed, will retry",
+				"delivery_id", delivery.Payload.ID,
+				"subscription", delivery.Subscription,
+				"attempts", delivery.Attempts,
+				"next_attempt", delivery.NextAttempt,
+				"error", err,
+			)
+			d.recordLocked(webhookPending, delivery)
+			d.metrics.deliveryCount.WithLabelValues("retry").Inc()
+		}
+		d.mtx.Unlock()
+	}
+}
+
+func (d *WebhookDispatcher) send(delivery *WebhookDelivery) error {
+	// The URL and secret are looked up when sending, so changes to them apply to queued deliveries
+	sub, ok := d.subscriptions[delivery.Subscription]
+	if !ok {
+		return fmt.Errorf("subscription %q is no longer configured", delivery.Subscription)
+	}
+
+	body, err := json.Marshal(delivery.Payload)
+	if err != nil {
+		return fmt.Errorf("encoding payload: %w", err)
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-d.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-ID", delivery.Payload.ID)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+SignWebhookPayload(sub.Secret, timestamp, body))
+
+	resp, err := d.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// SignWebhookPayload returns the hex-encoded HMAC-SHA256 signature of a payload,
+// as sent in the X-Webhook-Signature header.
+func SignWebhookPayload(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// retryInterval returns an exponential backoff for the given number of attempts,
+// with up to 20% jitter either side.
+func (d *WebhookDispatcher) retryInterval(attempts int) time.Duration {
+	interval := float64(d.cfg.InitialInterval) * math.Pow(2, float64(attempts-1))
+	interval = min(interval, float64(d.cfg.MaxInterval))
+	jitter := 1 + (mathrand.Float64()*0.4 - 0.2)
+	return time.Duration(interval * jitter)
+}
+
+// nextAttempt returns the earliest retry time of the deliveries to a subscription
+func (d *WebhookDispatcher) nextAttempt(id string) (time.Time, bool) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	var next time.Time
+	for _, delivery := range d.queue.Pending {
+		if delivery.Subscription != id {
+			continue
+		}
+		if next.IsZero() || delivery.NextAttempt.Before(next) {
+			next = delivery.NextAttempt
+		}
+	}
+	d.metrics.queueSize.Set(float64(len(d.queue.Pending)))
+	return next, !next.IsZero()
+}
+
+// deadLetterLocked moves a delivery from the pending queue to the dead letters, dropping the oldest beyond the limit
+func (d *WebhookDispatcher) deadLetterLocked(delivery *WebhookDelivery, err error) {
+	delivery.LastError = err.Error()
+	d.removeLocked(delivery)
+	d.queue.Dead = append(d.queue.Dead, delivery)
+	d.recordLocked(webhookDead, delivery)
+	if len(d.queue.Dead) > maxDeadLetters {
+		d.queue.Dead = d.queue.Dead[len(d.queue.Dead)-maxDeadLetters:]
+	}
+	d.metrics.deliveryCount.WithLabelValues("dead_letter").Inc()
+}
+
+// dropUnsubscribedLocked dead-letters pending deliveries to subscriptions that are no longer configured
+func (d *WebhookDispatcher) dropUnsubscribedLocked() {
+	for _, delivery := range slices.Clone(d.queue.Pending) {
+		if _, ok := d.subscriptions[delivery.Subscription]; !ok {
+			d.log().Warn("dead-lettering webhook delivery to a subscription that is no longer configured",
+				"delivery_id", delivery.Payload.ID,
+				"subscription", delivery.Subscription,
+			)
+			d.deadLetterLocked(delivery, fmt.Errorf("subscription %q is no longer configured", delivery.Subscription))
+		}
+	}
+}
+
+func (d *WebhookDispatcher) removeLocked(delivery *WebhookDelivery) {
+	d.queue.Pending = slices.DeleteFunc(d.queue.Pending, func(p *WebhookDelivery) bool {
+		return p == delivery
+	})
+}
+
+// recordLocked queues a change to a delivery to be appended to the queue file, if a queue path is configured
+func (d *WebhookDispatcher) recordLocked(state webhookState, delivery *WebhookDelivery) {
+	if d.journal == nil {
+		return
+	}
+	d.unwritten = append(d.unwritten, webhookRecord{State: state, Delivery: *delivery})
+	select {
+	case d.flush <- struct{}{}:
+	default:
+	}
+}
+
+// writeLoop persists changes to the queue as they are recorded, until the dispatcher is closed
+func (d *WebhookDispatcher) writeLoop() {
+	defer close(d.writerDone)
+	for {
+		select {
+		case <-d.flush:
+			d.write()
+		case <-d.stopWriter:
+			d.write()
+			return
+		}
+	}
+}
+
+// write appends the unwritten changes to the queue file, or replaces it with the current queue once it
+// holds enough records
+func (d *WebhookDispatcher) write() {
+	d.mtx.Lock()
+	records := d.unwritten
+	d.unwritten = nil
+	replace := d.journal.damaged || d.journal.records+len(records) >= compactWebhookQueueAfter
+	var pending, dead []WebhookDelivery
+	if replace {
+		pending, dead = copyDeliveries(d.queue.Pending), copyDeliveries(d.queue.Dead)
+	}
+	d.mtx.Unlock()
+
+	if replace {
+		err := d.journal.replace(pending, dead)
+		if err == nil {
+			return
+		}
+		// Appending to a damaged file would leave the partial line in the middle, so the replace is retried
+		// with the next write instead. The queue is still held in memory.
+		if d.journal.damaged {
+			d.log().Error("error persisting webhook queue", "path", d.cfg.QueuePath, "error", err)
+			return
+		}
+		d.log().Warn("error replacing webhook queue, appending instead", "path", d.cfg.QueuePath, "error", err)
+	}
+	if len(records) == 0 {
+		return
+	}
+	if err := d.journal.append(records); err != nil {
+		d.log().Error("error persisting webhook queue", "path", d.cfg.QueuePath, "error", err)
+	}
+}
+
+func (d *WebhookDispatcher) log() *slog.Logger {
+	if d.cfg.Logger == nil {
+		return slog.Default()
+	}
+	return d.cfg.Logger
+}
+
+func copyDeliveries(deliveries []*WebhookDelivery) []WebhookDelivery {
+	out := make([]WebhookDelivery, len(deliveries))
+	for i, delivery := range deliveries {
+		out[i] = *delivery
+	}
+	return out
+}
+
+func deliveryStatus(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
+
+func newDeliveryID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
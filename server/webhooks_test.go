@@ -0,0 +1,317 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	gitbackedrest "github.com/theothertomelliott/git-backed-rest"
+	"github.com/theothertomelliott/git-backed-rest/backends/memory"
+)
+
+func TestWebhookDelivery(t *testing.T) {
+	const secret = "secret1"
+
+	received := make(chan WebhookPayload, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		expected := "sha256=" + SignWebhookPayload(secret, r.Header.Get("X-Webhook-Timestamp"), body)
+		if r.Header.Get("X-Webhook-Signature") != expected {
+			t.Errorf("unexpected signature %q", r.Header.Get("X-Webhook-Signature"))
+		}
+
+		var payload WebhookPayload
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Error(err)
+		}
+		received <- payload
+	}))
+	defer receiver.Close()
+
+	webhooks, err := NewWebhookDispatcher(WebhookConfig{
+		Subscriptions: []WebhookSubscription{
+			{
+				Prefix: "/config/",
+				Events: []gitbackedrest.EventType{gitbackedrest.EventCreate, gitbackedrest.EventDelete},
+				URL:    receiver.URL,
+				Secret: secret,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer webhooks.Close()
+
+	server := New(memory.NewBackend(), WithWebhooks(webhooks))
+
+	doRequest(t, server, "POST", "/other/a", "content1", http.StatusCreated)
+	doRequest(t, server, "POST", "/config/a", "content1", http.StatusCreated)
+	doRequest(t, server, "PUT", "/config/a", "content2", http.StatusNoContent)
+	doRequest(t, server, "DELETE", "/config/a", "", http.StatusNoContent)
+
+	for _, operation := range []gitbackedrest.EventType{gitbackedrest.EventCreate, gitbackedrest.EventDelete} {
+		select {
+		case payload := <-received:
+			if payload.Operation != operation || payload.Path != "/config/a" {
+				t.Errorf("unexpected payload: %+v", payload)
+			}
+			if payload.Version == "" {
+				t.Error("expected a version")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %s delivery", operation)
+		}
+	}
+
+	select {
+	case payload := <-received:
+		t.Errorf("unexpected delivery: %+v", payload)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestWebhookDeadLetter(t *testing.T) {
+	var attempts atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer receiver.Close()
+
+	webhooks, err := NewWebhookDispatcher(WebhookConfig{
+		Subscriptions: []WebhookSubscription{
+			{URL: receiver.URL, Secret: "secret"},
+		},
+		MaxAttempts:     3,
+		InitialInterval: time.Millisecond,
+		MaxInterval:     5 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer webhooks.Close()
+
+	webhooks.Notify(gitbackedrest.Event{Type: gitbackedrest.EventCreate, Path: "/doc1"}, "alice")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(webhooks.DeadLetters()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for dead letter")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	dead := webhooks.DeadLetters()[0]
+	if dead.Attempts != 3 || attempts.Load() != 3 {
+		t.Errorf("expected 3 attempts, got %d (%d received)", dead.Attempts, attempts.Load())
+	}
+	if dead.Payload.Author != "alice" {
+		t.Errorf("expected author alice, got %q", dead.Payload.Author)
+	}
+	if len(webhooks.Pending()) != 0 {
+		t.Errorf("expected empty queue, got %v", webhooks.Pending())
+	}
+}
+
+func TestWebhookQueuePersistence(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer receiver.Close()
+
+	cfg := WebhookConfig{
+		Subscriptions: []WebhookSubscription{
+			{URL: receiver.URL, Secret: "secret"},
+		},
+		QueuePath:       filepath.Join(t.TempDir(), "queue.json"),
+		InitialInterval: time.Hour,
+	}
+
+	webhooks, err := NewWebhookDispatcher(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhooks.Notify(gitbackedrest.Event{Type: gitbackedrest.EventUpdate, Path: "/doc1"}, "")
+	if err := webhooks.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := NewWebhookDispatcher(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+
+	pending := restored.Pending()
+	if len(pending) != 1 || pending[0].Payload.Path != "/doc1" {
+		t.Errorf("expected persisted delivery for /doc1, got %+v", pending)
+	}
+}
+
+func TestWebhookQueueUsesCurrentSubscription(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	queuePath := filepath.Join(t.TempDir(), "queue.json")
+	webhooks, err := NewWebhookDispatcher(WebhookConfig{
+		Subscriptions: []WebhookSubscription{
+			{ID: "audit", URL: failing.URL, Secret: "old-secret"},
+		},
+		QueuePath:       queuePath,
+		InitialInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhooks.Notify(gitbackedrest.Event{Type: gitbackedrest.EventUpdate, Path: "/doc1"}, "")
+	if err := webhooks.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(queuePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "old-secret") || strings.Contains(string(data), failing.URL) {
+		t.Errorf("queue file contains subscription details: %s", data)
+	}
+
+	const secret = "new-secret"
+	received := make(chan string, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		expected := "sha256=" + SignWebhookPayload(secret, r.Header.Get("X-Webhook-Timestamp"), body)
+		if r.Header.Get("X-Webhook-Signature") != expected {
+			t.Errorf("unexpected signature %q", r.Header.Get("X-Webhook-Signature"))
+		}
+		var payload WebhookPayload
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Error(err)
+		}
+		received <- payload.Path
+	}))
+	defer receiver.Close()
+
+	restored, err := NewWebhookDispatcher(WebhookConfig{
+		Subscriptions: []WebhookSubscription{
+			{ID: "audit", URL: receiver.URL, Secret: secret},
+		},
+		QueuePath: queuePath,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+
+	select {
+	case path := <-received:
+		if path != "/doc1" {
+			t.Errorf("unexpected delivery for %q", path)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for restored delivery")
+	}
+}
+
+func TestWebhookQueueUnknownSubscription(t *testing.T) {
+	queuePath := filepath.Join(t.TempDir(), "queue.json")
+	webhooks, err := NewWebhookDispatcher(WebhookConfig{
+		Subscriptions: []WebhookSubscription{
+			{ID: "removed", URL: "http://127.0.0.1:1", Secret: "secret"},
+		},
+		QueuePath:       queuePath,
+		InitialInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhooks.Notify(gitbackedrest.Event{Type: gitbackedrest.EventUpdate, Path: "/doc1"}, "")
+	if err := webhooks.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := NewWebhookDispatcher(WebhookConfig{
+		Subscriptions: []WebhookSubscription{
+			{ID: "other", URL: "http://127.0.0.1:1", Secret: "secret"},
+		},
+		QueuePath:       queuePath,
+		InitialInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+
+	if pending := restored.Pending(); len(pending) != 0 {
+		t.Errorf("expected no pending deliveries, got %+v", pending)
+	}
+	if dead := restored.DeadLetters(); len(dead) != 1 || dead[0].Subscription != "removed" {
+		t.Errorf("expected a dead letter for the removed subscription, got %+v", dead)
+	}
+}
+
+func TestWebhookDuplicateSubscriptionID(t *testing.T) {
+	_, err := NewWebhookDispatcher(WebhookConfig{
+		Subscriptions: []WebhookSubscription{
+			{URL: "http://example.com/hook", Secret: "a"},
+			{URL: "http://example.com/hook", Secret: "b"},
+		},
+	})
+	if err == nil {
+		t.Error("expected an error for subscriptions with the same url and no id")
+	}
+}
+
+func TestWebhookSlowSubscriber(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+
+	received := make(chan struct{}, 1)
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- struct{}{}
+	}))
+	defer fast.Close()
+
+	webhooks, err := NewWebhookDispatcher(WebhookConfig{
+		Subscriptions: []WebhookSubscription{
+			{URL: slow.URL, Secret: "secret"},
+			{URL: fast.URL, Secret: "secret"},
+		},
+		QueuePath: filepath.Join(t.TempDir(), "queue.json"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer webhooks.Close()
+
+	webhooks.Notify(gitbackedrest.Event{Type: gitbackedrest.EventCreate, Path: "/doc1"}, "")
+
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("delivery to the fast subscriber waited for the slow one")
+	}
+}